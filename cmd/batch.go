@@ -0,0 +1,448 @@
+// SPDX-License-Identifier: Apache-2.0
+/*
+Copyright (C) 2023 The Diginfra Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/diginfra/diginfractl/pkg/output"
+	"github.com/diginfra/driverkit/pkg/driverbuilder"
+	"github.com/diginfra/driverkit/pkg/driverbuilder/builder"
+	"github.com/diginfra/driverkit/pkg/kubernetes/factory"
+	"github.com/olekukonko/tablewriter"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"gopkg.in/yaml.v3"
+)
+
+const defaultBatchParallelism = 4
+
+type batchCmdOptions struct {
+	file              string
+	kernelCrawlerFile string
+	kernelCrawler     kernelCrawlerOptions
+	filter            batchFilter
+	processor         string
+	parallelism       int
+	local             localCmdOptions
+}
+
+// batchSpec is a single build of a batch file.
+// Its keys are the same used by the driverkit config file.
+type batchSpec struct {
+	Target           string   `yaml:"target"`
+	KernelRelease    string   `yaml:"kernelrelease"`
+	KernelVersion    string   `yaml:"kernelversion"`
+	Architecture     string   `yaml:"architecture"`
+	DriverVersion    string   `yaml:"driverversion"`
+	KernelConfigData string   `yaml:"kernelconfigdata"`
+	KernelUrls       []string `yaml:"kernelurls"`
+	BuilderImage     string   `yaml:"builderimage"`
+	GCCVersion       string   `yaml:"gccversion"`
+	ModuleDriverName string   `yaml:"moduledrivername"`
+	ModuleDeviceName string   `yaml:"moduledevicename"`
+	Output           struct {
+		Module string `yaml:"module"`
+		Probe  string `yaml:"probe"`
+	} `yaml:"output"`
+}
+
+type batchFile struct {
+	Builds []batchSpec `yaml:"builds"`
+}
+
+type batchResult struct {
+	opts     *RootOptions
+	result   *driverbuilder.BuildResult
+	err      error
+	duration time.Duration
+}
+
+// NewBatchCmd creates the `driverkit batch` command.
+func NewBatchCmd(configOpts *ConfigOptions, rootOpts *RootOptions, rootFlags *pflag.FlagSet) *cobra.Command {
+	opts := batchCmdOptions{}
+	batchCmd := &cobra.Command{
+		Use:   "batch",
+		Short: "Build Diginfra kernel modules and eBPF probes for a list of kernels, concurrently.",
+	}
+
+	// Add Kubernetes client flags, used by the kubernetes processor
+	configFlags := addKubernetesConfigFlags(batchCmd.PersistentFlags())
+	flags := batchCmd.Flags()
+	flags.StringVarP(&opts.file, "file", "f", "", "yaml or json file containing the list of builds under the 'builds' key; each build uses the same keys of the config file")
+	flags.StringVar(&opts.kernelCrawlerFile, "kernel-crawler-file", "", "kernel-crawler json output listing the kernels to build for, each using its crawled headers as kernel urls")
+	flags.StringVar(&opts.kernelCrawler.outputDir, "output-dir", "", "directory where the drivers built from the kernel-crawler file are stored, under a directory per architecture")
+	flags.StringSliceVar(&opts.kernelCrawler.drivers, "output-drivers", []string{driverModule}, "drivers built for each kernel of the kernel-crawler file, one or more of ["+strings.Join(validOutputDrivers, ",")+"]")
+	flags.StringSliceVar(&opts.filter.targets, "filter-target", nil, "only run the builds for the given targets")
+	flags.StringSliceVar(&opts.filter.archs, "filter-arch", nil, "only run the builds for the given architectures")
+	flags.StringVar(&opts.filter.kernelRelease, "filter-kernelrelease", "", "only run the builds whose kernel release matches the given regular expression")
+	flags.StringVar(&opts.processor, "processor", driverbuilder.DockerBuildProcessorName, "processor used to run the builds, one of ["+strings.Join(validProcessors, ",")+"]")
+	flags.IntVar(&opts.parallelism, "parallelism", defaultBatchParallelism, "maximum number of builds running concurrently")
+	// Add Kubernetes pods options flags and local processor flags
+	addKubernetesFlags(flags)
+	addLocalFlags(flags, &opts.local)
+	batchCmd.PersistentFlags().AddFlagSet(flags)
+	// Add root flags; they are used as defaults for each build
+	batchCmd.PersistentFlags().AddFlagSet(rootFlags)
+
+	batchCmd.MarkFlagsOneRequired("file", "kernel-crawler-file")
+	batchCmd.MarkFlagsMutuallyExclusive("file", "kernel-crawler-file")
+	_ = batchCmd.MarkFlagFilename("file", "yaml", "yml", "json")
+	_ = batchCmd.MarkFlagFilename("kernel-crawler-file", "json")
+	_ = batchCmd.RegisterFlagCompletionFunc("processor", func(c *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		return validProcessors, cobra.ShellCompDirectiveDefault
+	})
+
+	batchCmd.RunE = func(c *cobra.Command, args []string) error {
+		var (
+			specs []*RootOptions
+			err   error
+		)
+		if opts.kernelCrawlerFile != "" {
+			specs, err = loadKernelCrawlerFile(opts.kernelCrawlerFile, rootOpts, opts.kernelCrawler)
+		} else {
+			specs, err = loadBatchFile(opts.file, rootOpts)
+		}
+		if err != nil {
+			return err
+		}
+		if specs, err = opts.filter.apply(specs); err != nil {
+			return err
+		}
+
+		validationFailed := false
+		for i, spec := range specs {
+			if errs := spec.Validate(); errs != nil {
+				for _, err := range errs {
+					configOpts.Printer.Logger.Error("error validating build options",
+						configOpts.Printer.Logger.Args("build", i, "err", err.Error()))
+				}
+				validationFailed = true
+			}
+		}
+		if validationFailed {
+			return errors.New("exiting for validation errors")
+		}
+		if err = checkDistinctOutputs(specs); err != nil {
+			return err
+		}
+
+		kubefactory, err := factory.NewFactory(configFlags)
+		if err != nil {
+			return err
+		}
+		newProcessor, err := processorFactory(opts.processor, opts.local, kubefactory, configOpts)
+		if err != nil {
+			return err
+		}
+
+		parallelism := opts.parallelism
+		if parallelism < 1 {
+			return fmt.Errorf("parallelism must be greater than 0, got %d", parallelism)
+		}
+		if opts.processor == driverbuilder.LocalBuildProcessorName && parallelism > 1 {
+			// Local builds share the same driver directory on the host
+			configOpts.Printer.Logger.Warn("local processor does not support concurrent builds, forcing parallelism to 1")
+			parallelism = 1
+		}
+
+		configOpts.Printer.Logger.Info("starting batch build",
+			configOpts.Printer.Logger.Args("processor", opts.processor, "builds", len(specs), "parallelism", parallelism))
+		if configOpts.dryRun {
+			return nil
+		}
+		if opts.kernelCrawlerFile != "" {
+			if err = createOutputDirs(specs); err != nil {
+				return err
+			}
+		}
+
+		results := runBatch(c.Context(), specs, parallelism, newProcessor, configOpts.Printer)
+
+		table := tablewriter.NewWriter(c.OutOrStdout())
+		table.SetHeader([]string{"Target", "Kernel Release", "Kernel Version", "Arch", "Status", "Duration", "Error"})
+		table.SetBorders(tablewriter.Border{Left: true, Top: false, Right: true, Bottom: false})
+		table.SetCenterSeparator("|")
+		failed := 0
+		for _, res := range results {
+			status := "success"
+			errStr := ""
+			if res.err != nil {
+				status = "failure"
+				errStr = res.err.Error()
+				failed++
+			}
+			table.Append([]string{
+				res.opts.Target,
+				res.opts.KernelRelease,
+				res.opts.KernelVersion,
+				res.opts.Architecture,
+				status,
+				res.duration.Round(time.Second).String(),
+				errStr,
+			})
+		}
+		table.Render()
+
+		if rootOpts.ReportFile != "" {
+			report := make([]*driverbuilder.BuildResult, 0, len(results))
+			for _, res := range results {
+				report = append(report, res.result)
+			}
+			if err = writeReport(rootOpts.ReportFile, report); err != nil {
+				return fmt.Errorf("error writing report file: %w", err)
+			}
+		}
+
+		if failed > 0 {
+			return fmt.Errorf("%d of %d builds failed", failed, len(results))
+		}
+		return nil
+	}
+
+	return batchCmd
+}
+
+// processorFactory returns a function creating a new build processor of the given kind for each build,
+// since processors hold per-build state and cannot be shared by concurrent builds.
+func processorFactory(processor string, local localCmdOptions, kubefactory factory.Factory, configOpts *ConfigOptions) (func() (driverbuilder.BuildProcessor, error), error) {
+	switch processor {
+	case driverbuilder.DockerBuildProcessorName:
+		return func() (driverbuilder.BuildProcessor, error) {
+			return driverbuilder.NewDockerBuildProcessor(configOpts.Timeout, configOpts.ProxyURL), nil
+		}, nil
+	case driverbuilder.PodmanBuildProcessorName:
+		return func() (driverbuilder.BuildProcessor, error) {
+			return driverbuilder.NewPodmanBuildProcessor(configOpts.Timeout, configOpts.ProxyURL), nil
+		}, nil
+	case driverbuilder.KubernetesBuildProcessorName, "k8s":
+		return func() (driverbuilder.BuildProcessor, error) {
+			return newKubernetesBuildProcessor(kubefactory, configOpts)
+		}, nil
+	case "kubernetes-in-cluster", "k8s-ic":
+		return func() (driverbuilder.BuildProcessor, error) {
+			return newKubernetesInClusterBuildProcessor(configOpts)
+		}, nil
+	case driverbuilder.LocalBuildProcessorName:
+		return func() (driverbuilder.BuildProcessor, error) {
+			// The local processor stores the kernel headers dir into the env map
+			localOpts := local
+			localOpts.envMap = make(map[string]string, len(local.envMap))
+			for k, v := range local.envMap {
+				localOpts.envMap[k] = v
+			}
+			return newLocalBuildProcessor(localOpts, configOpts), nil
+		}, nil
+	}
+	return nil, fmt.Errorf("unsupported processor %q, one of [%s]", processor, strings.Join(validProcessors, ","))
+}
+
+// loadBatchFile reads the builds from the given yaml or json file,
+// using base as default value for the options not set by each build.
+func loadBatchFile(path string, base *RootOptions) ([]*RootOptions, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var bf batchFile
+	if err = yaml.Unmarshal(data, &bf); err != nil {
+		return nil, fmt.Errorf("error unmarshalling batch file %s: %w", path, err)
+	}
+	if len(bf.Builds) == 0 {
+		return nil, fmt.Errorf("no builds found in batch file %s", path)
+	}
+
+	res := make([]*RootOptions, len(bf.Builds))
+	for i, spec := range bf.Builds {
+		res[i] = spec.toRootOptions(base)
+	}
+	return res, nil
+}
+
+func (s *batchSpec) toRootOptions(base *RootOptions) *RootOptions {
+	ro := *base
+	if s.Target != "" {
+		ro.Target = s.Target
+	}
+	if s.KernelRelease != "" {
+		ro.KernelRelease = s.KernelRelease
+	}
+	if s.KernelVersion != "" {
+		ro.KernelVersion = s.KernelVersion
+	}
+	if s.Architecture != "" {
+		ro.Architecture = s.Architecture
+	}
+	if s.DriverVersion != "" {
+		ro.DriverVersion = s.DriverVersion
+	}
+	if s.KernelConfigData != "" {
+		ro.KernelConfigData = s.KernelConfigData
+	}
+	if len(s.KernelUrls) > 0 {
+		ro.KernelUrls = s.KernelUrls
+	}
+	if s.BuilderImage != "" {
+		ro.BuilderImage = s.BuilderImage
+	}
+	if s.GCCVersion != "" {
+		ro.GCCVersion = s.GCCVersion
+	}
+	if s.ModuleDriverName != "" {
+		ro.ModuleDriverName = s.ModuleDriverName
+	}
+	if s.ModuleDeviceName != "" {
+		ro.ModuleDeviceName = s.ModuleDeviceName
+	}
+	// Outputs are always specific to each build
+	if s.Output.Module != "" || s.Output.Probe != "" {
+		ro.Output = OutputOptions{
+			Module: s.Output.Module,
+			Probe:  s.Output.Probe,
+		}
+	}
+	return &ro
+}
+
+// checkDistinctOutputs returns an error if some builds write to the same output file,
+// e.g. because they inherit the base --output-module, since they may run concurrently.
+func checkDistinctOutputs(specs []*RootOptions) error {
+	owners := make(map[string]int)
+	for i, spec := range specs {
+		for _, out := range []string{spec.Output.Module, spec.Output.Probe} {
+			if out == "" {
+				continue
+			}
+			out = filepath.Clean(out)
+			if j, ok := owners[out]; ok {
+				return fmt.Errorf("builds %d and %d write to the same output %s, set a distinct output for each build", j, i, out)
+			}
+			owners[out] = i
+		}
+	}
+	return nil
+}
+
+// runBatch runs the builds through a pool of parallelism workers,
+// returning the results in the same order of the given builds.
+func runBatch(ctx context.Context,
+	builds []*RootOptions,
+	parallelism int,
+	newProcessor func() (driverbuilder.BuildProcessor, error),
+	printer *output.Printer,
+) []batchResult {
+	var (
+		wg       sync.WaitGroup
+		printMtx sync.Mutex
+		listers  = imagesListersCache{listers: make(map[string][]builder.ImagesLister)}
+		jobs     = make(chan int)
+		results  = make([]batchResult, len(builds))
+	)
+
+	for w := 0; w < parallelism; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				opts := builds[i]
+				// Each build logs to its own buffer, flushed once the build is over,
+				// so that logs of concurrent builds do not interleave.
+				var buf bytes.Buffer
+				b := opts.ToBuild(printer.WithWriter(&buf))
+				listers.share(b)
+
+				start := time.Now()
+				var res *driverbuilder.BuildResult
+				processor, err := newProcessor()
+				if err == nil {
+					res, err = opts.WrapProcessor(processor).Start(ctx, b)
+				} else {
+					// No processor could be created to run the build
+					res, _ = driverbuilder.NewBuildResult("", b).Finish(b, err)
+				}
+				results[i] = batchResult{
+					opts:     opts,
+					result:   res,
+					err:      err,
+					duration: time.Since(start),
+				}
+
+				printMtx.Lock()
+				printer.DefaultText.Print(buf.String())
+				args := printer.Logger.Args("build", i, "target", opts.Target, "kernelrelease", opts.KernelRelease)
+				if err != nil {
+					printer.Logger.Error("build failed", append(args, printer.Logger.Args("err", err.Error())...))
+				} else {
+					printer.Logger.Info("build completed", args)
+				}
+				printMtx.Unlock()
+			}
+		}()
+	}
+
+	for i := range builds {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+	return results
+}
+
+// imagesListersCache shares the builder images listers between builds
+// with same target, architecture and builder repos,
+// so that each builder repo is only listed once per batch.
+type imagesListersCache struct {
+	mu      sync.Mutex
+	listers map[string][]builder.ImagesLister
+}
+
+func (ic *imagesListersCache) share(b *builder.Build) {
+	key := strings.Join(append([]string{b.TargetType.String(), b.Architecture, b.BuilderImage}, b.BuilderRepos...), "|")
+
+	ic.mu.Lock()
+	defer ic.mu.Unlock()
+	if listers, ok := ic.listers[key]; ok {
+		b.ImagesListers = listers
+		return
+	}
+	listers := make([]builder.ImagesLister, len(b.ImagesListers))
+	for i, lister := range b.ImagesListers {
+		listers[i] = &onceImagesLister{ImagesLister: lister}
+	}
+	ic.listers[key] = listers
+	b.ImagesListers = listers
+}
+
+// onceImagesLister is an ImagesLister that loads images only the first time.
+type onceImagesLister struct {
+	builder.ImagesLister
+	once   sync.Once
+	images []builder.Image
+}
+
+func (o *onceImagesLister) LoadImages(ctx context.Context, printer *output.Printer) []builder.Image {
+	o.once.Do(func() {
+		o.images = o.ImagesLister.LoadImages(ctx, printer)
+	})
+	return o.images
+}
@@ -0,0 +1,121 @@
+// SPDX-License-Identifier: Apache-2.0
+/*
+Copyright (C) 2023 The Diginfra Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/diginfra/driverkit/pkg/driverbuilder"
+	"github.com/olekukonko/tablewriter"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// cacheFlags are the root flags meaningful to the cache subcommands.
+var cacheFlags = []string{"config", "loglevel", "dryrun", "build-cache-dir"}
+
+// NewCacheCmd creates the `driverkit cache` command.
+func NewCacheCmd(configOpts *ConfigOptions, rootOpts *RootOptions, rootFlags *pflag.FlagSet) *cobra.Command {
+	cacheCmd := &cobra.Command{
+		Use:   "cache",
+		Short: "Manage the cache of the built drivers",
+	}
+	for _, name := range cacheFlags {
+		cacheCmd.PersistentFlags().AddFlag(rootFlags.Lookup(name))
+	}
+
+	cacheCmd.AddCommand(newCacheLsCmd(configOpts, rootOpts))
+	cacheCmd.AddCommand(newCachePruneCmd(configOpts, rootOpts))
+	return cacheCmd
+}
+
+func newCacheLsCmd(configOpts *ConfigOptions, rootOpts *RootOptions) *cobra.Command {
+	return &cobra.Command{
+		Use:   "ls",
+		Short: "List the drivers stored in the build cache",
+		Args:  cobra.NoArgs,
+		RunE: func(c *cobra.Command, args []string) error {
+			configOpts.Printer.Logger.Info("listing build cache",
+				configOpts.Printer.Logger.Args("dir", rootOpts.BuildCache.Dir))
+			entries, err := driverbuilder.ListCache(rootOpts.BuildCache.Dir)
+			if err != nil {
+				return err
+			}
+
+			table := tablewriter.NewWriter(c.OutOrStdout())
+			table.SetHeader([]string{"Key", "Target", "Kernel Release", "Kernel Version", "Arch", "Driver Version", "Drivers", "Size", "Created"})
+			table.SetBorders(tablewriter.Border{Left: true, Top: false, Right: true, Bottom: false})
+			table.SetCenterSeparator("|")
+			for _, entry := range entries {
+				var drivers []string
+				if entry.Module {
+					drivers = append(drivers, "module")
+				}
+				if entry.Probe {
+					drivers = append(drivers, "probe")
+				}
+				table.Append([]string{
+					shortCacheKey(entry.Key),
+					entry.Build.Target,
+					entry.Build.KernelRelease,
+					entry.Build.KernelVersion,
+					entry.Build.Architecture,
+					entry.Build.DriverVersion,
+					strings.Join(drivers, ","),
+					strconv.FormatInt(entry.Size, 10),
+					entry.Created.Format(time.RFC3339),
+				})
+			}
+			table.Render()
+			return nil
+		},
+	}
+}
+
+func newCachePruneCmd(configOpts *ConfigOptions, rootOpts *RootOptions) *cobra.Command {
+	var olderThan time.Duration
+	pruneCmd := &cobra.Command{
+		Use:   "prune",
+		Short: "Remove the drivers stored in the build cache",
+		Args:  cobra.NoArgs,
+		RunE: func(c *cobra.Command, args []string) error {
+			configOpts.Printer.Logger.Info("pruning build cache",
+				configOpts.Printer.Logger.Args("dir", rootOpts.BuildCache.Dir, "older-than", olderThan.String()))
+			if configOpts.dryRun {
+				return nil
+			}
+			pruned, err := driverbuilder.PruneCache(rootOpts.BuildCache.Dir, olderThan)
+			for _, entry := range pruned {
+				configOpts.Printer.Logger.Debug("removed build cache entry",
+					configOpts.Printer.Logger.Args("key", entry.Key))
+			}
+			configOpts.Printer.Logger.Info("build cache pruned",
+				configOpts.Printer.Logger.Args("removed", len(pruned)))
+			return err
+		},
+	}
+	pruneCmd.Flags().DurationVar(&olderThan, "older-than", 0, "only remove the entries older than the given duration (e.g. 72h); by default all the entries are removed")
+	return pruneCmd
+}
+
+// shortCacheKey abbreviates a cache key for display.
+func shortCacheKey(key string) string {
+	if len(key) > 12 {
+		return key[:12]
+	}
+	return key
+}
@@ -22,6 +22,7 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
+	"slices"
 	"sort"
 	"strings"
 	"testing"
@@ -85,6 +86,37 @@ var tests = []testCase{
 			err: "exiting for validation errors",
 		},
 	},
+	{
+		descr: "resolve/without-outputs",
+		args: []string{
+			"resolve",
+			"--loglevel",
+			"debug",
+			"--kernelrelease",
+			"5.14.10-300.fc35.x86_64",
+			"--target",
+			"fedora",
+		},
+		expect: expect{
+			out: "testdata/resolve-debug.txt",
+		},
+	},
+	{
+		descr: "resolve/invalid-format",
+		args: []string{
+			"resolve",
+			"--kernelrelease",
+			"5.14.10-300.fc35.x86_64",
+			"--target",
+			"fedora",
+			"--format",
+			"abc",
+		},
+		expect: expect{
+			out: "testdata/resolve-invalid-format.txt",
+			err: `unsupported format "abc", one of [table json]`,
+		},
+	},
 	{
 		descr: "docker/all-flags",
 		args: []string{
@@ -106,6 +138,23 @@ var tests = []testCase{
 			out: "testdata/docker-with-flags.txt",
 		},
 	},
+	{
+		descr: "podman/all-flags",
+		args: []string{
+			"podman",
+			"--kernelrelease",
+			"4.15.0-1057-aws",
+			"--kernelversion",
+			"59",
+			"--target",
+			"ubuntu-aws",
+			"--output-module",
+			"/tmp/diginfra-ubuntu-aws.ko",
+		},
+		expect: expect{
+			out: "testdata/podman-with-flags.txt",
+		},
+	},
 	{
 		descr: "docker/empty",
 		args:  []string{"docker"},
@@ -163,6 +212,20 @@ var tests = []testCase{
 			out: "testdata/docker-with-flags-debug.txt",
 		},
 	},
+	{
+		descr: "docker/from-config-file-invalid-mirrors",
+		args: []string{
+			"docker",
+			"-c",
+			"testdata/configs/3.yaml",
+			"--loglevel",
+			"debug",
+		},
+		expect: expect{
+			out: "testdata/docker-invalid-mirrors-from-config-debug.txt",
+			err: "exiting for validation errors",
+		},
+	},
 	{
 		descr: "docker/from-config-file",
 		args: []string{
@@ -247,6 +310,142 @@ var tests = []testCase{
 			err: "exiting for validation errors",
 		},
 	},
+	{
+		descr: "batch/from-file",
+		args: []string{
+			"batch",
+			"-f",
+			"testdata/batch/builds.yaml",
+			"--loglevel",
+			"debug",
+		},
+		expect: expect{
+			out: "testdata/batch-from-file-debug.txt",
+		},
+	},
+	{
+		descr: "batch/from-kernel-crawler-file",
+		args: []string{
+			"batch",
+			"--kernel-crawler-file",
+			"testdata/batch/kernel-crawler.json",
+			"--output-dir",
+			"/tmp/drivers",
+			"--filter-arch",
+			"x86_64",
+			"--loglevel",
+			"debug",
+		},
+		expect: expect{
+			out: "testdata/batch-from-kernel-crawler-file-debug.txt",
+		},
+	},
+	{
+		descr: "batch/from-kernel-crawler-file-without-output-dir",
+		args: []string{
+			"batch",
+			"--kernel-crawler-file",
+			"testdata/batch/kernel-crawler.json",
+		},
+		expect: expect{
+			out: "testdata/batch-kernel-crawler-without-output-dir.txt",
+			err: "an output directory is required to build from a kernel-crawler file",
+		},
+	},
+	{
+		descr: "batch/from-kernel-crawler-file-filtered-out",
+		args: []string{
+			"batch",
+			"--kernel-crawler-file",
+			"testdata/batch/kernel-crawler.json",
+			"--output-dir",
+			"/tmp/drivers",
+			"--filter-kernelrelease",
+			"^6\\.",
+		},
+		expect: expect{
+			out: "testdata/batch-kernel-crawler-filtered-out.txt",
+			err: "no builds left after filtering, out of 3",
+		},
+	},
+	{
+		descr: "batch/from-file-validation-error",
+		args: []string{
+			"batch",
+			"-f",
+			"testdata/batch/invalid.json",
+		},
+		expect: expect{
+			out: "testdata/batch-validation-error.txt",
+			err: "exiting for validation errors",
+		},
+	},
+	{
+		descr: "batch/from-file-shared-outputs",
+		args: []string{
+			"batch",
+			"-f",
+			"testdata/batch/shared-outputs.yaml",
+			"--output-module",
+			"/tmp/diginfra.ko",
+		},
+		expect: expect{
+			out: "testdata/batch-shared-outputs.txt",
+			err: "builds 0 and 1 write to the same output /tmp/diginfra.ko, set a distinct output for each build",
+		},
+	},
+	{
+		descr: "batch/invalid-processor",
+		args: []string{
+			"batch",
+			"-f",
+			"testdata/batch/builds.yaml",
+			"--processor",
+			"abc",
+		},
+		expect: expect{
+			out: "testdata/batch-invalid-processor.txt",
+			err: `unsupported processor "abc", one of [docker,kubernetes,kubernetes-in-cluster,local,podman]`,
+		},
+	},
+	{
+		descr: "serve/invalid-processor",
+		args: []string{
+			"serve",
+			"--processor",
+			"abc",
+		},
+		expect: expect{
+			out: "testdata/serve-invalid-processor.txt",
+			err: `unsupported processor "abc", one of [docker,kubernetes,kubernetes-in-cluster,local,podman]`,
+		},
+	},
+	{
+		descr: "cache/ls",
+		args: []string{
+			"cache",
+			"ls",
+			"--build-cache-dir",
+			"testdata/cache",
+		},
+		expect: expect{
+			out: "testdata/cache-ls.txt",
+		},
+	},
+	{
+		descr: "cache/prune",
+		args: []string{
+			"cache",
+			"prune",
+			"--build-cache-dir",
+			"testdata/cache",
+			"--older-than",
+			"24h",
+		},
+		expect: expect{
+			out: "testdata/cache-prune.txt",
+		},
+	},
 	{
 		descr: "complete/docker/targets",
 		args: []string{
@@ -453,3 +652,28 @@ func TestCLI(t *testing.T) {
 		})
 	}
 }
+
+func TestCompleteCustomTargets(t *testing.T) {
+	t.Cleanup(builder.UnloadTargets)
+	config := filepath.Join(t.TempDir(), "config.yaml")
+	assert.NilError(t, os.WriteFile(config, []byte("targets-dir: testdata/targets\n"), 0o644))
+
+	// The targets directory is read from the flags, or else from the config file
+	for _, args := range [][]string{
+		{"__complete", "docker", "--targets-dir", "testdata/targets", "--target", ""},
+		{"__complete", "docker", "--config", config, "--target", ""},
+	} {
+		builder.UnloadTargets()
+		configOpts, err := NewConfigOptions()
+		assert.NilError(t, err)
+		rootOpts, err := NewRootOptions()
+		assert.NilError(t, err)
+		var buf bytes.Buffer
+		configOpts.setOutput(&buf, true)
+		c := NewRootCmd(configOpts, rootOpts)
+		c.SetOutput(&buf)
+		c.SetArgs(args)
+		assert.NilError(t, c.Execute())
+		assert.Assert(t, slices.Contains(strings.Split(buf.String(), "\n"), "acme"), "custom target not completed with %v:\n%s", args, buf.String())
+	}
+}
@@ -31,7 +31,7 @@ import (
 	"github.com/pterm/pterm"
 )
 
-var validProcessors = []string{"docker", "kubernetes", "kubernetes-in-cluster", "local"}
+var validProcessors = []string{"docker", "kubernetes", "kubernetes-in-cluster", "local", "podman"}
 var aliasProcessors = []string{"docker", "k8s", "k8s-ic"}
 
 // ConfigOptions represent the persistent configuration flags of driverkit.
@@ -118,25 +118,12 @@ func (co *ConfigOptions) Init() bool {
 		}
 		configErr = true
 	}
-	if co.configFile != "" {
-		viper.SetConfigFile(co.configFile)
-	} else {
-		// Find home directory.
-		home, err := homedir.Dir()
-		if err != nil {
-			co.Printer.Logger.Error("error getting the home directory",
-				co.Printer.Logger.Args("err", err.Error()))
-			// not setting configErr = true because we fallback to `$HOME/.driverkit.yaml` and try with it
-		}
-
-		viper.AddConfigPath(home)
-		viper.SetConfigName(".driverkit")
+	if err := co.configureViper(viper.GetViper()); err != nil {
+		co.Printer.Logger.Error("error getting the home directory",
+			co.Printer.Logger.Args("err", err.Error()))
+		// not setting configErr = true because we fallback to `$HOME/.driverkit.yaml` and try with it
 	}
 
-	viper.AutomaticEnv()
-	viper.SetEnvPrefix("driverkit")
-	viper.SetEnvKeyReplacer(strings.NewReplacer("-", "_"))
-
 	// If a config file is found, read it in.
 	err := viper.ReadInConfig()
 	// Init printer with either read or existent one,
@@ -154,3 +141,23 @@ func (co *ConfigOptions) Init() bool {
 	}
 	return configErr
 }
+
+// configureViper sets the given viper instance up to read the config file and the environment variables.
+// The error getting the home directory, if any, is returned.
+func (co *ConfigOptions) configureViper(v *viper.Viper) error {
+	var err error
+	if co.configFile != "" {
+		v.SetConfigFile(co.configFile)
+	} else {
+		// Find home directory.
+		var home string
+		home, err = homedir.Dir()
+		v.AddConfigPath(home)
+		v.SetConfigName(".driverkit")
+	}
+
+	v.AutomaticEnv()
+	v.SetEnvPrefix("driverkit")
+	v.SetEnvKeyReplacer(strings.NewReplacer("-", "_"))
+	return err
+}
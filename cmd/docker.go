@@ -48,7 +48,7 @@ func NewDockerCmd(configOpts *ConfigOptions, rootOpts *RootOptions, rootFlags *p
 						configOpts.Printer.DefaultText.Print(buf.String())
 					}()
 				}
-				return driverbuilder.NewDockerBuildProcessor(configOpts.Timeout, configOpts.ProxyURL).Start(b)
+				return rootOpts.StartBuild(c.Context(), driverbuilder.NewDockerBuildProcessor(configOpts.Timeout, configOpts.ProxyURL), b)
 			}
 			return nil
 		},
@@ -44,7 +44,7 @@ func NewImagesCmd(configOpts *ConfigOptions, rootOpts *RootOptions, rootFlags *p
 				b = rootOpts.ToBuild(configOpts.Printer.WithWriter(&buf))
 				configOpts.Printer.Spinner, _ = configOpts.Printer.Spinner.Start("listing images, it will take a few seconds")
 			}
-			b.LoadImages()
+			b.LoadImages(c.Context())
 			if !configOpts.disableStyling {
 				_ = configOpts.Printer.Spinner.Stop()
 				configOpts.Printer.DefaultText.Print(buf.String())
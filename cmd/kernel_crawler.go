@@ -0,0 +1,192 @@
+// SPDX-License-Identifier: Apache-2.0
+/*
+Copyright (C) 2023 The Diginfra Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+
+	"github.com/diginfra/driverkit/pkg/kernelrelease"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	driverModule = "module"
+	driverProbe  = "probe"
+)
+
+var validOutputDrivers = []string{driverModule, driverProbe}
+
+// kernelCrawlerEntry is a kernel listed by the kernel-crawler JSON output.
+type kernelCrawlerEntry struct {
+	Target           string   `yaml:"target"`
+	KernelRelease    string   `yaml:"kernelrelease"`
+	KernelVersion    string   `yaml:"kernelversion"`
+	KernelConfigData string   `yaml:"kernelconfigdata"`
+	Headers          []string `yaml:"headers"`
+}
+
+// kernelCrawlerOptions configures the builds generated from a kernel-crawler file.
+type kernelCrawlerOptions struct {
+	// outputDir is the directory where the drivers of each build are stored.
+	outputDir string
+	// drivers are the kinds of driver built for each kernel.
+	drivers []string
+}
+
+// loadKernelCrawlerFile reads the kernels listed by the given kernel-crawler JSON output,
+// using base as default value for the options not set by each kernel.
+// Both the kernel-crawler output, mapping each architecture to its kernels, and a plain list of kernels are supported;
+// the latter uses the architecture of base.
+func loadKernelCrawlerFile(path string, base *RootOptions, opts kernelCrawlerOptions) ([]*RootOptions, error) {
+	if opts.outputDir == "" {
+		return nil, fmt.Errorf("an output directory is required to build from a kernel-crawler file")
+	}
+	if len(opts.drivers) == 0 {
+		return nil, fmt.Errorf("no output drivers, one or more of %v", validOutputDrivers)
+	}
+	for _, d := range opts.drivers {
+		if !slices.Contains(validOutputDrivers, d) {
+			return nil, fmt.Errorf("unsupported output driver %q, one or more of %v", d, validOutputDrivers)
+		}
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var node yaml.Node
+	if err = yaml.Unmarshal(data, &node); err != nil {
+		return nil, fmt.Errorf("error unmarshalling kernel-crawler file %s: %w", path, err)
+	}
+	byArch := map[string][]kernelCrawlerEntry{}
+	if len(node.Content) > 0 && node.Content[0].Kind == yaml.SequenceNode {
+		var entries []kernelCrawlerEntry
+		err = node.Decode(&entries)
+		byArch[base.Architecture] = entries
+	} else {
+		err = node.Decode(&byArch)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error unmarshalling kernel-crawler file %s: %w", path, err)
+	}
+
+	// Sort architectures, for a stable builds order
+	archs := make([]string, 0, len(byArch))
+	for arch := range byArch {
+		archs = append(archs, arch)
+	}
+	slices.Sort(archs)
+
+	var res []*RootOptions
+	for _, arch := range archs {
+		for _, entry := range byArch[arch] {
+			res = append(res, entry.toRootOptions(base, kernelCrawlerArch(arch), opts))
+		}
+	}
+	if len(res) == 0 {
+		return nil, fmt.Errorf("no kernels found in kernel-crawler file %s", path)
+	}
+	return res, nil
+}
+
+// kernelCrawlerArch returns the driverkit architecture of the given kernel-crawler one, e.g. x86_64.
+func kernelCrawlerArch(arch string) string {
+	for a, nonDeb := range kernelrelease.SupportedArchs {
+		if nonDeb == arch {
+			return a.String()
+		}
+	}
+	return arch
+}
+
+func (e *kernelCrawlerEntry) toRootOptions(base *RootOptions, arch string, opts kernelCrawlerOptions) *RootOptions {
+	ro := *base
+	ro.Target = e.Target
+	ro.KernelRelease = e.KernelRelease
+	if e.KernelVersion != "" {
+		ro.KernelVersion = e.KernelVersion
+	}
+	ro.Architecture = arch
+	if e.KernelConfigData != "" {
+		ro.KernelConfigData = e.KernelConfigData
+	}
+	// Crawled headers urls skip the urls lookup
+	ro.KernelUrls = e.Headers
+
+	// Outputs are named after the kernel, like the drivers published by the Diginfra project
+	name := fmt.Sprintf("%s_%s_%s_%s", ro.ModuleDriverName, ro.Target, ro.KernelRelease, ro.KernelVersion)
+	ro.Output = OutputOptions{}
+	if slices.Contains(opts.drivers, driverModule) {
+		ro.Output.Module = filepath.Join(opts.outputDir, arch, name+".ko")
+	}
+	if slices.Contains(opts.drivers, driverProbe) {
+		ro.Output.Probe = filepath.Join(opts.outputDir, arch, name+".o")
+	}
+	return &ro
+}
+
+// createOutputDirs creates the directories of the outputs of the given builds.
+func createOutputDirs(builds []*RootOptions) error {
+	for _, b := range builds {
+		for _, out := range []string{b.Output.Module, b.Output.Probe} {
+			if out == "" {
+				continue
+			}
+			if err := os.MkdirAll(filepath.Dir(out), 0o755); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// batchFilter selects the builds of a batch.
+type batchFilter struct {
+	targets       []string
+	archs         []string
+	kernelRelease string
+}
+
+// apply returns the builds matching the filter, in order.
+func (f *batchFilter) apply(builds []*RootOptions) ([]*RootOptions, error) {
+	var kernelReleaseRegex *regexp.Regexp
+	if f.kernelRelease != "" {
+		var err error
+		if kernelReleaseRegex, err = regexp.Compile(f.kernelRelease); err != nil {
+			return nil, fmt.Errorf("invalid kernel release filter: %w", err)
+		}
+	}
+	var res []*RootOptions
+	for _, b := range builds {
+		if len(f.targets) > 0 && !slices.Contains(f.targets, b.Target) {
+			continue
+		}
+		if len(f.archs) > 0 && !slices.Contains(f.archs, b.Architecture) && !slices.Contains(f.archs, kernelrelease.Architecture(b.Architecture).ToNonDeb()) {
+			continue
+		}
+		if kernelReleaseRegex != nil && !kernelReleaseRegex.MatchString(b.KernelRelease) {
+			continue
+		}
+		res = append(res, b)
+	}
+	if len(res) == 0 {
+		return nil, fmt.Errorf("no builds left after filtering, out of %d", len(builds))
+	}
+	return res, nil
+}
@@ -0,0 +1,82 @@
+// SPDX-License-Identifier: Apache-2.0
+/*
+Copyright (C) 2023 The Diginfra Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"gotest.tools/assert"
+)
+
+func TestLoadKernelCrawlerFile(t *testing.T) {
+	base, err := NewRootOptions()
+	assert.NilError(t, err)
+	base.Architecture = "amd64"
+	opts := kernelCrawlerOptions{outputDir: "/tmp/drivers", drivers: []string{driverModule, driverProbe}}
+
+	builds, err := loadKernelCrawlerFile("testdata/batch/kernel-crawler.json", base, opts)
+	assert.NilError(t, err)
+	assert.Equal(t, len(builds), 3)
+	// Architectures are sorted, kernels keep the crawled order
+	assert.Equal(t, builds[0].Architecture, "arm64")
+	assert.Equal(t, builds[0].Target, "fedora")
+	assert.Equal(t, builds[1].Architecture, "amd64")
+	assert.Equal(t, builds[1].Target, "ubuntu-aws")
+	assert.Equal(t, builds[1].KernelVersion, "59")
+	assert.Equal(t, len(builds[1].KernelUrls), 2)
+	assert.Equal(t, builds[1].Output.Module, "/tmp/drivers/amd64/diginfra_ubuntu-aws_4.15.0-1057-aws_59.ko")
+	assert.Equal(t, builds[1].Output.Probe, "/tmp/drivers/amd64/diginfra_ubuntu-aws_4.15.0-1057-aws_59.o")
+	// Base options are not modified
+	assert.Equal(t, len(base.KernelUrls), 0)
+
+	// Plain list of kernels, using the base architecture
+	path := filepath.Join(t.TempDir(), "kernels.json")
+	assert.NilError(t, os.WriteFile(path, []byte(`[{"target": "centos", "kernelrelease": "3.10.0-957.12.2.el7.x86_64", "kernelversion": "1"}]`), 0o600))
+	builds, err = loadKernelCrawlerFile(path, base, kernelCrawlerOptions{outputDir: "/tmp/drivers", drivers: []string{driverModule}})
+	assert.NilError(t, err)
+	assert.Equal(t, len(builds), 1)
+	assert.Equal(t, builds[0].Architecture, "amd64")
+	assert.Equal(t, builds[0].Output.Probe, "")
+	assert.Assert(t, builds[0].KernelUrls == nil)
+
+	_, err = loadKernelCrawlerFile(path, base, kernelCrawlerOptions{outputDir: "/tmp/drivers", drivers: []string{"foo"}})
+	assert.ErrorContains(t, err, `unsupported output driver "foo"`)
+}
+
+func TestBatchFilter(t *testing.T) {
+	base, err := NewRootOptions()
+	assert.NilError(t, err)
+	builds, err := loadKernelCrawlerFile("testdata/batch/kernel-crawler.json", base,
+		kernelCrawlerOptions{outputDir: "/tmp/drivers", drivers: []string{driverModule}})
+	assert.NilError(t, err)
+
+	filter := batchFilter{targets: []string{"centos", "fedora"}, archs: []string{"x86_64"}}
+	filtered, err := filter.apply(builds)
+	assert.NilError(t, err)
+	assert.Equal(t, len(filtered), 1)
+	assert.Equal(t, filtered[0].Target, "centos")
+
+	filter = batchFilter{kernelRelease: `\.fc35\.`}
+	filtered, err = filter.apply(builds)
+	assert.NilError(t, err)
+	assert.Equal(t, len(filtered), 1)
+	assert.Equal(t, filtered[0].Target, "fedora")
+
+	filter = batchFilter{kernelRelease: "("}
+	_, err = filter.apply(builds)
+	assert.ErrorContains(t, err, "invalid kernel release filter")
+}
@@ -16,6 +16,7 @@ package cmd
 
 import (
 	"bytes"
+	"context"
 	"github.com/diginfra/driverkit/pkg/driverbuilder/builder"
 	"regexp"
 	"strings"
@@ -25,6 +26,8 @@ import (
 	"github.com/spf13/cobra"
 	"github.com/spf13/pflag"
 	"k8s.io/cli-runtime/pkg/genericclioptions"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
 )
 
 // NewKubernetesCmd creates the `driverkit kubernetes` command.
@@ -36,18 +39,7 @@ func NewKubernetesCmd(configOpts *ConfigOptions, rootOpts *RootOptions, rootFlag
 	}
 
 	// Add Kubernetes client flags
-	configFlags := genericclioptions.NewConfigFlags(false)
-	configFlags.AddFlags(kubernetesCmd.PersistentFlags())
-	// Some styling to make Kubernetes client flags look like they were ours
-	dotEndingRegexp := regexp.MustCompile(`\.$`)
-	upperAfterPointRegexp := regexp.MustCompile(`\. ([A-Z0-9])`)
-	upperAfterCommaRegexp := regexp.MustCompile(`, ([A-Z0-9])`)
-	kubernetesCmd.PersistentFlags().VisitAll(func(f *pflag.Flag) {
-		f.Usage = strings.ToLower(f.Usage[:1]) + f.Usage[1:]
-		f.Usage = dotEndingRegexp.ReplaceAllString(f.Usage, "")
-		f.Usage = upperAfterPointRegexp.ReplaceAllString(f.Usage, ", ${1}")
-		f.Usage = upperAfterCommaRegexp.ReplaceAllStringFunc(f.Usage, strings.ToLower)
-	})
+	configFlags := addKubernetesConfigFlags(kubernetesCmd.PersistentFlags())
 	// Add Kubernetes pods options flags
 	flags := kubernetesCmd.Flags()
 	addKubernetesFlags(flags)
@@ -55,8 +47,6 @@ func NewKubernetesCmd(configOpts *ConfigOptions, rootOpts *RootOptions, rootFlag
 	// Add root flags
 	kubernetesCmd.PersistentFlags().AddFlagSet(rootFlags)
 
-	kubefactory := factory.NewFactory(configFlags)
-
 	kubernetesCmd.RunE = func(c *cobra.Command, args []string) error {
 		configOpts.Printer.Logger.Info("starting build",
 			configOpts.Printer.Logger.Args("processor", c.Name()))
@@ -78,7 +68,7 @@ func NewKubernetesCmd(configOpts *ConfigOptions, rootOpts *RootOptions, rootFlag
 					configOpts.Printer.DefaultText.Print(buf.String())
 				}()
 			}
-			return kubernetesRun(kubefactory, b, configOpts)
+			return kubernetesRun(c.Context(), configFlags, b, configOpts, rootOpts)
 		}
 		return nil
 	}
@@ -86,28 +76,75 @@ func NewKubernetesCmd(configOpts *ConfigOptions, rootOpts *RootOptions, rootFlag
 	return kubernetesCmd
 }
 
-func kubernetesRun(kubefactory factory.Factory,
+// addKubernetesConfigFlags registers the Kubernetes client flags into the given flagset.
+func addKubernetesConfigFlags(flags *pflag.FlagSet) *genericclioptions.ConfigFlags {
+	configFlags := genericclioptions.NewConfigFlags(false)
+	configFlags.AddFlags(flags)
+	// Some styling to make Kubernetes client flags look like they were ours
+	dotEndingRegexp := regexp.MustCompile(`\.$`)
+	upperAfterPointRegexp := regexp.MustCompile(`\. ([A-Z0-9])`)
+	upperAfterCommaRegexp := regexp.MustCompile(`, ([A-Z0-9])`)
+	flags.VisitAll(func(f *pflag.Flag) {
+		f.Usage = strings.ToLower(f.Usage[:1]) + f.Usage[1:]
+		f.Usage = dotEndingRegexp.ReplaceAllString(f.Usage, "")
+		f.Usage = upperAfterPointRegexp.ReplaceAllString(f.Usage, ", ${1}")
+		f.Usage = upperAfterCommaRegexp.ReplaceAllStringFunc(f.Usage, strings.ToLower)
+	})
+	return configFlags
+}
+
+func kubernetesRun(ctx context.Context,
+	configFlags *genericclioptions.ConfigFlags,
 	b *builder.Build,
 	configOpts *ConfigOptions,
+	rootOpts *RootOptions,
 ) error {
-	kc, err := kubefactory.KubernetesClientSet()
+	kubefactory, err := factory.NewFactory(configFlags)
 	if err != nil {
 		return err
 	}
-	clientConfig, err := kubefactory.ToRESTConfig()
+	buildProcessor, err := newKubernetesBuildProcessor(kubefactory, configOpts)
 	if err != nil {
 		return err
 	}
+	return rootOpts.StartBuild(ctx, buildProcessor, b)
+}
+
+func newKubernetesBuildProcessor(kubefactory factory.Factory, configOpts *ConfigOptions) (driverbuilder.BuildProcessor, error) {
+	kc, err := kubefactory.KubernetesClientSet()
+	if err != nil {
+		return nil, err
+	}
+	clientConfig, err := kubefactory.ToRESTConfig()
+	if err != nil {
+		return nil, err
+	}
 	if err := factory.SetKubernetesDefaults(clientConfig); err != nil {
-		return err
+		return nil, err
 	}
 
-	buildProcessor := driverbuilder.NewKubernetesBuildProcessor(kc.CoreV1(),
+	return newKubernetesProcessor(kc, clientConfig, configOpts), nil
+}
+
+// newKubernetesProcessor returns either a pod or a job based build processor, depending on the kubernetes options.
+func newKubernetesProcessor(kc kubernetes.Interface, clientConfig *rest.Config, configOpts *ConfigOptions) driverbuilder.BuildProcessor {
+	if kubernetesOptions.UseJob {
+		return driverbuilder.NewKubernetesJobBuildProcessor(kc.CoreV1(),
+			kc.BatchV1(),
+			clientConfig,
+			kubernetesOptions.RunAsUser,
+			kubernetesOptions.Namespace,
+			kubernetesOptions.ImagePullSecret,
+			configOpts.Timeout,
+			configOpts.ProxyURL,
+			kubernetesOptions.JobBackoffLimit,
+			kubernetesOptions.JobTTLSeconds)
+	}
+	return driverbuilder.NewKubernetesBuildProcessor(kc.CoreV1(),
 		clientConfig,
 		kubernetesOptions.RunAsUser,
 		kubernetesOptions.Namespace,
 		kubernetesOptions.ImagePullSecret,
 		configOpts.Timeout,
 		configOpts.ProxyURL)
-	return buildProcessor.Start(b)
 }
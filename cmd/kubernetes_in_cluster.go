@@ -16,6 +16,7 @@ package cmd
 
 import (
 	"bytes"
+	"context"
 	"github.com/diginfra/driverkit/pkg/driverbuilder"
 	"github.com/diginfra/driverkit/pkg/driverbuilder/builder"
 	"github.com/diginfra/driverkit/pkg/kubernetes/factory"
@@ -61,7 +62,7 @@ func NewKubernetesInClusterCmd(configOpts *ConfigOptions, rootOpts *RootOptions,
 					configOpts.Printer.DefaultText.Print(buf.String())
 				}()
 			}
-			return kubernetesInClusterRun(b, configOpts)
+			return kubernetesInClusterRun(c.Context(), b, configOpts, rootOpts)
 		}
 		return nil
 	}
@@ -69,26 +70,27 @@ func NewKubernetesInClusterCmd(configOpts *ConfigOptions, rootOpts *RootOptions,
 	return kubernetesInClusterCmd
 }
 
-func kubernetesInClusterRun(b *builder.Build, configOpts *ConfigOptions) error {
-	kubeConfig, err := rest.InClusterConfig()
+func kubernetesInClusterRun(ctx context.Context, b *builder.Build, configOpts *ConfigOptions, rootOpts *RootOptions) error {
+	buildProcessor, err := newKubernetesInClusterBuildProcessor(configOpts)
 	if err != nil {
 		return err
 	}
+	return rootOpts.StartBuild(ctx, buildProcessor, b)
+}
+
+func newKubernetesInClusterBuildProcessor(configOpts *ConfigOptions) (driverbuilder.BuildProcessor, error) {
+	kubeConfig, err := rest.InClusterConfig()
+	if err != nil {
+		return nil, err
+	}
 	if err = factory.SetKubernetesDefaults(kubeConfig); err != nil {
-		return err
+		return nil, err
 	}
 
 	kc, err := kubernetes.NewForConfig(kubeConfig)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	buildProcessor := driverbuilder.NewKubernetesBuildProcessor(kc.CoreV1(),
-		kubeConfig,
-		kubernetesOptions.RunAsUser,
-		kubernetesOptions.Namespace,
-		kubernetesOptions.ImagePullSecret,
-		configOpts.Timeout,
-		configOpts.ProxyURL)
-	return buildProcessor.Start(b)
+	return newKubernetesProcessor(kc, kubeConfig, configOpts), nil
 }
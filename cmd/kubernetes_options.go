@@ -22,10 +22,16 @@ type KubeOptions struct {
 	RunAsUser       int64  `json:"runAsUser,omitempty" protobuf:"varint,2,opt,name=runAsUser" default:"0"`
 	Namespace       string `validate:"required" name:"namespace" default:"default"`
 	ImagePullSecret string `validate:"omitempty" name:"image-pull-secret" default:""`
+	UseJob          bool   `name:"job" default:"false"`
+	JobBackoffLimit int32  `validate:"min=0" name:"job-backoff-limit" default:"3"`
+	JobTTLSeconds   int32  `validate:"min=0" name:"job-ttl-seconds" default:"300"`
 }
 
 func addKubernetesFlags(flags *flag.FlagSet) {
 	flags.StringVarP(&kubernetesOptions.Namespace, "namespace", "n", "default", "If present, the namespace scope for the pods and its config ")
 	flags.Int64Var(&kubernetesOptions.RunAsUser, "run-as-user", 0, "Pods runner user")
 	flags.StringVar(&kubernetesOptions.ImagePullSecret, "image-pull-secret", "", "ImagePullSecret")
+	flags.BoolVar(&kubernetesOptions.UseJob, "job", false, "Run the build as a batch/v1 Job, retried upon failures and pod evictions")
+	flags.Int32Var(&kubernetesOptions.JobBackoffLimit, "job-backoff-limit", 3, "Number of retries of the build Job before marking it as failed")
+	flags.Int32Var(&kubernetesOptions.JobTTLSeconds, "job-ttl-seconds", 300, "Seconds after which the finished build Job gets deleted")
 }
@@ -42,12 +42,7 @@ func NewLocalCmd(configOpts *ConfigOptions, rootOpts *RootOptions, rootFlags *pf
 						configOpts.Printer.DefaultText.Print(buf.String())
 					}()
 				}
-				return driverbuilder.NewLocalBuildProcessor(opts.useDKMS,
-					opts.downloadHeaders,
-					false,
-					opts.srcDir,
-					opts.envMap,
-					configOpts.Timeout).Start(b)
+				return rootOpts.StartBuild(c.Context(), newLocalBuildProcessor(opts, configOpts), b)
 			}
 			return nil
 		},
@@ -72,10 +67,23 @@ func NewLocalCmd(configOpts *ConfigOptions, rootOpts *RootOptions, rootFlags *pf
 			flagSet.AddFlag(flag)
 		}
 	})
+	addLocalFlags(flagSet, &opts)
+	localCmd.PersistentFlags().AddFlagSet(flagSet)
+	return localCmd
+}
+
+func addLocalFlags(flagSet *pflag.FlagSet, opts *localCmdOptions) {
 	flagSet.BoolVar(&opts.useDKMS, "dkms", false, "Enforce usage of DKMS to build the kernel module.")
 	flagSet.BoolVar(&opts.downloadHeaders, "download-headers", false, "Try to automatically download kernel headers.")
 	flagSet.StringVar(&opts.srcDir, "src-dir", "", "Enforce usage of local source dir to build drivers.")
 	flagSet.StringToStringVar(&opts.envMap, "env", make(map[string]string), "Env variables to be enforced during the driver build.")
-	localCmd.PersistentFlags().AddFlagSet(flagSet)
-	return localCmd
+}
+
+func newLocalBuildProcessor(opts localCmdOptions, configOpts *ConfigOptions) *driverbuilder.LocalBuildProcessor {
+	return driverbuilder.NewLocalBuildProcessor(opts.useDKMS,
+		opts.downloadHeaders,
+		false,
+		opts.srcDir,
+		opts.envMap,
+		configOpts.Timeout)
 }
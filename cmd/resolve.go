@@ -0,0 +1,124 @@
+// SPDX-License-Identifier: Apache-2.0
+/*
+Copyright (C) 2023 The Diginfra Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/diginfra/driverkit/pkg/driverbuilder/builder"
+	"github.com/olekukonko/tablewriter"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+var resolveFormats = []string{"table", "json"}
+
+// resolveResult is the output of the `driverkit resolve` command.
+type resolveResult struct {
+	Target        string `json:"target"`
+	KernelRelease string `json:"kernelRelease"`
+	KernelVersion string `json:"kernelVersion"`
+	Architecture  string `json:"architecture"`
+	*builder.KernelURLsResolution
+	Error string `json:"error,omitempty"`
+}
+
+// NewResolveCmd creates the `driverkit resolve` command.
+func NewResolveCmd(configOpts *ConfigOptions, rootOpts *RootOptions, rootFlags *pflag.FlagSet) *cobra.Command {
+	format := resolveFormats[0]
+	resolveCmd := &cobra.Command{
+		Use:   "resolve",
+		Short: "Resolve the kernel headers urls without building anything",
+		Args:  cobra.NoArgs,
+		RunE: func(c *cobra.Command, args []string) error {
+			if format != "table" && format != "json" {
+				return fmt.Errorf("unsupported format %q, one of %v", format, resolveFormats)
+			}
+			// Logs share the output with the result: keep them at debug level, not to break the JSON format
+			configOpts.Printer.Logger.Debug("resolving kernel headers urls",
+				configOpts.Printer.Logger.Args("target", rootOpts.Target, "kernelrelease", rootOpts.KernelRelease))
+			if configOpts.dryRun {
+				return nil
+			}
+
+			b := rootOpts.ToBuild(configOpts.Printer)
+			kr, err := b.KernelReleaseFromBuildConfig()
+			if err != nil {
+				return err
+			}
+			v, err := builder.Factory(b.TargetType)
+			if err != nil {
+				return err
+			}
+			ctx := builder.WithMirrors(c.Context(), b.Mirrors)
+			resolution, err := builder.ResolveKernelURLs(ctx, v, b.KernelUrls, kr)
+
+			res := resolveResult{
+				Target:               b.TargetType.String(),
+				KernelRelease:        b.KernelRelease,
+				KernelVersion:        b.KernelVersion,
+				Architecture:         b.Architecture,
+				KernelURLsResolution: resolution,
+			}
+			if err != nil {
+				res.Error = err.Error()
+			}
+			if format == "json" {
+				if printErr := printResolveJSON(c.OutOrStdout(), &res); printErr != nil {
+					return printErr
+				}
+			} else {
+				printResolveTable(c.OutOrStdout(), &res)
+			}
+			return err
+		},
+	}
+	resolveCmd.Flags().StringVar(&format, "format", format, fmt.Sprintf("output format, one of %v", resolveFormats))
+	// Add root flags
+	resolveCmd.PersistentFlags().AddFlagSet(rootFlags)
+
+	return resolveCmd
+}
+
+func printResolveJSON(w io.Writer, res *resolveResult) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(res)
+}
+
+func printResolveTable(w io.Writer, res *resolveResult) {
+	selected := make(map[string]bool, len(res.URLs))
+	for _, u := range res.URLs {
+		selected[u] = true
+	}
+	table := tablewriter.NewWriter(w)
+	table.SetHeader([]string{"URL", "Status", "Selected"})
+	table.SetBorders(tablewriter.Border{Left: true, Top: false, Right: true, Bottom: false})
+	table.SetCenterSeparator("|")
+	table.SetAutoWrapText(false)
+	for _, candidate := range res.Candidates {
+		status := candidate.Error
+		if candidate.StatusCode != 0 {
+			status = strconv.Itoa(candidate.StatusCode)
+		} else if candidate.Local && status == "" {
+			status = "local"
+		}
+		table.Append([]string{candidate.URL, status, strconv.FormatBool(selected[candidate.URL])})
+	}
+	table.Render()
+}
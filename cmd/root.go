@@ -15,6 +15,7 @@ limitations under the License.
 package cmd
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
@@ -23,7 +24,9 @@ import (
 	"strings"
 
 	"github.com/diginfra/driverkit/pkg/driverbuilder/builder"
+	"github.com/diginfra/driverkit/pkg/httpclient"
 	"github.com/diginfra/driverkit/pkg/kernelrelease"
+	"github.com/diginfra/driverkit/pkg/signals"
 	"github.com/diginfra/driverkit/pkg/version"
 	"github.com/spf13/cobra"
 	"github.com/spf13/pflag"
@@ -39,6 +42,14 @@ func persistentValidateFunc(rootCommand *RootCmd, configOpts *ConfigOptions, roo
 		if configErr {
 			return validationError
 		}
+		// Every kernel headers lookup and download goes through the shared HTTP client, honoring the proxy
+		httpClient, err := httpclient.New(httpclient.Options{ProxyURL: configOpts.ProxyURL, Retries: httpclient.DefaultRetries})
+		if err != nil {
+			configOpts.Printer.Logger.Error("error configuring http client",
+				configOpts.Printer.Logger.Args("err", err.Error()))
+			return validationError
+		}
+		httpclient.SetDefault(httpClient)
 		// Merge environment variables or config file values into the RootOptions instance
 		skip := map[string]bool{ // do not merge these
 			"config":   true,
@@ -82,12 +93,37 @@ func persistentValidateFunc(rootCommand *RootCmd, configOpts *ConfigOptions, roo
 			}
 		})
 
+		// User-defined targets must be registered before validating the target,
+		// completing it included
+		if rootOpts.TargetsDir != "" {
+			if err := builder.LoadTargets(rootOpts.TargetsDir); err != nil {
+				configOpts.Printer.Logger.Error("error loading targets",
+					configOpts.Printer.Logger.Args("err", err.Error()))
+				return validationError
+			}
+		}
+
+		// Mirrors have no flag, they can only be set from the config file
+		if err := viper.UnmarshalKey("mirrors", &rootOpts.Mirrors); err != nil {
+			configOpts.Printer.Logger.Error("error reading mirrors",
+				configOpts.Printer.Logger.Args("err", err.Error()))
+			return validationError
+		}
+
 		// Avoid sensitive info into default values help line
 		rootCommand.StripSensitive()
 
 		// Do not block root or help command to exec disregarding the root flags validity
-		if c.Root() != c && c.Name() != "help" && c.Name() != "__complete" && c.Name() != "__completeNoDesc" && c.Name() != "completion" {
-			if errs := rootOpts.Validate(); errs != nil {
+		// Batch and serve commands validate the options of each build by themselves
+		// Cache commands do not build anything
+		if c.Root() != c && c.Name() != "help" && c.Name() != "__complete" && c.Name() != "__completeNoDesc" && c.Name() != "completion" && c.Name() != "batch" && c.Name() != "serve" &&
+			c.Name() != "cache" && c.Parent().Name() != "cache" {
+			validateFunc := rootOpts.Validate
+			// Resolve command does not build any driver
+			if c.Name() == "resolve" {
+				validateFunc = rootOpts.ValidateWithoutOutputs
+			}
+			if errs := validateFunc(); errs != nil {
 				for _, err := range errs {
 					configOpts.Printer.Logger.Error("error validating build options",
 						configOpts.Printer.Logger.Args("err", err.Error()))
@@ -100,6 +136,19 @@ func persistentValidateFunc(rootCommand *RootCmd, configOpts *ConfigOptions, roo
 	}
 }
 
+// completionTargetsDir returns the directory of the user-defined targets while completing,
+// from the flags, or else from the environment variables or the config file,
+// since the completion commands do not merge them into the root options.
+func completionTargetsDir(configOpts *ConfigOptions, rootOpts *RootOptions) string {
+	if rootOpts.TargetsDir != "" {
+		return rootOpts.TargetsDir
+	}
+	v := viper.New()
+	_ = configOpts.configureViper(v)
+	_ = v.ReadInConfig()
+	return v.GetString("targets-dir")
+}
+
 // RootCmd wraps the main cobra.Command.
 type RootCmd struct {
 	c *cobra.Command
@@ -147,7 +196,14 @@ func NewRootCmd(configOpts *ConfigOptions, rootOpts *RootOptions) *RootCmd {
 	// Flag annotations and custom completions
 	_ = rootCmd.MarkFlagFilename("config", viper.SupportedExts...)
 	_ = rootCmd.RegisterFlagCompletionFunc("target", func(c *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
-		return targets, cobra.ShellCompDirectiveDefault
+		// Listed at completion time, to include the user-defined targets
+		if dir := completionTargetsDir(configOpts, rootOpts); dir != "" {
+			// Errors cannot be reported while completing, the builtin targets are still listed
+			_ = builder.LoadTargets(dir)
+		}
+		res := builder.Targets()
+		sort.Strings(res)
+		return res, cobra.ShellCompDirectiveDefault
 	})
 	_ = rootCmd.RegisterFlagCompletionFunc("architecture", func(c *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
 		return kernelrelease.SupportedArchs.Strings(), cobra.ShellCompDirectiveDefault
@@ -157,8 +213,13 @@ func NewRootCmd(configOpts *ConfigOptions, rootOpts *RootOptions) *RootCmd {
 	rootCmd.AddCommand(NewKubernetesCmd(configOpts, rootOpts, flags))
 	rootCmd.AddCommand(NewKubernetesInClusterCmd(configOpts, rootOpts, flags))
 	rootCmd.AddCommand(NewDockerCmd(configOpts, rootOpts, flags))
+	rootCmd.AddCommand(NewPodmanCmd(configOpts, rootOpts, flags))
 	rootCmd.AddCommand(NewLocalCmd(configOpts, rootOpts, flags))
+	rootCmd.AddCommand(NewBatchCmd(configOpts, rootOpts, flags))
+	rootCmd.AddCommand(NewServeCmd(configOpts, rootOpts, flags))
+	rootCmd.AddCommand(NewCacheCmd(configOpts, rootOpts, flags))
 	rootCmd.AddCommand(NewImagesCmd(configOpts, rootOpts, flags))
+	rootCmd.AddCommand(NewResolveCmd(configOpts, rootOpts, flags))
 	rootCmd.AddCommand(NewCompletionCmd(configOpts, rootOpts, flags))
 
 	ret.StripSensitive()
@@ -175,10 +236,12 @@ var Sensitive = []string{
 func (r *RootCmd) StripSensitive() {
 	for _, s := range Sensitive {
 		homeDir := os.Getenv(s)
+		strip := func(f *pflag.Flag) {
+			f.DefValue = strings.ReplaceAll(f.DefValue, homeDir, fmt.Sprintf("$%s", s))
+		}
+		r.c.Flags().VisitAll(strip)
 		for _, childCommand := range r.c.Commands() {
-			childCommand.Flags().VisitAll(func(f *pflag.Flag) {
-				f.DefValue = strings.ReplaceAll(f.DefValue, homeDir, fmt.Sprintf("$%s", s))
-			})
+			childCommand.Flags().VisitAll(strip)
 		}
 	}
 }
@@ -204,6 +267,12 @@ func (r *RootCmd) Execute() error {
 	return r.c.Execute()
 }
 
+// ExecuteContext proxies the cobra.Command execution with the given context,
+// that commands use to cancel the builds.
+func (r *RootCmd) ExecuteContext(ctx context.Context) error {
+	return r.c.ExecuteContext(ctx)
+}
+
 // Start creates the root command and runs it.
 func Start() {
 	configOpts, err := NewConfigOptions()
@@ -229,7 +298,9 @@ func Start() {
 		}
 	}()
 	root := NewRootCmd(configOpts, rootOpts)
-	if err = root.Execute(); err != nil {
+	// Builds are canceled upon SIGINT and SIGTERM
+	ctx := signals.WithStandardSignals(context.Background())
+	if err = root.ExecuteContext(ctx); err != nil {
 		configOpts.Printer.Logger.Fatal("error executing driverkit", configOpts.Printer.Logger.Args("err", err.Error()))
 	}
 }
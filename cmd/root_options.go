@@ -15,19 +15,26 @@ limitations under the License.
 package cmd
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/diginfra/diginfractl/pkg/output"
+	"github.com/mitchellh/go-homedir"
 	"github.com/spf13/pflag"
 	"os"
+	"path/filepath"
 	"runtime"
 	"strings"
 
 	"github.com/creasty/defaults"
+	"github.com/diginfra/driverkit/pkg/driverbuilder"
 	"github.com/diginfra/driverkit/pkg/driverbuilder/builder"
 	"github.com/diginfra/driverkit/pkg/kernelrelease"
+	"github.com/diginfra/driverkit/pkg/modsign"
 	"github.com/diginfra/driverkit/validate"
 	"github.com/go-playground/validator/v10"
+	"oras.land/oras-go/v2/registry"
 )
 
 // OutputOptions wraps the two drivers that driverkit builds.
@@ -45,6 +52,25 @@ type RepoOptions struct {
 	Name string `default:"libs" name:"repo name"`
 }
 
+// BuildCacheOptions configures the local cache of the built drivers.
+type BuildCacheOptions struct {
+	Enabled bool   `default:"false" name:"build cache"`
+	Dir     string `validate:"required_if=Enabled true" name:"build cache directory"`
+}
+
+// HeadersCacheOptions configures the local cache of the downloaded kernel headers.
+type HeadersCacheOptions struct {
+	Enabled bool   `default:"false" name:"headers cache"`
+	Dir     string `validate:"required_if=Enabled true" name:"headers cache directory"`
+}
+
+// SignOptions configures the signing of the built kernel module.
+type SignOptions struct {
+	Key  string `validate:"required_with=Cert,omitempty,file" name:"module signing key"`
+	Cert string `validate:"required_with=Key,omitempty,file" name:"module signing certificate"`
+	Hash string `default:"sha256" validate:"oneof=sha1 sha224 sha256 sha384 sha512" name:"module signing hash algorithm"`
+}
+
 type Registry struct {
 	Name      string `validate:"required_with=Username Password" name:"registry name"`
 	Username  string `validate:"required_with=Registry Password" name:"registry username"`
@@ -61,6 +87,7 @@ type RootOptions struct {
 	ModuleDeviceName string   `default:"diginfra" validate:"excludes=/,max=255" name:"kernel module device name"`
 	KernelRelease    string   `validate:"required,ascii" name:"kernel release"`
 	Target           string   `validate:"required,target" name:"target"`
+	TargetsDir       string   `name:"targets directory"`
 	KernelConfigData string   `validate:"omitempty,base64" name:"kernel config data"` // fixme > tag "name" does not seem to work when used at struct level, but works when used at inner level
 	BuilderImage     string   `validate:"omitempty,imagename" name:"builder image"`
 	BuilderRepos     []string `default:"[\"docker.io/diginfra/driverkit-builder\"]" validate:"omitempty" name:"docker repositories to look for builder images or absolute path pointing to a yaml file containing builder images index"`
@@ -69,6 +96,12 @@ type RootOptions struct {
 	Repo             RepoOptions
 	Output           OutputOptions
 	Registry         Registry
+	BuildCache       BuildCacheOptions
+	HeadersCache     HeadersCacheOptions
+	Sign             SignOptions
+	ReportFile       string `validate:"omitempty,filepath" name:"report file"`
+	PushOCI          string `name:"oci repository"`
+	Mirrors          builder.Mirrors
 }
 
 func init() {
@@ -81,12 +114,29 @@ func NewRootOptions() (*RootOptions, error) {
 	if err := defaults.Set(rootOpts); err != nil {
 		return nil, err
 	}
+	home, err := homedir.Dir()
+	if err != nil {
+		return nil, err
+	}
+	rootOpts.BuildCache.Dir = filepath.Join(home, ".cache", "driverkit")
+	rootOpts.HeadersCache.Dir = filepath.Join(home, ".cache", "driverkit-headers")
 	return rootOpts, nil
 }
 
 // Validate validates the RootOptions fields.
 func (ro *RootOptions) Validate() []error {
-	if err := validate.V.Struct(ro); err != nil {
+	return ro.validate(validate.V.Struct(ro))
+}
+
+// ValidateWithoutOutputs validates the RootOptions fields but the outputs,
+// for commands not building any driver.
+func (ro *RootOptions) ValidateWithoutOutputs() []error {
+	return ro.validate(validate.V.StructExcept(ro, "Output"))
+}
+
+// validate translates the given struct validation error, then validates the remaining RootOptions fields.
+func (ro *RootOptions) validate(err error) []error {
+	if err != nil {
 		var errs validator.ValidationErrors
 		errors.As(err, &errs)
 		errArr := []error{}
@@ -97,8 +147,25 @@ func (ro *RootOptions) Validate() []error {
 		return errArr
 	}
 
+	if err := ro.Mirrors.Validate(); err != nil {
+		return []error{err}
+	}
+
+	if ro.PushOCI != "" {
+		ref, err := registry.ParseReference(ro.PushOCI)
+		if err != nil {
+			return []error{fmt.Errorf("invalid oci repository: %w", err)}
+		}
+		if ref.Reference != "" {
+			return []error{fmt.Errorf("invalid oci repository %q: the tag is derived from the build options", ro.PushOCI)}
+		}
+	}
+
 	// check that the kernel versions supports at least one of probe and module
-	kr := kernelrelease.FromString(ro.KernelRelease)
+	kr, err := kernelrelease.Parse(ro.KernelRelease)
+	if err != nil {
+		return []error{err}
+	}
 	kr.Architecture = kernelrelease.Architecture(ro.Architecture)
 	if !kr.SupportsModule() && !kr.SupportsProbe() {
 		return []error{fmt.Errorf("both module and probe are not supported by given options")}
@@ -115,6 +182,7 @@ func (ro *RootOptions) AddFlags(flags *pflag.FlagSet, targets []string) {
 	flags.StringVar(&ro.KernelVersion, "kernelversion", ro.KernelVersion, "kernel version to build the module for, it's the numeric value after the hash when you execute 'uname -v'")
 	flags.StringVar(&ro.KernelRelease, "kernelrelease", ro.KernelRelease, "kernel release to build the module for, it can be found by executing 'uname -v'")
 	flags.StringVarP(&ro.Target, "target", "t", ro.Target, "the system to target the build for, one of ["+strings.Join(targets, ",")+"]")
+	flags.StringVar(&ro.TargetsDir, "targets-dir", ro.TargetsDir, "directory of YAML target definitions to register as additional targets, one per YAML file")
 	flags.StringVar(&ro.KernelConfigData, "kernelconfigdata", ro.KernelConfigData, "base64 encoded kernel config data: in some systems it can be found under the /boot directory, in other it is gzip compressed under /proc")
 	flags.StringVar(&ro.ModuleDeviceName, "moduledevicename", ro.ModuleDeviceName, "kernel module device name (the default is diginfra, so the device will be under /dev/diginfra*)")
 	flags.StringVar(&ro.ModuleDriverName, "moduledrivername", ro.ModuleDriverName, "kernel module driver name, i.e. the name you see when you check installed modules via lsmod")
@@ -122,7 +190,7 @@ func (ro *RootOptions) AddFlags(flags *pflag.FlagSet, targets []string) {
 	flags.StringSliceVar(&ro.BuilderRepos, "builderrepo", ro.BuilderRepos, "list of docker repositories or yaml file (absolute path) containing builder images index with the format 'images: [ { target:<target>, name:<image-name>, arch: <arch>, tag: <imagetag>, gcc_versions: [ <gcc-tag> ] },...]', in descending priority order. Used to search for builder images. eg: --builderrepo myorg/driverkit-builder --builderrepo diginfra/driverkit-builder --builderrepo '/path/to/my/index.yaml'.")
 	flags.StringVar(&ro.GCCVersion, "gccversion", ro.GCCVersion, "enforce a specific gcc version for the build")
 
-	flags.StringSliceVar(&ro.KernelUrls, "kernelurls", nil, "list of kernel header urls (e.g. --kernelurls <URL1> --kernelurls <URL2> --kernelurls \"<URL3>,<URL4>\")")
+	flags.StringSliceVar(&ro.KernelUrls, "kernelurls", nil, "list of kernel header urls or local paths to kernel header packages, for offline builds; append #sha256=<digest> to verify a package (e.g. --kernelurls <URL1> --kernelurls <URL2> --kernelurls \"<URL3>,<URL4>\")")
 
 	flags.StringVar(&ro.Repo.Org, "repo-org", ro.Repo.Org, "repository github organization")
 	flags.StringVar(&ro.Repo.Name, "repo-name", ro.Repo.Name, "repository github name")
@@ -131,6 +199,19 @@ func (ro *RootOptions) AddFlags(flags *pflag.FlagSet, targets []string) {
 	flags.StringVar(&ro.Registry.Username, "registry-user", ro.Registry.Username, "registry username")
 	flags.StringVar(&ro.Registry.Password, "registry-password", ro.Registry.Password, "registry password")
 	flags.BoolVar(&ro.Registry.PlainHTTP, "registry-plain-http", ro.Registry.PlainHTTP, "allows interacting with remote registry via plain http requests")
+
+	flags.BoolVar(&ro.BuildCache.Enabled, "build-cache", ro.BuildCache.Enabled, "reuse the drivers previously built with the same options from the build cache directory and store the newly built ones into it; the master driver version is never cached")
+	flags.StringVar(&ro.BuildCache.Dir, "build-cache-dir", ro.BuildCache.Dir, "directory where built drivers are cached")
+
+	flags.BoolVar(&ro.HeadersCache.Enabled, "headers-cache", ro.HeadersCache.Enabled, "download the kernel headers on the host, reusing the ones previously downloaded from the same urls, and inject them into the builder; not supported by kubernetes job builds")
+	flags.StringVar(&ro.HeadersCache.Dir, "headers-cache-dir", ro.HeadersCache.Dir, "directory where downloaded kernel headers are cached")
+
+	flags.StringVar(&ro.Sign.Key, "sign-key", ro.Sign.Key, "PEM private key used to sign the kernel module, e.g. for Secure Boot hosts enforcing module signatures")
+	flags.StringVar(&ro.Sign.Cert, "sign-cert", ro.Sign.Cert, "PEM or DER X.509 certificate of the key used to sign the kernel module")
+	flags.StringVar(&ro.Sign.Hash, "sign-hash", ro.Sign.Hash, "hash algorithm used to sign the kernel module, one of ["+strings.Join(modsign.HashAlgorithms(), ",")+"]")
+
+	flags.StringVar(&ro.ReportFile, "report-file", ro.ReportFile, "filepath where to write the build result as JSON")
+	flags.StringVar(&ro.PushOCI, "push-oci", ro.PushOCI, "OCI repository where to push the built drivers, tagged after the build options (e.g. registry.example.com/diginfra/drivers)")
 }
 
 // Log emits a log line containing the receiving RootOptions for debugging purposes.
@@ -152,6 +233,63 @@ func (ro *RootOptions) Log(printer *output.Printer) {
 		))
 }
 
+// WithBuildCache wraps the given BuildProcessor with the build cache, if enabled.
+func (ro *RootOptions) WithBuildCache(processor driverbuilder.BuildProcessor) driverbuilder.BuildProcessor {
+	if !ro.BuildCache.Enabled {
+		return processor
+	}
+	return driverbuilder.NewCachedBuildProcessor(processor, ro.BuildCache.Dir)
+}
+
+// WithSigning wraps the given BuildProcessor, signing the built kernel module, if a signing key is set.
+func (ro *RootOptions) WithSigning(processor driverbuilder.BuildProcessor) driverbuilder.BuildProcessor {
+	if ro.Sign.Key == "" {
+		return processor
+	}
+	return driverbuilder.NewSigningBuildProcessor(processor, ro.Sign.Key, ro.Sign.Cert, ro.Sign.Hash)
+}
+
+// WithPushOCI wraps the given BuildProcessor, pushing the built drivers to the OCI repository, if any.
+func (ro *RootOptions) WithPushOCI(processor driverbuilder.BuildProcessor) driverbuilder.BuildProcessor {
+	if ro.PushOCI == "" {
+		return processor
+	}
+	return driverbuilder.NewOCIPushBuildProcessor(processor, ro.PushOCI)
+}
+
+// WrapProcessor wraps the given BuildProcessor with the build cache, the module signing
+// and the push of the drivers, as requested by the options.
+// The cache stores unsigned modules, so that they can be signed with different keys.
+func (ro *RootOptions) WrapProcessor(processor driverbuilder.BuildProcessor) driverbuilder.BuildProcessor {
+	return ro.WithPushOCI(ro.WithSigning(ro.WithBuildCache(processor)))
+}
+
+// StartBuild runs the build through the given BuildProcessor, wrapped as requested by the options,
+// then writes the build result into the report file, if requested.
+func (ro *RootOptions) StartBuild(ctx context.Context, processor driverbuilder.BuildProcessor, b *builder.Build) error {
+	res, err := ro.WrapProcessor(processor).Start(ctx, b)
+	if reportErr := writeReport(ro.ReportFile, res); reportErr != nil {
+		b.Logger.Error("error writing report file",
+			b.Logger.Args("file", ro.ReportFile, "err", reportErr.Error()))
+		if err == nil {
+			err = reportErr
+		}
+	}
+	return err
+}
+
+// writeReport writes the given value as indented JSON into path, if not empty.
+func writeReport(path string, v interface{}) error {
+	if path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o644)
+}
+
 func (ro *RootOptions) ToBuild(printer *output.Printer) *builder.Build {
 	kernelConfigData := ro.KernelConfigData
 	if len(kernelConfigData) == 0 {
@@ -173,6 +311,7 @@ func (ro *RootOptions) ToBuild(printer *output.Printer) *builder.Build {
 		BuilderImage:      ro.BuilderImage,
 		BuilderRepos:      ro.BuilderRepos,
 		KernelUrls:        ro.KernelUrls,
+		Mirrors:           ro.Mirrors,
 		RepoOrg:           ro.Repo.Org,
 		RepoName:          ro.Repo.Name,
 		Images:            make(builder.ImagesMap),
@@ -182,6 +321,9 @@ func (ro *RootOptions) ToBuild(printer *output.Printer) *builder.Build {
 		RegistryPlainHTTP: ro.Registry.PlainHTTP,
 		Printer:           printer,
 	}
+	if ro.HeadersCache.Enabled {
+		build.HeadersCacheDir = ro.HeadersCache.Dir
+	}
 
 	// loop over BuilderRepos to build the list ImagesListers based on the value of the builderRepo:
 	// if it's a local path use FileImagesLister, otherwise use RepoImagesLister
@@ -203,8 +345,12 @@ func (ro *RootOptions) ToBuild(printer *output.Printer) *builder.Build {
 		}
 	}
 
-	// attempt the build in case it comes from an invalid config
-	kr := build.KernelReleaseFromBuildConfig()
+	// attempt the build in case it comes from an invalid config;
+	// invalid kernel releases make the build itself fail.
+	kr, err := build.KernelReleaseFromBuildConfig()
+	if err != nil {
+		return build
+	}
 	if len(build.ModuleFilePath) > 0 && !kr.SupportsModule() {
 		build.ModuleFilePath = ""
 		printer.Logger.Warn("skipping build attempt of module for unsupported kernel release",
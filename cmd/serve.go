@@ -0,0 +1,684 @@
+// SPDX-License-Identifier: Apache-2.0
+/*
+Copyright (C) 2023 The Diginfra Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/diginfra/diginfractl/pkg/output"
+	"github.com/diginfra/driverkit/pkg/driverbuilder"
+	"github.com/diginfra/driverkit/pkg/kubernetes/factory"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	defaultServeListen      = "127.0.0.1:8080"
+	defaultServeQueueSize   = 64
+	defaultServeParallelism = 2
+	defaultServeJobsTTL     = 24 * time.Hour
+	serveMaxSpecSize        = 1 << 20
+	serveShutdownTimeout    = 10 * time.Second
+	// Names of the drivers stored by the build server
+	serveModuleFile = "module.ko"
+	serveProbeFile  = "probe.o"
+)
+
+type serveCmdOptions struct {
+	listen       string
+	processor    string
+	parallelism  int
+	queueSize    int
+	artifactsDir string
+	tokenFile    string
+	jobsTTL      time.Duration
+	policy       buildServerPolicy
+	local        localCmdOptions
+}
+
+// buildServerPolicy restricts the options that API clients can set on their builds.
+type buildServerPolicy struct {
+	// allowBuilderImage allows the builds to run a builder image of their choice.
+	allowBuilderImage bool
+	// allowLocalKernelURLs allows the builds to use kernel headers packages from the server filesystem.
+	allowLocalKernelURLs bool
+}
+
+// NewServeCmd creates the `driverkit serve` command.
+func NewServeCmd(configOpts *ConfigOptions, rootOpts *RootOptions, rootFlags *pflag.FlagSet) *cobra.Command {
+	opts := serveCmdOptions{}
+	serveCmd := &cobra.Command{
+		Use:   "serve",
+		Short: "Serve an HTTP API to submit and track builds of Diginfra kernel modules and eBPF probes.",
+		Args:  cobra.NoArgs,
+	}
+
+	// Add Kubernetes client flags, used by the kubernetes processor
+	configFlags := addKubernetesConfigFlags(serveCmd.PersistentFlags())
+	flags := serveCmd.Flags()
+	flags.StringVar(&opts.listen, "listen", defaultServeListen, "address the HTTP API listens on")
+	flags.StringVar(&opts.processor, "processor", driverbuilder.DockerBuildProcessorName, "processor used to run the builds, one of ["+strings.Join(validProcessors, ",")+"]")
+	flags.IntVar(&opts.parallelism, "parallelism", defaultServeParallelism, "maximum number of builds running concurrently")
+	flags.IntVar(&opts.queueSize, "queue-size", defaultServeQueueSize, "maximum number of builds waiting to run; further builds are rejected")
+	flags.StringVar(&opts.artifactsDir, "artifacts-dir", "", "directory where built drivers are stored; a temporary directory removed on exit is used when empty")
+	flags.StringVar(&opts.tokenFile, "token-file", "", "file containing the token that clients must send as 'Authorization: Bearer <token>'; the API is not authenticated when empty")
+	flags.DurationVar(&opts.jobsTTL, "jobs-ttl", defaultServeJobsTTL, "time finished builds, their logs and drivers included, are kept for")
+	flags.BoolVar(&opts.policy.allowBuilderImage, "allow-builderimage", false, "allow the submitted builds to set their own builder image, run on the build host")
+	flags.BoolVar(&opts.policy.allowLocalKernelURLs, "allow-local-kernelurls", false, "allow the submitted builds to use kernel headers packages from the server filesystem as kernel urls")
+	// Add Kubernetes pods options flags and local processor flags
+	addKubernetesFlags(flags)
+	addLocalFlags(flags, &opts.local)
+	serveCmd.PersistentFlags().AddFlagSet(flags)
+	// Add root flags; they are used as defaults for each build
+	serveCmd.PersistentFlags().AddFlagSet(rootFlags)
+
+	_ = serveCmd.MarkFlagDirname("artifacts-dir")
+	_ = serveCmd.MarkFlagFilename("token-file")
+	_ = serveCmd.RegisterFlagCompletionFunc("processor", func(c *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		return validProcessors, cobra.ShellCompDirectiveDefault
+	})
+
+	serveCmd.RunE = func(c *cobra.Command, args []string) error {
+		kubefactory, err := factory.NewFactory(configFlags)
+		if err != nil {
+			return err
+		}
+		newProcessor, err := processorFactory(opts.processor, opts.local, kubefactory, configOpts)
+		if err != nil {
+			return err
+		}
+
+		parallelism := opts.parallelism
+		if parallelism < 1 {
+			return fmt.Errorf("parallelism must be greater than 0, got %d", parallelism)
+		}
+		if opts.queueSize < 1 {
+			return fmt.Errorf("queue size must be greater than 0, got %d", opts.queueSize)
+		}
+		if opts.jobsTTL <= 0 {
+			return fmt.Errorf("jobs ttl must be greater than 0, got %s", opts.jobsTTL)
+		}
+		var token string
+		if opts.tokenFile != "" {
+			data, err := os.ReadFile(opts.tokenFile)
+			if err != nil {
+				return fmt.Errorf("error reading token file: %w", err)
+			}
+			if token = strings.TrimSpace(string(data)); token == "" {
+				return fmt.Errorf("token file %s is empty", opts.tokenFile)
+			}
+		} else if !isLoopbackListen(opts.listen) {
+			configOpts.Printer.Logger.Warn("the build server API is not authenticated, set --token-file",
+				configOpts.Printer.Logger.Args("listen", opts.listen))
+		}
+		if opts.processor == driverbuilder.LocalBuildProcessorName && parallelism > 1 {
+			// Local builds share the same driver directory on the host
+			configOpts.Printer.Logger.Warn("local processor does not support concurrent builds, forcing parallelism to 1")
+			parallelism = 1
+		}
+
+		configOpts.Printer.Logger.Info("starting build server",
+			configOpts.Printer.Logger.Args("listen", opts.listen, "processor", opts.processor,
+				"parallelism", parallelism, "queue-size", opts.queueSize))
+		if configOpts.dryRun {
+			return nil
+		}
+
+		dir := opts.artifactsDir
+		if dir == "" {
+			if dir, err = os.MkdirTemp("", "driverkit-serve-"); err != nil {
+				return err
+			}
+			defer os.RemoveAll(dir)
+		}
+
+		ctx, cancel := context.WithCancel(c.Context())
+		defer cancel()
+		bs := newBuildServer(rootOpts, dir, opts.queueSize, newProcessor, configOpts.Printer)
+		bs.policy = opts.policy
+		bs.jobsTTL = opts.jobsTTL
+		workersDone := make(chan struct{})
+		go func() {
+			bs.run(ctx, parallelism)
+			close(workersDone)
+		}()
+
+		srv := &http.Server{
+			Addr:              opts.listen,
+			Handler:           withBearerToken(token, bs.handler()),
+			ReadHeaderTimeout: 10 * time.Second,
+		}
+		go func() {
+			<-ctx.Done()
+			// Running builds are canceled too, so that log streams get closed
+			shutdownCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), serveShutdownTimeout)
+			defer cancel()
+			_ = srv.Shutdown(shutdownCtx)
+		}()
+		err = srv.ListenAndServe()
+		cancel()
+		<-workersDone
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			return err
+		}
+		configOpts.Printer.Logger.Info("build server stopped")
+		return nil
+	}
+
+	return serveCmd
+}
+
+// buildJobStatus is the status of a build submitted to the build server.
+type buildJobStatus string
+
+const (
+	buildJobQueued  buildJobStatus = "queued"
+	buildJobRunning buildJobStatus = "running"
+	buildJobSuccess buildJobStatus = "success"
+	buildJobFailure buildJobStatus = "failure"
+)
+
+// buildJob is a build submitted to the build server.
+// Its exported fields are guarded by the build server mutex.
+type buildJob struct {
+	ID            string                     `json:"id"`
+	Status        buildJobStatus             `json:"status"`
+	Target        string                     `json:"target"`
+	KernelRelease string                     `json:"kernelRelease"`
+	KernelVersion string                     `json:"kernelVersion"`
+	Architecture  string                     `json:"architecture"`
+	DriverVersion string                     `json:"driverVersion"`
+	Created       time.Time                  `json:"created"`
+	Started       *time.Time                 `json:"started,omitempty"`
+	Finished      *time.Time                 `json:"finished,omitempty"`
+	Error         string                     `json:"error,omitempty"`
+	Result        *driverbuilder.BuildResult `json:"result,omitempty"`
+
+	opts *RootOptions
+	logs *buildLogs
+}
+
+// buildServer runs the submitted builds through a bounded queue
+// and serves their status, logs and drivers over HTTP.
+type buildServer struct {
+	mu           sync.Mutex
+	jobs         map[string]*buildJob
+	queue        chan *buildJob
+	base         *RootOptions
+	dir          string
+	newProcessor func() (driverbuilder.BuildProcessor, error)
+	printer      *output.Printer
+	policy       buildServerPolicy
+	// jobsTTL is the time finished jobs are kept for; they are never evicted when zero.
+	jobsTTL time.Duration
+}
+
+func newBuildServer(base *RootOptions,
+	dir string,
+	queueSize int,
+	newProcessor func() (driverbuilder.BuildProcessor, error),
+	printer *output.Printer,
+) *buildServer {
+	return &buildServer{
+		jobs:         make(map[string]*buildJob),
+		queue:        make(chan *buildJob, queueSize),
+		base:         base,
+		dir:          dir,
+		newProcessor: newProcessor,
+		printer:      printer,
+	}
+}
+
+// run runs the queued builds through a pool of parallelism workers, until ctx is canceled.
+// Meanwhile, it evicts the expired jobs.
+func (bs *buildServer) run(ctx context.Context, parallelism int) {
+	var wg sync.WaitGroup
+	if bs.jobsTTL > 0 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ticker := time.NewTicker(min(bs.jobsTTL, time.Minute))
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case now := <-ticker.C:
+					bs.evict(now)
+				}
+			}
+		}()
+	}
+	for w := 0; w < parallelism; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case job := <-bs.queue:
+					bs.runJob(ctx, job)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func (bs *buildServer) runJob(ctx context.Context, job *buildJob) {
+	bs.mu.Lock()
+	started := time.Now().UTC()
+	job.Status = buildJobRunning
+	job.Started = &started
+	bs.mu.Unlock()
+
+	args := bs.printer.Logger.Args("id", job.ID, "target", job.Target, "kernelrelease", job.KernelRelease)
+	bs.printer.Logger.Info("build started", args)
+
+	// Each build logs to its own buffer, streamed to the clients
+	b := job.opts.ToBuild(bs.printer.WithWriter(job.logs))
+	var res *driverbuilder.BuildResult
+	processor, err := bs.newProcessor()
+	if err == nil {
+		res, err = job.opts.WrapProcessor(processor).Start(ctx, b)
+	} else {
+		// No processor could be created to run the build
+		res, _ = driverbuilder.NewBuildResult("", b).Finish(b, err)
+	}
+	job.logs.close()
+
+	bs.mu.Lock()
+	finished := time.Now().UTC()
+	job.Finished = &finished
+	job.Result = res
+	job.Status = buildJobSuccess
+	if err != nil {
+		job.Status = buildJobFailure
+		job.Error = err.Error()
+	}
+	bs.mu.Unlock()
+
+	if err != nil {
+		bs.printer.Logger.Error("build failed", append(args, bs.printer.Logger.Args("err", err.Error())...))
+	} else {
+		bs.printer.Logger.Info("build completed", args)
+	}
+}
+
+// submit validates the given build spec and queues its build.
+// It returns the validation errors, if any, separately from the other errors.
+func (bs *buildServer) submit(spec *batchSpec) (*buildJob, []error, error) {
+	id, err := newBuildJobID()
+	if err != nil {
+		return nil, nil, err
+	}
+	jobDir := filepath.Join(bs.dir, id)
+
+	if errs := bs.policy.check(spec); errs != nil {
+		return nil, errs, nil
+	}
+	opts := spec.toRootOptions(bs.base)
+	// Drivers are stored by the server: the spec outputs only select the drivers to build
+	opts.Output = OutputOptions{}
+	if spec.Output.Module != "" || spec.Output.Probe == "" {
+		opts.Output.Module = filepath.Join(jobDir, serveModuleFile)
+	}
+	if spec.Output.Probe != "" || spec.Output.Module == "" {
+		opts.Output.Probe = filepath.Join(jobDir, serveProbeFile)
+	}
+	// Results are served by the API
+	opts.ReportFile = ""
+	if errs := opts.Validate(); errs != nil {
+		return nil, errs, nil
+	}
+
+	if err = os.MkdirAll(jobDir, 0o755); err != nil {
+		return nil, nil, err
+	}
+	job := &buildJob{
+		ID:            id,
+		Status:        buildJobQueued,
+		Target:        opts.Target,
+		KernelRelease: opts.KernelRelease,
+		KernelVersion: opts.KernelVersion,
+		Architecture:  opts.Architecture,
+		DriverVersion: opts.DriverVersion,
+		Created:       time.Now().UTC(),
+		opts:          opts,
+		logs:          newBuildLogs(),
+	}
+
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	select {
+	case bs.queue <- job:
+		bs.jobs[id] = job
+		return job, nil, nil
+	default:
+		_ = os.RemoveAll(jobDir)
+		return nil, nil, errBuildQueueFull
+	}
+}
+
+var errBuildQueueFull = errors.New("build queue is full")
+
+// check returns the options of the build spec not allowed by the policy.
+func (p buildServerPolicy) check(spec *batchSpec) []error {
+	var errs []error
+	if spec.BuilderImage != "" && !p.allowBuilderImage {
+		errs = append(errs, errors.New("builderimage is not allowed by the build server"))
+	}
+	if !p.allowLocalKernelURLs {
+		for _, u := range spec.KernelUrls {
+			if uu, err := url.Parse(u); err != nil || (uu.Scheme != "http" && uu.Scheme != "https") {
+				errs = append(errs, fmt.Errorf("kernel url %q is not allowed by the build server, only http and https urls are", u))
+			}
+		}
+	}
+	return errs
+}
+
+// evict removes the jobs finished for longer than the jobs ttl, together with their drivers.
+func (bs *buildServer) evict(now time.Time) {
+	bs.mu.Lock()
+	var expired []string
+	for id, job := range bs.jobs {
+		if job.Finished != nil && now.Sub(*job.Finished) > bs.jobsTTL {
+			expired = append(expired, id)
+			delete(bs.jobs, id)
+		}
+	}
+	bs.mu.Unlock()
+	for _, id := range expired {
+		if err := os.RemoveAll(filepath.Join(bs.dir, id)); err != nil {
+			bs.printer.Logger.Warn("error removing build drivers",
+				bs.printer.Logger.Args("id", id, "err", err.Error()))
+		}
+		bs.printer.Logger.Debug("build evicted", bs.printer.Logger.Args("id", id))
+	}
+}
+
+func newBuildJobID() (string, error) {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(id), nil
+}
+
+// get returns a copy of the job with the given id, safe to be read without holding the lock.
+func (bs *buildServer) get(id string) (buildJob, bool) {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	job, ok := bs.jobs[id]
+	if !ok {
+		return buildJob{}, false
+	}
+	return *job, true
+}
+
+// list returns a copy of all the jobs, most recent first.
+func (bs *buildServer) list() []buildJob {
+	bs.mu.Lock()
+	jobs := make([]buildJob, 0, len(bs.jobs))
+	for _, job := range bs.jobs {
+		jobs = append(jobs, *job)
+	}
+	bs.mu.Unlock()
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].Created.After(jobs[j].Created)
+	})
+	return jobs
+}
+
+func (bs *buildServer) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /v1/builds", bs.handleSubmit)
+	mux.HandleFunc("GET /v1/builds", bs.handleList)
+	mux.HandleFunc("GET /v1/builds/{id}", bs.handleGet)
+	mux.HandleFunc("GET /v1/builds/{id}/logs", bs.handleLogs)
+	mux.HandleFunc("GET /v1/builds/{id}/{kind}", bs.handleArtifact)
+	return mux
+}
+
+func (bs *buildServer) handleSubmit(w http.ResponseWriter, r *http.Request) {
+	// Specs use the same keys of the batch file builds, either as yaml or json
+	dec := yaml.NewDecoder(http.MaxBytesReader(w, r.Body, serveMaxSpecSize))
+	dec.KnownFields(true)
+	var spec batchSpec
+	if err := dec.Decode(&spec); err != nil {
+		writeJSONError(w, http.StatusBadRequest, fmt.Errorf("error decoding build spec: %w", err))
+		return
+	}
+
+	job, errs, err := bs.submit(&spec)
+	switch {
+	case errs != nil:
+		msgs := make([]string, len(errs))
+		for i, e := range errs {
+			msgs[i] = e.Error()
+		}
+		writeJSON(w, http.StatusBadRequest, map[string]interface{}{
+			"error":  "invalid build spec",
+			"errors": msgs,
+		})
+		return
+	case errors.Is(err, errBuildQueueFull):
+		writeJSONError(w, http.StatusServiceUnavailable, err)
+		return
+	case err != nil:
+		writeJSONError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	bs.printer.Logger.Info("build queued",
+		bs.printer.Logger.Args("id", job.ID, "target", job.Target, "kernelrelease", job.KernelRelease))
+	view, _ := bs.get(job.ID)
+	w.Header().Set("Location", "/v1/builds/"+job.ID)
+	writeJSON(w, http.StatusAccepted, view)
+}
+
+func (bs *buildServer) handleList(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, bs.list())
+}
+
+func (bs *buildServer) handleGet(w http.ResponseWriter, r *http.Request) {
+	job, ok := bs.get(r.PathValue("id"))
+	if !ok {
+		writeJSONError(w, http.StatusNotFound, errors.New("build not found"))
+		return
+	}
+	writeJSON(w, http.StatusOK, job)
+}
+
+// handleLogs writes the logs of the build; when the follow query parameter is true,
+// it streams them until the build is over.
+func (bs *buildServer) handleLogs(w http.ResponseWriter, r *http.Request) {
+	job, ok := bs.get(r.PathValue("id"))
+	if !ok {
+		writeJSONError(w, http.StatusNotFound, errors.New("build not found"))
+		return
+	}
+	follow := r.URL.Query().Get("follow") == "true"
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+
+	offset := 0
+	for {
+		data, done, changed := job.logs.readFrom(offset)
+		if len(data) > 0 {
+			if _, err := w.Write(data); err != nil {
+				return
+			}
+			offset += len(data)
+		}
+		if !follow || done {
+			return
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		select {
+		case <-r.Context().Done():
+			return
+		case <-changed:
+		}
+	}
+}
+
+// handleArtifact serves the module or the probe built by a successful build.
+func (bs *buildServer) handleArtifact(w http.ResponseWriter, r *http.Request) {
+	job, ok := bs.get(r.PathValue("id"))
+	if !ok {
+		writeJSONError(w, http.StatusNotFound, errors.New("build not found"))
+		return
+	}
+	var path, name string
+	switch r.PathValue("kind") {
+	case driverbuilder.ArtifactKindModule:
+		path, name = job.opts.Output.Module, job.opts.ModuleDriverName+".ko"
+	case driverbuilder.ArtifactKindProbe:
+		path, name = job.opts.Output.Probe, job.opts.ModuleDriverName+".o"
+	default:
+		writeJSONError(w, http.StatusNotFound, fmt.Errorf("unknown artifact %q, one of [%s,%s]",
+			r.PathValue("kind"), driverbuilder.ArtifactKindModule, driverbuilder.ArtifactKindProbe))
+		return
+	}
+	if job.Status != buildJobSuccess {
+		writeJSONError(w, http.StatusConflict, fmt.Errorf("build is %s", job.Status))
+		return
+	}
+	// Not requested, or not supported by the kernel release
+	notBuiltErr := fmt.Errorf("%s not built", r.PathValue("kind"))
+	if path == "" {
+		writeJSONError(w, http.StatusNotFound, notBuiltErr)
+		return
+	}
+	f, err := os.Open(filepath.Clean(path))
+	if err != nil {
+		writeJSONError(w, http.StatusNotFound, notBuiltErr)
+		return
+	}
+	defer f.Close()
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filepath.Base(name)))
+	http.ServeContent(w, r, "", *job.Finished, f)
+}
+
+// withBearerToken requires the requests to carry the given bearer token, if not empty.
+func withBearerToken(token string, next http.Handler) http.Handler {
+	if token == "" {
+		return next
+	}
+	expected := []byte("Bearer " + token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), expected) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeJSONError(w, http.StatusUnauthorized, errors.New("unauthorized"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// isLoopbackListen tells whether the listen address only accepts local connections.
+func isLoopbackListen(listen string) bool {
+	host, _, err := net.SplitHostPort(listen)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(v)
+}
+
+func writeJSONError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, map[string]string{"error": err.Error()})
+}
+
+// buildLogs is the log buffer of a build, that can be read while being written.
+type buildLogs struct {
+	mu      sync.Mutex
+	buf     bytes.Buffer
+	done    bool
+	changed chan struct{}
+}
+
+func newBuildLogs() *buildLogs {
+	return &buildLogs{changed: make(chan struct{})}
+}
+
+func (l *buildLogs) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.done {
+		return 0, io.ErrClosedPipe
+	}
+	n, err := l.buf.Write(p)
+	l.notify()
+	return n, err
+}
+
+func (l *buildLogs) close() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.done = true
+	l.notify()
+}
+
+// notify wakes up the readers waiting for changes; l.mu must be held.
+func (l *buildLogs) notify() {
+	close(l.changed)
+	l.changed = make(chan struct{})
+}
+
+// readFrom returns the logs written after offset, whether the logs are complete,
+// and a channel closed at the next change.
+func (l *buildLogs) readFrom(offset int) ([]byte, bool, <-chan struct{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	data := bytes.Clone(l.buf.Bytes()[offset:])
+	return data, l.done, l.changed
+}
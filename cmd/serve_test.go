@@ -0,0 +1,262 @@
+// SPDX-License-Identifier: Apache-2.0
+/*
+Copyright (C) 2023 The Diginfra Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/diginfra/driverkit/pkg/driverbuilder"
+	"github.com/diginfra/driverkit/pkg/driverbuilder/builder"
+	"gotest.tools/assert"
+)
+
+// fakeBuildProcessor writes fake drivers, blocking until release is closed.
+type fakeBuildProcessor struct {
+	release chan struct{}
+}
+
+func (bp *fakeBuildProcessor) String() string {
+	return "fake"
+}
+
+func (bp *fakeBuildProcessor) Start(ctx context.Context, b *builder.Build) (*driverbuilder.BuildResult, error) {
+	res := driverbuilder.NewBuildResult(bp.String(), b)
+	b.Logger.Info("fake build started")
+	select {
+	case <-ctx.Done():
+		return res.Finish(b, ctx.Err())
+	case <-bp.release:
+	}
+	if len(b.ModuleFilePath) > 0 {
+		if err := os.WriteFile(b.ModuleFilePath, []byte("module"), 0o644); err != nil {
+			return res.Finish(b, err)
+		}
+	}
+	return res.Finish(b, nil)
+}
+
+func newTestBuildServer(t *testing.T, queueSize int, processor driverbuilder.BuildProcessor) (*buildServer, *httptest.Server) {
+	configOpts, err := NewConfigOptions()
+	assert.NilError(t, err)
+	configOpts.setOutput(io.Discard, true)
+	rootOpts, err := NewRootOptions()
+	assert.NilError(t, err)
+	rootOpts.Architecture = "amd64"
+	rootOpts.BuilderRepos = nil
+
+	bs := newBuildServer(rootOpts, t.TempDir(), queueSize, func() (driverbuilder.BuildProcessor, error) {
+		return processor, nil
+	}, configOpts.Printer)
+	srv := httptest.NewServer(bs.handler())
+	t.Cleanup(srv.Close)
+	return bs, srv
+}
+
+// startWorkers runs the build server workers until the returned function is called,
+// or the test is over.
+func startWorkers(t *testing.T, bs *buildServer) context.CancelFunc {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		bs.run(ctx, 1)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	return cancel
+}
+
+func submitBuild(t *testing.T, srv *httptest.Server, spec string) (*http.Response, buildJob) {
+	resp, err := http.Post(srv.URL+"/v1/builds", "application/json", strings.NewReader(spec))
+	assert.NilError(t, err)
+	defer resp.Body.Close()
+	var job buildJob
+	if resp.StatusCode == http.StatusAccepted {
+		assert.NilError(t, json.NewDecoder(resp.Body).Decode(&job))
+	}
+	return resp, job
+}
+
+func getBuild(t *testing.T, srv *httptest.Server, id string) buildJob {
+	resp, err := http.Get(srv.URL + "/v1/builds/" + id)
+	assert.NilError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	var job buildJob
+	assert.NilError(t, json.NewDecoder(resp.Body).Decode(&job))
+	return job
+}
+
+const testServeSpec = `{"target": "ubuntu", "kernelrelease": "5.15.0-1-generic", "kernelversion": "1", "driverversion": "7.0.0+driver", "output": {"module": "diginfra.ko"}}`
+
+func TestServeBuild(t *testing.T) {
+	processor := &fakeBuildProcessor{release: make(chan struct{})}
+	bs, srv := newTestBuildServer(t, 1, processor)
+	startWorkers(t, bs)
+
+	resp, job := submitBuild(t, srv, testServeSpec)
+	assert.Equal(t, http.StatusAccepted, resp.StatusCode)
+	assert.Equal(t, "/v1/builds/"+job.ID, resp.Header.Get("Location"))
+	assert.Equal(t, "ubuntu", job.Target)
+
+	// Stream the logs while the build is running
+	logsResp, err := http.Get(srv.URL + "/v1/builds/" + job.ID + "/logs?follow=true")
+	assert.NilError(t, err)
+	defer logsResp.Body.Close()
+	close(processor.release)
+	logs, err := io.ReadAll(logsResp.Body)
+	assert.NilError(t, err)
+	assert.Assert(t, bytes.Contains(logs, []byte("fake build started")))
+
+	job = getBuild(t, srv, job.ID)
+	assert.Equal(t, buildJobSuccess, job.Status)
+	assert.Assert(t, job.Result != nil)
+	assert.Equal(t, 1, len(job.Result.Artifacts))
+
+	moduleResp, err := http.Get(srv.URL + "/v1/builds/" + job.ID + "/module")
+	assert.NilError(t, err)
+	defer moduleResp.Body.Close()
+	assert.Equal(t, http.StatusOK, moduleResp.StatusCode)
+	module, err := io.ReadAll(moduleResp.Body)
+	assert.NilError(t, err)
+	assert.Equal(t, "module", string(module))
+
+	// The probe was not requested
+	probeResp, err := http.Get(srv.URL + "/v1/builds/" + job.ID + "/probe")
+	assert.NilError(t, err)
+	defer probeResp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, probeResp.StatusCode)
+}
+
+func TestServeInvalidSpec(t *testing.T) {
+	_, srv := newTestBuildServer(t, 1, &fakeBuildProcessor{})
+
+	resp, _ := submitBuild(t, srv, `{"target": "ubuntu"}`)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	resp, _ = submitBuild(t, srv, `{"unknown": "key"}`)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	notFoundResp, err := http.Get(srv.URL + "/v1/builds/abc")
+	assert.NilError(t, err)
+	defer notFoundResp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, notFoundResp.StatusCode)
+}
+
+func TestServeQueueFull(t *testing.T) {
+	processor := &fakeBuildProcessor{release: make(chan struct{})}
+	bs, srv := newTestBuildServer(t, 1, processor)
+
+	// No workers are running: the first build fills the queue
+	resp, job := submitBuild(t, srv, testServeSpec)
+	assert.Equal(t, http.StatusAccepted, resp.StatusCode)
+	resp, _ = submitBuild(t, srv, testServeSpec)
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+
+	// Running builds are canceled with the server
+	cancel := startWorkers(t, bs)
+	for getBuild(t, srv, job.ID).Status == buildJobQueued {
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	for getBuild(t, srv, job.ID).Status == buildJobRunning {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, buildJobFailure, getBuild(t, srv, job.ID).Status)
+}
+
+func TestServePolicy(t *testing.T) {
+	bs, srv := newTestBuildServer(t, 4, &fakeBuildProcessor{})
+
+	for _, spec := range []string{
+		`{"target": "ubuntu", "kernelrelease": "5.15.0-1-generic", "kernelversion": "1", "builderimage": "evil/image:latest"}`,
+		`{"target": "ubuntu", "kernelrelease": "5.15.0-1-generic", "kernelversion": "1", "kernelurls": ["/etc/shadow"]}`,
+		`{"target": "ubuntu", "kernelrelease": "5.15.0-1-generic", "kernelversion": "1", "kernelurls": ["file:///etc/shadow"]}`,
+	} {
+		resp, _ := submitBuild(t, srv, spec)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	}
+
+	resp, _ := submitBuild(t, srv, `{"target": "ubuntu", "kernelrelease": "5.15.0-1-generic", "kernelversion": "1", "kernelurls": ["https://example.com/linux-headers.deb"]}`)
+	assert.Equal(t, http.StatusAccepted, resp.StatusCode)
+
+	bs.policy = buildServerPolicy{allowBuilderImage: true, allowLocalKernelURLs: true}
+	resp, _ = submitBuild(t, srv, `{"target": "ubuntu", "kernelrelease": "5.15.0-1-generic", "kernelversion": "1", "builderimage": "foo/builder:latest", "kernelurls": ["/srv/linux-headers.deb"]}`)
+	assert.Equal(t, http.StatusAccepted, resp.StatusCode)
+}
+
+func TestServeBearerToken(t *testing.T) {
+	srv := httptest.NewServer(withBearerToken("secret", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})))
+	defer srv.Close()
+
+	for token, expected := range map[string]int{"": http.StatusUnauthorized, "wrong": http.StatusUnauthorized, "secret": http.StatusOK} {
+		req, err := http.NewRequest(http.MethodGet, srv.URL+"/v1/builds", nil)
+		assert.NilError(t, err)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		assert.NilError(t, err)
+		_ = resp.Body.Close()
+		assert.Equal(t, expected, resp.StatusCode)
+	}
+
+	assert.Assert(t, isLoopbackListen("127.0.0.1:8080"))
+	assert.Assert(t, isLoopbackListen("localhost:8080"))
+	assert.Assert(t, !isLoopbackListen(":8080"))
+	assert.Assert(t, !isLoopbackListen("0.0.0.0:8080"))
+}
+
+func TestServeEvict(t *testing.T) {
+	processor := &fakeBuildProcessor{release: make(chan struct{})}
+	close(processor.release)
+	bs, srv := newTestBuildServer(t, 1, processor)
+	bs.jobsTTL = time.Hour
+	startWorkers(t, bs)
+
+	resp, job := submitBuild(t, srv, testServeSpec)
+	assert.Equal(t, http.StatusAccepted, resp.StatusCode)
+	for getBuild(t, srv, job.ID).Status != buildJobSuccess {
+		time.Sleep(10 * time.Millisecond)
+	}
+	jobDir := filepath.Join(bs.dir, job.ID)
+	_, err := os.Stat(jobDir)
+	assert.NilError(t, err)
+
+	// Not expired yet
+	bs.evict(time.Now())
+	_, ok := bs.get(job.ID)
+	assert.Assert(t, ok)
+
+	bs.evict(time.Now().Add(2 * time.Hour))
+	_, ok = bs.get(job.ID)
+	assert.Assert(t, !ok)
+	_, err = os.Stat(jobDir)
+	assert.Assert(t, os.IsNotExist(err))
+}
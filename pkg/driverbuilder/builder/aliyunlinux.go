@@ -15,6 +15,7 @@ limitations under the License.
 package builder
 
 import (
+	"context"
 	_ "embed"
 	"fmt"
 
@@ -53,8 +54,12 @@ func (c *alinux) TemplateScript() string {
 	return alinuxTemplate
 }
 
-func (c *alinux) URLs(kr kernelrelease.KernelRelease) ([]string, error) {
-	return fetchAlinuxKernelURLS(kr), nil
+func (c *alinux) DefaultMirrors(_ kernelrelease.KernelRelease) []string {
+	return []string{"http://mirrors.aliyun.com/alinux"}
+}
+
+func (c *alinux) URLs(ctx context.Context, kr kernelrelease.KernelRelease) ([]string, error) {
+	return fetchAlinuxKernelURLS(mirrorBaseURLs(ctx, TargetTypeAlinux.String(), c.DefaultMirrors(kr)...), kr), nil
 }
 
 func (c *alinux) KernelTemplateData(_ kernelrelease.KernelRelease, urls []string) interface{} {
@@ -63,7 +68,7 @@ func (c *alinux) KernelTemplateData(_ kernelrelease.KernelRelease, urls []string
 	}
 }
 
-func fetchAlinuxKernelURLS(kr kernelrelease.KernelRelease) []string {
+func fetchAlinuxKernelURLS(mirrors []string, kr kernelrelease.KernelRelease) []string {
 	alinuxReleases := []string{
 		"2",
 		"2.1903",
@@ -71,14 +76,17 @@ func fetchAlinuxKernelURLS(kr kernelrelease.KernelRelease) []string {
 	}
 
 	urls := []string{}
-	for _, r := range alinuxReleases {
-		urls = append(urls, fmt.Sprintf(
-			"http://mirrors.aliyun.com/alinux/%s/os/%s/Packages/kernel-devel-%s%s.rpm",
-			r,
-			kr.Architecture.ToNonDeb(),
-			kr.Fullversion,
-			kr.FullExtraversion,
-		))
+	for _, mirror := range mirrors {
+		for _, r := range alinuxReleases {
+			urls = append(urls, fmt.Sprintf(
+				"%s/%s/os/%s/Packages/kernel-devel-%s%s.rpm",
+				mirror,
+				r,
+				kr.Architecture.ToNonDeb(),
+				kr.Fullversion,
+				kr.FullExtraversion,
+			))
+		}
 	}
 	return urls
 }
@@ -15,6 +15,7 @@ limitations under the License.
 package builder
 
 import (
+	"context"
 	_ "embed"
 	"fmt"
 
@@ -54,8 +55,16 @@ func (c *alma) TemplateScript() string {
 	return almaTemplate
 }
 
-func (c *alma) URLs(kr kernelrelease.KernelRelease) ([]string, error) {
-	return fetchAlmaKernelURLS(kr), nil
+func (c *alma) DefaultMirrors(_ kernelrelease.KernelRelease) []string {
+	return []string{
+		"https://repo.almalinux.org/almalinux",
+		// older point releases are moved to the vault
+		"https://vault.almalinux.org",
+	}
+}
+
+func (c *alma) URLs(ctx context.Context, kr kernelrelease.KernelRelease) ([]string, error) {
+	return fetchAlmaKernelURLS(ctx, mirrorBaseURLs(ctx, TargetTypeAlma.String(), c.DefaultMirrors(kr)...), kr)
 }
 
 func (c *alma) KernelTemplateData(_ kernelrelease.KernelRelease, urls []string) interface{} {
@@ -64,33 +73,23 @@ func (c *alma) KernelTemplateData(_ kernelrelease.KernelRelease, urls []string)
 	}
 }
 
-func fetchAlmaKernelURLS(kr kernelrelease.KernelRelease) []string {
-	almaReleases := []string{
-		"8",
-		"8.6",
-		"9",
-		"9.0",
+func fetchAlmaKernelURLS(ctx context.Context, mirrors []string, kr kernelrelease.KernelRelease) ([]string, error) {
+	major, minor, ok := rpmELRelease(kr)
+	if !ok {
+		return nil, fmt.Errorf("%w: no almalinux release in %s", HeadersNotFoundErr, kr.String())
+	}
+	// kernel-devel moved from BaseOS to AppStream with almalinux 9
+	repo := "BaseOS"
+	if compareReleases(major, "9") >= 0 {
+		repo = "AppStream"
 	}
 
-	urls := []string{}
-	for _, r := range almaReleases {
-		if r >= "9" {
-			urls = append(urls, fmt.Sprintf(
-				"https://repo.almalinux.org/almalinux/%s/AppStream/%s/os/Packages/kernel-devel-%s%s.rpm",
-				r,
-				kr.Architecture.ToNonDeb(),
-				kr.Fullversion,
-				kr.FullExtraversion,
-			))
-		} else {
-			urls = append(urls, fmt.Sprintf(
-				"https://repo.almalinux.org/almalinux/%s/BaseOS/%s/os/Packages/kernel-devel-%s%s.rpm",
-				r,
-				kr.Architecture.ToNonDeb(),
-				kr.Fullversion,
-				kr.FullExtraversion,
-			))
+	var repos []rpmRepo
+	for _, mirror := range mirrors {
+		releases := append([]string{major}, rpmPointReleases(ctx, mirror, major, minor)...)
+		for _, r := range releases {
+			repos = append(repos, rpmRepo{URL: fmt.Sprintf("%s/%s/%s/%s/os", mirror, r, repo, kr.Architecture.ToNonDeb())})
 		}
 	}
-	return urls
+	return rpmRepodataURLs(ctx, repos, rpmKernelPackages{names: []string{"kernel-devel"}, kr: kr})
 }
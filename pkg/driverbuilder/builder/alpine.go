@@ -0,0 +1,284 @@
+// SPDX-License-Identifier: Apache-2.0
+/*
+Copyright (C) 2023 The Diginfra Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package builder
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"context"
+	_ "embed"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"sync"
+
+	"github.com/blang/semver"
+	"github.com/diginfra/driverkit/pkg/kernelrelease"
+)
+
+//go:embed templates/alpine_kernel.sh
+var alpineKernelTemplate string
+
+//go:embed templates/alpine.sh
+var alpineTemplate string
+
+// TargetTypeAlpine identifies the Alpine target.
+const TargetTypeAlpine Type = "alpine"
+
+func init() {
+	byTarget[TargetTypeAlpine] = &alpine{}
+}
+
+type alpineTemplateData struct {
+	KernelDownloadURL string
+}
+
+// alpine is a driverkit target.
+type alpine struct {
+	mu    sync.Mutex
+	infos map[string]*alpineKernelInfo
+}
+
+// alpineKernelInfo is the outcome of the lookup of the headers package of a kernel release.
+type alpineKernelInfo struct {
+	// branch is the branch the headers package was found in, e.g. v3.20.
+	branch string
+	url    string
+	// err is the lookup error, only reported when the headers urls are needed.
+	err error
+}
+
+// alpineReleaseRegex matches the package release and flavor of a kernel release, e.g. 0 and lts for 6.6.31-0-lts.
+var alpineReleaseRegex = regexp.MustCompile(`^-(\d+)-([a-z]+)$`)
+
+// alpineBranchRegex matches the stable branches directories.
+var alpineBranchRegex = regexp.MustCompile(`^v\d+\.\d+$`)
+
+// alpineBranchesGCC are the gcc versions shipped by each branch, and used to build its kernels.
+var alpineBranchesGCC = map[string]semver.Version{
+	"v3.9":  {Major: 8},
+	"v3.10": {Major: 8},
+	"v3.11": {Major: 9},
+	"v3.12": {Major: 9},
+	"v3.13": {Major: 10},
+	"v3.14": {Major: 10},
+	"v3.15": {Major: 10},
+	"v3.16": {Major: 11},
+	"v3.17": {Major: 12},
+	"v3.18": {Major: 12},
+	"v3.19": {Major: 13},
+	"v3.20": {Major: 13},
+	"v3.21": {Major: 14},
+}
+
+// errAPKIndexNotFound is returned when a branch does not publish the requested APKINDEX.
+var errAPKIndexNotFound = errors.New("APKINDEX not found")
+
+func (a *alpine) Name() string {
+	return TargetTypeAlpine.String()
+}
+
+func (a *alpine) TemplateKernelUrlsScript() string {
+	return alpineKernelTemplate
+}
+
+func (a *alpine) TemplateScript() string {
+	return alpineTemplate
+}
+
+func (a *alpine) DefaultMirrors(_ kernelrelease.KernelRelease) []string {
+	return []string{"https://dl-cdn.alpinelinux.org/alpine"}
+}
+
+// LoadKernelInfo looks the headers package up, needed also when `kernelurls` option is passed,
+// since the branch it is found in provides the gcc the kernel was built with.
+func (a *alpine) LoadKernelInfo(ctx context.Context, kr kernelrelease.KernelRelease) error {
+	info := &alpineKernelInfo{}
+	info.branch, info.url, info.err = a.findHeaders(ctx, kr)
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	a.setInfo(kr, info)
+	return nil
+}
+
+func (a *alpine) URLs(_ context.Context, kr kernelrelease.KernelRelease) ([]string, error) {
+	info := a.info(kr)
+	if info == nil {
+		return nil, fmt.Errorf("kernel infos of %s not loaded", kr.String())
+	}
+	if info.err != nil {
+		return nil, info.err
+	}
+	return []string{info.url}, nil
+}
+
+// findHeaders returns the branch and the url of the headers package of kr.
+func (a *alpine) findHeaders(ctx context.Context, kr kernelrelease.KernelRelease) (string, string, error) {
+	m := alpineReleaseRegex.FindStringSubmatch(kr.FullExtraversion)
+	if m == nil {
+		return "", "", fmt.Errorf("%w: no alpine package release and flavor in %s", HeadersNotFoundErr, kr.String())
+	}
+	name := fmt.Sprintf("linux-%s-dev", m[2])
+	version := fmt.Sprintf("%s-r%s", kr.Fullversion, m[1])
+	arch := kr.Architecture.ToNonDeb()
+
+	var tried []string
+	for _, mirror := range mirrorBaseURLs(ctx, TargetTypeAlpine.String(), a.DefaultMirrors(kr)...) {
+		// Each branch only keeps the latest build of its packages, look the newest branches up first
+		branches, err := listReleaseDirs(ctx, mirror, alpineBranchRegex)
+		if ctx.Err() != nil {
+			return "", "", ctx.Err()
+		}
+		if err != nil {
+			tried = append(tried, mirror)
+			continue
+		}
+		for _, branch := range append([]string{"edge"}, branches...) {
+			repo := fmt.Sprintf("%s/%s/main/%s", mirror, branch, arch)
+			tried = append(tried, repo)
+			found, err := fetchAPKIndexContains(ctx, repo, name, version, arch)
+			if ctx.Err() != nil {
+				return "", "", ctx.Err()
+			}
+			if err != nil && !errors.Is(err, errAPKIndexNotFound) {
+				return "", "", err
+			}
+			if found {
+				return branch, fmt.Sprintf("%s/%s-%s.apk", repo, name, version), nil
+			}
+		}
+	}
+	return "", "", &HeadersNotFoundError{URLs: tried}
+}
+
+func (a *alpine) KernelTemplateData(_ kernelrelease.KernelRelease, urls []string) interface{} {
+	return alpineTemplateData{
+		KernelDownloadURL: urls[0],
+	}
+}
+
+func (a *alpine) GCCVersion(kr kernelrelease.KernelRelease) semver.Version {
+	// Use the gcc of the branch the kernel was found in, if looked up
+	if info := a.info(kr); info != nil {
+		if gcc, ok := alpineBranchesGCC[info.branch]; ok {
+			return gcc
+		}
+	}
+	// Otherwise, the one of the first branch shipping the kernel series
+	switch {
+	case kr.Major > 6 || (kr.Major == 6 && kr.Minor >= 12):
+		return semver.Version{Major: 14}
+	case kr.Major == 6 && kr.Minor >= 6:
+		return semver.Version{Major: 13}
+	case kr.Major == 6:
+		return semver.Version{Major: 12}
+	case kr.Major == 5 && kr.Minor >= 10:
+		return semver.Version{Major: 10}
+	case kr.Major == 5:
+		return semver.Version{Major: 9}
+	case kr.Major == 4:
+		return semver.Version{Major: 8}
+	}
+	return semver.Version{}
+}
+
+func (a *alpine) info(kr kernelrelease.KernelRelease) *alpineKernelInfo {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.infos[kr.String()+"/"+kr.Architecture.String()]
+}
+
+func (a *alpine) setInfo(kr kernelrelease.KernelRelease, info *alpineKernelInfo) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.infos == nil {
+		a.infos = make(map[string]*alpineKernelInfo)
+	}
+	a.infos[kr.String()+"/"+kr.Architecture.String()] = info
+}
+
+// fetchAPKIndexContains downloads the APKINDEX of the repository at repo,
+// reporting whether it lists the given package version.
+func fetchAPKIndexContains(ctx context.Context, repo, name, version, arch string) (bool, error) {
+	u := repo + "/APKINDEX.tar.gz"
+	res, err := httpGet(ctx, u)
+	if err != nil {
+		return false, err
+	}
+	defer res.Body.Close()
+	switch {
+	case res.StatusCode == http.StatusNotFound || res.StatusCode == http.StatusForbidden:
+		return false, fmt.Errorf("%w: %s", errAPKIndexNotFound, u)
+	case res.StatusCode != http.StatusOK:
+		return false, fmt.Errorf("error downloading %s: %s", u, res.Status)
+	}
+	// APKINDEX.tar.gz concatenates the gzipped signature and index tarballs
+	gr, err := gzip.NewReader(res.Body)
+	if err != nil {
+		return false, fmt.Errorf("error reading %s: %w", u, err)
+	}
+	defer gr.Close()
+	tr := tar.NewReader(gr)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return false, fmt.Errorf("error reading %s: missing APKINDEX", u)
+		}
+		if err != nil {
+			return false, fmt.Errorf("error reading %s: %w", u, err)
+		}
+		if hdr.Name != "APKINDEX" {
+			continue
+		}
+		found, err := apkIndexContains(tr, name, version, arch)
+		if err != nil {
+			return false, fmt.Errorf("error reading %s: %w", u, err)
+		}
+		return found, nil
+	}
+}
+
+// apkIndexContains parses an APKINDEX, reporting whether it lists the given package version.
+func apkIndexContains(r io.Reader, name, version, arch string) (bool, error) {
+	var p, v, a string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			if p == name && v == version && a == arch {
+				return true, nil
+			}
+			p, v, a = "", "", ""
+			continue
+		}
+		switch {
+		case len(line) < 2 || line[1] != ':':
+		case line[0] == 'P':
+			p = line[2:]
+		case line[0] == 'V':
+			v = line[2:]
+		case line[0] == 'A':
+			a = line[2:]
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return false, err
+	}
+	return p == name && v == version && a == arch, nil
+}
@@ -0,0 +1,112 @@
+// SPDX-License-Identifier: Apache-2.0
+/*
+Copyright (C) 2023 The Diginfra Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package builder
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/blang/semver"
+
+	"github.com/diginfra/driverkit/pkg/kernelrelease"
+)
+
+func testAPKIndex(t *testing.T, index string) []byte {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	if err := tw.WriteHeader(&tar.Header{Name: "APKINDEX", Mode: 0o644, Size: int64(len(index))}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := tw.Write([]byte(index)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return gzipped(t, buf.Bytes())
+}
+
+func TestAlpineKernelURLs(t *testing.T) {
+	files := map[string][]byte{
+		"/alpine/": []byte(`<a href="edge/">edge/</a> <a href="latest-stable/">latest-stable/</a>
+<a href="v3.19/">v3.19/</a> <a href="v3.20/">v3.20/</a> <a href="v3.9/">v3.9/</a>`),
+		"/alpine/v3.20/main/x86_64/APKINDEX.tar.gz": testAPKIndex(t, `C:Q1abc=
+P:linux-lts
+V:6.6.31-r0
+A:x86_64
+
+C:Q1def=
+P:linux-lts-dev
+V:6.6.31-r0
+A:x86_64
+`),
+		"/alpine/v3.19/main/x86_64/APKINDEX.tar.gz": testAPKIndex(t, `P:linux-lts-dev
+V:6.6.30-r0
+A:x86_64
+`),
+	}
+	var requested []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested = append(requested, r.URL.Path)
+		testFilesHandler(files)(w, r)
+	}))
+	defer srv.Close()
+	ctx := WithMirrors(context.Background(), Mirrors{
+		TargetTypeAlpine.String(): {srv.URL + "/alpine"},
+	})
+
+	kr := kernelrelease.FromString("6.6.31-0-lts")
+	kr.Architecture = kernelrelease.ArchitectureAmd64
+	b := &alpine{}
+	if err := b.LoadKernelInfo(ctx, kr); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// The gcc of the branch is known before the urls are resolved, e.g. with kernelurls
+	if gcc := b.GCCVersion(kr); !gcc.Equals(semver.Version{Major: 13}) {
+		t.Fatalf("unexpected gcc version: %s", gcc)
+	}
+	urls, err := b.URLs(ctx, kr)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []string{srv.URL + "/alpine/v3.20/main/x86_64/linux-lts-dev-6.6.31-r0.apk"}
+	if !reflect.DeepEqual(urls, expected) {
+		t.Fatalf("unexpected urls: got %v, want %v", urls, expected)
+	}
+	// edge is looked up first, then the newest branches
+	if !reflect.DeepEqual(requested, []string{"/alpine/", "/alpine/edge/main/x86_64/APKINDEX.tar.gz", "/alpine/v3.20/main/x86_64/APKINDEX.tar.gz"}) {
+		t.Fatalf("unexpected requests: %v", requested)
+	}
+
+	// Headers not found are only reported with the urls
+	kr = kernelrelease.FromString("6.6.29-0-virt")
+	kr.Architecture = kernelrelease.ArchitectureAmd64
+	if err = b.LoadKernelInfo(ctx, kr); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err = b.URLs(ctx, kr); !errors.Is(err, HeadersNotFoundErr) {
+		t.Fatalf("expected headers not found error, got: %v", err)
+	}
+	// Without branch, the gcc is picked from the kernel series
+	if gcc := b.GCCVersion(kr); !gcc.Equals(semver.Version{Major: 13}) {
+		t.Fatalf("unexpected gcc version: %s", gcc)
+	}
+}
@@ -16,21 +16,11 @@ package builder
 
 import (
 	"bufio"
-	"bytes"
-	"compress/bzip2"
-	"compress/gzip"
-	"database/sql"
+	"context"
 	_ "embed"
 	"fmt"
-	"io"
-	"io/ioutil"
-	"log"
-	"net/http"
-	"os"
 	"strings"
 
-	_ "modernc.org/sqlite"
-
 	"github.com/diginfra/driverkit/pkg/kernelrelease"
 )
 
@@ -42,9 +32,8 @@ var amazonlinuxTemplate string
 
 type amazonBuilder interface {
 	Builder
+	MirrorsBuilder
 	repos() []string
-	baseUrl() string
-	ext() string
 }
 
 type amazonlinux struct {
@@ -95,8 +84,8 @@ func (a *amazonlinux) TemplateScript() string {
 	return amazonlinuxTemplate
 }
 
-func (a *amazonlinux) URLs(kr kernelrelease.KernelRelease) ([]string, error) {
-	return fetchAmazonLinuxPackagesURLs(a, kr)
+func (a *amazonlinux) URLs(ctx context.Context, kr kernelrelease.KernelRelease) ([]string, error) {
+	return fetchAmazonLinuxPackagesURLs(ctx, a, kr)
 }
 
 func (a *amazonlinux) KernelTemplateData(_ kernelrelease.KernelRelease, urls []string) interface{} {
@@ -118,20 +107,16 @@ func (a *amazonlinux) repos() []string {
 	}
 }
 
-func (a *amazonlinux) baseUrl() string {
-	return "http://repo.us-east-1.amazonaws.com"
-}
-
-func (a *amazonlinux) ext() string {
-	return "bz2"
+func (a *amazonlinux) DefaultMirrors(_ kernelrelease.KernelRelease) []string {
+	return []string{"http://repo.us-east-1.amazonaws.com"}
 }
 
 func (a *amazonlinux2022) Name() string {
 	return TargetTypeAmazonLinux2022.String()
 }
 
-func (a *amazonlinux2022) URLs(kr kernelrelease.KernelRelease) ([]string, error) {
-	return fetchAmazonLinuxPackagesURLs(a, kr)
+func (a *amazonlinux2022) URLs(ctx context.Context, kr kernelrelease.KernelRelease) ([]string, error) {
+	return fetchAmazonLinuxPackagesURLs(ctx, a, kr)
 }
 
 func (a *amazonlinux2022) repos() []string {
@@ -141,20 +126,16 @@ func (a *amazonlinux2022) repos() []string {
 	}
 }
 
-func (a *amazonlinux2022) baseUrl() string {
-	return "https://al2022-repos-us-east-1-9761ab97.s3.dualstack.us-east-1.amazonaws.com/core/mirrors"
-}
-
-func (a *amazonlinux2022) ext() string {
-	return "gz"
+func (a *amazonlinux2022) DefaultMirrors(_ kernelrelease.KernelRelease) []string {
+	return []string{"https://al2022-repos-us-east-1-9761ab97.s3.dualstack.us-east-1.amazonaws.com/core"}
 }
 
 func (a *amazonlinux2023) Name() string {
 	return TargetTypeAmazonLinux2023.String()
 }
 
-func (a *amazonlinux2023) URLs(kr kernelrelease.KernelRelease) ([]string, error) {
-	return fetchAmazonLinuxPackagesURLs(a, kr)
+func (a *amazonlinux2023) URLs(ctx context.Context, kr kernelrelease.KernelRelease) ([]string, error) {
+	return fetchAmazonLinuxPackagesURLs(ctx, a, kr)
 }
 
 func (a *amazonlinux2023) repos() []string {
@@ -163,20 +144,16 @@ func (a *amazonlinux2023) repos() []string {
 	}
 }
 
-func (a *amazonlinux2023) baseUrl() string {
-	return "https://cdn.amazonlinux.com/al2023/core/mirrors/"
-}
-
-func (a *amazonlinux2023) ext() string {
-	return "gz"
+func (a *amazonlinux2023) DefaultMirrors(_ kernelrelease.KernelRelease) []string {
+	return []string{"https://cdn.amazonlinux.com/al2023/core"}
 }
 
 func (a *amazonlinux2) Name() string {
 	return TargetTypeAmazonLinux2.String()
 }
 
-func (a *amazonlinux2) URLs(kr kernelrelease.KernelRelease) ([]string, error) {
-	return fetchAmazonLinuxPackagesURLs(a, kr)
+func (a *amazonlinux2) URLs(ctx context.Context, kr kernelrelease.KernelRelease) ([]string, error) {
+	return fetchAmazonLinuxPackagesURLs(ctx, a, kr)
 }
 
 func (a *amazonlinux2) repos() []string {
@@ -189,23 +166,19 @@ func (a *amazonlinux2) repos() []string {
 	}
 }
 
-func (a *amazonlinux2) baseUrl() string {
-	return "http://amazonlinux.us-east-1.amazonaws.com/2"
+func (a *amazonlinux2) DefaultMirrors(_ kernelrelease.KernelRelease) []string {
+	return []string{"http://amazonlinux.us-east-1.amazonaws.com/2"}
 }
 
-func (a *amazonlinux2) ext() string {
-	return "gz"
-}
-
-func buildMirror(a amazonBuilder, r string, kv kernelrelease.KernelRelease) (string, error) {
+func buildMirror(a amazonBuilder, base, r string, kv kernelrelease.KernelRelease) (string, error) {
 	var baseURL string
 	switch a.(type) {
 	case *amazonlinux:
-		baseURL = fmt.Sprintf("%s/%s", a.baseUrl(), r)
+		baseURL = fmt.Sprintf("%s/%s", base, r)
 	case *amazonlinux2:
-		baseURL = fmt.Sprintf("%s/%s/%s", a.baseUrl(), r, kv.Architecture.ToNonDeb())
-	case *amazonlinux2022:
-		baseURL = fmt.Sprintf("%s/%s/%s", a.baseUrl(), r, kv.Architecture.ToNonDeb())
+		baseURL = fmt.Sprintf("%s/%s/%s", base, r, kv.Architecture.ToNonDeb())
+	case *amazonlinux2022, *amazonlinux2023:
+		baseURL = fmt.Sprintf("%s/mirrors/%s/%s", base, r, kv.Architecture.ToNonDeb())
 	default:
 		return "", fmt.Errorf("unsupported target")
 	}
@@ -214,30 +187,47 @@ func buildMirror(a amazonBuilder, r string, kv kernelrelease.KernelRelease) (str
 	return mirror, nil
 }
 
-type unzipFunc func(io.Reader) ([]byte, error)
+func fetchAmazonLinuxPackagesURLs(ctx context.Context, a amazonBuilder, kv kernelrelease.KernelRelease) ([]string, error) {
+	var (
+		urls []string
+		err  error
+	)
+	// Fall back to the next mirror when the packages are not found
+	for _, base := range mirrorBaseURLs(ctx, a.Name(), a.DefaultMirrors(kv)...) {
+		urls, err = fetchAmazonLinuxMirrorPackagesURLs(ctx, a, base, kv)
+		if err == nil && len(urls) > 0 {
+			break
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+	}
+	return urls, err
+}
 
-func unzipFuncFromBuilder(a amazonBuilder) (unzipFunc, error) {
-	switch a.ext() {
-	case "gz":
-		return gunzip, nil
-	case "bz2":
-		return bunzip, nil
+// rebaseAmazonLinuxRepo rewrites a repository url listed by a mirror.list file
+// under one of the default mirrors to be under the given base url.
+func rebaseAmazonLinuxRepo(a amazonBuilder, base, repo string, kv kernelrelease.KernelRelease) string {
+	for _, defaultBase := range a.DefaultMirrors(kv) {
+		if strings.HasPrefix(repo, defaultBase) {
+			return base + strings.TrimPrefix(repo, defaultBase)
+		}
 	}
-	return nil, fmt.Errorf("unsupported extension: %s", a.ext())
+	return repo
 }
 
-func fetchAmazonLinuxPackagesURLs(a amazonBuilder, kv kernelrelease.KernelRelease) ([]string, error) {
+func fetchAmazonLinuxMirrorPackagesURLs(ctx context.Context, a amazonBuilder, base string, kv kernelrelease.KernelRelease) ([]string, error) {
 	urls := []string{}
 	visited := make(map[string]struct{})
 
 	for _, v := range a.repos() {
-		mirror, err := buildMirror(a, v, kv)
+		mirror, err := buildMirror(a, base, v, kv)
 		if err != nil {
 			return nil, err
 		}
 
 		// Obtain the repo URL by getting mirror URL content
-		mirrorRes, err := http.Get(mirror)
+		mirrorRes, err := httpGet(ctx, mirror)
 		if err != nil {
 			return nil, err
 		}
@@ -253,67 +243,20 @@ func fetchAmazonLinuxPackagesURLs(a amazonBuilder, kv kernelrelease.KernelReleas
 		}
 		repo = strings.ReplaceAll(strings.TrimSuffix(repo, "\n"), "$basearch", kv.Architecture.ToNonDeb())
 		repo = strings.TrimSuffix(repo, "/")
-		repoDatabaseURL := fmt.Sprintf("%s/repodata/primary.sqlite.%s", repo, a.ext())
-		if _, ok := visited[repoDatabaseURL]; ok {
+		repo = rebaseAmazonLinuxRepo(a, base, repo, kv)
+		if _, ok := visited[repo]; ok {
 			continue
 		}
-		// Download the repo database
-		repoRes, err := http.Get(repoDatabaseURL)
-		if err != nil {
-			return nil, err
-		}
-		defer repoRes.Body.Close()
-		visited[repoDatabaseURL] = struct{}{}
+		visited[repo] = struct{}{}
 
-		unzip, err := unzipFuncFromBuilder(a)
-		if err != nil {
-			return nil, err
-		}
-
-		dbBytes, err := unzip(repoRes.Body)
-		if err != nil {
-			return nil, err
-		}
-		// Create the temporary database file
-		dbFile, err := ioutil.TempFile(os.TempDir(), fmt.Sprintf("%s-*.sqlite", a.Name()))
-		if err != nil {
-			return nil, err
-		}
-		defer os.Remove(dbFile.Name())
-		if _, err := dbFile.Write(dbBytes); err != nil {
-			return nil, err
-		}
-		// Open the database
-		db, err := sql.Open("sqlite", dbFile.Name())
-		if err != nil {
-			return nil, err
-		}
-		defer db.Close()
-		// Query the database
 		rel := strings.TrimPrefix(strings.TrimSuffix(kv.FullExtraversion, fmt.Sprintf(".%s", kv.Architecture.ToNonDeb())), "-")
-		q := fmt.Sprintf("SELECT location_href FROM packages WHERE name LIKE 'kernel-devel%%' AND version='%s' AND release='%s'", kv.Fullversion, rel)
-		stmt, err := db.Prepare(q)
+		repoURLs, err := fetchRPMRepoPackageURLs(ctx, repo, func(p *rpmPackage) bool {
+			return strings.HasPrefix(p.Name, "kernel-devel") && p.Version == kv.Fullversion && p.Release == rel
+		})
 		if err != nil {
 			return nil, err
 		}
-		defer stmt.Close()
-		rows, err := stmt.Query()
-		if err != nil {
-			return nil, err
-		}
-		defer rows.Close()
-		for rows.Next() {
-			var href string
-			err = rows.Scan(&href)
-			if err != nil {
-				log.Fatal(err)
-			}
-			urls = append(urls, fmt.Sprintf("%s/%s", repo, href))
-		}
-
-		if err := dbFile.Close(); err != nil {
-			return nil, err
-		}
+		urls = append(urls, repoURLs...)
 
 		// Found, do not continue
 		if len(urls) > 0 {
@@ -323,36 +266,3 @@ func fetchAmazonLinuxPackagesURLs(a amazonBuilder, kv kernelrelease.KernelReleas
 
 	return urls, nil
 }
-
-func gunzip(data io.Reader) (res []byte, err error) {
-	var r io.Reader
-	r, err = gzip.NewReader(data)
-	if err != nil {
-		return
-	}
-
-	var b bytes.Buffer
-	_, err = b.ReadFrom(r)
-	if err != nil {
-		return
-	}
-
-	res = b.Bytes()
-
-	return
-}
-
-func bunzip(data io.Reader) (res []byte, err error) {
-	var r io.Reader
-	r = bzip2.NewReader(data)
-
-	var b bytes.Buffer
-	_, err = b.ReadFrom(r)
-	if err != nil {
-		return
-	}
-
-	res = b.Bytes()
-
-	return
-}
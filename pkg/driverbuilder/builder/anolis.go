@@ -0,0 +1,104 @@
+// SPDX-License-Identifier: Apache-2.0
+/*
+Copyright (C) 2023 The Diginfra Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package builder
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+
+	"github.com/blang/semver"
+	"github.com/diginfra/driverkit/pkg/kernelrelease"
+)
+
+// TargetTypeAnolis identifies the Anolis OS target.
+const TargetTypeAnolis Type = "anolis"
+
+func init() {
+	byTarget[TargetTypeAnolis] = &anolis{}
+}
+
+// anolis is a driverkit target.
+type anolis struct {
+}
+
+// anolisReleaseRegex matches the release of a kernel release, e.g. 8 and 8 for 4.18.0-477.13.1.0.1.an8_8.x86_64.
+var anolisReleaseRegex = regexp.MustCompile(`\.an(\d+)(?:_(\d+))?`)
+
+func (c *anolis) Name() string {
+	return TargetTypeAnolis.String()
+}
+
+// TemplateKernelUrlsScript reuses the rocky one: Anolis OS is rebuilt from the RHEL sources,
+// its kernel-devel packages being laid out like the Rocky Linux ones.
+func (c *anolis) TemplateKernelUrlsScript() string {
+	return rockyKernelTemplate
+}
+
+func (c *anolis) TemplateScript() string {
+	return rockyTemplate
+}
+
+func (c *anolis) DefaultMirrors(_ kernelrelease.KernelRelease) []string {
+	return []string{"https://mirrors.openanolis.cn/anolis"}
+}
+
+func (c *anolis) URLs(ctx context.Context, kr kernelrelease.KernelRelease) ([]string, error) {
+	m := anolisReleaseRegex.FindStringSubmatch(kr.FullExtraversion)
+	if m == nil {
+		return nil, fmt.Errorf("%w: no anolis release in %s", HeadersNotFoundErr, kr.String())
+	}
+	major, minor := m[1], m[2]
+	// The RHCK kernels are published in BaseOS, the ANCK ones in Plus;
+	// since Anolis OS 23, all of them in os and updates.
+	repoNames := []string{"BaseOS", "Plus", "AppStream"}
+	if compareReleases(major, "23") >= 0 {
+		repoNames = []string{"updates", "os"}
+	}
+
+	var repos []rpmRepo
+	for _, mirror := range mirrorBaseURLs(ctx, TargetTypeAnolis.String(), c.DefaultMirrors(kr)...) {
+		releases := append([]string{major}, rpmPointReleases(ctx, mirror, major, minor)...)
+		for _, r := range releases {
+			for _, repo := range repoNames {
+				repos = append(repos, rpmRepo{URL: fmt.Sprintf("%s/%s/%s/%s/os", mirror, r, repo, kr.Architecture.ToNonDeb())})
+			}
+		}
+	}
+	return rpmRepodataURLs(ctx, repos, rpmKernelPackages{names: []string{"kernel-devel"}, kr: kr})
+}
+
+func (c *anolis) KernelTemplateData(_ kernelrelease.KernelRelease, urls []string) interface{} {
+	return rockyTemplateData{
+		KernelDownloadURL: urls[0],
+	}
+}
+
+func (c *anolis) GCCVersion(kr kernelrelease.KernelRelease) semver.Version {
+	// Anolis OS 7 and 8 keep the gcc of their RHEL base, 23 ships its own
+	m := anolisReleaseRegex.FindStringSubmatch(kr.FullExtraversion)
+	if m == nil {
+		return semver.Version{}
+	}
+	switch {
+	case compareReleases(m[1], "23") >= 0:
+		return semver.Version{Major: 12}
+	case m[1] == "8":
+		return semver.Version{Major: 8}
+	case m[1] == "7":
+		return semver.Version{Major: 4, Minor: 8, Patch: 5}
+	}
+	return semver.Version{}
+}
@@ -15,6 +15,7 @@ limitations under the License.
 package builder
 
 import (
+	"context"
 	_ "embed"
 	"fmt"
 	"strings"
@@ -53,7 +54,7 @@ func (c *archlinux) TemplateScript() string {
 	return archlinuxTemplate
 }
 
-func (c *archlinux) URLs(kr kernelrelease.KernelRelease) ([]string, error) {
+func (c *archlinux) URLs(_ context.Context, kr kernelrelease.KernelRelease) ([]string, error) {
 	// uname -r returns "6.8.1-arch1-1" but headers URL is "6.8.1.arch1-1"
 	// Also, for 0-patch releases, like: "6.8.0-arch1-1", headers url is "6.8.arch1-1"
 	kr.FullExtraversion = strings.Replace(kr.FullExtraversion, "-arch", ".arch", 1)
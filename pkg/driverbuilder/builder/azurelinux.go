@@ -0,0 +1,130 @@
+// SPDX-License-Identifier: Apache-2.0
+/*
+Copyright (C) 2023 The Diginfra Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package builder
+
+import (
+	"context"
+	_ "embed"
+	"fmt"
+	"regexp"
+
+	"github.com/blang/semver"
+	"github.com/diginfra/driverkit/pkg/kernelrelease"
+)
+
+//go:embed templates/azurelinux_kernel.sh
+var azureLinuxKernelTemplate string
+
+//go:embed templates/azurelinux.sh
+var azureLinuxTemplate string
+
+// TargetTypeAzureLinux identifies the Azure Linux target.
+const TargetTypeAzureLinux Type = "azurelinux"
+
+// TargetTypeMariner identifies the CBL-Mariner target, as Azure Linux was named up to its release 2.
+const TargetTypeMariner Type = "mariner"
+
+func init() {
+	byTarget[TargetTypeAzureLinux] = &azurelinux{}
+	byTarget[TargetTypeMariner] = &mariner{}
+}
+
+type azureLinuxTemplateData struct {
+	KernelDownloadURL string
+}
+
+// azurelinux is a driverkit target.
+type azurelinux struct {
+}
+
+// mariner is a driverkit target, sharing the azurelinux builder.
+type mariner struct {
+	azurelinux
+}
+
+// azureLinuxReleaseRegex matches the release of a kernel release, e.g. cm2 for 5.15.153.1-2.cm2.x86_64.
+var azureLinuxReleaseRegex = regexp.MustCompile(`\.(cm|azl)(\d+)`)
+
+func (c *azurelinux) Name() string {
+	return TargetTypeAzureLinux.String()
+}
+
+func (c *azurelinux) TemplateKernelUrlsScript() string {
+	return azureLinuxKernelTemplate
+}
+
+func (c *azurelinux) TemplateScript() string {
+	return azureLinuxTemplate
+}
+
+func (c *azurelinux) DefaultMirrors(_ kernelrelease.KernelRelease) []string {
+	return []string{"https://packages.microsoft.com"}
+}
+
+func (c *azurelinux) URLs(ctx context.Context, kr kernelrelease.KernelRelease) ([]string, error) {
+	return fetchAzureLinuxKernelURLS(ctx, mirrorBaseURLs(ctx, c.Name(), c.DefaultMirrors(kr)...), kr)
+}
+
+func (c *azurelinux) KernelTemplateData(_ kernelrelease.KernelRelease, urls []string) interface{} {
+	return azureLinuxTemplateData{
+		KernelDownloadURL: urls[0],
+	}
+}
+
+func (c *azurelinux) GCCVersion(kr kernelrelease.KernelRelease) semver.Version {
+	// The toolchain is bumped with each major release
+	m := azureLinuxReleaseRegex.FindStringSubmatch(kr.FullExtraversion)
+	if m == nil {
+		return semver.Version{}
+	}
+	switch m[1] + m[2] {
+	case "cm1":
+		return semver.Version{Major: 9}
+	case "cm2":
+		return semver.Version{Major: 11}
+	case "azl3":
+		return semver.Version{Major: 13}
+	}
+	return semver.Version{}
+}
+
+func (c *mariner) Name() string {
+	return TargetTypeMariner.String()
+}
+
+func (c *mariner) URLs(ctx context.Context, kr kernelrelease.KernelRelease) ([]string, error) {
+	return fetchAzureLinuxKernelURLS(ctx, mirrorBaseURLs(ctx, c.Name(), c.DefaultMirrors(kr)...), kr)
+}
+
+func fetchAzureLinuxKernelURLS(ctx context.Context, mirrors []string, kr kernelrelease.KernelRelease) ([]string, error) {
+	m := azureLinuxReleaseRegex.FindStringSubmatch(kr.FullExtraversion)
+	if m == nil {
+		return nil, fmt.Errorf("%w: no azure linux release in %s", HeadersNotFoundErr, kr.String())
+	}
+	// Releases up to 2 are published as cbl-mariner, the later ones as azurelinux
+	dir := "azurelinux"
+	if m[1] == "cm" {
+		dir = "cbl-mariner"
+	}
+	release := m[2] + ".0"
+
+	var repos []rpmRepo
+	for _, mirror := range mirrors {
+		for _, repo := range []string{"base", "update"} {
+			repos = append(repos, rpmRepo{URL: fmt.Sprintf("%s/%s/%s/prod/%s/%s", mirror, dir, release, repo, kr.Architecture.ToNonDeb())})
+		}
+	}
+	return rpmRepodataURLs(ctx, repos, rpmKernelPackages{names: []string{"kernel-devel"}, kr: kr})
+}
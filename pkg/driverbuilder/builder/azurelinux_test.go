@@ -0,0 +1,65 @@
+// SPDX-License-Identifier: Apache-2.0
+/*
+Copyright (C) 2023 The Diginfra Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package builder
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/blang/semver"
+
+	"github.com/diginfra/driverkit/pkg/kernelrelease"
+)
+
+func TestAzureLinuxKernelURLs(t *testing.T) {
+	primary := strings.NewReplacer(
+		"5.14.10", "5.15.153.1",
+		"300.fc35", "2.cm2",
+	).Replace(testPrimaryXML)
+	srv := testFileServer(testRPMRepoFiles(t, primary, "/cbl-mariner/2.0/prod/base/x86_64"))
+	defer srv.Close()
+	ctx := WithMirrors(context.Background(), Mirrors{
+		TargetTypeAzureLinux.String(): {srv.URL},
+		TargetTypeMariner.String():    {srv.URL},
+	})
+
+	kr := kernelrelease.FromString("5.15.153.1-2.cm2.x86_64")
+	kr.Architecture = kernelrelease.ArchitectureAmd64
+	expected := []string{srv.URL + "/cbl-mariner/2.0/prod/base/x86_64/Packages/k/kernel-devel-5.15.153.1-2.cm2.x86_64.rpm#sha256=" + testKernelDevelDigest}
+	for _, b := range []Builder{&azurelinux{}, &mariner{}} {
+		urls, err := b.URLs(ctx, kr)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", b.Name(), err)
+		}
+		if !reflect.DeepEqual(urls, expected) {
+			t.Fatalf("%s: unexpected urls: got %v, want %v", b.Name(), urls, expected)
+		}
+	}
+	if gcc := (&azurelinux{}).GCCVersion(kr); !gcc.Equals(semver.Version{Major: 11}) {
+		t.Fatalf("unexpected gcc version: %s", gcc)
+	}
+
+	// Azure Linux 3 kernels are looked up under azurelinux
+	kr = kernelrelease.FromString("6.6.47.1-1.azl3.x86_64")
+	kr.Architecture = kernelrelease.ArchitectureAmd64
+	_, err := (&azurelinux{}).URLs(ctx, kr)
+	var notFound *HeadersNotFoundError
+	if !errors.As(err, &notFound) || !strings.HasPrefix(notFound.URLs[0], srv.URL+"/azurelinux/3.0/prod/base/x86_64") {
+		t.Fatalf("expected headers not found error, got: %v", err)
+	}
+}
@@ -42,6 +42,8 @@ type Build struct {
 	BuilderRepos      []string
 	ImagesListers     []ImagesLister
 	KernelUrls        []string
+	Mirrors           Mirrors
+	HeadersCacheDir   string
 	GCCVersion        string
 	RepoOrg           string
 	RepoName          string
@@ -54,11 +56,19 @@ type Build struct {
 	*output.Printer
 }
 
-func (b *Build) KernelReleaseFromBuildConfig() kernelrelease.KernelRelease {
-	kv := kernelrelease.FromString(b.KernelRelease)
+// KernelReleaseFromBuildConfig parses the kernel release to build for,
+// returning kernelrelease.ErrInvalidKernelRelease or kernelrelease.ErrUnsupportedArch on invalid configs.
+func (b *Build) KernelReleaseFromBuildConfig() (kernelrelease.KernelRelease, error) {
+	kv, err := kernelrelease.Parse(b.KernelRelease)
+	if err != nil {
+		return kv, err
+	}
 	kv.Architecture = kernelrelease.Architecture(b.Architecture)
+	if err = kv.Architecture.Validate(); err != nil {
+		return kv, err
+	}
 	kv.KernelVersion = b.KernelVersion
-	return kv
+	return kv, nil
 }
 
 func (b *Build) toGithubRepoArchive() string {
@@ -16,6 +16,7 @@ package builder
 
 import (
 	"bytes"
+	"context"
 	_ "embed"
 	"errors"
 	"fmt"
@@ -27,6 +28,7 @@ import (
 	"text/template"
 
 	"github.com/blang/semver"
+	"github.com/diginfra/driverkit/pkg/httpclient"
 	"github.com/diginfra/driverkit/pkg/kernelrelease"
 )
 
@@ -54,8 +56,25 @@ const (
 //go:embed templates/libs_download.sh
 var libsDownloadTemplate string
 
+// HeadersNotFoundErr is returned when the kernel headers cannot be found.
 var HeadersNotFoundErr = errors.New("kernel headers not found")
 
+// HeadersNotFoundError wraps HeadersNotFoundErr, listing the urls that were tried.
+type HeadersNotFoundError struct {
+	URLs []string
+}
+
+func (e *HeadersNotFoundError) Error() string {
+	if len(e.URLs) == 0 {
+		return HeadersNotFoundErr.Error()
+	}
+	return fmt.Sprintf("%s, tried: %s", HeadersNotFoundErr.Error(), strings.Join(e.URLs, ", "))
+}
+
+func (e *HeadersNotFoundError) Unwrap() error {
+	return HeadersNotFoundErr
+}
+
 // Config contains all the configurations needed to build the kernel module or the eBPF probe.
 type Config struct {
 	DriverName      string
@@ -87,7 +106,7 @@ type Builder interface {
 	Name() string
 	TemplateKernelUrlsScript() string
 	TemplateScript() string
-	URLs(kr kernelrelease.KernelRelease) ([]string, error)
+	URLs(ctx context.Context, kr kernelrelease.KernelRelease) ([]string, error)
 	KernelTemplateData(kr kernelrelease.KernelRelease, urls []string) interface{} // error return type is managed
 }
 
@@ -97,6 +116,13 @@ type MinimumURLsBuilder interface {
 	MinimumURLs() int
 }
 
+// KernelInfoLoader is an optional interface implemented by builders
+// that need to fetch infos about the kernel release before resolving its headers urls
+// or its gcc version, even when headers urls are provided by the user.
+type KernelInfoLoader interface {
+	LoadKernelInfo(ctx context.Context, kr kernelrelease.KernelRelease) error
+}
+
 // TemplateDataSpecifier is an optional interface implemented by builders
 // to specify a custom template data instead of the default one.
 type TemplateDataSpecifier interface {
@@ -118,7 +144,7 @@ func LibsDownloadScript(c Config) (string, error) {
 
 	td := libsDownloadTemplateData{
 		DriverBuildDir:    DriverDirectory,
-		ModuleDownloadURL: fmt.Sprintf("%s/%s.tar.gz", c.DownloadBaseURL, c.DriverVersion),
+		ModuleDownloadURL: c.LibsDownloadURL(),
 	}
 
 	buf := bytes.NewBuffer(nil)
@@ -130,49 +156,50 @@ func LibsDownloadScript(c Config) (string, error) {
 	return buf.String(), nil
 }
 
-// KernelDownloadScript returns the script that will download and extract kernel headers
-func KernelDownloadScript(b Builder,
+// LibsDownloadURL returns the url of the libs sources archive at the requested driver version.
+func (c Config) LibsDownloadURL() string {
+	return fmt.Sprintf("%s/%s.tar.gz", c.DownloadBaseURL, c.DriverVersion)
+}
+
+// KernelDownloadScript returns the script that will download and extract kernel headers,
+// together with the resolved kernel headers urls
+func KernelDownloadScript(ctx context.Context,
+	b Builder,
 	kernelurls []string,
 	kr kernelrelease.KernelRelease,
 	printer *output.Printer,
-) (string, error) {
-	t := template.New("download-kernel")
-	parsed, err := t.Parse(b.TemplateKernelUrlsScript())
+) (string, []string, error) {
+	resolution, err := ResolveKernelURLs(ctx, b, kernelurls, kr)
 	if err != nil {
-		return "", err
+		return "", nil, err
 	}
+	urls := resolution.URLs
 
-	var urls []string
-	minimumURLs := 1
-	if bb, ok := b.(MinimumURLsBuilder); ok {
-		minimumURLs = bb.MinimumURLs()
-	}
+	printer.Logger.Debug("kernel headers found",
+		printer.Logger.Args("urls", urls))
 
-	if kernelurls == nil {
-		urls, err = b.URLs(kr)
-		if err != nil {
-			return "", err
-		}
-		// Only if returned urls array is not empty
-		// Otherwise, it is up to the builder to return an error
-		if len(urls) > 0 {
-			// Check (and filter) existing kernels before continuing
-			urls, err = GetResolvingURLs(urls)
-		}
-	} else {
-		urls, err = GetResolvingURLs(kernelurls)
+	script, err := KernelDownloadScriptFromURLs(b, kr, urls)
+	if err != nil {
+		return "", nil, err
 	}
+	return script, urls, nil
+}
+
+// KernelDownloadScriptFromURLs returns the script that will download and extract
+// the kernel headers from the given, already resolved, urls.
+// The packages whose url has a checksum are verified before being extracted.
+func KernelDownloadScriptFromURLs(b Builder, kr kernelrelease.KernelRelease, urls []string) (string, error) {
+	t := template.New("download-kernel")
+	parsed, err := t.Parse(b.TemplateKernelUrlsScript())
 	if err != nil {
 		return "", err
 	}
 
-	if len(urls) < minimumURLs {
-		return "", fmt.Errorf("not enough headers packages found; expected %d, found %d", minimumURLs, len(urls))
+	urls, checksumsScript, err := verifiedKernelURLs(urls)
+	if err != nil {
+		return "", err
 	}
 
-	printer.Logger.Debug("kernel headers found",
-		printer.Logger.Args("urls", urls))
-
 	td := b.KernelTemplateData(kr, urls)
 	if tdErr, ok := td.(error); ok {
 		return "", tdErr
@@ -184,11 +211,26 @@ func KernelDownloadScript(b Builder,
 		return "", err
 	}
 
-	return buf.String(), nil
+	return withChecksumsScript(buf.String(), checksumsScript), nil
+}
+
+// withChecksumsScript includes the given checksums script into the kernel download script,
+// right after its shell options so that a mismatching package aborts the download.
+func withChecksumsScript(script, checksumsScript string) string {
+	if checksumsScript == "" {
+		return script
+	}
+	lines := strings.SplitAfter(script, "\n")
+	for i, line := range lines {
+		if strings.HasPrefix(line, "set -") {
+			return strings.Join(lines[:i+1], "") + checksumsScript + strings.Join(lines[i+1:], "")
+		}
+	}
+	return "set -euo pipefail\n" + checksumsScript + script
 }
 
 // Script retrieves the actually drivers building script
-func Script(b Builder, c Config, kr kernelrelease.KernelRelease) (string, error) {
+func Script(ctx context.Context, b Builder, c Config, kr kernelrelease.KernelRelease) (string, error) {
 	t := template.New(b.Name())
 	parsed, err := t.Parse(b.TemplateScript())
 	if err != nil {
@@ -199,7 +241,10 @@ func Script(b Builder, c Config, kr kernelrelease.KernelRelease) (string, error)
 	if bb, ok := b.(TemplateDataSpecifier); ok {
 		td = bb.TemplateData(c, kr)
 	} else {
-		td = c.toTemplateData(b, kr)
+		td, err = c.toTemplateData(ctx, b, kr)
+		if err != nil {
+			return "", err
+		}
 	}
 
 	buf := bytes.NewBuffer(nil)
@@ -243,27 +288,22 @@ func defaultGCC(kr kernelrelease.KernelRelease) semver.Version {
 	}
 }
 
-func mustParseTolerant(gccStr string) semver.Version {
-	g, err := semver.ParseTolerant(gccStr)
-	if err != nil {
-		panic(err)
-	}
-	return g
-}
-
 // Algorithm.
 // * always load images (note that it loads only images that provide gccversion, if set by user)
 // * if user set a fixed gccversion, we are good to go
 // * otherwise, try to fix the best-match gcc version provided by any of the loaded images;
 // see below for algorithm explanation
-func (b *Build) setGCCVersion(builder Builder, kr kernelrelease.KernelRelease) {
-	if !b.hasCustomBuilderImage() {
-		b.LoadImages()
+func (b *Build) setGCCVersion(ctx context.Context, builder Builder, kr kernelrelease.KernelRelease) error {
+	// Images may have already been loaded, eg: by ResolveBuilderImage.
+	if !b.hasCustomBuilderImage() && len(b.Images) == 0 {
+		if err := b.LoadImages(ctx); err != nil {
+			return err
+		}
 	}
 
 	if len(b.GCCVersion) > 0 {
 		// If set from user, go on
-		return
+		return nil
 	}
 
 	b.GCCVersion = "8" // default value
@@ -285,7 +325,7 @@ func (b *Build) setGCCVersion(builder Builder, kr kernelrelease.KernelRelease) {
 
 	if b.hasCustomBuilderImage() {
 		b.GCCVersion = targetGCC.String()
-		return
+		return nil
 	}
 
 	// Step 1:
@@ -323,6 +363,7 @@ func (b *Build) setGCCVersion(builder Builder, kr kernelrelease.KernelRelease) {
 	}
 	b.Logger.Debug("found GCC",
 		b.Logger.Args("targetGCC", targetGCC.String(), "version", b.GCCVersion))
+	return nil
 }
 
 type BuilderImageNetworkMode interface {
@@ -330,18 +371,47 @@ type BuilderImageNetworkMode interface {
 	BuilderImageNetMode() string
 }
 
-func (b *Build) GetBuilderImage() string {
+// GetBuilderImage returns the builder image providing the gcc version fixed by setGCCVersion(),
+// or ErrNoBuilderImage if there is none.
+func (b *Build) GetBuilderImage() (string, error) {
 	if b.hasCustomBuilderImage() {
 		// BuilderImage MUST have requested GCC installed inside
-		return b.BuilderImage
+		return b.BuilderImage, nil
 	}
 
-	// NOTE: here below we are already sure that we are going
-	// to find an image, because setGCCVersion()
-	// has already set an existent gcc version
-	// (ie: one provided by an image) for us
-	image, _ := b.Images.findImage(b.TargetType, mustParseTolerant(b.GCCVersion))
-	return image.Name
+	gcc, err := semver.ParseTolerant(b.GCCVersion)
+	if err != nil {
+		return "", fmt.Errorf("invalid gcc version %q: %w", b.GCCVersion, err)
+	}
+	// setGCCVersion() fixes a gcc version provided by an image,
+	// unless it was forced by the user.
+	image, ok := b.Images.findImage(b.TargetType, gcc)
+	if !ok {
+		return "", fmt.Errorf("%w: target %s, gcc %s", ErrNoBuilderImage, b.TargetType, b.GCCVersion)
+	}
+	return image.Name, nil
+}
+
+// ResolveBuilderImage returns the builder image that will be used for the build,
+// fixing the gcc version as the build itself would do.
+func (b *Build) ResolveBuilderImage(ctx context.Context) (string, error) {
+	v, err := Factory(b.TargetType)
+	if err != nil {
+		return "", err
+	}
+	kr, err := b.KernelReleaseFromBuildConfig()
+	if err != nil {
+		return "", err
+	}
+	if bb, ok := v.(KernelInfoLoader); ok {
+		if err = bb.LoadKernelInfo(ctx, kr); err != nil {
+			return "", err
+		}
+	}
+	if err = b.setGCCVersion(ctx, v, kr); err != nil {
+		return "", err
+	}
+	return b.GetBuilderImage()
 }
 
 // Factory returns a builder for the given target.
@@ -373,8 +443,10 @@ func Targets() []string {
 	return res
 }
 
-func (c Config) toTemplateData(b Builder, kr kernelrelease.KernelRelease) commonTemplateData {
-	c.setGCCVersion(b, kr)
+func (c Config) toTemplateData(ctx context.Context, b Builder, kr kernelrelease.KernelRelease) (commonTemplateData, error) {
+	if err := c.setGCCVersion(ctx, b, kr); err != nil {
+		return commonTemplateData{}, err
+	}
 	return commonTemplateData{
 		DriverBuildDir:   DriverDirectory,
 		ModuleDriverName: c.DriverName,
@@ -391,40 +463,37 @@ func (c Config) toTemplateData(b Builder, kr kernelrelease.KernelRelease) common
 			c.DeviceName,
 			c.DeviceName,
 			c.DriverVersion),
-	}
+	}, nil
 }
 
-func resolveURLReference(u string) string {
+func resolveURLReference(u string) (string, error) {
 	uu, err := url.Parse(u)
 	if err != nil {
-		panic(err)
-	}
-	base, err := url.Parse(uu.Host)
-	if err != nil {
-		panic(err)
+		return "", err
 	}
-	return base.ResolveReference(uu).String()
+	// Resolving an absolute url against itself removes its dot segments
+	return uu.ResolveReference(uu).String(), nil
 }
 
-func GetResolvingURLs(urls []string) ([]string, error) {
+// GetResolvingURLs returns the urls that can be reached, issuing a HEAD request for each of them.
+func GetResolvingURLs(ctx context.Context, urls []string) ([]string, error) {
+	statuses, err := CheckURLs(ctx, urls)
+	if err != nil {
+		return nil, err
+	}
 	var results []string
-	for _, u := range urls {
-		// in case url has some relative paths
-		// (kernel-crawler does not resolve them for us,
-		// neither it is expected, because they are effectively valid urls),
-		// resolve the absolute one.
-		// HEAD would fail otherwise.
-		u = resolveURLReference(u)
-		res, err := http.Head(u)
-		if err != nil {
-			continue
-		}
-		if res.StatusCode == http.StatusOK {
-			results = append(results, u)
+	for i := range statuses {
+		if statuses[i].Resolving() {
+			results = append(results, statuses[i].URL)
 		}
 	}
 	if len(results) == 0 {
-		return nil, HeadersNotFoundErr
+		return nil, &HeadersNotFoundError{URLs: urls}
 	}
 	return results, nil
 }
+
+// httpGet issues a GET request bound to the given context through the shared HTTP client.
+func httpGet(ctx context.Context, u string) (*http.Response, error) {
+	return httpclient.Default().Get(ctx, u)
+}
@@ -15,6 +15,13 @@ limitations under the License.
 package builder
 
 import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/blang/semver"
@@ -105,3 +112,110 @@ func TestDefaultGCC(t *testing.T) {
 		}
 	}
 }
+
+func TestGetResolvingURLs(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/found.deb" {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+	baseURL := strings.Replace(srv.URL, "127.0.0.1", "localhost", 1)
+
+	urls, err := GetResolvingURLs(context.Background(), []string{baseURL + "/found.deb", baseURL + "/missing.deb"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(urls) != 1 || urls[0] != baseURL+"/found.deb" {
+		t.Fatalf("unexpected resolving urls: %v", urls)
+	}
+
+	_, err = GetResolvingURLs(context.Background(), []string{baseURL + "/missing.deb"})
+	if !errors.Is(err, HeadersNotFoundErr) {
+		t.Fatalf("expected headers not found error, got: %v", err)
+	}
+	var notFoundErr *HeadersNotFoundError
+	if !errors.As(err, &notFoundErr) || len(notFoundErr.URLs) != 1 {
+		t.Fatalf("expected tried urls attached to the error, got: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err = GetResolvingURLs(ctx, []string{baseURL + "/found.deb"}); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context canceled error, got: %v", err)
+	}
+}
+
+func TestResolveKernelURLs(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, "/alinux/3/") {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+	baseURL := strings.Replace(srv.URL, "127.0.0.1", "localhost", 1)
+
+	kr := kernelrelease.FromString("5.10.134-13.al8.x86_64")
+	kr.Architecture = kernelrelease.ArchitectureAmd64
+	ctx := WithMirrors(context.Background(), Mirrors{
+		TargetTypeAlinux.String(): {baseURL + "/alinux"},
+	})
+
+	res, err := ResolveKernelURLs(ctx, &alinux{}, nil, kr)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	found := baseURL + "/alinux/3/os/x86_64/Packages/kernel-devel-5.10.134-13.al8.x86_64.rpm"
+	if len(res.URLs) != 1 || res.URLs[0] != found {
+		t.Fatalf("unexpected resolved urls: %v", res.URLs)
+	}
+	if len(res.Candidates) != 3 {
+		t.Fatalf("unexpected candidates: %v", res.Candidates)
+	}
+	for _, candidate := range res.Candidates {
+		if candidate.Resolving() != (candidate.URL == found) {
+			t.Fatalf("unexpected candidate status: %v", candidate)
+		}
+		if !candidate.Resolving() && candidate.StatusCode != http.StatusNotFound {
+			t.Fatalf("unexpected candidate status code: %v", candidate)
+		}
+	}
+
+	// Explicit kernel urls are checked as well
+	res, err = ResolveKernelURLs(ctx, &alinux{}, []string{baseURL + "/missing.rpm"}, kr)
+	if !errors.Is(err, HeadersNotFoundErr) {
+		t.Fatalf("expected headers not found error, got: %v", err)
+	}
+	if len(res.Candidates) != 1 || res.Candidates[0].StatusCode != http.StatusNotFound {
+		t.Fatalf("unexpected candidates: %v", res.Candidates)
+	}
+}
+
+func TestResolveLocalKernelURLs(t *testing.T) {
+	dir := t.TempDir()
+	local := filepath.Join(dir, "kernel-devel.rpm")
+	if err := os.WriteFile(local, []byte("headers"), 0o600); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, u := range []string{local, "file://" + local} {
+		p, ok := LocalPath(u)
+		if !ok || p != local {
+			t.Fatalf("unexpected local path for %s: %s", u, p)
+		}
+	}
+	for _, u := range []string{"https://mirrors.kernel.org/fedora/kernel-devel.rpm", "mirrors.kernel.org/fedora/kernel-devel.rpm"} {
+		if _, ok := LocalPath(u); ok {
+			t.Fatalf("remote url %s considered local", u)
+		}
+	}
+
+	// Local packages are checked without any request, missing ones are skipped
+	urls, err := GetResolvingURLs(context.Background(), []string{local, filepath.Join(dir, "missing.rpm"), dir})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(urls) != 1 || urls[0] != "file://"+local {
+		t.Fatalf("unexpected resolving urls: %v", urls)
+	}
+}
@@ -15,6 +15,7 @@ limitations under the License.
 package builder
 
 import (
+	"context"
 	_ "embed"
 	"fmt"
 
@@ -53,131 +54,59 @@ func (c *centos) TemplateScript() string {
 	return centosTemplate
 }
 
-func (c *centos) URLs(kr kernelrelease.KernelRelease) ([]string, error) {
-	vaultReleases := []string{
-		"6.0/os",
-		"6.0/updates",
-		"6.1/os",
-		"6.1/updates",
-		"6.2/os",
-		"6.2/updates",
-		"6.3/os",
-		"6.3/updates",
-		"6.4/os",
-		"6.4/updates",
-		"6.5/os",
-		"6.5/updates",
-		"6.6/os",
-		"6.6/updates",
-		"6.7/os",
-		"6.7/updates",
-		"6.8/os",
-		"6.8/updates",
-		"6.9/os",
-		"6.9/updates",
-		"6.10/os",
-		"6.10/updates",
-		"7.0.1406/os",
-		"7.0.1406/updates",
-		"7.1.1503/os",
-		"7.1.1503/updates",
-		"7.2.1511/os",
-		"7.2.1511/updates",
-		"7.3.1611/os",
-		"7.3.1611/updates",
-		"7.4.1708/os",
-		"7.4.1708/updates",
-		"7.5.1804/os",
-		"7.5.1804/updates",
-		"7.6.1810/os",
-		"7.6.1810/updates",
-		"7.7.1908/os",
-		"7.7.1908/updates",
-		"7.8.2003/os",
-		"7.8.2003/updates",
-		"7.9.2009/os",
-		"7.9.2009/updates",
-		"8.0.1905/os",
-		"8.0.1905/updates",
-		"8.1.1911/os",
-		"8.1.1911/updates",
-	}
+// centos mirrors, each one hosting a different set of releases
+const (
+	centosEdgeMirror   = "https://mirrors.edge.kernel.org/centos"
+	centosVaultMirror  = "http://vault.centos.org"
+	centosStreamMirror = "http://mirror.stream.centos.org"
+)
 
-	centos8VaultReleases := []string{
-		"8.0.1905/BaseOS",
-		"8.1.1911/BaseOS",
-		"8.2.2004/BaseOS",
-		"8.3.2011/BaseOS",
-		"8.4.2105/BaseOS",
-		"8.5.2111/BaseOS",
-	}
+func (c *centos) DefaultMirrors(_ kernelrelease.KernelRelease) []string {
+	return []string{centosEdgeMirror, centosVaultMirror, centosStreamMirror}
+}
 
-	edgeReleases := []string{
-		"6/os",
-		"6/updates",
-		"7/os",
-		"7/updates",
+func (c *centos) URLs(ctx context.Context, kr kernelrelease.KernelRelease) ([]string, error) {
+	major, minor, ok := rpmELRelease(kr)
+	if !ok {
+		return nil, fmt.Errorf("%w: no centos release in %s", HeadersNotFoundErr, kr.String())
 	}
-
-	streamReleases := []string{
-		"8/BaseOS",
-		"8-stream/BaseOS",
+	arch := kr.Architecture.ToNonDeb()
+
+	// Up to centos 7, releases are split into os and updates repositories;
+	// since centos 8, into BaseOS and AppStream ones, also published as streams.
+	layouts := []string{"%s/%s/os/%s", "%s/%s/updates/%s"}
+	var streams []string
+	if compareReleases(major, "8") >= 0 {
+		layouts = []string{"%s/%s/BaseOS/%s/os", "%s/%s/AppStream/%s/os"}
+		streams = []string{major + "-stream"}
 	}
 
-	stream9Releases := []string{
-		"9-stream/AppStream",
-		"9-stream/BaseOS",
+	var repos []rpmRepo
+	appendRepos := func(mirror string, releases ...string) {
+		for _, r := range releases {
+			for _, layout := range layouts {
+				repos = append(repos, rpmRepo{URL: fmt.Sprintf(layout, mirror, r, arch)})
+			}
+		}
 	}
-
-	urls := []string{}
-	for _, r := range edgeReleases {
-		urls = append(urls, fmt.Sprintf(
-			"https://mirrors.edge.kernel.org/centos/%s/%s/Packages/kernel-devel-%s%s.rpm",
-			r,
-			kr.Architecture.ToNonDeb(),
-			kr.Fullversion,
-			kr.FullExtraversion,
-		))
-	}
-	for _, r := range streamReleases {
-		urls = append(urls, fmt.Sprintf(
-			"https://mirrors.edge.kernel.org/centos/%s/%s/os/Packages/kernel-devel-%s%s.rpm",
-			r,
-			kr.Architecture.ToNonDeb(),
-			kr.Fullversion,
-			kr.FullExtraversion,
-		))
-	}
-	for _, r := range vaultReleases {
-		urls = append(urls, fmt.Sprintf(
-			"http://vault.centos.org/%s/%s/Packages/kernel-devel-%s%s.rpm",
-			r,
-			kr.Architecture.ToNonDeb(),
-			kr.Fullversion,
-			kr.FullExtraversion,
-		))
-	}
-	for _, r := range centos8VaultReleases {
-		urls = append(urls, fmt.Sprintf(
-			"http://vault.centos.org/%s/%s/os/Packages/kernel-devel-%s%s.rpm",
-			r,
-			kr.Architecture.ToNonDeb(),
-			kr.Fullversion,
-			kr.FullExtraversion,
-		))
-	}
-
-	for _, r := range stream9Releases {
-		urls = append(urls, fmt.Sprintf(
-			"http://mirror.stream.centos.org/%s/%s/os/Packages/kernel-devel-%s%s.rpm",
-			r,
-			kr.Architecture.ToNonDeb(),
-			kr.Fullversion,
-			kr.FullExtraversion,
-		))
+	for _, mirror := range mirrorBaseURLs(ctx, TargetTypeCentos.String(), c.DefaultMirrors(kr)...) {
+		// Default mirrors only host some releases; custom ones may host all of them.
+		custom := mirror != centosEdgeMirror && mirror != centosVaultMirror && mirror != centosStreamMirror
+		if mirror == centosEdgeMirror || custom {
+			appendRepos(mirror, major)
+			appendRepos(mirror, streams...)
+		}
+		if mirror == centosVaultMirror || custom {
+			// The vault holds every point release, e.g. 7.9.2009
+			appendRepos(mirror, rpmPointReleases(ctx, mirror, major, minor)...)
+			appendRepos(mirror, streams...)
+		}
+		if mirror == centosStreamMirror || custom {
+			appendRepos(mirror, streams...)
+		}
 	}
 
-	return urls, nil
+	return rpmRepodataURLs(ctx, repos, rpmKernelPackages{names: []string{"kernel-devel"}, kr: kr})
 }
 
 func (c *centos) KernelTemplateData(_ kernelrelease.KernelRelease, urls []string) interface{} {
@@ -0,0 +1,204 @@
+// SPDX-License-Identifier: Apache-2.0
+/*
+Copyright (C) 2023 The Diginfra Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package builder
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	_ "embed"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+//go:embed templates/kernel_checksums.sh
+var kernelChecksumsTemplate string
+
+// checksumsDownloadDir is the directory the kernel headers packages with a checksum are downloaded into,
+// to be verified before being extracted by the kernel download script.
+const checksumsDownloadDir = "/tmp/kernel-verified"
+
+var checksumHashes = map[string]func() hash.Hash{
+	"sha1":   sha1.New,
+	"sha256": sha256.New,
+	"sha512": sha512.New,
+}
+
+// checksumAliases maps the checksum types published by repositories metadata to the supported algorithms.
+var checksumAliases = map[string]string{
+	"sha": "sha1",
+}
+
+// Checksum is the expected digest of a kernel headers package.
+// It is attached to a kernel headers url as its fragment, e.g. https://example.com/kernel-devel.rpm#sha256=<digest>.
+type Checksum struct {
+	Algorithm string `json:"algorithm"`
+	Digest    string `json:"digest"`
+}
+
+// NewChecksum returns the checksum with the given algorithm and hex digest,
+// validating them.
+func NewChecksum(algorithm, digest string) (*Checksum, error) {
+	algorithm = strings.ToLower(algorithm)
+	if alias, ok := checksumAliases[algorithm]; ok {
+		algorithm = alias
+	}
+	newHash, ok := checksumHashes[algorithm]
+	if !ok {
+		return nil, fmt.Errorf("unsupported checksum algorithm %q, one of %s", algorithm, strings.Join(ChecksumAlgorithms(), ", "))
+	}
+	digest = strings.ToLower(digest)
+	if b, err := hex.DecodeString(digest); err != nil || len(b) != newHash().Size() {
+		return nil, fmt.Errorf("invalid %s digest %q", algorithm, digest)
+	}
+	return &Checksum{Algorithm: algorithm, Digest: digest}, nil
+}
+
+// ChecksumAlgorithms returns the supported checksum algorithms.
+func ChecksumAlgorithms() []string {
+	res := make([]string, 0, len(checksumHashes))
+	for k := range checksumHashes {
+		res = append(res, k)
+	}
+	sort.Strings(res)
+	return res
+}
+
+func (c *Checksum) String() string {
+	return c.Algorithm + "=" + c.Digest
+}
+
+// Verify checks that the content of r matches the checksum.
+func (c *Checksum) Verify(r io.Reader) error {
+	h := checksumHashes[c.Algorithm]()
+	if _, err := io.Copy(h, r); err != nil {
+		return err
+	}
+	if digest := hex.EncodeToString(h.Sum(nil)); digest != c.Digest {
+		return fmt.Errorf("%s checksum mismatch: expected %s, got %s", c.Algorithm, c.Digest, digest)
+	}
+	return nil
+}
+
+// VerifyFile checks that the content of the file at p matches the checksum.
+func (c *Checksum) VerifyFile(p string) error {
+	f, err := os.Open(filepath.Clean(p))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err = c.Verify(f); err != nil {
+		return fmt.Errorf("%s: %w", p, err)
+	}
+	return nil
+}
+
+// WithChecksum returns the given kernel headers url with the checksum attached.
+func WithChecksum(u string, c *Checksum) string {
+	u, _, _ = SplitChecksum(u)
+	if c == nil {
+		return u
+	}
+	return u + "#" + c.String()
+}
+
+// SplitChecksum returns the given kernel headers url without its checksum, if any, together with the checksum.
+// Fragments not in the <algorithm>=<digest> form are left in the url.
+func SplitChecksum(u string) (string, *Checksum, error) {
+	i := strings.LastIndex(u, "#")
+	if i < 0 {
+		return u, nil, nil
+	}
+	algorithm, digest, ok := strings.Cut(u[i+1:], "=")
+	if !ok {
+		return u, nil, nil
+	}
+	c, err := NewChecksum(algorithm, digest)
+	if err != nil {
+		return u, nil, fmt.Errorf("invalid checksum of %s: %w", u[:i], err)
+	}
+	return u[:i], c, nil
+}
+
+type kernelChecksumsTemplateData struct {
+	DownloadDir string
+	Packages    []kernelChecksumsPackage
+}
+
+type kernelChecksumsPackage struct {
+	// URL is the url the package is downloaded from, empty for local packages.
+	URL     string
+	Path    string
+	Command string
+	Digest  string
+}
+
+// verifiedKernelURLs strips the checksums from the given kernel headers urls.
+// When any url has a checksum, it also returns the script downloading and verifying the packages with a checksum,
+// whose urls are replaced by the ones of the verified packages.
+func verifiedKernelURLs(urls []string) ([]string, string, error) {
+	res := make([]string, len(urls))
+	td := kernelChecksumsTemplateData{DownloadDir: checksumsDownloadDir}
+	for i, u := range urls {
+		bare, c, err := SplitChecksum(u)
+		if err != nil {
+			return nil, "", err
+		}
+		res[i] = bare
+		if c == nil {
+			continue
+		}
+		pkg := kernelChecksumsPackage{
+			Command: c.Algorithm + "sum",
+			Digest:  c.Digest,
+		}
+		if p, ok := LocalPath(bare); ok {
+			// Local packages are verified in place
+			pkg.Path = p
+		} else {
+			// Keep the file name of the package, since the download scripts may rely on its extension
+			name := "package"
+			if base := path.Base(strings.SplitN(bare, "?", 2)[0]); base != "/" && base != "." {
+				name = base
+			}
+			pkg.URL = bare
+			pkg.Path = path.Join(checksumsDownloadDir, fmt.Sprintf("%d-%s", i, name))
+			res[i] = "file://" + pkg.Path
+		}
+		td.Packages = append(td.Packages, pkg)
+	}
+	if len(td.Packages) == 0 {
+		return res, "", nil
+	}
+
+	parsed, err := template.New("kernel-checksums").Parse(kernelChecksumsTemplate)
+	if err != nil {
+		return nil, "", err
+	}
+	buf := bytes.NewBuffer(nil)
+	if err = parsed.Execute(buf, td); err != nil {
+		return nil, "", err
+	}
+	return res, buf.String(), nil
+}
@@ -0,0 +1,127 @@
+// SPDX-License-Identifier: Apache-2.0
+/*
+Copyright (C) 2023 The Diginfra Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package builder
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/diginfra/driverkit/pkg/kernelrelease"
+)
+
+const testSha256 = "5f70bf18a086007016e948b04aed3b82103a36bea41755b6cddfaf10ace3c6ef"
+
+func TestSplitChecksum(t *testing.T) {
+	u, c, err := SplitChecksum("https://example.com/kernel-devel.rpm#SHA256=" + strings.ToUpper(testSha256))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if u != "https://example.com/kernel-devel.rpm" || c == nil || c.Algorithm != "sha256" || c.Digest != testSha256 {
+		t.Fatalf("unexpected split: %s %v", u, c)
+	}
+	if WithChecksum(u, c) != u+"#sha256="+testSha256 {
+		t.Fatalf("unexpected url with checksum: %s", WithChecksum(u, c))
+	}
+
+	// Urls without a checksum are left untouched
+	for _, u := range []string{"https://example.com/kernel-devel.rpm", "https://example.com/index.html#top"} {
+		got, c, err := SplitChecksum(u)
+		if err != nil || c != nil || got != u {
+			t.Fatalf("unexpected split of %s: %s %v %v", u, got, c, err)
+		}
+	}
+
+	for _, u := range []string{"https://example.com/kernel-devel.rpm#md5=abc", "https://example.com/kernel-devel.rpm#sha256=abc"} {
+		if _, _, err = SplitChecksum(u); err == nil {
+			t.Fatalf("expected error for %s", u)
+		}
+	}
+
+	// Repositories metadata name sha1 as sha
+	c, err = NewChecksum("sha", strings.Repeat("a", 40))
+	if err != nil || c.Algorithm != "sha1" {
+		t.Fatalf("unexpected checksum: %v %v", c, err)
+	}
+	if err = c.Verify(strings.NewReader("")); err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+		t.Fatalf("expected checksum mismatch, got %v", err)
+	}
+}
+
+func TestKernelDownloadScriptChecksums(t *testing.T) {
+	b, err := Factory(TargetTypeAmazonLinux2)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	kr := kernelrelease.FromString("5.10.96-90.460.amzn2.x86_64")
+	urls := []string{
+		"https://example.com/kernel-devel.rpm#sha256=" + testSha256,
+		"https://example.com/kernel-headers.rpm",
+	}
+	script, err := KernelDownloadScriptFromURLs(b, kr, urls)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, expected := range []string{
+		"curl --silent -o \"/tmp/kernel-verified/0-kernel-devel.rpm\" -SL \"https://example.com/kernel-devel.rpm\"\n",
+		"echo \"" + testSha256 + "  /tmp/kernel-verified/0-kernel-devel.rpm\" | sha256sum -c -",
+		// The verified package is extracted, packages without a checksum are downloaded as usual
+		"curl --silent -o kernel.rpm -SL file:///tmp/kernel-verified/0-kernel-devel.rpm\n",
+		"curl --silent -o kernel.rpm -SL https://example.com/kernel-headers.rpm\n",
+	} {
+		if !strings.Contains(script, expected) {
+			t.Fatalf("script does not contain %q:\n%s", expected, script)
+		}
+	}
+	if strings.Contains(script, "#sha256") {
+		t.Fatalf("checksum leaked into the script:\n%s", script)
+	}
+	// The packages are verified once the shell options of the download script are set
+	if strings.Index(script, "sha256sum -c -") < strings.Index(script, "set -xeuo pipefail") {
+		t.Fatalf("checksums verified before the shell options are set:\n%s", script)
+	}
+
+	// Without checksums, the script is not changed
+	script, err = KernelDownloadScriptFromURLs(b, kr, urls[1:])
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if strings.Contains(script, "/tmp/kernel-verified") {
+		t.Fatalf("unexpected checksums script:\n%s", script)
+	}
+}
+
+func TestResolveKernelURLsChecksum(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	urls, err := GetResolvingURLs(context.Background(), []string{srv.URL + "/a/../kernel-devel.rpm#sha256=" + testSha256})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(urls) != 1 || urls[0] != srv.URL+"/kernel-devel.rpm#sha256="+testSha256 {
+		t.Fatalf("unexpected resolving urls: %v", urls)
+	}
+
+	statuses, err := CheckURLs(context.Background(), []string{srv.URL + "/kernel-devel.rpm#sha256=abc"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if statuses[0].Resolving() || !strings.Contains(statuses[0].Error, "invalid sha256 digest") {
+		t.Fatalf("unexpected status: %+v", statuses[0])
+	}
+}
@@ -0,0 +1,267 @@
+// SPDX-License-Identifier: Apache-2.0
+/*
+Copyright (C) 2023 The Diginfra Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package builder
+
+import (
+	"bufio"
+	"context"
+	_ "embed"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/diginfra/driverkit/pkg/httpclient"
+	"github.com/diginfra/driverkit/pkg/kernelrelease"
+)
+
+//go:embed templates/cos_kernel.sh
+var cosKernelTemplate string
+
+//go:embed templates/cos.sh
+var cosTemplate string
+
+// TargetTypeCos identifies the Container-Optimized OS target.
+const TargetTypeCos Type = "cos"
+
+func init() {
+	byTarget[TargetTypeCos] = &cos{}
+}
+
+// cosToolchainDir is the directory the COS toolchain is extracted into by the kernel download script.
+const cosToolchainDir = "/tmp/cos-toolchain"
+
+// chromiumOSSDKURL is the base url of the toolchains listed by the toolchain_path file of older COS builds.
+var chromiumOSSDKURL = "https://storage.googleapis.com/chromiumos-sdk"
+
+// cosBuildIDRegex matches a COS build id, e.g. 17800.66.78, passed as kernelversion.
+var cosBuildIDRegex = regexp.MustCompile(`^\d+\.\d+\.\d+$`)
+
+// cosToolchainVars are the toolchain_env variables passed to make to build the kernel module.
+var cosToolchainVars = []string{"CC", "LD", "AR", "NM", "OBJCOPY", "OBJDUMP", "READELF", "STRIP"}
+
+type cosTemplateData struct {
+	KernelDownloadURL    string
+	ToolchainDownloadURL string
+	ToolchainDir         string
+	// MakeFlags are the variables passed to make to build the kernel module with the COS toolchain, if any.
+	MakeFlags string
+}
+
+// cos is a driverkit target.
+type cos struct {
+	mu sync.Mutex
+	// infos are the build infos loaded by LoadKernelInfo, by kernel release and build id,
+	// since the builder is shared by concurrent builds.
+	infos map[string]*cosBuildInfo
+}
+
+// cosBuildInfo are the artifacts published by COS for a build.
+type cosBuildInfo struct {
+	HeadersURL   string
+	ToolchainURL string
+	// ToolchainEnv are the variables of the toolchain_env file, e.g. CC=x86_64-cros-linux-gnu-clang.
+	ToolchainEnv map[string]string
+}
+
+func (c *cos) Name() string {
+	return TargetTypeCos.String()
+}
+
+func (c *cos) TemplateKernelUrlsScript() string {
+	return cosKernelTemplate
+}
+
+func (c *cos) TemplateScript() string {
+	return cosTemplate
+}
+
+func (c *cos) DefaultMirrors(_ kernelrelease.KernelRelease) []string {
+	return []string{"https://storage.googleapis.com/cos-tools"}
+}
+
+// LoadKernelInfo fetches the build artifacts infos, needed also when `kernelurls` option is passed,
+// since they provide the toolchain the kernel was built with.
+func (c *cos) LoadKernelInfo(ctx context.Context, kr kernelrelease.KernelRelease) error {
+	if kr.Architecture != kernelrelease.ArchitectureAmd64 {
+		return fmt.Errorf("unsupported architecture for %s: %s", TargetTypeCos, kr.Architecture)
+	}
+	if !cosBuildIDRegex.MatchString(kr.KernelVersion) {
+		return fmt.Errorf("not a valid cos build id: %q, expected as kernelversion, e.g. 17800.66.78", kr.KernelVersion)
+	}
+
+	info, err := fetchCosBuildInfo(ctx, mirrorBaseURLs(ctx, TargetTypeCos.String(), c.DefaultMirrors(kr)...), kr.KernelVersion)
+	if err != nil {
+		return err
+	}
+	c.setInfo(kr, info)
+	return nil
+}
+
+func (c *cos) URLs(_ context.Context, kr kernelrelease.KernelRelease) ([]string, error) {
+	info := c.info(kr)
+	if info == nil {
+		return nil, fmt.Errorf("build infos of %s (%s) not loaded", kr.String(), kr.KernelVersion)
+	}
+	urls := []string{info.HeadersURL}
+	if info.ToolchainURL != "" {
+		urls = append(urls, info.ToolchainURL)
+	}
+	return urls, nil
+}
+
+func (c *cos) info(kr kernelrelease.KernelRelease) *cosBuildInfo {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.infos[kr.String()+"/"+kr.KernelVersion]
+}
+
+func (c *cos) setInfo(kr kernelrelease.KernelRelease, info *cosBuildInfo) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.infos == nil {
+		c.infos = make(map[string]*cosBuildInfo)
+	}
+	c.infos[kr.String()+"/"+kr.KernelVersion] = info
+}
+
+func (c *cos) KernelTemplateData(kr kernelrelease.KernelRelease, urls []string) interface{} {
+	td := cosTemplateData{
+		KernelDownloadURL: urls[0],
+		ToolchainDir:      cosToolchainDir,
+	}
+	// The toolchain is the second url, when published for the build
+	if len(urls) > 1 {
+		td.ToolchainDownloadURL = urls[1]
+		var env map[string]string
+		if info := c.info(kr); info != nil {
+			env = info.ToolchainEnv
+		}
+		td.MakeFlags = cosMakeFlags(env)
+	}
+	return td
+}
+
+// cosMakeFlags returns the make variables selecting the toolchain programs listed by toolchain_env;
+// builds not listing them are built with gcc.
+func cosMakeFlags(env map[string]string) string {
+	if env["CC"] == "" {
+		env = map[string]string{"CC": "x86_64-cros-linux-gnu-gcc"}
+	}
+	var flags []string
+	for _, k := range cosToolchainVars {
+		v := env[k]
+		if v == "" {
+			continue
+		}
+		if !path.IsAbs(v) {
+			v = path.Join(cosToolchainDir, "bin", v)
+		}
+		flags = append(flags, k+"="+v)
+	}
+	return strings.Join(flags, " ")
+}
+
+// fetchCosBuildInfo looks the artifacts of the given build up under the first mirror publishing its kernel headers.
+func fetchCosBuildInfo(ctx context.Context, mirrors []string, buildID string) (*cosBuildInfo, error) {
+	var tried []string
+	for _, mirror := range mirrors {
+		base := fmt.Sprintf("%s/%s", mirror, buildID)
+		info := cosBuildInfo{HeadersURL: base + "/kernel-headers.tgz"}
+		tried = append(tried, info.HeadersURL)
+		found, err := cosArtifactExists(ctx, info.HeadersURL)
+		if err != nil {
+			return nil, err
+		}
+		if !found {
+			continue
+		}
+
+		// Recent builds publish their toolchain next to the headers,
+		// older ones the path of a toolchain of the Chromium OS SDK.
+		toolchainURL := base + "/toolchain.tar.xz"
+		if found, err = cosArtifactExists(ctx, toolchainURL); err != nil {
+			return nil, err
+		}
+		if found {
+			info.ToolchainURL = toolchainURL
+		} else {
+			toolchainPath, err := getCosArtifact(ctx, base+"/toolchain_path")
+			if err != nil {
+				return nil, err
+			}
+			if toolchainPath = strings.TrimSpace(toolchainPath); toolchainPath != "" {
+				info.ToolchainURL = chromiumOSSDKURL + "/" + strings.TrimPrefix(toolchainPath, "/")
+			}
+		}
+
+		toolchainEnv, err := getCosArtifact(ctx, base+"/toolchain_env")
+		if err != nil {
+			return nil, err
+		}
+		info.ToolchainEnv = parseCosToolchainEnv(toolchainEnv)
+		return &info, nil
+	}
+	return nil, &HeadersNotFoundError{URLs: tried}
+}
+
+// parseCosToolchainEnv parses the KEY=VALUE lines of a toolchain_env file.
+func parseCosToolchainEnv(s string) map[string]string {
+	env := make(map[string]string)
+	scanner := bufio.NewScanner(strings.NewReader(s))
+	for scanner.Scan() {
+		line := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(scanner.Text()), "export "))
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		k, v, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		env[strings.TrimSpace(k)] = strings.Trim(strings.TrimSpace(v), `"'`)
+	}
+	return env
+}
+
+func cosArtifactExists(ctx context.Context, u string) (bool, error) {
+	res, err := httpclient.Default().Head(ctx, u)
+	if err != nil {
+		return false, err
+	}
+	return res.StatusCode == http.StatusOK, nil
+}
+
+// getCosArtifact returns the content of the given text artifact, empty if it does not exist.
+func getCosArtifact(ctx context.Context, u string) (string, error) {
+	res, err := httpGet(ctx, u)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	switch {
+	case res.StatusCode == http.StatusNotFound || res.StatusCode == http.StatusForbidden:
+		return "", nil
+	case res.StatusCode != http.StatusOK:
+		return "", fmt.Errorf("error downloading %s: %s", u, res.Status)
+	}
+	b, err := io.ReadAll(res.Body)
+	if err != nil {
+		return "", fmt.Errorf("error reading %s: %w", u, err)
+	}
+	return string(b), nil
+}
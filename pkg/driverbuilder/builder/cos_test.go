@@ -0,0 +1,118 @@
+// SPDX-License-Identifier: Apache-2.0
+/*
+Copyright (C) 2023 The Diginfra Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package builder
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/diginfra/driverkit/pkg/kernelrelease"
+)
+
+func TestCosKernelURLs(t *testing.T) {
+	srv := testFileServer(map[string][]byte{
+		"/cos-tools/17800.66.78/kernel-headers.tgz":                       nil,
+		"/cos-tools/17800.66.78/toolchain_path":                           []byte("2023/06/x86_64-cros-linux-gnu-2023.06.07.tar.xz\n"),
+		"/cos-tools/17800.66.78/toolchain_env":                            []byte("CC=x86_64-cros-linux-gnu-clang\nCXX=x86_64-cros-linux-gnu-clang++\nLD=x86_64-cros-linux-gnu-ld.lld\n"),
+		"/cos-tools/16919.404.26/kernel-headers.tgz":                      nil,
+		"/cos-tools/16919.404.26/toolchain.tar.xz":                        nil,
+		"/chromiumos-sdk/2023/06/x86_64-cros-linux-gnu-2023.06.07.tar.xz": nil,
+	})
+	defer srv.Close()
+	defer func(u string) { chromiumOSSDKURL = u }(chromiumOSSDKURL)
+	chromiumOSSDKURL = srv.URL + "/chromiumos-sdk"
+	ctx := WithMirrors(context.Background(), Mirrors{
+		TargetTypeCos.String(): {srv.URL + "/missing", srv.URL + "/cos-tools"},
+	})
+
+	tests := []struct {
+		buildID   string
+		urls      []string
+		makeFlags string
+	}{
+		{
+			buildID: "17800.66.78",
+			urls: []string{
+				srv.URL + "/cos-tools/17800.66.78/kernel-headers.tgz",
+				srv.URL + "/chromiumos-sdk/2023/06/x86_64-cros-linux-gnu-2023.06.07.tar.xz",
+			},
+			makeFlags: "CC=/tmp/cos-toolchain/bin/x86_64-cros-linux-gnu-clang LD=/tmp/cos-toolchain/bin/x86_64-cros-linux-gnu-ld.lld",
+		},
+		{
+			// No toolchain_env, the toolchain gcc is used
+			buildID: "16919.404.26",
+			urls: []string{
+				srv.URL + "/cos-tools/16919.404.26/kernel-headers.tgz",
+				srv.URL + "/cos-tools/16919.404.26/toolchain.tar.xz",
+			},
+			makeFlags: "CC=/tmp/cos-toolchain/bin/x86_64-cros-linux-gnu-gcc",
+		},
+	}
+	for _, test := range tests {
+		kr := kernelrelease.FromString("6.1.58+")
+		kr.Architecture = kernelrelease.ArchitectureAmd64
+		kr.KernelVersion = test.buildID
+		b := &cos{}
+		res, err := ResolveKernelURLs(ctx, b, nil, kr)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", test.buildID, err)
+		}
+		if !reflect.DeepEqual(res.URLs, test.urls) {
+			t.Fatalf("%s: unexpected urls: got %v, want %v", test.buildID, res.URLs, test.urls)
+		}
+		td := b.KernelTemplateData(kr, res.URLs).(cosTemplateData)
+		if td.MakeFlags != test.makeFlags {
+			t.Fatalf("%s: unexpected make flags: got %q, want %q", test.buildID, td.MakeFlags, test.makeFlags)
+		}
+		script, err := KernelDownloadScriptFromURLs(b, kr, res.URLs)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", test.buildID, err)
+		}
+		if !strings.Contains(script, `export COS_MAKE_FLAGS="`+test.makeFlags+`"`) {
+			t.Fatalf("%s: make flags not exported by script:\n%s", test.buildID, script)
+		}
+	}
+
+	// A shared builder keeps the infos of each build id
+	b := &cos{}
+	krs := make([]kernelrelease.KernelRelease, len(tests))
+	for i, test := range tests {
+		krs[i] = kernelrelease.FromString("6.1.58+")
+		krs[i].Architecture = kernelrelease.ArchitectureAmd64
+		krs[i].KernelVersion = test.buildID
+		if err := b.LoadKernelInfo(ctx, krs[i]); err != nil {
+			t.Fatalf("%s: unexpected error: %v", test.buildID, err)
+		}
+	}
+	for i, test := range tests {
+		urls, err := b.URLs(ctx, krs[i])
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", test.buildID, err)
+		}
+		if !reflect.DeepEqual(urls, test.urls) {
+			t.Fatalf("%s: unexpected urls from the shared builder: got %v, want %v", test.buildID, urls, test.urls)
+		}
+	}
+
+	// The build id is passed as kernelversion
+	kr := kernelrelease.FromString("6.1.58+")
+	kr.Architecture = kernelrelease.ArchitectureAmd64
+	kr.KernelVersion = "1"
+	if _, err := ResolveKernelURLs(ctx, &cos{}, nil, kr); err == nil || !strings.Contains(err.Error(), "not a valid cos build id") {
+		t.Fatalf("expected invalid build id error, got: %v", err)
+	}
+}
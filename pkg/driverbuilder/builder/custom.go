@@ -0,0 +1,265 @@
+// SPDX-License-Identifier: Apache-2.0
+/*
+Copyright (C) 2023 The Diginfra Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package builder
+
+import (
+	"bytes"
+	"context"
+	_ "embed"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/template"
+
+	"github.com/blang/semver"
+	"github.com/diginfra/driverkit/pkg/kernelrelease"
+	"gopkg.in/yaml.v3"
+)
+
+//go:embed templates/custom_kernel.sh
+var customKernelTemplate string
+
+// customPackageFormats maps the package formats supported by user-defined targets
+// to the default glob of the kernel headers directory within the extracted packages.
+var customPackageFormats = map[string]string{
+	"rpm":    "usr/src/kernels/*",
+	"deb":    "usr/src/linux-headers-*",
+	"apk":    "usr/src/linux-headers-*",
+	"tar.gz": "*",
+	"tar.xz": "*",
+}
+
+var (
+	customTargetNameRegex = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]*$`)
+	customGCCKeyRegex     = regexp.MustCompile(`^\d+(\.\d+){0,2}$`)
+)
+
+// customTargets holds the targets registered by LoadTargets,
+// that may be registered again, unlike the builtin ones.
+var customTargets = map[Type]struct{}{}
+
+// TargetDefinition is the YAML definition of a user-defined target.
+type TargetDefinition struct {
+	Name string `yaml:"name"`
+	// URLs are Go templates over kernelrelease.KernelRelease rendering the kernel headers packages urls;
+	// the ones rendering to an empty string are skipped.
+	URLs        []string `yaml:"urls"`
+	MinimumURLs int      `yaml:"minimum_urls"`
+	// PackageFormat is the format of the kernel headers packages, one of rpm, deb, apk, tar.gz or tar.xz.
+	// It may be omitted when KernelUrlsScript is set.
+	PackageFormat string `yaml:"package_format"`
+	// HeadersDir is the shell glob of the kernel headers directory within the extracted packages,
+	// defaulting to the usual one for the package format.
+	HeadersDir string `yaml:"headers_dir"`
+	// GCC maps kernel version prefixes, e.g. "5.14", to gcc versions; the longest matching prefix wins.
+	GCC              map[string]string `yaml:"gcc"`
+	KernelUrlsScript string            `yaml:"kernel_urls_script"`
+	Script           string            `yaml:"script"`
+}
+
+// customTarget is a driverkit target defined by a TargetDefinition.
+type customTarget struct {
+	def  TargetDefinition
+	urls []*template.Template
+	gcc  map[string]semver.Version
+}
+
+type customTemplateData struct {
+	KernelDownloadURLs []string
+	PackageFormat      string
+	HeadersDir         string
+	KernelRelease      kernelrelease.KernelRelease
+}
+
+// LoadTargets registers the targets defined by the .yaml and .yml files of the given directory,
+// one definition per file. Builtin targets cannot be redefined.
+func LoadTargets(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("error reading targets directory: %w", err)
+	}
+	loaded := map[Type]*customTarget{}
+	for _, e := range entries {
+		ext := filepath.Ext(e.Name())
+		if e.IsDir() || (ext != ".yaml" && ext != ".yml") {
+			continue
+		}
+		p := filepath.Join(dir, e.Name())
+		data, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		var def TargetDefinition
+		if err = yaml.Unmarshal(data, &def); err != nil {
+			return fmt.Errorf("error parsing target definition %s: %w", p, err)
+		}
+		c, err := newCustomTarget(def)
+		if err != nil {
+			return fmt.Errorf("invalid target definition %s: %w", p, err)
+		}
+		target := Type(def.Name)
+		if _, ok := loaded[target]; ok {
+			return fmt.Errorf("invalid target definition %s: target %q already defined", p, def.Name)
+		}
+		loaded[target] = c
+	}
+	for target, c := range loaded {
+		byTarget[target] = c
+		customTargets[target] = struct{}{}
+	}
+	return nil
+}
+
+// UnloadTargets unregisters the targets registered by LoadTargets.
+func UnloadTargets() {
+	for target := range customTargets {
+		delete(byTarget, target)
+		delete(customTargets, target)
+	}
+}
+
+func newCustomTarget(def TargetDefinition) (*customTarget, error) {
+	target := Type(def.Name)
+	switch {
+	case !customTargetNameRegex.MatchString(def.Name):
+		return nil, fmt.Errorf("invalid target name %q", def.Name)
+	// Factory maps every ubuntu-* target to ubuntu
+	case strings.HasPrefix(def.Name, "ubuntu") || def.Name == "local":
+		return nil, fmt.Errorf("target %q is reserved", def.Name)
+	}
+	if _, ok := byTarget[target]; ok {
+		if _, ok = customTargets[target]; !ok {
+			return nil, fmt.Errorf("target %q is a builtin target", def.Name)
+		}
+	}
+
+	if def.PackageFormat == "" && def.KernelUrlsScript == "" {
+		return nil, fmt.Errorf("either package_format or kernel_urls_script is required")
+	}
+	if def.PackageFormat != "" {
+		headersDir, ok := customPackageFormats[def.PackageFormat]
+		if !ok {
+			return nil, fmt.Errorf("unsupported package format %q", def.PackageFormat)
+		}
+		if def.HeadersDir == "" {
+			def.HeadersDir = headersDir
+		}
+	}
+
+	if len(def.URLs) == 0 {
+		return nil, fmt.Errorf("at least one url is required")
+	}
+	if def.MinimumURLs == 0 {
+		def.MinimumURLs = 1
+	}
+	if def.MinimumURLs < 0 || def.MinimumURLs > len(def.URLs) {
+		return nil, fmt.Errorf("minimum_urls must be between 1 and the number of urls (%d)", len(def.URLs))
+	}
+
+	c := &customTarget{def: def, gcc: map[string]semver.Version{}}
+	// Render the templates once, so that unknown kernel release fields are reported upfront
+	sample := kernelrelease.FromString("5.10.0-1.x86_64")
+	sample.Architecture = kernelrelease.ArchitectureAmd64
+	for i, u := range def.URLs {
+		t, err := template.New(fmt.Sprintf("url-%d", i)).Parse(u)
+		if err != nil {
+			return nil, fmt.Errorf("invalid url template %q: %w", u, err)
+		}
+		if err = t.Execute(&bytes.Buffer{}, sample); err != nil {
+			return nil, fmt.Errorf("invalid url template %q: %w", u, err)
+		}
+		c.urls = append(c.urls, t)
+	}
+	for name, script := range map[string]string{"kernel_urls_script": def.KernelUrlsScript, "script": def.Script} {
+		if _, err := template.New(name).Parse(script); err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", name, err)
+		}
+	}
+
+	for prefix, v := range def.GCC {
+		if !customGCCKeyRegex.MatchString(prefix) {
+			return nil, fmt.Errorf("invalid gcc kernel version prefix %q", prefix)
+		}
+		gcc, err := semver.ParseTolerant(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid gcc version %q for kernel %s: %w", v, prefix, err)
+		}
+		c.gcc[prefix] = gcc
+	}
+	return c, nil
+}
+
+func (c *customTarget) Name() string {
+	return c.def.Name
+}
+
+func (c *customTarget) TemplateKernelUrlsScript() string {
+	if c.def.KernelUrlsScript != "" {
+		return c.def.KernelUrlsScript
+	}
+	return customKernelTemplate
+}
+
+func (c *customTarget) TemplateScript() string {
+	if c.def.Script != "" {
+		return c.def.Script
+	}
+	return rockyTemplate
+}
+
+func (c *customTarget) URLs(_ context.Context, kr kernelrelease.KernelRelease) ([]string, error) {
+	urls := make([]string, 0, len(c.urls))
+	for _, t := range c.urls {
+		buf := bytes.Buffer{}
+		if err := t.Execute(&buf, kr); err != nil {
+			return nil, fmt.Errorf("error rendering url template: %w", err)
+		}
+		if u := strings.TrimSpace(buf.String()); u != "" {
+			urls = append(urls, u)
+		}
+	}
+	return urls, nil
+}
+
+func (c *customTarget) MinimumURLs() int {
+	return c.def.MinimumURLs
+}
+
+func (c *customTarget) KernelTemplateData(kr kernelrelease.KernelRelease, urls []string) interface{} {
+	return customTemplateData{
+		KernelDownloadURLs: urls,
+		PackageFormat:      c.def.PackageFormat,
+		HeadersDir:         c.def.HeadersDir,
+		KernelRelease:      kr,
+	}
+}
+
+// GCCVersion returns the gcc version mapped to the longest prefix of the kernel version, if any.
+func (c *customTarget) GCCVersion(kr kernelrelease.KernelRelease) semver.Version {
+	version := fmt.Sprintf("%d.%d.%d", kr.Major, kr.Minor, kr.Patch)
+	var (
+		res     semver.Version
+		longest = -1
+	)
+	for prefix, gcc := range c.gcc {
+		if (version == prefix || strings.HasPrefix(version, prefix+".")) && len(prefix) > longest {
+			res = gcc
+			longest = len(prefix)
+		}
+	}
+	return res
+}
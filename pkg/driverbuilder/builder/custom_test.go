@@ -0,0 +1,158 @@
+// SPDX-License-Identifier: Apache-2.0
+/*
+Copyright (C) 2023 The Diginfra Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package builder
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/blang/semver"
+
+	"github.com/diginfra/driverkit/pkg/kernelrelease"
+)
+
+// testTargetsDir writes the given target definitions into a temporary directory,
+// unregistering the targets loaded from it at the end of the test.
+func testTargetsDir(t *testing.T, defs map[string]string) string {
+	dir := t.TempDir()
+	for name, def := range defs {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(def), 0o644); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	t.Cleanup(UnloadTargets)
+	return dir
+}
+
+func TestLoadTargets(t *testing.T) {
+	dir := testTargetsDir(t, map[string]string{
+		"acme.yaml": `name: acme
+package_format: rpm
+urls:
+  - https://repo.acme.internal/{{ .Architecture.ToNonDeb }}/kernel-devel-{{ .Fullversion }}{{ .FullExtraversion }}.rpm
+gcc:
+  "5": "11"
+  "5.14": "12"
+  "5.14.21": "12.2"
+`,
+		"acme-deb.yml": `name: acme-deb
+package_format: deb
+minimum_urls: 2
+urls:
+  - https://deb.acme.internal/linux-headers-{{ .Fullversion }}{{ .FullExtraversion }}_{{ .Architecture }}.deb
+  - https://deb.acme.internal/linux-headers-{{ .Fullversion }}-common_all.deb
+  - '{{ if ge .Major 6 }}https://deb.acme.internal/linux-kbuild-{{ .Major }}.{{ .Minor }}_{{ .Architecture }}.deb{{ end }}'
+`,
+		"README.md": "not a target",
+	})
+	if err := LoadTargets(dir); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	targets := Targets()
+	for _, target := range []string{"acme", "acme-deb"} {
+		if !slices.Contains(targets, target) {
+			t.Fatalf("expected target %s to be listed in %v", target, targets)
+		}
+	}
+	// Loading the same definitions again replaces them
+	if err := LoadTargets(dir); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	b, err := Factory("acme")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	kr := kernelrelease.FromString("5.14.21-150500.55.39-default")
+	kr.Architecture = kernelrelease.ArchitectureAmd64
+	urls, err := b.URLs(context.Background(), kr)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []string{"https://repo.acme.internal/x86_64/kernel-devel-5.14.21-150500.55.39-default.rpm"}
+	if !reflect.DeepEqual(urls, expected) {
+		t.Fatalf("expected %v, got %v", expected, urls)
+	}
+	script, err := KernelDownloadScriptFromURLs(b, kr, urls)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.Contains(script, "rpm2cpio kernel-0.pkg") || !strings.Contains(script, "for dir in usr/src/kernels/*; do") {
+		t.Fatalf("unexpected kernel download script:\n%s", script)
+	}
+
+	gccTests := map[string]semver.Version{
+		"5.14.21-150500.55.39-default": {Major: 12, Minor: 2},
+		"5.14.0-1":                     {Major: 12},
+		"5.10.0-1":                     {Major: 11},
+		"6.1.0-1":                      {},
+	}
+	for release, expectedGCC := range gccTests {
+		if gcc := b.(GCCVersionRequestor).GCCVersion(kernelrelease.FromString(release)); !gcc.EQ(expectedGCC) {
+			t.Fatalf("%s: expected gcc %s, got %s", release, expectedGCC, gcc)
+		}
+	}
+
+	b, err = Factory("acme-deb")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if min := b.(MinimumURLsBuilder).MinimumURLs(); min != 2 {
+		t.Fatalf("expected 2 minimum urls, got %d", min)
+	}
+	urls, err = b.URLs(context.Background(), kr)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(urls) != 2 {
+		t.Fatalf("expected the kbuild url to be skipped, got %v", urls)
+	}
+}
+
+func TestLoadTargetsInvalid(t *testing.T) {
+	tests := map[string]string{
+		"builtin":         "name: rocky\npackage_format: rpm\nurls: [https://example.com]\n",
+		"reserved":        "name: ubuntu-acme\npackage_format: deb\nurls: [https://example.com]\n",
+		"invalid name":    "name: Acme Linux\npackage_format: rpm\nurls: [https://example.com]\n",
+		"no format":       "name: acme\nurls: [https://example.com]\n",
+		"unknown format":  "name: acme\npackage_format: zip\nurls: [https://example.com]\n",
+		"no urls":         "name: acme\npackage_format: rpm\n",
+		"minimum urls":    "name: acme\npackage_format: rpm\nminimum_urls: 2\nurls: [https://example.com]\n",
+		"unknown field":   "name: acme\npackage_format: rpm\nurls: ['https://example.com/{{ .Flavor }}']\n",
+		"invalid gcc":     "name: acme\npackage_format: rpm\nurls: [https://example.com]\ngcc:\n  \"5.x\": \"11\"\n",
+		"invalid script":  "name: acme\npackage_format: rpm\nurls: [https://example.com]\nscript: '{{ if }}'\n",
+		"malformed yaml":  "name: [acme\n",
+		"duplicate names": "",
+	}
+	for name, def := range tests {
+		defs := map[string]string{"target.yaml": def}
+		if name == "duplicate names" {
+			def = "name: acme\npackage_format: rpm\nurls: [https://example.com]\n"
+			defs = map[string]string{"a.yaml": def, "b.yaml": def}
+		}
+		dir := testTargetsDir(t, defs)
+		if err := LoadTargets(dir); err == nil {
+			t.Fatalf("%s: expected an error", name)
+		}
+		if _, err := Factory("acme"); err == nil {
+			t.Fatalf("%s: expected no target to be registered", name)
+		}
+	}
+}
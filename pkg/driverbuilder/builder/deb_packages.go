@@ -0,0 +1,224 @@
+// SPDX-License-Identifier: Apache-2.0
+/*
+Copyright (C) 2023 The Diginfra Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package builder
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ulikunitz/xz"
+	"golang.org/x/sync/singleflight"
+)
+
+// errDebIndexNotFound is returned when an archive does not publish the requested Packages index.
+var errDebIndexNotFound = errors.New("packages index not found")
+
+// debPackage is a package listed by the Packages index of a Debian-like archive.
+type debPackage struct {
+	Name         string
+	Version      string
+	Architecture string
+	// Filename is the path of the package, relative to the archive base url.
+	Filename string
+	SHA256   string
+	// Depends are the names of the packages the package depends on, alternatives included.
+	Depends []string
+}
+
+// url returns the url of the package within the archive at baseURL, with its checksum attached.
+func (p *debPackage) url(baseURL string) string {
+	u := strings.TrimSuffix(baseURL, "/") + "/" + strings.TrimPrefix(p.Filename, "/")
+	if c, err := NewChecksum("sha256", p.SHA256); err == nil {
+		return WithChecksum(u, c)
+	}
+	return u
+}
+
+// debIndexTTL is how long a downloaded Packages index is reused for, archives being updated several times a day.
+const debIndexTTL = 30 * time.Minute
+
+// debIndexes caches the Packages indexes downloaded recently, since each kernel release lookup
+// goes through the indexes of several series, pockets and components;
+// concurrent lookups of the same index share a single download.
+var debIndexes = struct {
+	mu       sync.Mutex
+	entries  map[string]debIndex
+	inflight singleflight.Group
+}{entries: make(map[string]debIndex)}
+
+type debIndex struct {
+	pkgs    []debPackage
+	err     error
+	expires time.Time
+}
+
+// fetchDebPackages returns the packages whose name starts with prefix
+// from the Packages index of the given dist, component and architecture of the archive at baseURL.
+// The indexes are reused for debIndexTTL once downloaded, the xz compressed one first, then the gzip compressed one.
+// errDebIndexNotFound is returned when the archive does not publish the index.
+func fetchDebPackages(ctx context.Context, baseURL, dist, component, arch, prefix string) ([]debPackage, error) {
+	base := fmt.Sprintf("%s/dists/%s/%s/binary-%s/Packages", strings.TrimSuffix(baseURL, "/"), dist, component, arch)
+	key := base + "|" + prefix
+	debIndexes.mu.Lock()
+	index, ok := debIndexes.entries[key]
+	debIndexes.mu.Unlock()
+	if ok && time.Now().Before(index.expires) {
+		return index.pkgs, index.err
+	}
+
+	res, err, _ := debIndexes.inflight.Do(key, func() (interface{}, error) {
+		pkgs, err := downloadDebPackages(ctx, base, prefix)
+		// Transient errors are not cached
+		if err == nil || errors.Is(err, errDebIndexNotFound) {
+			now := time.Now()
+			debIndexes.mu.Lock()
+			for k, index := range debIndexes.entries {
+				if !now.Before(index.expires) {
+					delete(debIndexes.entries, k)
+				}
+			}
+			debIndexes.entries[key] = debIndex{pkgs: pkgs, err: err, expires: now.Add(debIndexTTL)}
+			debIndexes.mu.Unlock()
+		}
+		return pkgs, err
+	})
+	pkgs, _ := res.([]debPackage)
+	return pkgs, err
+}
+
+// downloadDebPackages downloads the Packages index at base, the xz compressed one first,
+// returning the packages whose name starts with prefix.
+func downloadDebPackages(ctx context.Context, base, prefix string) ([]debPackage, error) {
+	match := func(name string) bool {
+		return strings.HasPrefix(name, prefix)
+	}
+	pkgs, err := fetchDebIndex(ctx, base+".xz", match)
+	if errors.Is(err, errDebIndexNotFound) {
+		pkgs, err = fetchDebIndex(ctx, base+".gz", match)
+	}
+	return pkgs, err
+}
+
+// fetchDebIndex downloads the Packages index at u, compressed according to its extension,
+// returning the packages whose name matches.
+func fetchDebIndex(ctx context.Context, u string, match func(name string) bool) ([]debPackage, error) {
+	res, err := httpGet(ctx, u)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	switch {
+	case res.StatusCode == http.StatusNotFound || res.StatusCode == http.StatusForbidden:
+		return nil, fmt.Errorf("%w: %s", errDebIndexNotFound, u)
+	case res.StatusCode != http.StatusOK:
+		return nil, fmt.Errorf("error downloading %s: %s", u, res.Status)
+	}
+	var r io.Reader
+	if strings.HasSuffix(u, ".xz") {
+		r, err = xz.NewReader(res.Body)
+	} else {
+		var gr *gzip.Reader
+		gr, err = gzip.NewReader(res.Body)
+		if err == nil {
+			defer gr.Close()
+		}
+		r = gr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %w", u, err)
+	}
+	pkgs, err := parseDebPackages(r, match)
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %w", u, err)
+	}
+	return pkgs, nil
+}
+
+// parseDebPackages parses a Packages index, returning the packages whose name matches, in order.
+func parseDebPackages(r io.Reader, match func(name string) bool) ([]debPackage, error) {
+	var (
+		res []debPackage
+		cur debPackage
+	)
+	flush := func() {
+		if cur.Name != "" && match(cur.Name) {
+			res = append(res, cur)
+		}
+		cur = debPackage{}
+	}
+
+	scanner := bufio.NewScanner(r)
+	// Some fields, like Description, may be long
+	scanner.Buffer(make([]byte, 0, 64*1024), 4*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			flush()
+			continue
+		}
+		// Continuation lines of multiline fields
+		if line[0] == ' ' || line[0] == '\t' {
+			continue
+		}
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		switch key {
+		case "Package":
+			cur.Name = value
+		case "Version":
+			cur.Version = value
+		case "Architecture":
+			cur.Architecture = value
+		case "Filename":
+			cur.Filename = value
+		case "SHA256":
+			cur.SHA256 = value
+		case "Depends":
+			cur.Depends = parseDebDepends(value)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	flush()
+	return res, nil
+}
+
+// parseDebDepends returns the package names of a Depends field, e.g. "libc6 (>= 2.34), libelf1 | libelf2".
+func parseDebDepends(value string) []string {
+	var res []string
+	for _, dep := range strings.Split(value, ",") {
+		for _, alt := range strings.Split(dep, "|") {
+			name, _, _ := strings.Cut(strings.TrimSpace(alt), " ")
+			// Drop the architecture qualifier, e.g. libc6:amd64
+			name, _, _ = strings.Cut(name, ":")
+			if name != "" {
+				res = append(res, name)
+			}
+		}
+	}
+	return res
+}
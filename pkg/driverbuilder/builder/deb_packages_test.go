@@ -0,0 +1,90 @@
+// SPDX-License-Identifier: Apache-2.0
+/*
+Copyright (C) 2023 The Diginfra Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package builder
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestFetchDebPackagesCache(t *testing.T) {
+	files := map[string][]byte{
+		"/debian/dists/bookworm/main/binary-amd64/Packages.gz": gzipped(t, []byte(`Package: linux-headers-6.1.0-18-amd64
+Architecture: amd64
+Version: 6.1.76-1
+Filename: pool/main/l/linux/linux-headers-6.1.0-18-amd64_6.1.76-1_amd64.deb
+`)),
+	}
+	var mu sync.Mutex
+	requests := make(map[string]int)
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests[r.URL.Path]++
+		mu.Unlock()
+		<-release
+		testFilesHandler(files)(w, r)
+	}))
+	defer srv.Close()
+	count := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return requests["/debian/dists/bookworm/main/binary-amd64/Packages.gz"]
+	}
+	fetch := func() {
+		pkgs, err := fetchDebPackages(context.Background(), srv.URL+"/debian", "bookworm", "main", "amd64", "linux-")
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		} else if len(pkgs) != 1 {
+			t.Errorf("unexpected packages: %v", pkgs)
+		}
+	}
+
+	// Concurrent lookups share the download in flight
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			fetch()
+		}()
+	}
+	time.Sleep(100 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	if n := count(); n != 1 {
+		t.Fatalf("index downloaded %d times", n)
+	}
+
+	// Then the index is reused until it expires
+	fetch()
+	if n := count(); n != 1 {
+		t.Fatalf("index downloaded %d times", n)
+	}
+	debIndexes.mu.Lock()
+	for key, index := range debIndexes.entries {
+		index.expires = time.Now()
+		debIndexes.entries[key] = index
+	}
+	debIndexes.mu.Unlock()
+	fetch()
+	if n := count(); n != 2 {
+		t.Fatalf("expired index downloaded %d times", n)
+	}
+}
@@ -15,11 +15,14 @@ limitations under the License.
 package builder
 
 import (
+	"context"
 	_ "embed"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
-	"net/http"
+	"net/url"
+	"path"
 	"regexp"
 	"strings"
 
@@ -65,8 +68,8 @@ func (v *debian) TemplateScript() string {
 	return debianTemplate
 }
 
-func (v *debian) URLs(kr kernelrelease.KernelRelease) ([]string, error) {
-	return fetchDebianKernelURLs(kr)
+func (v *debian) URLs(ctx context.Context, kr kernelrelease.KernelRelease) ([]string, error) {
+	return fetchDebianKernelURLs(ctx, kr)
 }
 
 func (v *debian) KernelTemplateData(kr kernelrelease.KernelRelease, urls []string) interface{} {
@@ -90,13 +93,45 @@ func (v *debian) MinimumURLs() int {
 	return debianRequiredURLs
 }
 
-func fetchDebianKernelURLs(kr kernelrelease.KernelRelease) ([]string, error) {
-	kbuildURL, err := debianKbuildURLFromRelease(kr)
+func (v *debian) DefaultMirrors(_ kernelrelease.KernelRelease) []string {
+	return debianDefaultMirrors
+}
+
+// debianDefaultMirrors are the base urls the kernel headers are looked up from.
+// The kbuild package is looked up from debianDefaultKbuildMirror instead.
+var debianDefaultMirrors = []string{
+	"http://security-cdn.debian.org",
+	"https://mirrors.edge.kernel.org/debian",
+}
+
+const debianDefaultKbuildMirror = "http://mirrors.kernel.org/debian"
+
+func fetchDebianKernelURLs(ctx context.Context, kr kernelrelease.KernelRelease) ([]string, error) {
+	urls, err := debianKernelURLsFromPool(ctx, kr)
+	if err == nil {
+		return urls, nil
+	}
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+
+	// Kernels rotated out of the pool are still available from snapshot.debian.org
+	urls, snapshotErr := debianKernelURLsFromSnapshot(ctx, kr)
+	if snapshotErr != nil {
+		return nil, errors.Join(err, snapshotErr)
+	}
+	return urls, nil
+}
+
+// debianKernelURLsFromPool looks the kernel headers up in the pool of the mirrors,
+// then the kbuild package of the same version.
+func debianKernelURLsFromPool(ctx context.Context, kr kernelrelease.KernelRelease) ([]string, error) {
+	urls, err := debianHeadersURLFromRelease(ctx, kr)
 	if err != nil {
 		return nil, err
 	}
 
-	urls, err := debianHeadersURLFromRelease(kr)
+	kbuildURL, err := debianKbuildURLFromRelease(ctx, kr, urls[0])
 	if err != nil {
 		return nil, err
 	}
@@ -105,33 +140,34 @@ func fetchDebianKernelURLs(kr kernelrelease.KernelRelease) ([]string, error) {
 	return urls, nil
 }
 
-func debianHeadersURLFromRelease(kr kernelrelease.KernelRelease) ([]string, error) {
-	baseURLS := []string{
-		"http://security-cdn.debian.org/pool/main/l/linux/",
-		"http://security-cdn.debian.org/pool/updates/main/l/linux/",
-		"https://mirrors.edge.kernel.org/debian/pool/main/l/linux/",
+func debianHeadersURLFromRelease(ctx context.Context, kr kernelrelease.KernelRelease) ([]string, error) {
+	baseURLS := []string{}
+	for _, mirror := range mirrorBaseURLs(ctx, TargetTypeDebian.String(), debianDefaultMirrors...) {
+		baseURLS = append(baseURLS,
+			mirror+"/pool/main/l/linux/",
+			mirror+"/pool/updates/main/l/linux/",
+		)
 	}
 
 	for _, u := range baseURLS {
-		urls, err := fetchDebianHeadersURLFromRelease(u, kr)
+		urls, err := fetchDebianHeadersURLFromRelease(ctx, u, kr)
 
 		if err == nil {
 			return urls, err
 		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
 	}
 
-	return nil, HeadersNotFoundErr
+	return nil, &HeadersNotFoundError{URLs: baseURLS}
 }
 
-func fetchDebianHeadersURLFromRelease(baseURL string, kr kernelrelease.KernelRelease) ([]string, error) {
+// debianFlavor returns the kernel release extraversion without the flavor and the architecture, e.g. -6 for 4.19.0-6-cloud-amd64,
+// together with the suffix of the headers package, e.g. cloud-amd64, and the one of the common headers package, e.g. common.
+func debianFlavor(kr kernelrelease.KernelRelease) (string, string, string) {
 	extraVersionPartial := strings.TrimSuffix(kr.FullExtraversion, "-"+kr.Architecture.String())
 	matchExtraGroup := kr.Architecture.String()
-	rmatch := `href="(linux-headers-%d\.%d\.%d%s-(%s)_.*(%s|all)\.deb)"`
-
-	// For urls like: http://security.debian.org/pool/updates/main/l/linux/linux-headers-5.10.0-12-amd64_5.10.103-1_amd64.deb
-	// when 5.10.103-1 is passed as kernel version
-	rmatchNew := `href="(linux-headers-[0-9]+\.[0-9]+\.[0-9]+-[0-9]+-(%s)_%d\.%d\.%d%s_(%s|all)\.deb)"`
-
 	matchExtraGroupCommon := "common"
 
 	// match for kernel versions like 4.19.0-6-cloud-amd64
@@ -148,9 +184,19 @@ func fetchDebianHeadersURLFromRelease(baseURL string, kr kernelrelease.KernelRel
 			break
 		}
 	}
+	return extraVersionPartial, matchExtraGroup, matchExtraGroupCommon
+}
+
+func fetchDebianHeadersURLFromRelease(ctx context.Context, baseURL string, kr kernelrelease.KernelRelease) ([]string, error) {
+	extraVersionPartial, matchExtraGroup, matchExtraGroupCommon := debianFlavor(kr)
+	rmatch := `href="(linux-headers-%d\.%d\.%d%s-(%s)_.*(%s|all)\.deb)"`
+
+	// For urls like: http://security.debian.org/pool/updates/main/l/linux/linux-headers-5.10.0-12-amd64_5.10.103-1_amd64.deb
+	// when 5.10.103-1 is passed as kernel version
+	rmatchNew := `href="(linux-headers-[0-9]+\.[0-9]+\.[0-9]+-[0-9]+-(%s)_%d\.%d\.%d%s_(%s|all)\.deb)"`
 
 	// download index
-	resp, err := http.Get(baseURL)
+	resp, err := httpGet(ctx, baseURL)
 	if err != nil {
 		return nil, err
 	}
@@ -197,16 +243,70 @@ func fetchDebianHeadersURLFromRelease(baseURL string, kr kernelrelease.KernelRel
 	return foundURLs, nil
 }
 
-func debianKbuildURLFromRelease(kr kernelrelease.KernelRelease) (string, error) {
-	rmatch := `href="(linux-kbuild-%d\.%d.*%s\.deb)"`
-
-	kbuildPattern := regexp.MustCompile(fmt.Sprintf(rmatch, kr.Major, kr.Minor, kr.Architecture.String()))
-	baseURL := "http://mirrors.kernel.org/debian/pool/main/l/linux/"
+// debianKbuildURLFromRelease looks the kbuild package built from the same source version as the given headers package up,
+// next to the headers package first, then in the pool of the kbuild mirrors.
+func debianKbuildURLFromRelease(ctx context.Context, kr kernelrelease.KernelRelease, headersURL string) (string, error) {
+	var kbuildPattern *regexp.Regexp
+	pool := "/pool/main/l/linux/"
+	baseURLs := []string{}
 	if kr.Major == 3 {
-		baseURL = "http://mirrors.kernel.org/debian/pool/main/l/linux-tools/"
+		// 3.x kbuild packages are built from linux-tools, with their own versions
+		rmatch := `href="(linux-kbuild-%d\.%d.*%s\.deb)"`
+		kbuildPattern = regexp.MustCompile(fmt.Sprintf(rmatch, kr.Major, kr.Minor, kr.Architecture.String()))
+		pool = "/pool/main/l/linux-tools/"
+	} else {
+		version, err := debianPackageVersion(headersURL)
+		if err != nil {
+			return "", err
+		}
+		rmatch := `href="(linux-kbuild-%d\.%d[^_"]*_%s_%s\.deb)"`
+		kbuildPattern = regexp.MustCompile(fmt.Sprintf(rmatch, kr.Major, kr.Minor, debianVersionRegex(version), kr.Architecture.String()))
+		baseURLs = append(baseURLs, headersURL[:strings.LastIndex(headersURL, "/")+1])
+	}
+
+	for _, mirror := range mirrorBaseURLs(ctx, TargetTypeDebian.String(), debianDefaultKbuildMirror) {
+		baseURLs = append(baseURLs, mirror+pool)
+	}
+	for _, baseURL := range baseURLs {
+		kbuildURL, err := fetchDebianKbuildURL(ctx, baseURL, kbuildPattern)
+		if err == nil {
+			return kbuildURL, nil
+		}
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
 	}
 
-	resp, err := http.Get(baseURL)
+	return "", &HeadersNotFoundError{URLs: baseURLs}
+}
+
+// debianPackageVersion returns the version of the package at the given url, e.g. 6.1.76-1~bpo11+1
+// for https://deb.debian.org/debian/pool/main/l/linux/linux-headers-6.1.0-0.deb11.17-amd64_6.1.76-1~bpo11+1_amd64.deb.
+func debianPackageVersion(u string) (string, error) {
+	name, err := url.PathUnescape(path.Base(u))
+	if err != nil {
+		return "", err
+	}
+	parts := strings.Split(strings.TrimSuffix(name, ".deb"), "_")
+	if len(parts) != 3 {
+		return "", fmt.Errorf("unexpected debian package name %q", name)
+	}
+	return parts[1], nil
+}
+
+// debianVersionRegex returns the regex matching the given package version in the links of a pool directory,
+// where characters like ~ and + may be escaped.
+func debianVersionRegex(version string) string {
+	r := strings.NewReplacer(
+		"~", "(?:~|%7[eE])",
+		"+", "(?:\\+|%2[bB])",
+		".", "\\.",
+	)
+	return r.Replace(version)
+}
+
+func fetchDebianKbuildURL(ctx context.Context, baseURL string, kbuildPattern *regexp.Regexp) (string, error) {
+	resp, err := httpGet(ctx, baseURL)
 	if err != nil {
 		return "", err
 	}
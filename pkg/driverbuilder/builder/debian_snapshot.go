@@ -0,0 +1,142 @@
+// SPDX-License-Identifier: Apache-2.0
+/*
+Copyright (C) 2023 The Diginfra Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package builder
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/diginfra/driverkit/pkg/kernelrelease"
+)
+
+// debianSnapshotURL is the base url of the snapshot.debian.org archive,
+// holding every package ever published by Debian, backports included.
+var debianSnapshotURL = "https://snapshot.debian.org"
+
+// debianSnapshotVersions is the response of the snapshot.debian.org API listing the versions of a binary package.
+type debianSnapshotVersions struct {
+	Result []struct {
+		BinaryVersion string `json:"binary_version"`
+		Source        string `json:"source"`
+		Version       string `json:"version"`
+	} `json:"result"`
+}
+
+// debianSnapshotBinFiles is the response of the snapshot.debian.org API listing the files of a binary package version.
+type debianSnapshotBinFiles struct {
+	Result []struct {
+		Architecture string `json:"architecture"`
+		// Hash is the sha1 of the file.
+		Hash string `json:"hash"`
+	} `json:"result"`
+	FileInfo map[string][]struct {
+		ArchiveName string `json:"archive_name"`
+		FirstSeen   string `json:"first_seen"`
+		Name        string `json:"name"`
+		Path        string `json:"path"`
+	} `json:"fileinfo"`
+}
+
+// debianKernelURLsFromSnapshot looks the headers, common headers and kbuild packages of the kernel release up
+// through the snapshot.debian.org machine-readable API, all of them with the same version.
+// The version matching the kernel version is used, if any, the most recent one otherwise.
+func debianKernelURLsFromSnapshot(ctx context.Context, kr kernelrelease.KernelRelease) ([]string, error) {
+	extraVersionPartial, matchExtraGroup, matchExtraGroupCommon := debianFlavor(kr)
+	abi := kr.Fullversion + extraVersionPartial
+	headersName := fmt.Sprintf("linux-headers-%s-%s", abi, matchExtraGroup)
+
+	var versions debianSnapshotVersions
+	if err := getDebianSnapshot(ctx, fmt.Sprintf("/mr/binary/%s/", url.PathEscape(headersName)), &versions); err != nil {
+		return nil, err
+	}
+	if len(versions.Result) == 0 {
+		return nil, fmt.Errorf("%w: %s not found in %s", HeadersNotFoundErr, headersName, debianSnapshotURL)
+	}
+	// Versions are listed newest first
+	version := versions.Result[0].BinaryVersion
+	for _, v := range versions.Result {
+		if v.BinaryVersion == kr.KernelVersion {
+			version = v.BinaryVersion
+			break
+		}
+	}
+
+	packages := []struct {
+		name string
+		arch string
+	}{
+		{headersName, kr.Architecture.String()},
+		{fmt.Sprintf("linux-headers-%s-%s", abi, matchExtraGroupCommon), "all"},
+		// The kbuild package is built from the same source, hence with the same version
+		{fmt.Sprintf("linux-kbuild-%d.%d", kr.Major, kr.Minor), kr.Architecture.String()},
+	}
+	urls := make([]string, 0, len(packages))
+	for _, p := range packages {
+		u, err := debianSnapshotFileURL(ctx, p.name, version, p.arch)
+		if err != nil {
+			return nil, err
+		}
+		urls = append(urls, u)
+	}
+	return urls, nil
+}
+
+// debianSnapshotFileURL returns the url of the given binary package version and architecture in the snapshot archive,
+// with its checksum attached.
+func debianSnapshotFileURL(ctx context.Context, name, version, arch string) (string, error) {
+	var files debianSnapshotBinFiles
+	p := fmt.Sprintf("/mr/binary/%s/%s/binfiles?fileinfo=1", url.PathEscape(name), url.PathEscape(version))
+	if err := getDebianSnapshot(ctx, p, &files); err != nil {
+		return "", err
+	}
+	for _, f := range files.Result {
+		if f.Architecture != arch {
+			continue
+		}
+		for _, info := range files.FileInfo[f.Hash] {
+			u := fmt.Sprintf("%s/archive/%s/%s%s/%s", debianSnapshotURL, info.ArchiveName, info.FirstSeen,
+				strings.TrimSuffix(info.Path, "/"), url.PathEscape(info.Name))
+			checksum, err := NewChecksum("sha1", f.Hash)
+			if err != nil {
+				return "", fmt.Errorf("package %s: %w", info.Name, err)
+			}
+			return WithChecksum(u, checksum), nil
+		}
+	}
+	return "", fmt.Errorf("%w: %s %s for %s not found in %s", HeadersNotFoundErr, name, version, arch, debianSnapshotURL)
+}
+
+func getDebianSnapshot(ctx context.Context, p string, v interface{}) error {
+	u := debianSnapshotURL + p
+	res, err := httpGet(ctx, u)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	switch {
+	case res.StatusCode == http.StatusNotFound:
+		return fmt.Errorf("%w: %s", HeadersNotFoundErr, u)
+	case res.StatusCode != http.StatusOK:
+		return fmt.Errorf("error querying %s: %s", u, res.Status)
+	}
+	if err = json.NewDecoder(res.Body).Decode(v); err != nil {
+		return fmt.Errorf("error decoding %s: %w", u, err)
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+// SPDX-License-Identifier: Apache-2.0
+/*
+Copyright (C) 2023 The Diginfra Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package builder
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/diginfra/driverkit/pkg/kernelrelease"
+)
+
+func TestDebianKernelURLsFromPool(t *testing.T) {
+	pool := "/debian/pool/main/l/linux/"
+	files := []string{
+		"linux-headers-6.1.0-0.deb11.17-amd64_6.1.76-1~bpo11+1_amd64.deb",
+		"linux-headers-6.1.0-0.deb11.17-common_6.1.76-1~bpo11+1_all.deb",
+		// The kbuild of another version comes first, and must not be picked
+		"linux-kbuild-6.1_6.1.69-1~bpo11+1_amd64.deb",
+		"linux-kbuild-6.1_6.1.76-1%7Ebpo11%2B1_amd64.deb",
+	}
+	listing := func(files []string) []byte {
+		var buf bytes.Buffer
+		for _, f := range files {
+			_, _ = fmt.Fprintf(&buf, "<a href=\"%s\">%s</a>\n", f, f)
+		}
+		return buf.Bytes()
+	}
+	served := map[string][]byte{pool: listing(files)}
+	srv := testFileServer(served)
+	defer srv.Close()
+
+	kr := kernelrelease.FromString("6.1.0-0.deb11.17-amd64")
+	kr.Architecture = kernelrelease.ArchitectureAmd64
+	ctx := WithMirrors(context.Background(), Mirrors{TargetTypeDebian.String(): {srv.URL + "/debian"}})
+	urls, err := fetchDebianKernelURLs(ctx, kr)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []string{
+		srv.URL + pool + files[0],
+		srv.URL + pool + files[1],
+		srv.URL + pool + files[3],
+	}
+	if !reflect.DeepEqual(urls, expected) {
+		t.Fatalf("unexpected urls: got %v, want %v", urls, expected)
+	}
+
+	// A missing kbuild package reports the pools it was looked up in
+	served[pool] = listing(files[:3])
+	_, err = debianKbuildURLFromRelease(ctx, kr, urls[0])
+	var notFound *HeadersNotFoundError
+	if !errors.As(err, &notFound) || !reflect.DeepEqual(notFound.URLs, []string{srv.URL + pool, srv.URL + pool}) {
+		t.Fatalf("expected headers not found error, got %v", err)
+	}
+}
+
+func TestDebianKernelURLsFromSnapshot(t *testing.T) {
+	const version = "5.10.103-1"
+	hashes := map[string]string{
+		"linux-headers-5.10.0-12-amd64":  strings.Repeat("1", 40),
+		"linux-headers-5.10.0-12-common": strings.Repeat("2", 40),
+		"linux-kbuild-5.10":              strings.Repeat("3", 40),
+	}
+	archs := map[string]string{"linux-headers-5.10.0-12-common": "all"}
+	files := map[string][]byte{
+		"/mr/binary/linux-headers-5.10.0-12-amd64/": []byte(fmt.Sprintf(`{"result": [{"binary_version": "5.10.106-1", "source": "linux", "version": "5.10.106-1"},
+				{"binary_version": "%s", "source": "linux", "version": "%s"}]}`, version, version)),
+	}
+	for name, hash := range hashes {
+		arch := "amd64"
+		if a, ok := archs[name]; ok {
+			arch = a
+		}
+		files["/mr/binary/"+name+"/"+version+"/binfiles"] = []byte(fmt.Sprintf(`{"result": [{"architecture": "arm64", "hash": "%s"}, {"architecture": "%s", "hash": "%s"}],
+				"fileinfo": {"%s": [{"archive_name": "debian-security", "first_seen": "20220308T000000Z", "name": "%s_%s_%s.deb", "path": "/pool/updates/main/l/linux"}]}}`,
+			strings.Repeat("f", 40), arch, hash, hash, name, version, arch))
+	}
+	srv := testFileServer(files)
+	defer srv.Close()
+	defaultSnapshotURL := debianSnapshotURL
+	debianSnapshotURL = srv.URL
+	t.Cleanup(func() { debianSnapshotURL = defaultSnapshotURL })
+
+	// The kernel is not in the pool anymore
+	kr := kernelrelease.FromString("5.10.0-12-amd64")
+	kr.Architecture = kernelrelease.ArchitectureAmd64
+	kr.KernelVersion = version
+	ctx := WithMirrors(context.Background(), Mirrors{TargetTypeDebian.String(): {srv.URL + "/debian"}})
+	urls, err := fetchDebianKernelURLs(ctx, kr)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	archive := srv.URL + "/archive/debian-security/20220308T000000Z/pool/updates/main/l/linux/"
+	expected := []string{
+		archive + "linux-headers-5.10.0-12-amd64_5.10.103-1_amd64.deb#sha1=" + hashes["linux-headers-5.10.0-12-amd64"],
+		archive + "linux-headers-5.10.0-12-common_5.10.103-1_all.deb#sha1=" + hashes["linux-headers-5.10.0-12-common"],
+		archive + "linux-kbuild-5.10_5.10.103-1_amd64.deb#sha1=" + hashes["linux-kbuild-5.10"],
+	}
+	if !reflect.DeepEqual(urls, expected) {
+		t.Fatalf("unexpected urls: got %v, want %v", urls, expected)
+	}
+}
@@ -15,6 +15,7 @@ limitations under the License.
 package builder
 
 import (
+	"context"
 	_ "embed"
 	"fmt"
 	"strings"
@@ -53,7 +54,18 @@ func (c *fedora) TemplateScript() string {
 	return fedoraTemplate
 }
 
-func (c *fedora) URLs(kr kernelrelease.KernelRelease) ([]string, error) {
+// fedoraMetalinkURL is the url of the Fedora metalinks, listing the mirrors of each repository.
+const fedoraMetalinkURL = "https://mirrors.fedoraproject.org/metalink"
+
+func (c *fedora) DefaultMirrors(_ kernelrelease.KernelRelease) []string {
+	return []string{
+		"https://mirrors.kernel.org/fedora",
+		// releases reaching their end of life are moved to the archive
+		"https://archives.fedoraproject.org/pub/archive/fedora/linux",
+	}
+}
+
+func (c *fedora) URLs(ctx context.Context, kr kernelrelease.KernelRelease) ([]string, error) {
 
 	// fedora FullExtraversion looks like "-200.fc36.x86_64"
 	// need to get the "fc36" out of the middle
@@ -61,34 +73,30 @@ func (c *fedora) URLs(kr kernelrelease.KernelRelease) ([]string, error) {
 
 	// trim off the "fc" from fedoraVersion
 	version := strings.Trim(fedoraVersion, "fc")
-
-	// template the kernel info into all possible URL strings
-	urls := []string{
-		fmt.Sprintf( // updates
-			"https://mirrors.kernel.org/fedora/updates/%s/Everything/%s/Packages/k/kernel-devel-%s%s.rpm",
-			version,
-			kr.Architecture.ToNonDeb(),
-			kr.Fullversion,
-			kr.FullExtraversion,
-		),
-		fmt.Sprintf( // releases
-			"https://mirrors.kernel.org/fedora/releases/%s/Everything/%s/os/Packages/k/kernel-devel-%s%s.rpm",
-			version,
-			kr.Architecture.ToNonDeb(),
-			kr.Fullversion,
-			kr.FullExtraversion,
-		),
-		fmt.Sprintf( // development
-			"https://mirrors.kernel.org/fedora/development/%s/Everything/%s/os/Packages/k/kernel-devel-%s%s.rpm",
-			version,
-			kr.Architecture.ToNonDeb(),
-			kr.Fullversion,
-			kr.FullExtraversion,
-		),
+	arch := kr.Architecture.ToNonDeb()
+
+	// list the repositories of the release, for each mirror
+	var repos []rpmRepo
+	defaults := c.DefaultMirrors(kr)
+	metalinks := false
+	for _, mirror := range mirrorBaseURLs(ctx, TargetTypeFedora.String(), defaults...) {
+		metalinks = metalinks || mirror == defaults[0]
+		repos = append(repos,
+			rpmRepo{URL: fmt.Sprintf("%s/updates/%s/Everything/%s", mirror, version, arch)},
+			rpmRepo{URL: fmt.Sprintf("%s/releases/%s/Everything/%s/os", mirror, version, arch)},
+			rpmRepo{URL: fmt.Sprintf("%s/development/%s/Everything/%s/os", mirror, version, arch)},
+		)
+	}
+	// the metalinks list the mirrors closest to the host, as a last resort;
+	// they are skipped when only custom mirrors are configured, that may be the only reachable ones
+	if metalinks {
+		repos = append(repos,
+			rpmRepo{URL: fmt.Sprintf("%s?repo=updates-released-f%s&arch=%s", fedoraMetalinkURL, version, arch), Kind: rpmRepoMetalink},
+			rpmRepo{URL: fmt.Sprintf("%s?repo=fedora-%s&arch=%s", fedoraMetalinkURL, version, arch), Kind: rpmRepoMetalink},
+		)
 	}
 
-	// return out all possible urls
-	return urls, nil
+	return rpmRepodataURLs(ctx, repos, rpmKernelPackages{names: []string{"kernel-devel"}, kr: kr})
 }
 
 func (c *fedora) KernelTemplateData(_ kernelrelease.KernelRelease, urls []string) interface{} {
@@ -15,11 +15,12 @@ limitations under the License.
 package builder
 
 import (
+	"context"
 	_ "embed"
 	"fmt"
 	"io/ioutil"
-	"net/http"
 	"strings"
+	"sync"
 
 	"github.com/blang/semver"
 	"github.com/diginfra/driverkit/pkg/kernelrelease"
@@ -44,7 +45,10 @@ type flatcarTemplateData struct {
 
 // flatcar is a driverkit target.
 type flatcar struct {
-	info *flatcarReleaseInfo
+	mu sync.Mutex
+	// infos are the release infos loaded by LoadKernelInfo, by kernel release and architecture,
+	// since the builder is shared by concurrent builds.
+	infos map[string]*flatcarReleaseInfo
 }
 
 func (f *flatcar) Name() string {
@@ -59,32 +63,50 @@ func (f *flatcar) TemplateScript() string {
 	return flatcarTemplate
 }
 
-func (f *flatcar) URLs(kr kernelrelease.KernelRelease) ([]string, error) {
-	if err := f.fillFlatcarInfos(kr); err != nil {
-		return nil, err
+func (f *flatcar) URLs(_ context.Context, kr kernelrelease.KernelRelease) ([]string, error) {
+	info := f.info(kr)
+	if info == nil {
+		return nil, fmt.Errorf("release infos of %s not loaded", kr.String())
 	}
-	return fetchFlatcarKernelURLS(f.info.KernelVersion), nil
+	return fetchFlatcarKernelURLS(info.KernelVersion)
 }
 
-func (f *flatcar) KernelTemplateData(kr kernelrelease.KernelRelease, urls []string) interface{} {
-	// This happens when `kernelurls` option is passed,
-	// therefore URLs() method is not called.
-	if f.info == nil {
-		if err := f.fillFlatcarInfos(kr); err != nil {
-			return err
-		}
-	}
+// LoadKernelInfo fetches the release infos, needed also when `kernelurls` option is passed,
+// since they provide the gcc version.
+func (f *flatcar) LoadKernelInfo(ctx context.Context, kr kernelrelease.KernelRelease) error {
+	return f.fillFlatcarInfos(ctx, kr)
+}
 
+func (f *flatcar) KernelTemplateData(_ kernelrelease.KernelRelease, urls []string) interface{} {
 	return flatcarTemplateData{
 		KernelDownloadURL: urls[0],
 	}
 }
 
-func (f *flatcar) GCCVersion(_ kernelrelease.KernelRelease) semver.Version {
-	return f.info.GCCVersion
+func (f *flatcar) GCCVersion(kr kernelrelease.KernelRelease) semver.Version {
+	info := f.info(kr)
+	if info == nil {
+		return semver.Version{}
+	}
+	return info.GCCVersion
+}
+
+func (f *flatcar) info(kr kernelrelease.KernelRelease) *flatcarReleaseInfo {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.infos[kr.String()+"/"+kr.Architecture.String()]
 }
 
-func (f *flatcar) fillFlatcarInfos(kr kernelrelease.KernelRelease) error {
+func (f *flatcar) setInfo(kr kernelrelease.KernelRelease, info *flatcarReleaseInfo) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.infos == nil {
+		f.infos = make(map[string]*flatcarReleaseInfo)
+	}
+	f.infos[kr.String()+"/"+kr.Architecture.String()] = info
+}
+
+func (f *flatcar) fillFlatcarInfos(ctx context.Context, kr kernelrelease.KernelRelease) error {
 	if kr.Extraversion != "" {
 		return fmt.Errorf("unexpected extraversion: %s", kr.Extraversion)
 	}
@@ -94,26 +116,32 @@ func (f *flatcar) fillFlatcarInfos(kr kernelrelease.KernelRelease) error {
 		return fmt.Errorf("not a valid flatcar release version: %d", kr.Major)
 	}
 
-	var err error
-	f.info, err = fetchFlatcarMetadata(kr)
-	return err
+	info, err := fetchFlatcarMetadata(ctx, kr)
+	if err != nil {
+		return err
+	}
+	f.setInfo(kr, info)
+	return nil
 }
 
-func fetchFlatcarKernelURLS(kernelVersion string) []string {
-	kv := kernelrelease.FromString(kernelVersion)
-	return []string{fetchVanillaKernelURLFromKernelVersion(kv)}
+func fetchFlatcarKernelURLS(kernelVersion string) ([]string, error) {
+	kv, err := kernelrelease.Parse(kernelVersion)
+	if err != nil {
+		return nil, err
+	}
+	return []string{fetchVanillaKernelURLFromKernelVersion(kv)}, nil
 }
 
-func fetchFlatcarMetadata(kr kernelrelease.KernelRelease) (*flatcarReleaseInfo, error) {
+func fetchFlatcarMetadata(ctx context.Context, kr kernelrelease.KernelRelease) (*flatcarReleaseInfo, error) {
 	flatcarInfo := flatcarReleaseInfo{}
 	flatcarVersion := kr.Fullversion
-	packageIndexUrl, err := GetResolvingURLs(fetchFlatcarPackageListURL(kr.Architecture, flatcarVersion))
+	packageIndexUrl, err := GetResolvingURLs(ctx, fetchFlatcarPackageListURL(kr.Architecture, flatcarVersion))
 	if err != nil {
 		return nil, err
 	}
 	// first part of the URL is the channel
 	flatcarInfo.Channel = strings.Split(packageIndexUrl[0], ".")[0][len("https://"):]
-	resp, err := http.Get(packageIndexUrl[0])
+	resp, err := httpGet(ctx, packageIndexUrl[0])
 	if err != nil {
 		return nil, err
 	}
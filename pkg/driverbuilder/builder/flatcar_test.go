@@ -0,0 +1,54 @@
+// SPDX-License-Identifier: Apache-2.0
+/*
+Copyright (C) 2023 The Diginfra Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package builder
+
+import (
+	"context"
+	"testing"
+
+	"github.com/blang/semver"
+
+	"github.com/diginfra/driverkit/pkg/kernelrelease"
+)
+
+func TestFlatcarInfosByRelease(t *testing.T) {
+	f := &flatcar{}
+	kr1 := kernelrelease.FromString("3815.2.0")
+	kr1.Architecture = kernelrelease.ArchitectureAmd64
+	kr2 := kernelrelease.FromString("3975.1.0")
+	kr2.Architecture = kernelrelease.ArchitectureAmd64
+	f.setInfo(kr1, &flatcarReleaseInfo{GCCVersion: semver.Version{Major: 12}, KernelVersion: "6.1.77"})
+	f.setInfo(kr2, &flatcarReleaseInfo{GCCVersion: semver.Version{Major: 13}, KernelVersion: "6.6.21"})
+
+	if gcc := f.GCCVersion(kr1); !gcc.EQ(semver.Version{Major: 12}) {
+		t.Fatalf("expected gcc 12, got %s", gcc)
+	}
+	urls, err := f.URLs(context.Background(), kr2)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if expected := "https://cdn.kernel.org/pub/linux/kernel/v6.x/linux-6.6.21.tar.xz"; urls[0] != expected {
+		t.Fatalf("expected %s, got %s", expected, urls[0])
+	}
+
+	kr3 := kernelrelease.FromString("3975.1.0")
+	kr3.Architecture = kernelrelease.ArchitectureArm64
+	if gcc := f.GCCVersion(kr3); !gcc.EQ(semver.Version{}) {
+		t.Fatalf("expected no gcc for a release not loaded, got %s", gcc)
+	}
+	if _, err = f.URLs(context.Background(), kr3); err == nil {
+		t.Fatalf("expected an error for a release not loaded")
+	}
+}
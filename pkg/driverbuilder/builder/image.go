@@ -16,6 +16,7 @@ package builder
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/diginfra/diginfractl/pkg/output"
 	"os"
@@ -28,6 +29,9 @@ import (
 	"gopkg.in/yaml.v3"
 )
 
+// ErrNoBuilderImage is returned when no builder image can be used for the build.
+var ErrNoBuilderImage = errors.New("no builder image found")
+
 type YAMLImage struct {
 	Target      string   `yaml:"target"`
 	GCCVersions []string `yaml:"gcc_versions"` // we expect images to internally link eg: gcc5 to gcc5.0.0
@@ -47,7 +51,7 @@ type Image struct {
 }
 
 type ImagesLister interface {
-	LoadImages(printer *output.Printer) []Image
+	LoadImages(ctx context.Context, printer *output.Printer) []Image
 }
 
 type FileImagesLister struct {
@@ -59,6 +63,7 @@ type FileImagesLister struct {
 
 type RepoImagesLister struct {
 	*repository.Repository
+	tagReg *regexp.Regexp
 }
 
 type ImageKey string
@@ -69,8 +74,6 @@ func (i *Image) toKey() ImageKey {
 
 type ImagesMap map[ImageKey]Image
 
-var tagReg *regexp.Regexp
-
 func (im ImagesMap) findImage(target Type, gccVers semver.Version) (Image, bool) {
 	targetImage := Image{
 		Target:     target,
@@ -98,7 +101,7 @@ func NewFileImagesLister(filePath string, build *Build) (*FileImagesLister, erro
 	}, nil
 }
 
-func (f *FileImagesLister) LoadImages(printer *output.Printer) []Image {
+func (f *FileImagesLister) LoadImages(_ context.Context, printer *output.Printer) []Image {
 	var (
 		res       []Image
 		imageList YAMLImagesList
@@ -148,10 +151,16 @@ func (f *FileImagesLister) LoadImages(printer *output.Printer) []Image {
 		}
 
 		for _, gcc := range image.GCCVersions {
+			gccVersion, err := semver.ParseTolerant(gcc)
+			if err != nil {
+				printer.Logger.Debug("skipping invalid gcc version",
+					printer.Logger.Args("filepath", f.FilePath, "image", image, "gcc", gcc))
+				continue
+			}
 			buildImage := Image{
 				Name:       image.Name,
 				Target:     Type(image.Target),
-				GCCVersion: mustParseTolerant(gcc),
+				GCCVersion: gccVersion,
 			}
 			res = append(res, buildImage)
 		}
@@ -160,14 +169,11 @@ func (f *FileImagesLister) LoadImages(printer *output.Printer) []Image {
 }
 
 func NewRepoImagesLister(repo string, build *Build) (*RepoImagesLister, error) {
-	// Lazy inizialization
-	if tagReg == nil {
-		imageTag := build.builderImageTag()
-		// Create the proper regexes to load "any" and target-specific images for requested arch
-		arch := kernelrelease.Architecture(build.Architecture).ToNonDeb()
-		targetFmt := fmt.Sprintf("^(?P<target>%s|any)-%s(?P<gccVers>(_gcc[0-9]+.[0-9]+.[0-9]+)+)-%s$", build.TargetType.String(), arch, imageTag)
-		tagReg = regexp.MustCompile(targetFmt)
-	}
+	imageTag := build.builderImageTag()
+	// Create the proper regexes to load "any" and target-specific images for requested arch
+	arch := kernelrelease.Architecture(build.Architecture).ToNonDeb()
+	targetFmt := fmt.Sprintf("^(?P<target>%s|any)-%s(?P<gccVers>(_gcc[0-9]+.[0-9]+.[0-9]+)+)-%s$", build.TargetType.String(), arch, imageTag)
+	tagReg := regexp.MustCompile(targetFmt)
 
 	// Get the registry URL from repository.
 	registry, err := getRegistryFromRef(repo)
@@ -181,11 +187,11 @@ func NewRepoImagesLister(repo string, build *Build) (*RepoImagesLister, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &RepoImagesLister{repoOCI}, nil
+	return &RepoImagesLister{Repository: repoOCI, tagReg: tagReg}, nil
 }
 
-func (repo *RepoImagesLister) LoadImages(printer *output.Printer) []Image {
-	tags, err := repo.Tags(context.Background())
+func (repo *RepoImagesLister) LoadImages(ctx context.Context, printer *output.Printer) []Image {
+	tags, err := repo.Tags(ctx)
 	if err != nil {
 		printer.Logger.Warn("skipping repo",
 			printer.Logger.Args("repo", repo.Reference, "err", err.Error()))
@@ -195,7 +201,7 @@ func (repo *RepoImagesLister) LoadImages(printer *output.Printer) []Image {
 	var res []Image
 	for _, t := range tags {
 		img := fmt.Sprintf("%s:%s", repo.Reference, t)
-		match := tagReg.FindStringSubmatch(t)
+		match := repo.tagReg.FindStringSubmatch(t)
 		if len(match) == 0 {
 			continue
 		}
@@ -204,7 +210,7 @@ func (repo *RepoImagesLister) LoadImages(printer *output.Printer) []Image {
 			target  string
 			gccVers []string
 		)
-		for i, name := range tagReg.SubexpNames() {
+		for i, name := range repo.tagReg.SubexpNames() {
 			if i > 0 && i <= len(match) {
 				switch name {
 				case "gccVers":
@@ -222,9 +228,14 @@ func (repo *RepoImagesLister) LoadImages(printer *output.Printer) []Image {
 		// and we cannot guarantee here that any subsequent docker repos
 		// does not provide a target-specific image that offers same gcc version
 		for _, gccVer := range gccVers {
-			// If user set a fixed gcc version, only load images that provide it.
+			gccVersion, err := semver.ParseTolerant(gccVer)
+			if err != nil {
+				printer.Logger.Debug("skipping invalid gcc version",
+					printer.Logger.Args("repo", repo.Reference, "tag", t, "gcc", gccVer))
+				continue
+			}
 			buildImage := Image{
-				GCCVersion: mustParseTolerant(gccVer),
+				GCCVersion: gccVersion,
 				Name:       img,
 				Target:     Type(target),
 			}
@@ -234,9 +245,11 @@ func (repo *RepoImagesLister) LoadImages(printer *output.Printer) []Image {
 	return res
 }
 
-func (b *Build) LoadImages() {
+// LoadImages loads the builder images from all the listers,
+// returning ErrNoBuilderImage if none is found.
+func (b *Build) LoadImages(ctx context.Context) error {
 	for _, imagesLister := range b.ImagesListers {
-		for _, image := range imagesLister.LoadImages(b.Printer) {
+		for _, image := range imagesLister.LoadImages(ctx, b.Printer) {
 			// User forced a gcc version? Only load images matching the requested gcc version.
 			if b.GCCVersion != "" && b.GCCVersion != image.GCCVersion.String() {
 				continue
@@ -248,8 +261,9 @@ func (b *Build) LoadImages() {
 		}
 	}
 	if len(b.Images) == 0 {
-		b.Printer.Logger.Fatal("Could not load any builder image. Leaving.")
+		return ErrNoBuilderImage
 	}
+	return nil
 }
 
 // getRegistryFromRef extracts the registry from a ref string.
@@ -15,6 +15,7 @@ limitations under the License.
 package builder
 
 import (
+	"context"
 	"github.com/diginfra/diginfractl/pkg/output"
 	"github.com/pterm/pterm"
 	"io"
@@ -273,7 +274,7 @@ func TestFileImagesLister(t *testing.T) {
 			t.Fatal(err)
 		}
 
-		assert.DeepEqual(t, test.expected, lister.LoadImages(printer))
+		assert.DeepEqual(t, test.expected, lister.LoadImages(context.Background(), printer))
 	}
 }
 
@@ -306,6 +307,6 @@ func TestRepoImagesLister(t *testing.T) {
 		mock.RegisterHandler("/v2/foo/test/tags/list", func(w http.ResponseWriter, r *http.Request) {
 			w.Write([]byte(test.jsonData))
 		})
-		assert.DeepEqual(t, test.expected, lister.LoadImages(printer))
+		assert.DeepEqual(t, test.expected, lister.LoadImages(context.Background(), printer))
 	}
 }
@@ -1,7 +1,9 @@
 package builder
 
 import (
+	"context"
 	_ "embed"
+	"errors"
 	"fmt"
 	"github.com/diginfra/driverkit/pkg/kernelrelease"
 	"path/filepath"
@@ -24,15 +26,20 @@ func (l *LocalBuilder) Name() string {
 	return "local"
 }
 
+// errLocalBuilderHeaders is returned when the local builder is asked for kernel headers:
+// they are expected to be already available on the host.
+var errLocalBuilderHeaders = errors.New("kernel headers download not supported by local builder")
+
 func (l *LocalBuilder) TemplateKernelUrlsScript() string {
-	panic("cannot be called on local builder")
+	// KernelTemplateData fails, the template will never be executed
+	return ""
 }
 
 func (l *LocalBuilder) TemplateScript() string {
 	return localTemplate
 }
 
-func (l *LocalBuilder) URLs(_ kernelrelease.KernelRelease) ([]string, error) {
+func (l *LocalBuilder) URLs(_ context.Context, _ kernelrelease.KernelRelease) ([]string, error) {
 	return nil, nil
 }
 
@@ -50,7 +57,7 @@ type localTemplateData struct {
 }
 
 func (l *LocalBuilder) KernelTemplateData(_ kernelrelease.KernelRelease, _ []string) interface{} {
-	panic("cannot be called on local builder")
+	return errLocalBuilderHeaders
 }
 
 func (l *LocalBuilder) TemplateData(c Config, kr kernelrelease.KernelRelease) interface{} {
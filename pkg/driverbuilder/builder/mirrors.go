@@ -0,0 +1,120 @@
+// SPDX-License-Identifier: Apache-2.0
+/*
+Copyright (C) 2023 The Diginfra Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package builder
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/diginfra/driverkit/pkg/kernelrelease"
+)
+
+// DefaultMirror is the mirrors entry standing for the default base urls of a target.
+// Listing it after custom mirrors prepends them to the defaults; omitting it replaces the defaults.
+const DefaultMirror = "default"
+
+// Mirrors maps each target to the base urls its kernel headers are looked up from,
+// in order of preference.
+type Mirrors map[string][]string
+
+// MirrorsBuilder is an optional interface implemented by builders
+// whose base urls can be overridden through Mirrors.
+type MirrorsBuilder interface {
+	// DefaultMirrors returns the base urls used when no mirrors are configured for the builder.
+	DefaultMirrors(kr kernelrelease.KernelRelease) []string
+}
+
+// MirrorsTargets returns the list of targets supporting mirrors.
+func MirrorsTargets() []string {
+	res := []string{}
+	for k, b := range byTarget {
+		if _, ok := b.(MirrorsBuilder); ok {
+			res = append(res, k.String())
+		}
+	}
+	sort.Strings(res)
+	return res
+}
+
+// Validate checks that every target of m supports mirrors,
+// and that every mirror is either DefaultMirror or an absolute http(s) url.
+func (m Mirrors) Validate() error {
+	for target, mirrors := range m {
+		b, ok := byTarget[Type(target)]
+		if !ok {
+			return fmt.Errorf("mirrors: unknown target %q", target)
+		}
+		if _, ok = b.(MirrorsBuilder); !ok {
+			return fmt.Errorf("mirrors: target %q does not support mirrors, supported targets: %s",
+				target, strings.Join(MirrorsTargets(), ", "))
+		}
+		if len(mirrors) == 0 {
+			return fmt.Errorf("mirrors: no mirrors for target %q", target)
+		}
+		for _, mirror := range mirrors {
+			if mirror == DefaultMirror {
+				continue
+			}
+			u, err := url.Parse(mirror)
+			if err != nil {
+				return fmt.Errorf("mirrors: invalid mirror for target %q: %w", target, err)
+			}
+			if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return fmt.Errorf("mirrors: invalid mirror for target %q: %q is not an absolute http(s) url", target, mirror)
+			}
+		}
+	}
+	return nil
+}
+
+// baseURLs returns the base urls for the given target, expanding DefaultMirror to the given defaults.
+// When no mirrors are configured for the target, the defaults are returned.
+func (m Mirrors) baseURLs(target string, defaults ...string) []string {
+	mirrors, ok := m[target]
+	if !ok {
+		return defaults
+	}
+	res := []string{}
+	for _, mirror := range mirrors {
+		if mirror == DefaultMirror {
+			res = append(res, defaults...)
+			continue
+		}
+		res = append(res, strings.TrimSuffix(mirror, "/"))
+	}
+	return res
+}
+
+type mirrorsContextKey struct{}
+
+// WithMirrors returns a copy of ctx carrying the given mirrors,
+// used by builders to resolve the kernel headers urls.
+func WithMirrors(ctx context.Context, m Mirrors) context.Context {
+	return context.WithValue(ctx, mirrorsContextKey{}, m)
+}
+
+// mirrorsFromContext returns the mirrors carried by ctx, if any.
+func mirrorsFromContext(ctx context.Context) Mirrors {
+	m, _ := ctx.Value(mirrorsContextKey{}).(Mirrors)
+	return m
+}
+
+// mirrorBaseURLs returns the base urls to look up the kernel headers of the given target from, in order.
+func mirrorBaseURLs(ctx context.Context, target string, defaults ...string) []string {
+	return mirrorsFromContext(ctx).baseURLs(target, defaults...)
+}
@@ -0,0 +1,130 @@
+// SPDX-License-Identifier: Apache-2.0
+/*
+Copyright (C) 2023 The Diginfra Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package builder
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/diginfra/driverkit/pkg/kernelrelease"
+)
+
+func TestMirrorsBaseURLs(t *testing.T) {
+	defaults := []string{"https://a.example.com", "https://b.example.com"}
+	mirrors := Mirrors{
+		"replace": {"https://mirror.example.com/"},
+		"prepend": {"https://mirror.example.com", DefaultMirror},
+	}
+	tests := map[string][]string{
+		"replace": {"https://mirror.example.com"},
+		"prepend": {"https://mirror.example.com", "https://a.example.com", "https://b.example.com"},
+		"missing": defaults,
+	}
+	for target, expected := range tests {
+		if got := mirrors.baseURLs(target, defaults...); !reflect.DeepEqual(got, expected) {
+			t.Fatalf("unexpected base urls for %s: got %v, want %v", target, got, expected)
+		}
+	}
+	if got := Mirrors(nil).baseURLs("missing", defaults...); !reflect.DeepEqual(got, defaults) {
+		t.Fatalf("unexpected base urls without mirrors: %v", got)
+	}
+}
+
+func TestMirrorsValidate(t *testing.T) {
+	tests := []struct {
+		mirrors Mirrors
+		err     string
+	}{
+		{mirrors: nil},
+		{mirrors: Mirrors{"ubuntu": {"https://mirror.example.com/ubuntu", DefaultMirror}}},
+		{mirrors: Mirrors{"foo": {"https://mirror.example.com"}}, err: "unknown target"},
+		{mirrors: Mirrors{"vanilla": {"https://mirror.example.com"}}, err: "does not support mirrors"},
+		{mirrors: Mirrors{"debian": {}}, err: "no mirrors"},
+		{mirrors: Mirrors{"debian": {"mirror.example.com/debian"}}, err: "not an absolute http(s) url"},
+		{mirrors: Mirrors{"debian": {"ftp://mirror.example.com/debian"}}, err: "not an absolute http(s) url"},
+	}
+	for _, test := range tests {
+		err := test.mirrors.Validate()
+		if test.err == "" && err != nil {
+			t.Fatalf("unexpected error for %v: %v", test.mirrors, err)
+		}
+		if test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)) {
+			t.Fatalf("expected error containing %q for %v, got: %v", test.err, test.mirrors, err)
+		}
+	}
+}
+
+func TestMirrorsURLs(t *testing.T) {
+	kr := kernelrelease.FromString("5.10.134-13.al8.x86_64")
+	kr.Architecture = kernelrelease.ArchitectureAmd64
+	ctx := WithMirrors(context.Background(), Mirrors{
+		TargetTypeAlinux.String(): {"https://mirror.example.com/alinux", DefaultMirror},
+	})
+
+	urls, err := (&alinux{}).URLs(ctx, kr)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []string{
+		"https://mirror.example.com/alinux/2/os/x86_64/Packages/kernel-devel-5.10.134-13.al8.x86_64.rpm",
+		"https://mirror.example.com/alinux/2.1903/os/x86_64/Packages/kernel-devel-5.10.134-13.al8.x86_64.rpm",
+		"https://mirror.example.com/alinux/3/os/x86_64/Packages/kernel-devel-5.10.134-13.al8.x86_64.rpm",
+		"http://mirrors.aliyun.com/alinux/2/os/x86_64/Packages/kernel-devel-5.10.134-13.al8.x86_64.rpm",
+		"http://mirrors.aliyun.com/alinux/2.1903/os/x86_64/Packages/kernel-devel-5.10.134-13.al8.x86_64.rpm",
+		"http://mirrors.aliyun.com/alinux/3/os/x86_64/Packages/kernel-devel-5.10.134-13.al8.x86_64.rpm",
+	}
+	if !reflect.DeepEqual(urls, expected) {
+		t.Fatalf("unexpected urls: got %v, want %v", urls, expected)
+	}
+}
+
+func TestUbuntuHeadersURLFromMirrors(t *testing.T) {
+	pool := "/ubuntu/pool/main/l/linux/"
+	packages := map[string]bool{
+		pool + "linux-headers-5.15.0-1_5.15.0-1.1_all.deb":           true,
+		pool + "linux-headers-5.15.0-1-generic_5.15.0-1.1_amd64.deb": true,
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !packages[r.URL.Path] {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+	baseURL := strings.Replace(srv.URL, "127.0.0.1", "localhost", 1)
+
+	kr := kernelrelease.FromString("5.15.0-1-generic")
+	kr.Architecture = kernelrelease.ArchitectureAmd64
+	kr.KernelVersion = "1"
+	// The first mirror does not host the packages, resolution falls back to the second one
+	ctx := WithMirrors(context.Background(), Mirrors{
+		TargetTypeUbuntu.String(): {baseURL + "/empty", baseURL + "/ubuntu"},
+	})
+
+	urls, err := ubuntuHeadersURLFromRelease(ctx, kr)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []string{
+		baseURL + pool + "linux-headers-5.15.0-1-generic_5.15.0-1.1_amd64.deb",
+		baseURL + pool + "linux-headers-5.15.0-1_5.15.0-1.1_all.deb",
+	}
+	if !reflect.DeepEqual(urls, expected) {
+		t.Fatalf("unexpected urls: got %v, want %v", urls, expected)
+	}
+}
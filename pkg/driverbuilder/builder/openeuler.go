@@ -0,0 +1,104 @@
+// SPDX-License-Identifier: Apache-2.0
+/*
+Copyright (C) 2023 The Diginfra Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package builder
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/blang/semver"
+	"github.com/diginfra/driverkit/pkg/kernelrelease"
+)
+
+// TargetTypeOpenEuler identifies the openEuler target.
+const TargetTypeOpenEuler Type = "openeuler"
+
+func init() {
+	byTarget[TargetTypeOpenEuler] = &openeuler{}
+}
+
+// openeuler is a driverkit target.
+type openeuler struct {
+}
+
+// openEulerReleaseRegex matches the release of a kernel release, e.g. 22, 03 and sp1 for 5.10.0-136.12.0.86.oe2203sp1.x86_64.
+var openEulerReleaseRegex = regexp.MustCompile(`\.oe(\d{2})(\d{2})(sp\d+)?`)
+
+func (c *openeuler) Name() string {
+	return TargetTypeOpenEuler.String()
+}
+
+// TemplateKernelUrlsScript reuses the rocky one, openEuler kernel-devel packages also installing
+// the headers under /usr/src/kernels.
+func (c *openeuler) TemplateKernelUrlsScript() string {
+	return rockyKernelTemplate
+}
+
+func (c *openeuler) TemplateScript() string {
+	return rockyTemplate
+}
+
+func (c *openeuler) DefaultMirrors(_ kernelrelease.KernelRelease) []string {
+	return []string{"https://repo.openeuler.org"}
+}
+
+func (c *openeuler) URLs(ctx context.Context, kr kernelrelease.KernelRelease) ([]string, error) {
+	m := openEulerReleaseRegex.FindStringSubmatch(kr.FullExtraversion)
+	if m == nil {
+		return nil, fmt.Errorf("%w: no openeuler release in %s", HeadersNotFoundErr, kr.String())
+	}
+	// e.g. openEuler-22.03-LTS-SP1; only some releases are LTS ones
+	release := fmt.Sprintf("openEuler-%s.%s", m[1], m[2])
+	releases := []string{release + "-LTS", release}
+	if m[3] != "" {
+		releases = []string{release + "-LTS-" + strings.ToUpper(m[3])}
+	}
+
+	var repos []rpmRepo
+	for _, mirror := range mirrorBaseURLs(ctx, TargetTypeOpenEuler.String(), c.DefaultMirrors(kr)...) {
+		for _, r := range releases {
+			for _, repo := range []string{"update", "OS", "everything"} {
+				repos = append(repos, rpmRepo{URL: fmt.Sprintf("%s/%s/%s/%s", mirror, r, repo, kr.Architecture.ToNonDeb())})
+			}
+		}
+	}
+	return rpmRepodataURLs(ctx, repos, rpmKernelPackages{names: []string{"kernel-devel"}, kr: kr})
+}
+
+func (c *openeuler) KernelTemplateData(_ kernelrelease.KernelRelease, urls []string) interface{} {
+	return rockyTemplateData{
+		KernelDownloadURL: urls[0],
+	}
+}
+
+func (c *openeuler) GCCVersion(kr kernelrelease.KernelRelease) semver.Version {
+	// The LTS releases are named after their year: 20.03 ships gcc 7, 22.03 gcc 10, 24.03 gcc 12
+	m := openEulerReleaseRegex.FindStringSubmatch(kr.FullExtraversion)
+	if m == nil {
+		return semver.Version{}
+	}
+	year, _ := strconv.Atoi(m[1])
+	switch {
+	case year <= 20:
+		return semver.Version{Major: 7}
+	case year <= 22:
+		return semver.Version{Major: 10}
+	default:
+		return semver.Version{Major: 12}
+	}
+}
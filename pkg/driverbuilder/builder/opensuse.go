@@ -15,6 +15,7 @@ limitations under the License.
 package builder
 
 import (
+	"context"
 	_ "embed"
 	"fmt"
 	"strings"
@@ -91,7 +92,7 @@ func (o *opensuse) TemplateScript() string {
 	return opensuseTemplate
 }
 
-func (o *opensuse) URLs(kr kernelrelease.KernelRelease) ([]string, error) {
+func (o *opensuse) URLs(ctx context.Context, kr kernelrelease.KernelRelease) ([]string, error) {
 
 	// SUSE requires 2 urls: a kernel-default-devel*{arch}.rpm and a kernel-devel*noarch.rpm
 	kernelDefaultDevelPattern := fmt.Sprintf("kernel-default-devel-%s%s.rpm", kr.Fullversion, kr.FullExtraversion)
@@ -105,7 +106,7 @@ func (o *opensuse) URLs(kr kernelrelease.KernelRelease) ([]string, error) {
 	possibleURLs := buildURLs(kr, kernelDefaultDevelPattern, kernelDevelNoArchPattern)
 
 	// trim the list to only resolving URLs
-	urls, err := GetResolvingURLs(possibleURLs)
+	urls, err := GetResolvingURLs(ctx, possibleURLs)
 	if err != nil {
 		return nil, err
 	}
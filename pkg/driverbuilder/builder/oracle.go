@@ -15,8 +15,10 @@ limitations under the License.
 package builder
 
 import (
+	"context"
 	_ "embed"
 	"fmt"
+	"regexp"
 	"strings"
 
 	"github.com/diginfra/driverkit/pkg/kernelrelease"
@@ -55,7 +57,11 @@ func (c *oracle) TemplateScript() string {
 	return oracleTemplate
 }
 
-func (c *oracle) URLs(kr kernelrelease.KernelRelease) ([]string, error) {
+func (c *oracle) DefaultMirrors(_ kernelrelease.KernelRelease) []string {
+	return []string{"http://yum.oracle.com/repo/OracleLinux"}
+}
+
+func (c *oracle) URLs(ctx context.Context, kr kernelrelease.KernelRelease) ([]string, error) {
 
 	// oracle FullExtraversion looks like "-2047.510.5.5.el7uek.x86_64"
 	// need to get the "el7uek" out of the middle
@@ -70,61 +76,38 @@ func (c *oracle) URLs(kr kernelrelease.KernelRelease) ([]string, error) {
 		version = strings.Split(version, "_")[0]
 	}
 
-	// list of possible UEK versions, which are used in the URL - ex: "UEKR3"
-	// may need to evolve over time if Oracle adds more
-	ueks := []string{"R3", "R4", "R5", "R6", "R7"}
-
-	// template the kernel info into all possible URL strings
-	urls := []string{
-		fmt.Sprintf( // latest (Oracle 7)
-			"http://yum.oracle.com/repo/OracleLinux/OL%s/latest/%s/getPackage/kernel-devel-%s%s.rpm",
-			version,
-			kr.Architecture.ToNonDeb(),
-			kr.Fullversion,
-			kr.FullExtraversion,
-		),
-		fmt.Sprintf( // latest + baseos (Oracle 8 + 9)
-			"http://yum.oracle.com/repo/OracleLinux/OL%s/baseos/latest/%s/getPackage/kernel-devel-%s%s.rpm",
-			version,
-			kr.Architecture.ToNonDeb(),
-			kr.Fullversion,
-			kr.FullExtraversion,
-		),
-		fmt.Sprintf( // appstream (Oracle 8 + 9)
-			"http://yum.oracle.com/repo/OracleLinux/OL%s/appstream/%s/getPackage/kernel-devel-%s%s.rpm",
-			version,
-			kr.Architecture.ToNonDeb(),
-			kr.Fullversion,
-			kr.FullExtraversion,
-		),
-		fmt.Sprintf( // MODRHCK (Oracle 7)
-			"http://yum.oracle.com/repo/OracleLinux/OL%s/MODRHCK/%s/getPackage/kernel-devel-%s%s.rpm",
-			version,
-			kr.Architecture.ToNonDeb(),
-			kr.Fullversion,
-			kr.FullExtraversion,
-		),
-	}
-
-	// add in all the UEK versions
-	for _, uekVers := range ueks {
-		urls = append(
-			urls,
-			fmt.Sprintf( // UEK versions URL
-				"http://yum.oracle.com/repo/OracleLinux/OL%s/UEK%s/%s/getPackage/kernel-uek-devel-%s%s.rpm",
-				version,
-				uekVers,
-				kr.Architecture.ToNonDeb(),
-				kr.Fullversion,
-				kr.FullExtraversion,
-			),
+	arch := kr.Architecture.ToNonDeb()
+
+	// template the kernel info into all possible repositories, for each mirror
+	var repos []rpmRepo
+	for _, mirror := range mirrorBaseURLs(ctx, TargetTypeoracle.String(), c.DefaultMirrors(kr)...) {
+		base := fmt.Sprintf("%s/OL%s", mirror, version)
+		repos = append(repos,
+			rpmRepo{URL: fmt.Sprintf("%s/latest/%s", base, arch)},        // latest (Oracle 7)
+			rpmRepo{URL: fmt.Sprintf("%s/baseos/latest/%s", base, arch)}, // latest + baseos (Oracle 8 + 9)
+			rpmRepo{URL: fmt.Sprintf("%s/appstream/%s", base, arch)},     // appstream (Oracle 8 + 9)
+			rpmRepo{URL: fmt.Sprintf("%s/MODRHCK/%s", base, arch)},       // MODRHCK (Oracle 7)
 		)
+
+		// add in all the UEK versions, e.g. "UEKR7"
+		ueks, err := listReleaseDirs(ctx, base, oracleUEKRegex)
+		if err != nil || len(ueks) == 0 {
+			ueks = oracleUEKReleases
+		}
+		for _, uek := range ueks {
+			repos = append(repos, rpmRepo{URL: fmt.Sprintf("%s/%s/%s", base, uek, arch)})
+		}
 	}
 
-	// return out all possible urls
-	return urls, nil
+	return rpmRepodataURLs(ctx, repos, rpmKernelPackages{names: []string{"kernel-devel", "kernel-uek-devel"}, kr: kr})
 }
 
+// oracleUEKRegex matches the UEK repositories directories.
+var oracleUEKRegex = regexp.MustCompile(`^UEKR\d+$`)
+
+// oracleUEKReleases are the known UEK repositories, used when they cannot be listed.
+var oracleUEKReleases = []string{"UEKR7", "UEKR6", "UEKR5", "UEKR4", "UEKR3"}
+
 func (c *oracle) KernelTemplateData(_ kernelrelease.KernelRelease, urls []string) interface{} {
 	return oracleTemplateData{
 		KernelDownloadURL: urls[0],
@@ -15,8 +15,10 @@ limitations under the License.
 package builder
 
 import (
+	"context"
 	_ "embed"
 	"fmt"
+	"regexp"
 
 	"github.com/diginfra/driverkit/pkg/kernelrelease"
 )
@@ -54,8 +56,12 @@ func (p *photon) TemplateScript() string {
 	return photonTemplate
 }
 
-func (p *photon) URLs(kr kernelrelease.KernelRelease) ([]string, error) {
-	return fetchPhotonKernelURLS(kr), nil
+func (p *photon) DefaultMirrors(_ kernelrelease.KernelRelease) []string {
+	return []string{"https://packages.vmware.com/photon"}
+}
+
+func (p *photon) URLs(ctx context.Context, kr kernelrelease.KernelRelease) ([]string, error) {
+	return fetchPhotonKernelURLS(ctx, mirrorBaseURLs(ctx, TargetTypePhoton.String(), p.DefaultMirrors(kr)...), kr)
 }
 
 func (p *photon) KernelTemplateData(_ kernelrelease.KernelRelease, urls []string) interface{} {
@@ -64,42 +70,26 @@ func (p *photon) KernelTemplateData(_ kernelrelease.KernelRelease, urls []string
 	}
 }
 
-func fetchPhotonKernelURLS(kr kernelrelease.KernelRelease) []string {
-	photonReleases := []string{
-		"3.0",
-		"4.0",
-		"5.0",
-	}
+// photonReleaseRegex matches the photon release of a kernel release, e.g. 4 for 5.10.83-7.ph4.
+var photonReleaseRegex = regexp.MustCompile(`\.ph(\d+)`)
 
-	var urls []string
-	for _, r := range photonReleases {
-		urls = append(urls, fmt.Sprintf(
-			"https://packages.vmware.com/photon/%s/photon_%s_%s/%s/linux-devel-%s%s.x86_64.rpm",
-			r,
-			r,
-			kr.Architecture.ToNonDeb(),
-			kr.Architecture.ToNonDeb(),
-			kr.Fullversion,
-			kr.FullExtraversion,
-		))
-		urls = append(urls, fmt.Sprintf(
-			"https://packages.vmware.com/photon/%s/photon_release_%s_%s/%s/linux-devel-%s%s.x86_64.rpm",
-			r,
-			r,
-			kr.Architecture.ToNonDeb(),
-			kr.Architecture.ToNonDeb(),
-			kr.Fullversion,
-			kr.FullExtraversion,
-		))
-		urls = append(urls, fmt.Sprintf(
-			"https://packages.vmware.com/photon/%s/photon_updates_%s_%s/%s/linux-devel-%s%s.x86_64.rpm",
-			r,
-			r,
-			kr.Architecture.ToNonDeb(),
-			kr.Architecture.ToNonDeb(),
-			kr.Fullversion,
-			kr.FullExtraversion,
-		))
+func fetchPhotonKernelURLS(ctx context.Context, mirrors []string, kr kernelrelease.KernelRelease) ([]string, error) {
+	m := photonReleaseRegex.FindStringSubmatch(kr.FullExtraversion)
+	if m == nil {
+		return nil, fmt.Errorf("%w: no photon release in %s", HeadersNotFoundErr, kr.String())
+	}
+	r := m[1] + ".0"
+	arch := kr.Architecture.ToNonDeb()
+
+	var repos []rpmRepo
+	for _, mirror := range mirrors {
+		for _, layout := range []string{
+			"%s/%s/photon_%s_%s",
+			"%s/%s/photon_release_%s_%s",
+			"%s/%s/photon_updates_%s_%s",
+		} {
+			repos = append(repos, rpmRepo{URL: fmt.Sprintf(layout, mirror, r, r, arch)})
+		}
 	}
-	return urls
+	return rpmRepodataURLs(ctx, repos, rpmKernelPackages{names: []string{"linux-devel"}, kr: kr})
 }
@@ -0,0 +1,536 @@
+// SPDX-License-Identifier: Apache-2.0
+/*
+Copyright (C) 2023 The Diginfra Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package builder
+
+import (
+	"bufio"
+	"compress/bzip2"
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	_ "modernc.org/sqlite"
+
+	"github.com/diginfra/driverkit/pkg/kernelrelease"
+)
+
+// errRepodataNotFound is returned when a repository, or one of its metadata files, does not exist.
+var errRepodataNotFound = errors.New("repository metadata not found")
+
+// maxRepoMirrors bounds the number of mirrors tried for each repository listed by a mirror list or a metalink.
+const maxRepoMirrors = 3
+
+type rpmRepoKind int
+
+const (
+	// rpmRepoBase is a repository root, holding the repodata directory.
+	rpmRepoBase rpmRepoKind = iota
+	// rpmRepoMirrorList is a mirror list, listing a repository root per line.
+	rpmRepoMirrorList
+	// rpmRepoMetalink is a metalink, listing the repomd.xml urls of a repository.
+	rpmRepoMetalink
+)
+
+// rpmRepo is an RPM repository, either as its root or as a mirror list or a metalink.
+type rpmRepo struct {
+	URL  string
+	Kind rpmRepoKind
+}
+
+// rpmPackage is a package listed by the primary metadata of an RPM repository.
+type rpmPackage struct {
+	Name    string
+	Arch    string
+	Version string
+	Release string
+	// Location is the path of the package, relative to the repository root or to LocationBase, if set.
+	Location     string
+	LocationBase string
+	ChecksumType string
+	Checksum     string
+}
+
+// url returns the url of the package within the repository rooted at baseURL, with its checksum attached.
+func (p *rpmPackage) url(baseURL string) string {
+	if p.LocationBase != "" {
+		baseURL = p.LocationBase
+	}
+	u := strings.TrimSuffix(baseURL, "/") + "/" + strings.TrimPrefix(p.Location, "/")
+	if c, err := NewChecksum(p.ChecksumType, p.Checksum); err == nil {
+		return WithChecksum(u, c)
+	}
+	return u
+}
+
+// rpmKernelPackages selects the packages with the given names built for the kernel release,
+// whose version-release, with or without the architecture, is the kernel release.
+type rpmKernelPackages struct {
+	names []string
+	kr    kernelrelease.KernelRelease
+}
+
+func (q *rpmKernelPackages) match(p *rpmPackage) bool {
+	found := false
+	for _, name := range q.names {
+		if p.Name == name {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return false
+	}
+	release := q.kr.Fullversion + q.kr.FullExtraversion
+	vr := p.Version + "-" + p.Release
+	return release == vr+"."+p.Arch || (release == vr && p.Arch == q.kr.Architecture.ToNonDeb())
+}
+
+// rpmRepodataURLs returns the urls of the packages selected by q, from the first of the given repositories holding any.
+// Repositories missing from their mirror are skipped, as well as the ones of hosts that cannot be reached.
+func rpmRepodataURLs(ctx context.Context, repos []rpmRepo, q rpmKernelPackages) ([]string, error) {
+	unreachable := make(map[string]error)
+	visited := make(map[string]struct{})
+	var tried []string
+	for _, repo := range repos {
+		if _, ok := visited[repo.URL]; ok {
+			continue
+		}
+		visited[repo.URL] = struct{}{}
+		roots, err := rpmRepoRoots(ctx, repo, unreachable)
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if err != nil {
+			tried = append(tried, repo.URL)
+			continue
+		}
+		for _, root := range roots {
+			tried = append(tried, root)
+			if _, ok := unreachable[urlHost(root)]; ok {
+				continue
+			}
+			urls, err := fetchRPMRepoPackageURLs(ctx, root, q.match)
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			markUnreachable(unreachable, root, err)
+			if err == nil && len(urls) > 0 {
+				return urls, nil
+			}
+			if err == nil {
+				// The repository holds no such package, its mirrors neither
+				break
+			}
+		}
+	}
+	return nil, &HeadersNotFoundError{URLs: tried}
+}
+
+// rpmRepoRoots returns the roots of the given repository, resolving its mirror list or metalink.
+func rpmRepoRoots(ctx context.Context, repo rpmRepo, unreachable map[string]error) ([]string, error) {
+	if repo.Kind == rpmRepoBase {
+		return []string{strings.TrimSuffix(repo.URL, "/")}, nil
+	}
+	if err, ok := unreachable[urlHost(repo.URL)]; ok {
+		return nil, err
+	}
+	res, err := httpGet(ctx, repo.URL)
+	if err != nil {
+		markUnreachable(unreachable, repo.URL, err)
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: %s: %s", errRepodataNotFound, repo.URL, res.Status)
+	}
+	var roots []string
+	if repo.Kind == rpmRepoMetalink {
+		roots, err = parseMetalink(res.Body)
+	} else {
+		roots, err = parseMirrorList(res.Body)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %w", repo.URL, err)
+	}
+	if len(roots) > maxRepoMirrors {
+		roots = roots[:maxRepoMirrors]
+	}
+	return roots, nil
+}
+
+// markUnreachable records the host of u as unreachable when err is a network error.
+func markUnreachable(unreachable map[string]error, u string, err error) {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		unreachable[urlHost(u)] = err
+	}
+}
+
+func urlHost(u string) string {
+	uu, err := url.Parse(u)
+	if err != nil {
+		return ""
+	}
+	return uu.Host
+}
+
+// parseMirrorList returns the repository roots listed by a mirror list, one per line.
+func parseMirrorList(r io.Reader) ([]string, error) {
+	var roots []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		roots = append(roots, strings.TrimSuffix(line, "/"))
+	}
+	return roots, scanner.Err()
+}
+
+type metalink struct {
+	Files []struct {
+		Name string `xml:"name,attr"`
+		URLs []struct {
+			Protocol   string `xml:"protocol,attr"`
+			Preference int    `xml:"preference,attr"`
+			URL        string `xml:",chardata"`
+		} `xml:"resources>url"`
+	} `xml:"files>file"`
+}
+
+// parseMetalink returns the repository roots listed by a metalink, most preferred first.
+func parseMetalink(r io.Reader) ([]string, error) {
+	var ml metalink
+	if err := xml.NewDecoder(r).Decode(&ml); err != nil {
+		return nil, err
+	}
+	var roots []string
+	for _, f := range ml.Files {
+		if f.Name != "repomd.xml" {
+			continue
+		}
+		urls := f.URLs
+		sort.SliceStable(urls, func(i, j int) bool {
+			return urls[i].Preference > urls[j].Preference
+		})
+		for _, u := range urls {
+			if u.Protocol != "http" && u.Protocol != "https" {
+				continue
+			}
+			roots = append(roots, strings.TrimSuffix(strings.TrimSpace(u.URL), "/repodata/repomd.xml"))
+		}
+	}
+	return roots, nil
+}
+
+type repomd struct {
+	Data []struct {
+		Type     string `xml:"type,attr"`
+		Location struct {
+			Href string `xml:"href,attr"`
+		} `xml:"location"`
+	} `xml:"data"`
+}
+
+// fetchRPMRepoPackageURLs returns the urls of the packages matching in the repository rooted at root,
+// reading its primary metadata, either as XML or as SQLite database.
+func fetchRPMRepoPackageURLs(ctx context.Context, root string, match func(*rpmPackage) bool) ([]string, error) {
+	var md repomd
+	repomdURL := root + "/repodata/repomd.xml"
+	err := withRepoFile(ctx, repomdURL, func(r io.Reader) error {
+		return xml.NewDecoder(r).Decode(&md)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	hrefs := make(map[string]string)
+	for _, d := range md.Data {
+		hrefs[d.Type] = d.Location.Href
+	}
+	var pkgs []rpmPackage
+	switch {
+	case hrefs["primary"] != "" && decompressorSupported(hrefs["primary"]):
+		err = withRepoFile(ctx, root+"/"+hrefs["primary"], func(r io.Reader) error {
+			pkgs, err = parsePrimaryXML(r, match)
+			return err
+		})
+	case hrefs["primary_db"] != "" && decompressorSupported(hrefs["primary_db"]):
+		err = withRepoFile(ctx, root+"/"+hrefs["primary_db"], func(r io.Reader) error {
+			pkgs, err = queryPrimaryDB(r, match)
+			return err
+		})
+	default:
+		err = fmt.Errorf("no supported primary metadata in %s", repomdURL)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	urls := make([]string, 0, len(pkgs))
+	for i := range pkgs {
+		urls = append(urls, pkgs[i].url(root))
+	}
+	return urls, nil
+}
+
+// withRepoFile downloads the given repository metadata file, calling fn with its decompressed content.
+func withRepoFile(ctx context.Context, u string, fn func(r io.Reader) error) error {
+	res, err := httpGet(ctx, u)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	switch {
+	case res.StatusCode == http.StatusNotFound || res.StatusCode == http.StatusForbidden:
+		return fmt.Errorf("%w: %s", errRepodataNotFound, u)
+	case res.StatusCode != http.StatusOK:
+		return fmt.Errorf("error downloading %s: %s", u, res.Status)
+	}
+	r, closer, err := decompressor(u, res.Body)
+	if err != nil {
+		return fmt.Errorf("error reading %s: %w", u, err)
+	}
+	defer closer()
+	if err = fn(r); err != nil {
+		return fmt.Errorf("error reading %s: %w", u, err)
+	}
+	return nil
+}
+
+func decompressorSupported(href string) bool {
+	switch path.Ext(href) {
+	case ".gz", ".bz2", ".zst", ".xml", ".sqlite":
+		return true
+	}
+	return false
+}
+
+// decompressor returns the reader decompressing r, according to the extension of the file at u.
+func decompressor(u string, r io.Reader) (io.Reader, func(), error) {
+	switch path.Ext(u) {
+	case ".gz":
+		gr, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, nil, err
+		}
+		return gr, func() { gr.Close() }, nil
+	case ".bz2":
+		return bzip2.NewReader(r), func() {}, nil
+	case ".zst":
+		zr, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, nil, err
+		}
+		return zr, zr.Close, nil
+	case ".xml", ".sqlite":
+		return r, func() {}, nil
+	}
+	return nil, nil, fmt.Errorf("unsupported compression %q", path.Ext(u))
+}
+
+type primaryPackage struct {
+	Name    string `xml:"name"`
+	Arch    string `xml:"arch"`
+	Version struct {
+		Ver string `xml:"ver,attr"`
+		Rel string `xml:"rel,attr"`
+	} `xml:"version"`
+	Checksum struct {
+		Type  string `xml:"type,attr"`
+		Value string `xml:",chardata"`
+	} `xml:"checksum"`
+	Location struct {
+		Href string `xml:"href,attr"`
+		Base string `xml:"base,attr"`
+	} `xml:"location"`
+}
+
+// parsePrimaryXML streams a primary.xml file, returning the matching packages, in order.
+func parsePrimaryXML(r io.Reader, match func(*rpmPackage) bool) ([]rpmPackage, error) {
+	var res []rpmPackage
+	d := xml.NewDecoder(r)
+	for {
+		tok, err := d.Token()
+		if errors.Is(err, io.EOF) {
+			return res, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		start, ok := tok.(xml.StartElement)
+		if !ok || start.Name.Local != "package" {
+			continue
+		}
+		var pp primaryPackage
+		if err = d.DecodeElement(&pp, &start); err != nil {
+			return nil, err
+		}
+		p := rpmPackage{
+			Name:         pp.Name,
+			Arch:         pp.Arch,
+			Version:      pp.Version.Ver,
+			Release:      pp.Version.Rel,
+			Location:     pp.Location.Href,
+			LocationBase: pp.Location.Base,
+			ChecksumType: pp.Checksum.Type,
+			Checksum:     strings.TrimSpace(pp.Checksum.Value),
+		}
+		if match(&p) {
+			res = append(res, p)
+		}
+	}
+}
+
+// queryPrimaryDB reads a primary.sqlite database, returning the matching packages, in order.
+func queryPrimaryDB(r io.Reader, match func(*rpmPackage) bool) ([]rpmPackage, error) {
+	// Create the temporary database file
+	dbFile, err := os.CreateTemp(os.TempDir(), "driverkit-primary-*.sqlite")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(dbFile.Name())
+	_, err = io.Copy(dbFile, r)
+	if closeErr := dbFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	// Open the database
+	db, err := sql.Open("sqlite", dbFile.Name())
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	// pkgId is the package checksum, of type checksum_type
+	rows, err := db.Query("SELECT name, arch, version, release, location_href, COALESCE(location_base, ''), checksum_type, pkgId FROM packages")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var res []rpmPackage
+	for rows.Next() {
+		var p rpmPackage
+		if err = rows.Scan(&p.Name, &p.Arch, &p.Version, &p.Release, &p.Location, &p.LocationBase, &p.ChecksumType, &p.Checksum); err != nil {
+			return nil, err
+		}
+		if match(&p) {
+			res = append(res, p)
+		}
+	}
+	return res, rows.Err()
+}
+
+// rpmELRelease returns the major and minor Enterprise Linux releases of the kernel release,
+// e.g. 8 and 7 for 4.18.0-425.3.1.el8_7.x86_64, the minor one being empty when unknown.
+func rpmELRelease(kr kernelrelease.KernelRelease) (string, string, bool) {
+	m := elReleaseRegex.FindStringSubmatch(kr.FullExtraversion)
+	if m == nil {
+		return "", "", false
+	}
+	return m[1], m[2], true
+}
+
+var elReleaseRegex = regexp.MustCompile(`\.el(\d+)(?:_(\d+))?`)
+
+// rpmPointReleases returns the point releases of the given major release listed under u, newest first,
+// the one of the given minor release, if any, coming first.
+// Releases that cannot be listed are skipped.
+func rpmPointReleases(ctx context.Context, u, major, minor string) []string {
+	dirs, err := listReleaseDirs(ctx, u, regexp.MustCompile(`^`+regexp.QuoteMeta(major)+`\.\d+(\.\d+)?$`))
+	if err != nil || minor == "" {
+		return dirs
+	}
+	prefix := major + "." + minor
+	sort.SliceStable(dirs, func(i, j int) bool {
+		return matchesPointRelease(dirs[i], prefix) && !matchesPointRelease(dirs[j], prefix)
+	})
+	return dirs
+}
+
+func matchesPointRelease(dir, release string) bool {
+	return dir == release || strings.HasPrefix(dir, release+".")
+}
+
+// listReleaseDirs lists the release directories of the given url, e.g. 7.9.2009 under https://vault.centos.org,
+// returning the ones matching pattern, newest first.
+func listReleaseDirs(ctx context.Context, u string, pattern *regexp.Regexp) ([]string, error) {
+	res, err := httpGet(ctx, strings.TrimSuffix(u, "/")+"/")
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: %s: %s", errRepodataNotFound, u, res.Status)
+	}
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]struct{})
+	var dirs []string
+	for _, m := range releaseDirRegex.FindAllStringSubmatch(string(body), -1) {
+		dir := m[1]
+		if _, ok := seen[dir]; ok || !pattern.MatchString(dir) {
+			continue
+		}
+		seen[dir] = struct{}{}
+		dirs = append(dirs, dir)
+	}
+	sort.SliceStable(dirs, func(i, j int) bool {
+		return compareReleases(dirs[i], dirs[j]) > 0
+	})
+	return dirs, nil
+}
+
+var releaseDirRegex = regexp.MustCompile(`href="(?:\./)?([^"/?#]+)/"`)
+
+// compareReleases compares dotted release numbers, e.g. 8.10 is newer than 8.9.
+func compareReleases(a, b string) int {
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		an, aErr := strconv.Atoi(as[i])
+		bn, bErr := strconv.Atoi(bs[i])
+		if aErr != nil || bErr != nil {
+			if c := strings.Compare(as[i], bs[i]); c != 0 {
+				return c
+			}
+			continue
+		}
+		if an != bn {
+			if an > bn {
+				return 1
+			}
+			return -1
+		}
+	}
+	return len(as) - len(bs)
+}
@@ -0,0 +1,297 @@
+// SPDX-License-Identifier: Apache-2.0
+/*
+Copyright (C) 2023 The Diginfra Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package builder
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/blang/semver"
+	"github.com/klauspost/compress/zstd"
+
+	"github.com/diginfra/driverkit/pkg/kernelrelease"
+)
+
+const (
+	testKernelDevelDigest = "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+	testPrimaryXML        = `<?xml version="1.0" encoding="UTF-8"?>
+<metadata xmlns="http://linux.duke.edu/metadata/common" packages="3">
+<package type="rpm">
+  <name>kernel-devel</name>
+  <arch>x86_64</arch>
+  <version epoch="0" ver="5.14.10" rel="200.fc35"/>
+  <checksum type="sha256" pkgid="YES">fedcba9876543210fedcba9876543210fedcba9876543210fedcba9876543210</checksum>
+  <location href="Packages/k/kernel-devel-5.14.10-200.fc35.x86_64.rpm"/>
+</package>
+<package type="rpm">
+  <name>kernel-headers</name>
+  <arch>x86_64</arch>
+  <version epoch="0" ver="5.14.10" rel="300.fc35"/>
+  <checksum type="sha256" pkgid="YES">fedcba9876543210fedcba9876543210fedcba9876543210fedcba9876543210</checksum>
+  <location href="Packages/k/kernel-headers-5.14.10-300.fc35.x86_64.rpm"/>
+</package>
+<package type="rpm">
+  <name>kernel-devel</name>
+  <arch>x86_64</arch>
+  <version epoch="0" ver="5.14.10" rel="300.fc35"/>
+  <checksum type="sha256" pkgid="YES">` + testKernelDevelDigest + `</checksum>
+  <location href="Packages/k/kernel-devel-5.14.10-300.fc35.x86_64.rpm"/>
+</package>
+</metadata>`
+)
+
+func testRepomd(primaryType, href string) string {
+	return fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<repomd xmlns="http://linux.duke.edu/metadata/repo">
+  <data type="primary">
+    <location href="repodata/primary.xml.xz"/>
+  </data>
+  <data type="%s">
+    <location href="%s"/>
+  </data>
+</repomd>`, primaryType, href)
+}
+
+func gzipped(t *testing.T, data []byte) []byte {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(data); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// testRPMRepoFiles returns the repodata files of each of the given repositories, listing the given primary.xml.
+func testRPMRepoFiles(t *testing.T, primary string, repos ...string) map[string][]byte {
+	files := make(map[string][]byte)
+	for _, repo := range repos {
+		files[repo+"/repodata/repomd.xml"] = []byte(testRepomd("primary", "repodata/primary.xml.gz"))
+		files[repo+"/repodata/primary.xml.gz"] = gzipped(t, []byte(primary))
+	}
+	return files
+}
+
+// testFileServer serves the given files, by path.
+func testFileServer(files map[string][]byte) *httptest.Server {
+	return httptest.NewServer(testFilesHandler(files))
+}
+
+// testFilesHandler serves the given files, by path, answering 404 for the other ones.
+func testFilesHandler(files map[string][]byte) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		data, ok := files[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write(data)
+	}
+}
+
+func TestRPMRepodataURLs(t *testing.T) {
+	files := map[string][]byte{}
+	srv := testFileServer(files)
+	defer srv.Close()
+	baseURL := srv.URL
+	files["/mirrorlist"] = []byte(fmt.Sprintf("# repositories\n\n%s/missing\n%s/repo/\n", baseURL, baseURL))
+	files["/metalink"] = []byte(fmt.Sprintf(`<?xml version="1.0" encoding="utf-8"?>
+<metalink version="3.0" xmlns="http://www.metalinker.org/">
+  <files>
+    <file name="repomd.xml">
+      <resources maxconnections="1">
+        <url protocol="rsync" type="rsync" location="US" preference="100">rsync://example.com/repo/repodata/repomd.xml</url>
+        <url protocol="http" type="http" location="US" preference="90">%s/missing/repodata/repomd.xml</url>
+        <url protocol="http" type="http" location="US" preference="99">%s/repo/repodata/repomd.xml</url>
+      </resources>
+    </file>
+  </files>
+</metalink>`, baseURL, baseURL))
+
+	// The xz compressed primary metadata is not supported, the XML one is used
+	files["/repo/repodata/repomd.xml"] = []byte(strings.Replace(testRepomd("primary", "repodata/primary.xml.gz"), "primary.xml.xz", "primary.sqlite.xz", 1))
+	files["/repo/repodata/primary.xml.gz"] = gzipped(t, []byte(testPrimaryXML))
+
+	kr := kernelrelease.FromString("5.14.10-300.fc35.x86_64")
+	kr.Architecture = kernelrelease.ArchitectureAmd64
+	q := rpmKernelPackages{names: []string{"kernel-devel"}, kr: kr}
+	expected := []string{baseURL + "/repo/Packages/k/kernel-devel-5.14.10-300.fc35.x86_64.rpm#sha256=" + testKernelDevelDigest}
+
+	tests := map[string][]rpmRepo{
+		"base":       {{URL: baseURL + "/missing"}, {URL: baseURL + "/repo"}},
+		"mirrorlist": {{URL: baseURL + "/mirrorlist", Kind: rpmRepoMirrorList}},
+		"metalink":   {{URL: baseURL + "/metalink", Kind: rpmRepoMetalink}},
+	}
+	for name, repos := range tests {
+		urls, err := rpmRepodataURLs(context.Background(), repos, q)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", name, err)
+		}
+		if !reflect.DeepEqual(urls, expected) {
+			t.Fatalf("%s: unexpected urls: got %v, want %v", name, urls, expected)
+		}
+	}
+
+	// Packages missing from all the repositories
+	q.kr = kernelrelease.FromString("5.14.10-400.fc35.x86_64")
+	q.kr.Architecture = kernelrelease.ArchitectureAmd64
+	_, err := rpmRepodataURLs(context.Background(), tests["base"], q)
+	var notFound *HeadersNotFoundError
+	if !errors.As(err, &notFound) {
+		t.Fatalf("expected headers not found error, got: %v", err)
+	}
+	if !reflect.DeepEqual(notFound.URLs, []string{baseURL + "/missing", baseURL + "/repo"}) {
+		t.Fatalf("unexpected tried urls: %v", notFound.URLs)
+	}
+}
+
+func TestRPMRepodataURLsPrimaryDB(t *testing.T) {
+	// Build a primary database holding a photon kernel, whose release has no architecture
+	dbPath := filepath.Join(t.TempDir(), "primary.sqlite")
+	db, err := sql.Open("sqlite", dbPath)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, stmt := range []string{
+		"CREATE TABLE packages (name TEXT, arch TEXT, version TEXT, release TEXT, location_href TEXT, location_base TEXT, checksum_type TEXT, pkgId TEXT)",
+		"INSERT INTO packages VALUES ('linux-devel', 'aarch64', '4.19.225', '3.ph3', 'aarch64/linux-devel-4.19.225-3.ph3.aarch64.rpm', NULL, 'sha256', '" + testKernelDevelDigest + "')",
+		"INSERT INTO packages VALUES ('linux-devel', 'x86_64', '4.19.225', '3.ph3', 'x86_64/linux-devel-4.19.225-3.ph3.x86_64.rpm', NULL, 'sha256', '" + testKernelDevelDigest + "')",
+	} {
+		if _, err = db.Exec(stmt); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if err = db.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	raw, err := os.ReadFile(dbPath)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	enc, err := zstd.NewWriter(nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	compressed := enc.EncodeAll(raw, nil)
+
+	srv := testFileServer(map[string][]byte{
+		"/photon/repodata/repomd.xml":         []byte(testRepomd("primary_db", "repodata/primary.sqlite.zst")),
+		"/photon/repodata/primary.sqlite.zst": compressed,
+	})
+	defer srv.Close()
+
+	kr := kernelrelease.FromString("4.19.225-3.ph3")
+	kr.Architecture = kernelrelease.ArchitectureAmd64
+	urls, err := rpmRepodataURLs(context.Background(), []rpmRepo{{URL: srv.URL + "/photon"}}, rpmKernelPackages{names: []string{"linux-devel"}, kr: kr})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []string{srv.URL + "/photon/x86_64/linux-devel-4.19.225-3.ph3.x86_64.rpm#sha256=" + testKernelDevelDigest}
+	if !reflect.DeepEqual(urls, expected) {
+		t.Fatalf("unexpected urls: got %v, want %v", urls, expected)
+	}
+}
+
+func TestRPMPointReleases(t *testing.T) {
+	srv := testFileServer(map[string][]byte{"/": []byte(`<html><body>
+<a href="../">../</a>
+<a href="6.10/">6.10/</a>
+<a href="8.4/">8.4/</a>
+<a href="8.9/">8.9/</a>
+<a href="8.10/">8.10/</a>
+<a href="8-stream/">8-stream/</a>
+<a href="RPM-GPG-KEY">RPM-GPG-KEY</a>
+</body></html>`)})
+	defer srv.Close()
+
+	tests := []struct {
+		major    string
+		minor    string
+		expected []string
+	}{
+		{major: "8", expected: []string{"8.10", "8.9", "8.4"}},
+		{major: "8", minor: "4", expected: []string{"8.4", "8.10", "8.9"}},
+		{major: "6", minor: "1", expected: []string{"6.10"}},
+		{major: "9"},
+	}
+	for _, test := range tests {
+		releases := rpmPointReleases(context.Background(), srv.URL, test.major, test.minor)
+		if !reflect.DeepEqual(releases, test.expected) {
+			t.Fatalf("unexpected point releases of %s.%s: got %v, want %v", test.major, test.minor, releases, test.expected)
+		}
+	}
+}
+
+func TestRPMTargetsKernelURLs(t *testing.T) {
+	for _, test := range []struct {
+		target        Type
+		kernelRelease string
+		repo          string
+		gcc           semver.Version
+	}{
+		{
+			// The point releases are listed from the mirror index
+			target:        TargetTypeAnolis,
+			kernelRelease: "5.10.134-13.an8.x86_64",
+			repo:          "/anolis/8.8/Plus/x86_64/os",
+			gcc:           semver.Version{Major: 8},
+		},
+		{
+			target:        TargetTypeOpenEuler,
+			kernelRelease: "5.10.0-136.12.0.86.oe2203sp1.x86_64",
+			repo:          "/openEuler-22.03-LTS-SP1/update/x86_64",
+			gcc:           semver.Version{Major: 10},
+		},
+	} {
+		version, release, _ := strings.Cut(strings.TrimSuffix(test.kernelRelease, ".x86_64"), "-")
+		primary := strings.NewReplacer("5.14.10", version, "300.fc35", release).Replace(testPrimaryXML)
+		files := testRPMRepoFiles(t, primary, test.repo)
+		files["/anolis/"] = []byte(`<a href="7.9/">7.9/</a> <a href="8/">8/</a> <a href="8.6/">8.6/</a> <a href="8.8/">8.8/</a>`)
+		srv := testFileServer(files)
+		ctx := WithMirrors(context.Background(), Mirrors{
+			TargetTypeAnolis.String():    {srv.URL + "/anolis"},
+			TargetTypeOpenEuler.String(): {srv.URL},
+		})
+
+		kr := kernelrelease.FromString(test.kernelRelease)
+		kr.Architecture = kernelrelease.ArchitectureAmd64
+		b := byTarget[test.target]
+		urls, err := b.URLs(ctx, kr)
+		srv.Close()
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", test.target, err)
+		}
+		expected := []string{srv.URL + test.repo + "/Packages/k/kernel-devel-" + test.kernelRelease + ".rpm#sha256=" + testKernelDevelDigest}
+		if !reflect.DeepEqual(urls, expected) {
+			t.Fatalf("%s: unexpected urls: got %v, want %v", test.target, urls, expected)
+		}
+		if gcc := b.(GCCVersionRequestor).GCCVersion(kr); !gcc.Equals(test.gcc) {
+			t.Fatalf("%s: unexpected gcc version: %s", test.target, gcc)
+		}
+	}
+}
@@ -0,0 +1,224 @@
+// SPDX-License-Identifier: Apache-2.0
+/*
+Copyright (C) 2023 The Diginfra Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package builder
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/diginfra/driverkit/pkg/httpclient"
+	"github.com/diginfra/driverkit/pkg/kernelrelease"
+)
+
+// URLStatus is the outcome of the HEAD request checking a kernel headers url,
+// or of the lookup of a local kernel headers package.
+type URLStatus struct {
+	URL string `json:"url"`
+	// Local tells whether the url points to a package on the local filesystem.
+	Local bool `json:"local,omitempty"`
+	// StatusCode is the HTTP status code of the response, if any.
+	StatusCode int `json:"statusCode,omitempty"`
+	// Error is the error preventing the request from completing, if any.
+	Error string `json:"error,omitempty"`
+}
+
+// Resolving tells whether the url can be reached.
+func (s *URLStatus) Resolving() bool {
+	if s.Local {
+		return s.Error == ""
+	}
+	return s.StatusCode == http.StatusOK
+}
+
+// LocalPath returns the absolute path of the given kernel headers url,
+// if it points to the local filesystem, either as a file:// url, as an absolute path,
+// or as a relative path existing on disk; anything else is left to the remote handling.
+// The checksum of the url, if any, is ignored.
+func LocalPath(u string) (string, bool) {
+	u, _, _ = SplitChecksum(u)
+	uu, err := url.Parse(u)
+	if err != nil {
+		return "", false
+	}
+	var p string
+	switch uu.Scheme {
+	case "file":
+		p = uu.Path
+	case "":
+		p = u
+		if _, err = os.Stat(p); !filepath.IsAbs(p) && err != nil {
+			return "", false
+		}
+	default:
+		return "", false
+	}
+	if p == "" {
+		return "", false
+	}
+	abs, err := filepath.Abs(p)
+	if err != nil {
+		return "", false
+	}
+	return abs, true
+}
+
+// KernelURLsResolution is the outcome of the kernel headers urls resolution for a kernel release.
+type KernelURLsResolution struct {
+	// Candidates are the urls checked during the resolution, in order, including the ones checked by the builder.
+	Candidates []URLStatus `json:"candidates"`
+	// URLs are the resolved kernel headers urls.
+	URLs []string `json:"urls"`
+	// MinimumURLs is the number of urls required by the builder.
+	MinimumURLs int `json:"minimumURLs"`
+}
+
+// urlsRecorder collects the status of the urls checked while resolving the kernel headers urls.
+type urlsRecorder struct {
+	mu       sync.Mutex
+	statuses []URLStatus
+	index    map[string]int
+}
+
+func (r *urlsRecorder) record(s URLStatus) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	// The same url may be checked by the builder, then by the resolution: keep the latest status
+	if i, ok := r.index[s.URL]; ok {
+		r.statuses[i] = s
+		return
+	}
+	r.index[s.URL] = len(r.statuses)
+	r.statuses = append(r.statuses, s)
+}
+
+type urlsRecorderContextKey struct{}
+
+func urlsRecorderFromContext(ctx context.Context) *urlsRecorder {
+	r, _ := ctx.Value(urlsRecorderContextKey{}).(*urlsRecorder)
+	return r
+}
+
+// CheckURLs issues a HEAD request for each of the given urls, concurrently, returning their status in order.
+// An error is returned only when ctx is done.
+func CheckURLs(ctx context.Context, urls []string) ([]URLStatus, error) {
+	statuses := make([]URLStatus, len(urls))
+	err := httpclient.Default().Probe(ctx, urls, func(i int, u string) {
+		statuses[i] = checkURL(ctx, u)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if recorder := urlsRecorderFromContext(ctx); recorder != nil {
+		for _, s := range statuses {
+			recorder.record(s)
+		}
+	}
+	return statuses, nil
+}
+
+func checkURL(ctx context.Context, u string) URLStatus {
+	// The checksum is verified on download, keep it attached to the url
+	u, checksum, err := SplitChecksum(u)
+	if err != nil {
+		return URLStatus{URL: u, Error: err.Error()}
+	}
+	s := doCheckURL(ctx, u)
+	s.URL = WithChecksum(s.URL, checksum)
+	return s
+}
+
+func doCheckURL(ctx context.Context, u string) URLStatus {
+	// Local packages allow offline builds: check that they exist
+	if p, ok := LocalPath(u); ok {
+		s := URLStatus{URL: "file://" + p, Local: true}
+		if info, err := os.Stat(p); err != nil {
+			s.Error = err.Error()
+		} else if !info.Mode().IsRegular() {
+			s.Error = fmt.Sprintf("%s is not a regular file", p)
+		}
+		return s
+	}
+	// in case url has some relative paths
+	// (kernel-crawler does not resolve them for us,
+	// neither it is expected, because they are effectively valid urls),
+	// resolve the absolute one.
+	// HEAD would fail otherwise.
+	resolved, err := resolveURLReference(u)
+	if err != nil {
+		return URLStatus{URL: u, Error: err.Error()}
+	}
+	res, err := httpclient.Default().Head(ctx, resolved)
+	if err != nil {
+		return URLStatus{URL: resolved, Error: err.Error()}
+	}
+	return URLStatus{URL: resolved, StatusCode: res.StatusCode}
+}
+
+// ResolveKernelURLs resolves the kernel headers urls of the given kernel release through the builder,
+// or checks the given kernelurls, if not nil.
+// The returned resolution is filled as far as possible also when an error is returned.
+func ResolveKernelURLs(ctx context.Context, b Builder, kernelurls []string, kr kernelrelease.KernelRelease) (*KernelURLsResolution, error) {
+	recorder := &urlsRecorder{statuses: []URLStatus{}, index: make(map[string]int)}
+	ctx = context.WithValue(ctx, urlsRecorderContextKey{}, recorder)
+	res := &KernelURLsResolution{MinimumURLs: 1}
+	err := resolveKernelURLs(ctx, b, kernelurls, kr, res)
+	res.Candidates = recorder.statuses
+	if res.URLs == nil {
+		res.URLs = []string{}
+	}
+	return res, err
+}
+
+func resolveKernelURLs(ctx context.Context, b Builder, kernelurls []string, kr kernelrelease.KernelRelease, res *KernelURLsResolution) error {
+	if bb, ok := b.(MinimumURLsBuilder); ok {
+		res.MinimumURLs = bb.MinimumURLs()
+	}
+
+	if bb, ok := b.(KernelInfoLoader); ok {
+		if err := bb.LoadKernelInfo(ctx, kr); err != nil {
+			return err
+		}
+	}
+
+	var err error
+	if kernelurls == nil {
+		kernelurls, err = b.URLs(ctx, kr)
+		if err != nil {
+			return err
+		}
+		// Only if returned urls array is not empty
+		// Otherwise, it is up to the builder to return an error
+		if len(kernelurls) == 0 {
+			kernelurls = nil
+		}
+	}
+	if kernelurls != nil {
+		// Check (and filter) existing kernels before continuing
+		res.URLs, err = GetResolvingURLs(ctx, kernelurls)
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(res.URLs) < res.MinimumURLs {
+		return fmt.Errorf("%w: not enough headers packages found; expected %d, found %d", HeadersNotFoundErr, res.MinimumURLs, len(res.URLs))
+	}
+	return nil
+}
@@ -15,6 +15,7 @@ limitations under the License.
 package builder
 
 import (
+	"context"
 	_ "embed"
 	"fmt"
 
@@ -54,8 +55,12 @@ func (c *rocky) TemplateScript() string {
 	return rockyTemplate
 }
 
-func (c *rocky) URLs(kr kernelrelease.KernelRelease) ([]string, error) {
-	return fetchRockyKernelURLS(kr), nil
+func (c *rocky) DefaultMirrors(_ kernelrelease.KernelRelease) []string {
+	return []string{"https://download.rockylinux.org"}
+}
+
+func (c *rocky) URLs(ctx context.Context, kr kernelrelease.KernelRelease) ([]string, error) {
+	return fetchRockyKernelURLS(ctx, mirrorBaseURLs(ctx, TargetTypeRocky.String(), c.DefaultMirrors(kr)...), kr)
 }
 
 func (c *rocky) KernelTemplateData(_ kernelrelease.KernelRelease, urls []string) interface{} {
@@ -64,60 +69,30 @@ func (c *rocky) KernelTemplateData(_ kernelrelease.KernelRelease, urls []string)
 	}
 }
 
-func fetchRockyKernelURLS(kr kernelrelease.KernelRelease) []string {
-	rockyReleases := []string{
-		"8",
-		"8.7",
-		"9",
-		"9.1",
+func fetchRockyKernelURLS(ctx context.Context, mirrors []string, kr kernelrelease.KernelRelease) ([]string, error) {
+	major, minor, ok := rpmELRelease(kr)
+	if !ok {
+		return nil, fmt.Errorf("%w: no rocky release in %s", HeadersNotFoundErr, kr.String())
 	}
-
-	rockyVaultReleases := []string{
-		"8.3",
-		"8.4",
-		"8.5",
-		"8.6",
-		"9.1",
+	// kernel-devel moved from BaseOS to AppStream with rocky 9
+	repo := "BaseOS"
+	if compareReleases(major, "9") >= 0 {
+		repo = "AppStream"
 	}
 
-	urls := []string{}
-	for _, r := range rockyReleases {
-		if r >= "9" {
-			urls = append(urls, fmt.Sprintf(
-				"https://download.rockylinux.org/pub/rocky/%s/AppStream/%s/os/Packages/k/kernel-devel-%s%s.rpm",
-				r,
-				kr.Architecture.ToNonDeb(),
-				kr.Fullversion,
-				kr.FullExtraversion,
-			))
-		} else {
-			urls = append(urls, fmt.Sprintf(
-				"https://download.rockylinux.org/pub/rocky/%s/BaseOS/%s/os/Packages/k/kernel-devel-%s%s.rpm",
-				r,
-				kr.Architecture.ToNonDeb(),
-				kr.Fullversion,
-				kr.FullExtraversion,
-			))
-		}
-	}
-	for _, r := range rockyVaultReleases {
-		if r >= "9" {
-			urls = append(urls, fmt.Sprintf(
-				"https://download.rockylinux.org/vault/rocky/%s/AppStream/%s/os/Packages/k/kernel-devel-%s%s.rpm",
-				r,
-				kr.Architecture.ToNonDeb(),
-				kr.Fullversion,
-				kr.FullExtraversion,
-			))
-		} else {
-			urls = append(urls, fmt.Sprintf(
-				"https://download.rockylinux.org/vault/rocky/%s/BaseOS/%s/os/Packages/k/kernel-devel-%s%s.rpm",
-				r,
-				kr.Architecture.ToNonDeb(),
-				kr.Fullversion,
-				kr.FullExtraversion,
-			))
+	var repos []rpmRepo
+	for _, mirror := range mirrors {
+		// The latest point release is published under the major one, the older ones are moved to the vault
+		for _, dir := range []string{"pub/rocky", "vault/rocky"} {
+			base := mirror + "/" + dir
+			releases := rpmPointReleases(ctx, base, major, minor)
+			if dir == "pub/rocky" {
+				releases = append([]string{major}, releases...)
+			}
+			for _, r := range releases {
+				repos = append(repos, rpmRepo{URL: fmt.Sprintf("%s/%s/%s/%s/os", base, r, repo, kr.Architecture.ToNonDeb())})
+			}
 		}
 	}
-	return urls
+	return rpmRepodataURLs(ctx, repos, rpmKernelPackages{names: []string{"kernel-devel"}, kr: kr})
 }
@@ -15,6 +15,7 @@ limitations under the License.
 package builder
 
 import (
+	"context"
 	_ "embed"
 
 	"github.com/diginfra/driverkit/pkg/kernelrelease"
@@ -53,7 +54,7 @@ func (v *sles) TemplateScript() string {
 	return slesTemplate
 }
 
-func (v *sles) URLs(_ kernelrelease.KernelRelease) ([]string, error) {
+func (v *sles) URLs(_ context.Context, _ kernelrelease.KernelRelease) ([]string, error) {
 	return nil, nil
 }
 
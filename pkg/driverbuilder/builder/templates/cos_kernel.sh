@@ -0,0 +1,42 @@
+#!/bin/bash
+# SPDX-License-Identifier: Apache-2.0
+#
+# Copyright (C) 2023 The Diginfra Authors.
+#
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#     http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+#
+# Fetch the kernel headers and the toolchain published by Container-Optimized OS
+# for the build, so that the module is built the same way as the kernel.
+#
+set -xeuo pipefail
+
+# Fetch the kernel headers
+rm -Rf /tmp/kernel-download /tmp/kernel
+mkdir -p /tmp/kernel-download /tmp/kernel
+cd /tmp/kernel-download
+curl --silent -o kernel-headers.tgz -SL {{ .KernelDownloadURL }}
+tar -xzf kernel-headers.tgz -C /tmp/kernel-download
+
+# The headers are extracted under usr/src/linux-headers-<kernelrelease>
+KERNEL_HEADERS_DIR=$(find /tmp/kernel-download -mindepth 1 -maxdepth 4 -type d -name 'linux-headers-*' | head -n 1)
+cp -a "${KERNEL_HEADERS_DIR}"/. /tmp/kernel/
+{{ if .ToolchainDownloadURL }}
+# Fetch the toolchain the kernel was built with
+rm -Rf {{ .ToolchainDir }}
+mkdir -p {{ .ToolchainDir }}
+curl --silent -SL {{ .ToolchainDownloadURL }} | tar -Jxf - -C {{ .ToolchainDir }}
+export COS_MAKE_FLAGS="{{ .MakeFlags }}"
+{{ end }}
+# exit value
+export KERNELDIR=/tmp/kernel
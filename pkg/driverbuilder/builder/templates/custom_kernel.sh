@@ -0,0 +1,63 @@
+#!/bin/bash
+# SPDX-License-Identifier: Apache-2.0
+#
+# Copyright (C) 2023 The Diginfra Authors.
+#
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#     http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+#
+# Simple script that desperately tries to load the kernel instrumentation by
+# looking for it in a bunch of ways. Convenient when running Diginfra inside
+# a container or in other weird environments.
+#
+set -xeuo pipefail
+
+# Fetch the kernel
+mkdir /tmp/kernel-download
+cd /tmp/kernel-download
+{{ range $i, $url := .KernelDownloadURLs }}
+curl --silent -o kernel-{{ $i }}.pkg -SL {{ $url }}
+{{- if eq $.PackageFormat "rpm" }}
+rpm2cpio kernel-{{ $i }}.pkg | cpio --extract --make-directories
+{{- else if eq $.PackageFormat "deb" }}
+ar x kernel-{{ $i }}.pkg
+tar -xf data.tar.*
+rm -f data.tar.* control.tar.* debian-binary
+{{- else if eq $.PackageFormat "apk" }}
+# APKs concatenate the gzipped signature, control and data tarballs
+tar --warning=no-unknown-keyword --ignore-zeros -xzf kernel-{{ $i }}.pkg
+{{- else if eq $.PackageFormat "tar.gz" }}
+tar -xzf kernel-{{ $i }}.pkg
+{{- else }}
+tar -xJf kernel-{{ $i }}.pkg
+{{- end }}
+rm -f kernel-{{ $i }}.pkg
+{{ end }}
+
+# Prefer the headers directory holding the kernel config, e.g. over the common headers of debs
+sourcedir=$(ls -d {{ .HeadersDir }} | head -n 1)
+for dir in {{ .HeadersDir }}; do
+  if [ -f "$dir/.config" ]; then
+    sourcedir=$dir
+    break
+  fi
+done
+sourcedir=$(readlink -f "$sourcedir")
+
+{{ if eq .PackageFormat "deb" }}
+# Patch makefile to avoid using absolute `/usr/src` path; instead use `..` relative one.
+sed -i 's/\/usr\/src/../g' $sourcedir/Makefile
+{{ end }}
+
+# exit value
+export KERNELDIR=$sourcedir
@@ -0,0 +1,10 @@
+# Download the kernel headers packages with a known checksum,
+# and verify them before they are extracted.
+rm -Rf "{{ .DownloadDir }}"
+mkdir -p "{{ .DownloadDir }}"
+{{ range $pkg := .Packages }}
+{{- if $pkg.URL }}
+curl --silent -o "{{ $pkg.Path }}" -SL "{{ $pkg.URL }}"
+{{- end }}
+echo "{{ $pkg.Digest }}  {{ $pkg.Path }}" | {{ $pkg.Command }} -c - >&2
+{{ end }}
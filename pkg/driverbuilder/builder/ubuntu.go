@@ -15,7 +15,9 @@ limitations under the License.
 package builder
 
 import (
+	"context"
 	_ "embed"
+	"errors"
 	"fmt"
 	"regexp"
 	"strings"
@@ -61,8 +63,8 @@ func (v *ubuntu) TemplateScript() string {
 	return ubuntuTemplate
 }
 
-func (v *ubuntu) URLs(kr kernelrelease.KernelRelease) ([]string, error) {
-	return ubuntuHeadersURLFromRelease(kr)
+func (v *ubuntu) URLs(ctx context.Context, kr kernelrelease.KernelRelease) ([]string, error) {
+	return ubuntuHeadersURLFromRelease(ctx, kr)
 }
 
 func (v *ubuntu) MinimumURLs() int {
@@ -91,38 +93,192 @@ func (v *ubuntu) KernelTemplateData(kr kernelrelease.KernelRelease, urls []strin
 	}
 }
 
-func ubuntuHeadersURLFromRelease(kr kernelrelease.KernelRelease) ([]string, error) {
-	// decide which mirrors to use based on the architecture passed in
-	baseURLs := []string{}
+func (v *ubuntu) DefaultMirrors(kr kernelrelease.KernelRelease) []string {
+	return ubuntuDefaultMirrors(kr)
+}
+
+// ubuntuDefaultMirrors returns the Ubuntu archive base urls for the architecture of the kernel release.
+func ubuntuDefaultMirrors(kr kernelrelease.KernelRelease) []string {
 	if kr.Architecture.String() == kernelrelease.ArchitectureAmd64 {
-		baseURLs = []string{
-			"https://mirrors.edge.kernel.org/ubuntu/pool/main/l",
-			"http://security.ubuntu.com/ubuntu/pool/main/l",
+		return []string{
+			"https://mirrors.edge.kernel.org/ubuntu",
+			"http://security.ubuntu.com/ubuntu",
 		}
-	} else {
-		baseURLs = []string{
-			// arm64 and others are hosted on ports.ubuntu.com
-			// but they will resolve for amd64 without this if logic
-			"http://ports.ubuntu.com/ubuntu-ports/pool/main/l",
+	}
+	// arm64 and others are hosted on ports.ubuntu.com
+	// but they will resolve for amd64 without this if logic
+	return []string{
+		"http://ports.ubuntu.com/ubuntu-ports",
+	}
+}
+
+// ubuntuSeries are the Ubuntu series whose Packages indexes are looked up first for the kernel headers, newest first,
+// and the ones looked up when the series published by an archive cannot be listed.
+var ubuntuSeries = []string{"noble", "jammy", "focal", "bionic", "xenial"}
+
+// ubuntuSeriesRegex matches the series directories of an archive, e.g. jammy, but not jammy-updates.
+var ubuntuSeriesRegex = regexp.MustCompile(`^[a-z]+$`)
+
+// ubuntuDefaultKernelVersion is the default kernelversion: the headers of other series are only looked up
+// when a different one was given.
+const ubuntuDefaultKernelVersion = "1"
+
+// ubuntuPockets are the pockets of each series holding kernel headers; security ones are also hosted by security.ubuntu.com.
+var ubuntuPockets = []string{"-updates", "-security", ""}
+
+// ubuntuComponents are the archive components holding kernel headers.
+var ubuntuComponents = []string{"main", "restricted", "universe"}
+
+func ubuntuHeadersURLFromRelease(ctx context.Context, kr kernelrelease.KernelRelease) ([]string, error) {
+	// use the configured mirrors, falling back to the ones for the architecture passed in
+	mirrors := mirrorBaseURLs(ctx, TargetTypeUbuntu.String(), ubuntuDefaultMirrors(kr)...)
+
+	// Look the exact packages up in the archive indexes first
+	for _, mirror := range mirrors {
+		urls, err := ubuntuHeadersURLFromPackages(ctx, mirror, kr)
+		if err == nil {
+			return urls, nil
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
 		}
 	}
 
-	for _, url := range baseURLs {
+	// Then fall back to guessing the packages urls, e.g. for archives without indexes
+	var triedURLs []string
+	for _, mirror := range mirrors {
 		// get all possible URLs
-		possibleURLs, err := fetchUbuntuKernelURL(url, kr)
+		possibleURLs, err := fetchUbuntuKernelURL(mirror+"/pool/main/l", kr)
 		if err != nil {
 			return nil, err
 		}
+		triedURLs = append(triedURLs, possibleURLs...)
 		// try resolving the URLs
-		urls, err := GetResolvingURLs(possibleURLs)
+		urls, err := GetResolvingURLs(ctx, possibleURLs)
 		// there should be 2 urls returned - the _all.deb package and the _{arch}.deb package
 		if err == nil && len(urls) == ubuntuRequiredURLs {
 			return urls, err
 		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
 	}
 
 	// packages weren't found, return error out
-	return nil, fmt.Errorf("kernel headers not found")
+	return nil, &HeadersNotFoundError{URLs: triedURLs}
+}
+
+// ubuntuHeadersURLFromPackages looks the linux-headers-<kernelrelease> package up in the Packages indexes of the archive at mirror,
+// together with the linux-*-headers package it depends on, holding the headers shared by the flavors.
+// Series are looked up in order, stopping as soon as a series holds the packages with the version matching the kernel version;
+// when none matches, the packages of the first series holding them are returned.
+func ubuntuHeadersURLFromPackages(ctx context.Context, mirror string, kr kernelrelease.KernelRelease) ([]string, error) {
+	firstExtra, _ := parseUbuntuExtraVersion(kr.Extraversion)
+	abi := fmt.Sprintf("%s-%s", kr.Fullversion, firstExtra)
+	headersName := fmt.Sprintf("linux-headers-%s%s", kr.Fullversion, kr.FullExtraversion)
+	// e.g. linux-headers-5.15.0-1, linux-aws-headers-5.15.0-1020, linux-hwe-6.8-headers-6.8.0-45
+	commonRegex := regexp.MustCompile(`^linux-(.+-)?headers-` + regexp.QuoteMeta(abi) + `$`)
+	expectedVersion := fmt.Sprintf("%s.%s", abi, kr.KernelVersion)
+	match := func(name string) bool {
+		return name == headersName || commonRegex.MatchString(name)
+	}
+
+	var (
+		found    []string
+		indexErr error
+	)
+	for _, series := range ubuntuArchiveSeries(ctx, mirror) {
+		var pkgs []debPackage
+		for _, pocket := range ubuntuPockets {
+			for _, component := range ubuntuComponents {
+				res, err := fetchDebPackages(ctx, mirror, series+pocket, component, kr.Architecture.String(), "linux-")
+				if errors.Is(err, errDebIndexNotFound) {
+					indexErr = err
+					continue
+				}
+				if err != nil {
+					return nil, err
+				}
+				for _, pkg := range res {
+					if match(pkg.Name) {
+						pkgs = append(pkgs, pkg)
+					}
+				}
+				if urls, version := ubuntuHeadersPackagesURLs(mirror, pkgs, headersName, commonRegex); version == expectedVersion {
+					return urls, nil
+				}
+			}
+		}
+		if found == nil {
+			found, _ = ubuntuHeadersPackagesURLs(mirror, pkgs, headersName, commonRegex)
+		}
+		if found != nil && kr.KernelVersion == ubuntuDefaultKernelVersion {
+			return found, nil
+		}
+	}
+	if found != nil {
+		return found, nil
+	}
+	if indexErr != nil {
+		return nil, fmt.Errorf("%w: %s not found in the packages indexes of %s (%v)", HeadersNotFoundErr, headersName, mirror, indexErr)
+	}
+	return nil, fmt.Errorf("%w: %s not found in the packages indexes of %s", HeadersNotFoundErr, headersName, mirror)
+}
+
+// ubuntuArchiveSeries returns the series published by the archive at mirror, the ones of ubuntuSeries first.
+// ubuntuSeries is returned when the series of the archive cannot be listed.
+func ubuntuArchiveSeries(ctx context.Context, mirror string) []string {
+	dirs, err := listReleaseDirs(ctx, mirror+"/dists", ubuntuSeriesRegex)
+	if err != nil || len(dirs) == 0 {
+		return ubuntuSeries
+	}
+	published := make(map[string]bool, len(dirs))
+	for _, dir := range dirs {
+		published[dir] = true
+	}
+	var res []string
+	for _, series := range ubuntuSeries {
+		if published[series] {
+			res = append(res, series)
+			delete(published, series)
+		}
+	}
+	for _, dir := range dirs {
+		// devel is an alias of the series under development
+		if published[dir] && dir != "devel" {
+			res = append(res, dir)
+		}
+	}
+	return res
+}
+
+// ubuntuHeadersPackagesURLs returns the urls of the headers package and of the common headers package it depends on,
+// among the given ones, together with the version of the headers package.
+func ubuntuHeadersPackagesURLs(mirror string, pkgs []debPackage, headersName string, commonRegex *regexp.Regexp) ([]string, string) {
+	for _, headers := range pkgs {
+		if headers.Name != headersName {
+			continue
+		}
+		for _, dep := range headers.Depends {
+			if !commonRegex.MatchString(dep) {
+				continue
+			}
+			// Prefer the common package built from the same source version
+			var common *debPackage
+			for i := range pkgs {
+				if pkgs[i].Name != dep {
+					continue
+				}
+				if common == nil || pkgs[i].Version == headers.Version {
+					common = &pkgs[i]
+				}
+			}
+			if common != nil {
+				return []string{headers.url(mirror), common.url(mirror)}, headers.Version
+			}
+		}
+	}
+	return nil, ""
 }
 
 func fetchUbuntuKernelURL(baseURL string, kr kernelrelease.KernelRelease) ([]string, error) {
@@ -15,10 +15,18 @@ limitations under the License.
 package builder
 
 import (
-	"fmt"
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
 	"testing"
 
 	"github.com/blang/semver"
+	"github.com/ulikunitz/xz"
 
 	"github.com/diginfra/driverkit/pkg/kernelrelease"
 )
@@ -62,7 +70,7 @@ var tests = []struct {
 			},
 			firstExtra: "188",
 			flavor:     "generic",
-			err:        fmt.Errorf("kernel headers not found"),
+			err:        HeadersNotFoundErr,
 		},
 	},
 	{
@@ -155,7 +163,7 @@ var tests = []struct {
 			},
 			firstExtra: "24",
 			flavor:     "lowlatency-hwe",
-			err:        fmt.Errorf("kernel headers not found"),
+			err:        HeadersNotFoundErr,
 		},
 	},
 	{
@@ -278,11 +286,9 @@ func TestUbuntuHeadersURLFromRelease(t *testing.T) {
 		expected := test.expected.headersURLs
 
 		// call function
-		gotURLs, err := ubuntuHeadersURLFromRelease(test.config)
+		gotURLs, err := ubuntuHeadersURLFromRelease(context.Background(), test.config)
 		// compare errors
-		// there are no official errors, so comparing fmt.Errorf() doesn't really work
-		// compare error message text instead
-		if err != nil && test.expected.err != nil && err.Error() != test.expected.err.Error() {
+		if err != nil && test.expected.err != nil && !errors.Is(err, test.expected.err) {
 			t.Fatalf("Unexpected error encountered with Test Input: '%v' | Error: '%s'", test.config, err)
 		}
 
@@ -291,15 +297,169 @@ func TestUbuntuHeadersURLFromRelease(t *testing.T) {
 			t.Fatalf("Slice sizes don't match! Test Input: '%v' | Got: '%v' / Want: '%v'", test.config, gotURLs, expected)
 		}
 
-		// check values are exact match
+		// check values are exact match, ignoring the checksums published by the archive indexes
 		for i, v := range gotURLs {
-			if v != expected[i] {
+			if v, _, _ = SplitChecksum(v); v != expected[i] {
 				t.Fatalf("Slice values don't match! Test Input: '%v' | Got: '%v' / Want: '%v'", test.config, gotURLs, expected)
 			}
 		}
 	}
 }
 
+func TestUbuntuHeadersURLFromPackages(t *testing.T) {
+	indexes := map[string]string{
+		"/ubuntu/dists/noble/main/binary-amd64/Packages.gz": `Package: linux-headers-5.15.0-1
+Architecture: all
+Version: 5.15.0-1.2
+Filename: pool/main/l/linux/linux-headers-5.15.0-1_5.15.0-1.2_all.deb
+SHA256: 5555555555555555555555555555555555555555555555555555555555555555
+Description: Header files related to Linux kernel version 5.15.0
+ This package provides kernel header files for version 5.15.0.
+
+Package: linux-headers-5.15.0-1-generic
+Architecture: amd64
+Version: 5.15.0-1.2
+Depends: linux-headers-5.15.0-1, libc6 (>= 2.34), libelf1 (>= 0.142)
+Filename: pool/main/l/linux/linux-headers-5.15.0-1-generic_5.15.0-1.2_amd64.deb
+SHA256: 6666666666666666666666666666666666666666666666666666666666666666
+`,
+		"/ubuntu/dists/jammy/main/binary-amd64/Packages.gz": `Package: linux-headers-5.15.0-1
+Architecture: all
+Version: 5.15.0-1.3
+Filename: pool/main/l/linux/linux-headers-5.15.0-1_5.15.0-1.3_all.deb
+SHA256: 1111111111111111111111111111111111111111111111111111111111111111
+Description: Header files related to Linux kernel version 5.15.0
+ This package provides kernel header files for version 5.15.0.
+
+Package: linux-headers-5.15.0-1-generic
+Architecture: amd64
+Version: 5.15.0-1.3
+Depends: linux-headers-5.15.0-1, libc6 (>= 2.34), libelf1 (>= 0.142)
+Filename: pool/main/l/linux/linux-headers-5.15.0-1-generic_5.15.0-1.3_amd64.deb
+SHA256: 2222222222222222222222222222222222222222222222222222222222222222
+`,
+		"/ubuntu/dists/jammy-updates/restricted/binary-amd64/Packages.xz": `Package: linux-nvidia-headers-5.15.0-1020
+Architecture: all
+Version: 5.15.0-1020.20
+Filename: pool/restricted/l/linux-nvidia/linux-nvidia-headers-5.15.0-1020_5.15.0-1020.20_all.deb
+SHA256: 3333333333333333333333333333333333333333333333333333333333333333
+
+Package: linux-headers-5.15.0-1020-nvidia
+Architecture: amd64
+Version: 5.15.0-1020.20
+Depends: libc6 (>= 2.34), linux-nvidia-headers-5.15.0-1020
+Filename: pool/restricted/l/linux-nvidia/linux-headers-5.15.0-1020-nvidia_5.15.0-1020.20_amd64.deb
+SHA256: 4444444444444444444444444444444444444444444444444444444444444444
+`,
+	}
+	files := map[string][]byte{
+		"/ubuntu/dists/": []byte(`<a href="devel/">devel/</a> <a href="jammy/">jammy/</a> <a href="noble/">noble/</a> <a href="jammy-updates/">jammy-updates/</a>`),
+	}
+	for path, index := range indexes {
+		if !strings.HasSuffix(path, ".xz") {
+			files[path] = gzipped(t, []byte(index))
+			continue
+		}
+		var buf bytes.Buffer
+		w, err := xz.NewWriter(&buf)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		_, _ = w.Write([]byte(index))
+		if err = w.Close(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		files[path] = buf.Bytes()
+	}
+	var mu sync.Mutex
+	requests := make(map[string]int)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests[r.URL.Path]++
+		mu.Unlock()
+		testFilesHandler(files)(w, r)
+	}))
+	defer srv.Close()
+	mirror := srv.URL + "/ubuntu"
+
+	for _, test := range []struct {
+		kernelRelease string
+		kernelVersion string
+		expected      []string
+	}{
+		{
+			// The first series shipping the kernel release, when the kernel version is the default one
+			kernelRelease: "5.15.0-1-generic",
+			kernelVersion: "1",
+			expected: []string{
+				mirror + "/pool/main/l/linux/linux-headers-5.15.0-1-generic_5.15.0-1.2_amd64.deb#sha256=" + strings.Repeat("6", 64),
+				mirror + "/pool/main/l/linux/linux-headers-5.15.0-1_5.15.0-1.2_all.deb#sha256=" + strings.Repeat("5", 64),
+			},
+		},
+		{
+			// The series shipping the kernel version, otherwise
+			kernelRelease: "5.15.0-1-generic",
+			kernelVersion: "3",
+			expected: []string{
+				mirror + "/pool/main/l/linux/linux-headers-5.15.0-1-generic_5.15.0-1.3_amd64.deb#sha256=" + strings.Repeat("2", 64),
+				mirror + "/pool/main/l/linux/linux-headers-5.15.0-1_5.15.0-1.3_all.deb#sha256=" + strings.Repeat("1", 64),
+			},
+		},
+		{
+			// Flavors not fitting the url patterns, found whatever the kernel version
+			kernelRelease: "5.15.0-1020-nvidia",
+			kernelVersion: "1",
+			expected: []string{
+				mirror + "/pool/restricted/l/linux-nvidia/linux-headers-5.15.0-1020-nvidia_5.15.0-1020.20_amd64.deb#sha256=" + strings.Repeat("4", 64),
+				mirror + "/pool/restricted/l/linux-nvidia/linux-nvidia-headers-5.15.0-1020_5.15.0-1020.20_all.deb#sha256=" + strings.Repeat("3", 64),
+			},
+		},
+	} {
+		kr := kernelrelease.FromString(test.kernelRelease)
+		kr.Architecture = kernelrelease.ArchitectureAmd64
+		kr.KernelVersion = test.kernelVersion
+		urls, err := ubuntuHeadersURLFromPackages(context.Background(), mirror, kr)
+		if err != nil {
+			t.Fatalf("unexpected error for %s: %v", test.kernelRelease, err)
+		}
+		if !reflect.DeepEqual(urls, test.expected) {
+			t.Fatalf("unexpected urls for %s (%s): got %v, want %v", test.kernelRelease, test.kernelVersion, urls, test.expected)
+		}
+		if test.kernelVersion == "1" && test.kernelRelease == "5.15.0-1-generic" {
+			mu.Lock()
+			for path := range requests {
+				if strings.Contains(path, "/jammy") {
+					mu.Unlock()
+					t.Fatalf("unexpected request for a series after the one shipping the kernel release: %s", path)
+				}
+			}
+			mu.Unlock()
+		}
+	}
+
+	kr := kernelrelease.FromString("5.15.0-2-generic")
+	kr.Architecture = kernelrelease.ArchitectureAmd64
+	kr.KernelVersion = "1"
+	if _, err := ubuntuHeadersURLFromPackages(context.Background(), mirror, kr); !errors.Is(err, HeadersNotFoundErr) {
+		t.Fatalf("expected headers not found error, got %v", err)
+	}
+
+	// Only the series published by the archive are looked up, and each index is downloaded once
+	mu.Lock()
+	defer mu.Unlock()
+	for path, count := range requests {
+		if strings.Contains(path, "/focal") {
+			t.Fatalf("unexpected request for a series not published by the archive: %s", path)
+		}
+		if count > 1 && path != "/ubuntu/dists/" {
+			t.Fatalf("index %s downloaded %d times", path, count)
+		}
+	}
+	if requests["/ubuntu/dists/jammy/main/binary-amd64/Packages.xz"] != 1 {
+		t.Fatalf("expected the xz index to be tried first, got requests %v", requests)
+	}
+}
+
 func TestFetchUbuntuKernelURL(t *testing.T) {
 	for _, test := range tests {
 
@@ -15,6 +15,7 @@ limitations under the License.
 package builder
 
 import (
+	"context"
 	_ "embed"
 	"fmt"
 	"strings"
@@ -57,7 +58,7 @@ func (v *vanilla) TemplateScript() string {
 	return vanillaTemplate
 }
 
-func (v *vanilla) URLs(kr kernelrelease.KernelRelease) ([]string, error) {
+func (v *vanilla) URLs(_ context.Context, kr kernelrelease.KernelRelease) ([]string, error) {
 	return []string{fetchVanillaKernelURLFromKernelVersion(kr)}, nil
 }
 
@@ -15,10 +15,14 @@ limitations under the License.
 package driverbuilder
 
 import (
+	"context"
+
 	"github.com/diginfra/driverkit/pkg/driverbuilder/builder"
 )
 
 type BuildProcessor interface {
-	Start(b *builder.Build) error
+	// Start runs the build, returning its result also when the build fails.
+	// Canceling ctx aborts the build.
+	Start(ctx context.Context, b *builder.Build) (*BuildResult, error)
 	String() string
 }
@@ -0,0 +1,329 @@
+// SPDX-License-Identifier: Apache-2.0
+/*
+Copyright (C) 2023 The Diginfra Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driverbuilder
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/diginfra/driverkit/pkg/driverbuilder/builder"
+)
+
+const (
+	cacheModuleFile   = "module.ko"
+	cacheProbeFile    = "probe.o"
+	cacheMetadataFile = "build.json"
+	// mutableDriverVersion is a branch: same inputs can lead to different drivers.
+	mutableDriverVersion = "master"
+)
+
+// CacheKeyData contains all the build inputs that identify the built drivers.
+type CacheKeyData struct {
+	Target           string `json:"target"`
+	KernelRelease    string `json:"kernelrelease"`
+	KernelVersion    string `json:"kernelversion"`
+	Architecture     string `json:"architecture"`
+	DriverVersion    string `json:"driverversion"`
+	RepoOrg          string `json:"repoorg"`
+	RepoName         string `json:"reponame"`
+	GCCVersion       string `json:"gccversion"`
+	BuilderImage     string `json:"builderimage"`
+	KernelConfigData string `json:"kernelconfigdata"`
+	ModuleDriverName string `json:"moduledrivername"`
+	ModuleDeviceName string `json:"moduledevicename"`
+}
+
+// Key returns the content address of the key data.
+func (k *CacheKeyData) Key() (string, error) {
+	data, err := json.Marshal(k)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// CacheEntry is a set of drivers stored in the build cache.
+type CacheEntry struct {
+	Key     string       `json:"key"`
+	Build   CacheKeyData `json:"build"`
+	Module  bool         `json:"module"`
+	Probe   bool         `json:"probe"`
+	Created time.Time    `json:"created"`
+	Size    int64        `json:"-"`
+}
+
+// CachedBuildProcessor wraps a BuildProcessor, skipping the build
+// when the requested drivers are already available in the cache directory.
+type CachedBuildProcessor struct {
+	BuildProcessor
+	dir string
+}
+
+// NewCachedBuildProcessor wraps the given BuildProcessor with a build cache stored in dir.
+func NewCachedBuildProcessor(processor BuildProcessor, dir string) *CachedBuildProcessor {
+	return &CachedBuildProcessor{
+		BuildProcessor: processor,
+		dir:            dir,
+	}
+}
+
+func (cp *CachedBuildProcessor) Start(ctx context.Context, b *builder.Build) (*BuildResult, error) {
+	if b.DriverVersion == mutableDriverVersion {
+		b.Logger.Info("skipping build cache for mutable driver version",
+			b.Logger.Args("driverversion", b.DriverVersion))
+		return cp.BuildProcessor.Start(ctx, b)
+	}
+
+	res := NewBuildResult(cp.BuildProcessor.String(), b)
+	stageStart := time.Now()
+	keyData, err := cp.keyData(ctx, b)
+	if err != nil {
+		return res.Finish(b, err)
+	}
+	key, err := keyData.Key()
+	if err != nil {
+		return res.Finish(b, err)
+	}
+	entryDir := filepath.Join(cp.dir, key)
+
+	if cp.lookup(b, entryDir) {
+		b.Logger.Info("drivers found in build cache, skipping build",
+			b.Logger.Args("key", key))
+		res.Cached = true
+		res.BuilderImage = keyData.BuilderImage
+		res.GCCVersion = keyData.GCCVersion
+		res.StageDone(StagePrepare, stageStart)
+		stageStart = time.Now()
+		if len(b.ModuleFilePath) > 0 {
+			if err = copyDataToLocalPath(filepath.Join(entryDir, cacheModuleFile), b.ModuleFilePath); err != nil {
+				return res.Finish(b, err)
+			}
+			b.Logger.Info("kernel module available", b.Logger.Args("path", b.ModuleFilePath))
+		}
+		if len(b.ProbeFilePath) > 0 {
+			if err = copyDataToLocalPath(filepath.Join(entryDir, cacheProbeFile), b.ProbeFilePath); err != nil {
+				return res.Finish(b, err)
+			}
+			b.Logger.Info("eBPF probe available", b.Logger.Args("path", b.ProbeFilePath))
+		}
+		res.StageDone(StageCopy, stageStart)
+		return res.Finish(b, nil)
+	}
+
+	b.Logger.Debug("drivers not found in build cache", b.Logger.Args("key", key))
+	// Some processors reset the paths of the drivers while building them
+	modulePath, probePath := b.ModuleFilePath, b.ProbeFilePath
+	res, err = cp.BuildProcessor.Start(ctx, b)
+	if err != nil {
+		return res, err
+	}
+
+	// A failure while storing the drivers must not fail the build.
+	if err = cp.store(b, keyData, key, entryDir, modulePath, probePath); err != nil {
+		b.Logger.Warn("error storing drivers into build cache",
+			b.Logger.Args("key", key, "err", err.Error()))
+	}
+	return res, nil
+}
+
+func (cp *CachedBuildProcessor) keyData(ctx context.Context, b *builder.Build) (*CacheKeyData, error) {
+	keyData := &CacheKeyData{
+		Target:           b.TargetType.String(),
+		KernelRelease:    b.KernelRelease,
+		KernelVersion:    b.KernelVersion,
+		Architecture:     b.Architecture,
+		DriverVersion:    b.DriverVersion,
+		RepoOrg:          b.RepoOrg,
+		RepoName:         b.RepoName,
+		KernelConfigData: b.KernelConfigData,
+		ModuleDriverName: b.ModuleDriverName,
+		ModuleDeviceName: b.ModuleDeviceName,
+	}
+	// Local builds use the host toolchain, not builder images.
+	if cp.BuildProcessor.String() == LocalBuildProcessorName {
+		keyData.BuilderImage = LocalBuildProcessorName
+		keyData.GCCVersion = b.GCCVersion
+		return keyData, nil
+	}
+	builderImage, err := b.ResolveBuilderImage(ctx)
+	if err != nil {
+		return nil, err
+	}
+	keyData.BuilderImage = builderImage
+	keyData.GCCVersion = b.GCCVersion
+	return keyData, nil
+}
+
+// lookup returns whether all the requested drivers are in the cache entry.
+func (cp *CachedBuildProcessor) lookup(b *builder.Build, entryDir string) bool {
+	if len(b.ModuleFilePath) > 0 {
+		if _, err := os.Stat(filepath.Join(entryDir, cacheModuleFile)); err != nil {
+			return false
+		}
+	}
+	if len(b.ProbeFilePath) > 0 {
+		if _, err := os.Stat(filepath.Join(entryDir, cacheProbeFile)); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+func (cp *CachedBuildProcessor) store(b *builder.Build, keyData *CacheKeyData, key, entryDir, modulePath, probePath string) error {
+	// Keep drivers already stored by previous builds of the same entry.
+	entry, err := readCacheEntry(entryDir)
+	if err != nil {
+		entry = &CacheEntry{
+			Key:   key,
+			Build: *keyData,
+		}
+	}
+	if len(modulePath) > 0 {
+		if err = storeCacheFile(modulePath, filepath.Join(entryDir, cacheModuleFile)); err != nil {
+			return err
+		}
+		entry.Module = true
+	}
+	if len(probePath) > 0 {
+		if err = storeCacheFile(probePath, filepath.Join(entryDir, cacheProbeFile)); err != nil {
+			return err
+		}
+		entry.Probe = true
+	}
+	entry.Created = time.Now().UTC()
+	data, err := json.MarshalIndent(entry, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err = writeCacheFile(filepath.Join(entryDir, cacheMetadataFile), bytes.NewReader(data)); err != nil {
+		return err
+	}
+	b.Logger.Debug("drivers stored in build cache", b.Logger.Args("key", key))
+	return nil
+}
+
+// storeCacheFile copies the src file into the cache at dest.
+func storeCacheFile(src, dest string) error {
+	in, err := os.Open(filepath.Clean(src))
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	return writeCacheFile(dest, in)
+}
+
+// writeCacheFile atomically writes the content of r into dest, through a temporary file renamed into place,
+// so that concurrent builds looking the entry up never see a partially written file.
+func writeCacheFile(dest string, r io.Reader) error {
+	dir := filepath.Dir(dest)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = io.Copy(tmp, r)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if err = os.Chmod(tmp.Name(), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), dest)
+}
+
+func readCacheEntry(entryDir string) (*CacheEntry, error) {
+	data, err := os.ReadFile(filepath.Join(entryDir, cacheMetadataFile))
+	if err != nil {
+		return nil, err
+	}
+	var entry CacheEntry
+	if err = json.Unmarshal(data, &entry); err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+// ListCache returns the entries stored in the build cache directory, most recent first.
+func ListCache(dir string) ([]CacheEntry, error) {
+	dirEntries, err := os.ReadDir(dir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var entries []CacheEntry
+	for _, dirEntry := range dirEntries {
+		if !dirEntry.IsDir() {
+			continue
+		}
+		entryDir := filepath.Join(dir, dirEntry.Name())
+		entry, err := readCacheEntry(entryDir)
+		if err != nil {
+			// Not a cache entry, or a partially written one
+			continue
+		}
+		// The entry directory is named after the key, trust it over a hand-edited metadata file
+		entry.Key = dirEntry.Name()
+		for _, f := range []string{cacheModuleFile, cacheProbeFile} {
+			if info, err := os.Stat(filepath.Join(entryDir, f)); err == nil {
+				entry.Size += info.Size()
+			}
+		}
+		entries = append(entries, *entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Created.After(entries[j].Created)
+	})
+	return entries, nil
+}
+
+// PruneCache removes the entries of the build cache directory older than the given duration;
+// a zero duration removes all the entries. It returns the removed entries.
+func PruneCache(dir string, olderThan time.Duration) ([]CacheEntry, error) {
+	entries, err := ListCache(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var pruned []CacheEntry
+	for _, entry := range entries {
+		if olderThan > 0 && time.Since(entry.Created) < olderThan {
+			continue
+		}
+		if err = os.RemoveAll(filepath.Join(dir, entry.Key)); err != nil {
+			return pruned, err
+		}
+		pruned = append(pruned, entry)
+	}
+	return pruned, nil
+}
@@ -0,0 +1,123 @@
+// SPDX-License-Identifier: Apache-2.0
+/*
+Copyright (C) 2023 The Diginfra Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driverbuilder
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/diginfra/diginfractl/pkg/output"
+	"github.com/diginfra/driverkit/pkg/driverbuilder/builder"
+	"github.com/pterm/pterm"
+	"gotest.tools/assert"
+)
+
+// countingBuildProcessor writes fake drivers and counts the builds.
+type countingBuildProcessor struct {
+	builds int
+}
+
+func (bp *countingBuildProcessor) String() string {
+	return "counting"
+}
+
+func (bp *countingBuildProcessor) Start(_ context.Context, b *builder.Build) (*BuildResult, error) {
+	res := NewBuildResult(bp.String(), b)
+	bp.builds++
+	if len(b.ModuleFilePath) > 0 {
+		if err := os.WriteFile(b.ModuleFilePath, []byte("module"), 0o644); err != nil {
+			return res.Finish(b, err)
+		}
+	}
+	if len(b.ProbeFilePath) > 0 {
+		if err := os.WriteFile(b.ProbeFilePath, []byte("probe"), 0o644); err != nil {
+			return res.Finish(b, err)
+		}
+	}
+	return res.Finish(b, nil)
+}
+
+func newCacheTestBuild(outDir, driverVersion string) *builder.Build {
+	return &builder.Build{
+		TargetType:     builder.TargetTypeUbuntu,
+		KernelRelease:  "5.15.0-1-generic",
+		KernelVersion:  "1",
+		Architecture:   "amd64",
+		DriverVersion:  driverVersion,
+		BuilderImage:   "foo/builder:latest",
+		ModuleFilePath: filepath.Join(outDir, "diginfra.ko"),
+		Images:         make(builder.ImagesMap),
+		Printer:        output.NewPrinter(pterm.LogLevelInfo, pterm.LogFormatterColorful, io.Discard),
+	}
+}
+
+func TestCachedBuildProcessor(t *testing.T) {
+	cacheDir := t.TempDir()
+	outDir := t.TempDir()
+	bp := &countingBuildProcessor{}
+	cp := NewCachedBuildProcessor(bp, cacheDir)
+
+	// First build is a miss, second one is served by the cache
+	for i := 0; i < 2; i++ {
+		res, err := cp.Start(context.Background(), newCacheTestBuild(outDir, "7.0.0+driver"))
+		assert.NilError(t, err)
+		assert.Equal(t, res.Cached, i == 1)
+		assert.Equal(t, res.Status, BuildStatusSuccess)
+		assert.Equal(t, len(res.Artifacts), 1)
+	}
+	assert.Equal(t, bp.builds, 1)
+
+	entries, err := ListCache(cacheDir)
+	assert.NilError(t, err)
+	assert.Equal(t, len(entries), 1)
+	assert.Equal(t, entries[0].Build.BuilderImage, "foo/builder:latest")
+	assert.Assert(t, entries[0].Module)
+	assert.Assert(t, !entries[0].Probe)
+	// Files are written through temporary files renamed into place
+	files, err := os.ReadDir(filepath.Join(cacheDir, entries[0].Key))
+	assert.NilError(t, err)
+	assert.Equal(t, len(files), 2)
+
+	// The key of a hand-edited metadata file is ignored
+	key := entries[0].Key
+	assert.NilError(t, os.WriteFile(filepath.Join(cacheDir, key, cacheMetadataFile), []byte(`{"key": "abc", "module": true}`), 0o644))
+	entries, err = ListCache(cacheDir)
+	assert.NilError(t, err)
+	assert.Equal(t, entries[0].Key, key)
+
+	// Requesting a driver missing from the entry triggers a build
+	b := newCacheTestBuild(outDir, "7.0.0+driver")
+	b.ProbeFilePath = filepath.Join(outDir, "diginfra.o")
+	_, err = cp.Start(context.Background(), b)
+	assert.NilError(t, err)
+	assert.Equal(t, bp.builds, 2)
+
+	// Mutable driver versions are never cached
+	for i := 0; i < 2; i++ {
+		_, err = cp.Start(context.Background(), newCacheTestBuild(outDir, "master"))
+		assert.NilError(t, err)
+	}
+	assert.Equal(t, bp.builds, 4)
+
+	pruned, err := PruneCache(cacheDir, 0)
+	assert.NilError(t, err)
+	assert.Equal(t, len(pruned), 1)
+	entries, err = ListCache(cacheDir)
+	assert.NilError(t, err)
+	assert.Equal(t, len(entries), 0)
+}
@@ -25,10 +25,10 @@ import (
 	"github.com/docker/docker/api/types/image"
 	"github.com/diginfra/diginfractl/pkg/output"
 	"io"
-	"log"
 	"runtime"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
@@ -36,8 +36,8 @@ import (
 	"github.com/docker/docker/pkg/archive"
 	"github.com/diginfra/driverkit/pkg/driverbuilder/builder"
 	"github.com/diginfra/driverkit/pkg/kernelrelease"
-	"github.com/diginfra/driverkit/pkg/signals"
 	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pterm/pterm"
 	"k8s.io/apimachinery/pkg/util/uuid"
 )
 
@@ -63,15 +63,17 @@ func (bp *DockerBuildProcessor) String() string {
 	return DockerBuildProcessorName
 }
 
-func (bp *DockerBuildProcessor) mustCheckArchUseQemu(ctx context.Context, b *builder.Build, cli *client.Client) {
+func (bp *DockerBuildProcessor) checkArchUseQemu(ctx context.Context, b *builder.Build, cli *client.Client) error {
 	var err error
 	if b.Architecture == runtime.GOARCH {
 		// Nothing to do
-		return
+		return nil
 	}
 
 	if runtime.GOARCH != kernelrelease.ArchitectureAmd64 {
-		bp.Logger.Fatal("qemu-user-static image is only available for x86_64 hosts: https://github.com/multiarch/qemu-user-static#supported-host-architectures")
+		// See https://github.com/multiarch/qemu-user-static#supported-host-architectures
+		return fmt.Errorf("%w: qemu-user-static image is only available for x86_64 hosts, cannot build for %s on %s",
+			kernelrelease.ErrUnsupportedArch, b.Architecture, runtime.GOARCH)
 	}
 
 	bp.Logger.Debug("using qemu for cross build")
@@ -80,12 +82,12 @@ func (bp *DockerBuildProcessor) mustCheckArchUseQemu(ctx context.Context, b *bui
 			bp.Logger.Args("image", "multiarch/qemu-user-static"))
 		pullRes, err := cli.ImagePull(ctx, "multiarch/qemu-user-static", types.ImagePullOptions{})
 		if err != nil {
-			log.Fatal(err)
+			return err
 		}
 		defer pullRes.Close()
 		_, err = io.Copy(io.Discard, pullRes)
 		if err != nil {
-			log.Fatal(err)
+			return err
 		}
 	}
 
@@ -99,41 +101,49 @@ func (bp *DockerBuildProcessor) mustCheckArchUseQemu(ctx context.Context, b *bui
 			Privileged: true,
 		}, nil, nil, "")
 	if err != nil {
-		bp.Logger.Fatal("failed to create qemu container",
-			bp.Logger.Args("err", err.Error()))
+		return fmt.Errorf("failed to create qemu container: %w", err)
 	}
 
 	if err = cli.ContainerStart(ctx, qemuImage.ID, container.StartOptions{}); err != nil {
-		panic(err)
+		return fmt.Errorf("failed to start qemu container: %w", err)
 	}
 
 	statusCh, errCh := cli.ContainerWait(ctx, qemuImage.ID, container.WaitConditionNotRunning)
 	select {
 	case err = <-errCh:
 		if err != nil {
-			panic(err)
+			return fmt.Errorf("failed to wait qemu container: %w", err)
 		}
 	case <-statusCh:
 	}
 
 	err = cli.ContainerStop(ctx, qemuImage.ID, container.StopOptions{})
 	if err != nil && !client.IsErrNotFound(err) {
-		bp.Logger.Fatal("failed to stop qemu container",
-			bp.Logger.Args("err", err.Error()))
+		return fmt.Errorf("failed to stop qemu container: %w", err)
 	}
+	return nil
 }
 
 // Start the docker processor
-func (bp *DockerBuildProcessor) Start(b *builder.Build) error {
+func (bp *DockerBuildProcessor) Start(ctx context.Context, b *builder.Build) (*BuildResult, error) {
 	bp.Printer = b.Printer
+	res := NewBuildResult(bp.String(), b)
+	return res.Finish(b, bp.run(ctx, b, res))
+}
+
+func (bp *DockerBuildProcessor) run(ctx context.Context, b *builder.Build, res *BuildResult) error {
+	stageStart := time.Now()
 
 	cli, err := client.NewClientWithOpts(client.FromEnv)
 	if err != nil {
 		return err
 	}
-	cli.NegotiateAPIVersion(context.Background())
+	cli.NegotiateAPIVersion(ctx)
 
-	kr := b.KernelReleaseFromBuildConfig()
+	kr, err := b.KernelReleaseFromBuildConfig()
+	if err != nil {
+		return err
+	}
 
 	// create a builder based on the choosen build type
 	v, err := builder.Factory(b.TargetType)
@@ -147,13 +157,19 @@ func (bp *DockerBuildProcessor) Start(b *builder.Build) error {
 		return err
 	}
 
-	kernelDownloadScript, err := builder.KernelDownloadScript(v, c.KernelUrls, kr, b.Printer)
+	kernelDownloadScript, kernelURLs, err := builder.KernelDownloadScript(builder.WithMirrors(ctx, c.Mirrors), v, c.KernelUrls, kr, b.Printer)
+	if err != nil {
+		return err
+	}
+	res.KernelHeadersURLs = kernelURLs
+	res.LibsDownloadURL = c.LibsDownloadURL()
+	kernelDownloadScript, headers, err := localizeHeaders(ctx, b, v, kr, kernelURLs, kernelDownloadScript, builderHeadersDir, res)
 	if err != nil {
 		return err
 	}
 
 	// Generate the build script from the builder
-	driverkitScript, err := builder.Script(v, c, kr)
+	driverkitScript, err := builder.Script(ctx, v, c, kr)
 	if err != nil {
 		return err
 	}
@@ -163,13 +179,23 @@ func (bp *DockerBuildProcessor) Start(b *builder.Build) error {
 		return err
 	}
 
-	builderImage := b.GetBuilderImage()
+	builderImage, err := b.GetBuilderImage()
+	if err != nil {
+		return err
+	}
+	res.BuilderImage = builderImage
+	res.GCCVersion = b.GCCVersion
+	res.StageDone(StagePrepare, stageStart)
+
+	// Stops the cleanup goroutine once the build is over
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
 
-	// Create the container
-	ctx := context.Background()
-	ctx = signals.WithStandardSignals(ctx)
+	stageStart = time.Now()
 
-	bp.mustCheckArchUseQemu(ctx, b, cli)
+	if err = bp.checkArchUseQemu(ctx, b, cli); err != nil {
+		return err
+	}
 
 	var inspect types.ImageInspect
 	if inspect, _, err = cli.ImageInspectWithRaw(ctx, builderImage); client.IsErrNotFound(err) ||
@@ -189,7 +215,10 @@ func (bp *DockerBuildProcessor) Start(b *builder.Build) error {
 		}
 	}
 
+	res.StageDone(StagePull, stageStart)
+
 	bp.Logger.Debug("starting container", bp.Logger.Args("image", builderImage))
+	stageStart = time.Now()
 
 	containerCfg := &container.Config{
 		Tty:   true,
@@ -270,6 +299,13 @@ chmod +x /driverkit/driverkit.sh
 	if err != nil {
 		return err
 	}
+	// Copy the local kernel headers to the container
+	if len(headers) > 0 {
+		err = cli.CopyToContainer(ctx, cdata.ID, "/", headersTarReader(headers, builderHeadersDir), types.CopyToContainerOptions{})
+		if err != nil {
+			return err
+		}
+	}
 
 	// Construct environment variable array of string
 	var envs []string
@@ -311,10 +347,14 @@ chmod +x /driverkit/driverkit.sh
 		isMultiplexed = val == "application/vnd.docker.multiplexed-stream"
 	}
 	if isMultiplexed {
-		bp.multiplexedForwardLogs(hr.Reader)
+		multiplexedForwardLogs(bp.Logger, hr.Reader)
 	} else {
-		bp.forwardLogs(hr.Reader)
+		forwardLogs(bp.Logger, hr.Reader)
 	}
+	res.StageDone(StageBuild, stageStart)
+
+	stageStart = time.Now()
+	defer res.StageDone(StageCopy, stageStart)
 
 	if len(b.ModuleFilePath) > 0 {
 		if err := copyFromContainer(ctx, cli, cdata.ID, c.ToDriverFullPath(), b.ModuleFilePath); err != nil {
@@ -354,6 +394,7 @@ func (bp *DockerBuildProcessor) cleanup(cli *client.Client, ID string) {
 		bp.clean = true
 		bp.Logger.Debug("context canceled")
 		duration := 1
+		// The build context may be already canceled
 		if err := cli.ContainerStop(context.Background(), ID, container.StopOptions{Timeout: &duration}); err != nil && !client.IsErrNotFound(err) {
 			bp.Logger.Error("error stopping container",
 				bp.Logger.Args("err", err.Error()))
@@ -385,21 +426,21 @@ func tarWriterFiles(buf io.Writer, files []dockerCopyFile) error {
 	return nil
 }
 
-func (bp *DockerBuildProcessor) forwardLogs(logPipe io.Reader) {
+func forwardLogs(logger *pterm.Logger, logPipe io.Reader) {
 	lineReader := bufio.NewReader(logPipe)
 	for {
 		line, err := lineReader.ReadBytes('\n')
 		if len(line) > 0 {
-			bp.Logger.Debug(string(line))
+			logger.Debug(string(line))
 		}
 		if err == io.EOF {
 			break
 		}
 		if err != nil {
-			bp.Logger.Error("log pipe error", bp.Logger.Args("err", err.Error()))
+			logger.Error("log pipe error", logger.Args("err", err.Error()))
 		}
 	}
-	bp.Logger.Debug("log pipe close")
+	logger.Debug("log pipe close")
 }
 
 // When docker container attach is called on a non-tty terminal,
@@ -408,7 +449,7 @@ func (bp *DockerBuildProcessor) forwardLogs(logPipe io.Reader) {
 // > The format of the multiplexed stream is as follows:
 // > [8]byte{STREAM_TYPE, 0, 0, 0, SIZE1, SIZE2, SIZE3, SIZE4}[]byte{OUTPUT}
 // see cli.ContainerAttach() method for more info.
-func (bp *DockerBuildProcessor) multiplexedForwardLogs(logPipe io.Reader) {
+func multiplexedForwardLogs(logger *pterm.Logger, logPipe io.Reader) {
 	hdr := make([]byte, 8)
 	for {
 		// Load size of message
@@ -417,7 +458,7 @@ func (bp *DockerBuildProcessor) multiplexedForwardLogs(logPipe io.Reader) {
 			break
 		}
 		if err != nil {
-			bp.Logger.Error("log pipe error", bp.Logger.Args("err", err.Error()))
+			logger.Error("log pipe error", logger.Args("err", err.Error()))
 			return
 		}
 		count := binary.BigEndian.Uint32(hdr[4:])
@@ -432,11 +473,11 @@ func (bp *DockerBuildProcessor) multiplexedForwardLogs(logPipe io.Reader) {
 				if uint32(readCnt) == count {
 					break
 				}
-				bp.Logger.Error("log pipe error", bp.Logger.Args("err", io.EOF.Error()))
+				logger.Error("log pipe error", logger.Args("err", io.EOF.Error()))
 				return
 			}
 			if err != nil {
-				bp.Logger.Error("log pipe error", bp.Logger.Args("err", err.Error()))
+				logger.Error("log pipe error", logger.Args("err", err.Error()))
 				return
 			}
 		}
@@ -445,9 +486,9 @@ func (bp *DockerBuildProcessor) multiplexedForwardLogs(logPipe io.Reader) {
 		lines := strings.Split(string(dat), "\n")
 		for _, line := range lines {
 			if line != "" {
-				bp.Logger.Debug(line)
+				logger.Debug(line)
 			}
 		}
 	}
-	bp.Logger.Debug("log pipe close")
+	logger.Debug("log pipe close")
 }
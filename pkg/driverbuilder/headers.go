@@ -0,0 +1,144 @@
+// SPDX-License-Identifier: Apache-2.0
+/*
+Copyright (C) 2023 The Diginfra Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driverbuilder
+
+import (
+	"archive/tar"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	"github.com/diginfra/driverkit/pkg/driverbuilder/builder"
+	"github.com/diginfra/driverkit/pkg/kernelrelease"
+)
+
+// builderHeadersDir is the directory of the builder containers the local kernel headers are shipped into.
+const builderHeadersDir = "/driverkit-headers"
+
+// localHeader is a kernel headers package available on the host.
+type localHeader struct {
+	// Path is the path of the package on the host.
+	Path string
+	// Name is the file name of the package within the builder.
+	Name string
+}
+
+// localizeHeaders makes the given kernel headers urls available to the builder from dir:
+// local packages are always shipped to the builder, remote ones only when the headers cache of the build is enabled,
+// after being downloaded into it.
+// Shipped packages are verified against the checksum of their url, if any, which is kept for the builder to verify them again.
+// It returns the packages to ship and the kernel download script extracting them from dir;
+// when dir is empty, the script extracts them straight from their path on the host.
+// When there is nothing to ship, the given script is returned.
+func localizeHeaders(ctx context.Context, b *builder.Build, v builder.Builder, kr kernelrelease.KernelRelease,
+	urls []string, script, dir string, res *BuildResult) (string, []localHeader, error) {
+	var hc *HeadersCache
+	if b.HeadersCacheDir != "" {
+		hc = NewHeadersCache(b.HeadersCacheDir)
+		defer res.StageDone(StageHeaders, time.Now())
+	}
+
+	var headers []localHeader
+	localURLs := make([]string, 0, len(urls))
+	for i, u := range urls {
+		bare, checksum, err := builder.SplitChecksum(u)
+		if err != nil {
+			return "", nil, err
+		}
+		p, local := builder.LocalPath(bare)
+		if local && checksum != nil {
+			if err = checksum.VerifyFile(p); err != nil {
+				return "", nil, err
+			}
+		}
+		if !local {
+			if hc == nil {
+				localURLs = append(localURLs, u)
+				continue
+			}
+			var hit bool
+			p, hit, err = hc.Fetch(ctx, bare, checksum)
+			if err != nil {
+				return "", nil, err
+			}
+			b.Logger.Debug("kernel headers cached", b.Logger.Args("url", bare, "path", p, "hit", hit))
+		}
+		header := localHeader{
+			Path: p,
+			// Different urls may share the same file name
+			Name: fmt.Sprintf("%d-%s", i, filepath.Base(p)),
+		}
+		headers = append(headers, header)
+		if dir == "" {
+			localURLs = append(localURLs, builder.WithChecksum("file://"+p, checksum))
+		} else {
+			localURLs = append(localURLs, builder.WithChecksum("file://"+path.Join(dir, header.Name), checksum))
+		}
+	}
+	if len(headers) == 0 {
+		return script, nil, nil
+	}
+
+	script, err := builder.KernelDownloadScriptFromURLs(v, kr, localURLs)
+	if err != nil {
+		return "", nil, err
+	}
+	return script, headers, nil
+}
+
+// headersTarReader returns a tar stream of the given cached packages, extracting them into dir.
+func headersTarReader(headers []localHeader, dir string) io.ReadCloser {
+	r, w := io.Pipe()
+	go func() {
+		w.CloseWithError(writeHeadersTar(w, headers, dir))
+	}()
+	return r
+}
+
+func writeHeadersTar(w io.Writer, headers []localHeader, dir string) error {
+	tw := tar.NewWriter(w)
+	for _, header := range headers {
+		if err := writeTarFile(tw, header.Path, path.Join(dir, header.Name)); err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+func writeTarFile(tw *tar.Writer, src, name string) error {
+	f, err := os.Open(filepath.Clean(src))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	hdr := &tar.Header{
+		Name: name,
+		Mode: 0644,
+		Size: info.Size(),
+	}
+	if err = tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, f)
+	return err
+}
@@ -0,0 +1,184 @@
+// SPDX-License-Identifier: Apache-2.0
+/*
+Copyright (C) 2023 The Diginfra Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driverbuilder
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	"github.com/diginfra/driverkit/pkg/driverbuilder/builder"
+	"github.com/diginfra/driverkit/pkg/httpclient"
+)
+
+const (
+	headersCacheBlobsDir = "blobs"
+	headersCacheURLsDir  = "urls"
+)
+
+// HeadersCacheEntry is a kernel headers package stored in the headers cache.
+type HeadersCacheEntry struct {
+	URL     string    `json:"url"`
+	Sha256  string    `json:"sha256"`
+	Size    int64     `json:"size"`
+	Created time.Time `json:"created"`
+}
+
+// HeadersCache stores the kernel headers packages downloaded on the host, by url and by sha256,
+// so that builds against the same kernel download them only once.
+type HeadersCache struct {
+	dir string
+}
+
+// NewHeadersCache returns the headers cache stored into dir.
+func NewHeadersCache(dir string) *HeadersCache {
+	return &HeadersCache{dir: dir}
+}
+
+// Fetch returns the path of the package downloaded from the given url, downloading it when not cached yet,
+// and whether it was already cached.
+// When checksum is not nil, the package is verified against it, and a cached package not matching it is downloaded again.
+func (hc *HeadersCache) Fetch(ctx context.Context, u string, checksum *builder.Checksum) (string, bool, error) {
+	if p, ok := hc.lookup(u, checksum); ok {
+		return p, true, nil
+	}
+	p, err := hc.download(ctx, u, checksum)
+	return p, false, err
+}
+
+// urlKey returns the name of the cache entry of the given url.
+func urlKey(u string) string {
+	sum := sha256.Sum256([]byte(u))
+	return hex.EncodeToString(sum[:]) + ".json"
+}
+
+// blobPath returns the path of the package with the given digest, keeping the file name of the url,
+// since the download scripts may rely on its extension.
+func (hc *HeadersCache) blobPath(digest, u string) string {
+	name := "package"
+	if uu, err := url.Parse(u); err == nil && path.Base(uu.Path) != "/" && path.Base(uu.Path) != "." {
+		name = path.Base(uu.Path)
+	}
+	return filepath.Join(hc.dir, headersCacheBlobsDir, digest, name)
+}
+
+func (hc *HeadersCache) lookup(u string, checksum *builder.Checksum) (string, bool) {
+	data, err := os.ReadFile(filepath.Join(hc.dir, headersCacheURLsDir, urlKey(u)))
+	if err != nil {
+		return "", false
+	}
+	var entry HeadersCacheEntry
+	if err = json.Unmarshal(data, &entry); err != nil || entry.URL != u {
+		return "", false
+	}
+	p := hc.blobPath(entry.Sha256, u)
+	if info, err := os.Stat(p); err != nil || info.Size() != entry.Size {
+		return "", false
+	}
+	if checksum != nil {
+		if checksum.Algorithm == "sha256" {
+			return p, checksum.Digest == entry.Sha256
+		}
+		return p, checksum.VerifyFile(p) == nil
+	}
+	return p, true
+}
+
+func (hc *HeadersCache) download(ctx context.Context, u string, checksum *builder.Checksum) (string, error) {
+	res, err := httpclient.Default().Get(ctx, u)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("error downloading %s: %s", u, res.Status)
+	}
+
+	blobsDir := filepath.Join(hc.dir, headersCacheBlobsDir)
+	if err = os.MkdirAll(blobsDir, 0o755); err != nil {
+		return "", err
+	}
+	// Concurrent builds may download the same package: write to a temporary file, then rename it
+	tmp, err := os.CreateTemp(blobsDir, "download-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+	h := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, h), res.Body)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", err
+	}
+	// Never store packages not matching their checksum
+	if checksum != nil {
+		if err = checksum.VerifyFile(tmp.Name()); err != nil {
+			return "", fmt.Errorf("error verifying %s: %w", u, err)
+		}
+	}
+
+	entry := HeadersCacheEntry{
+		URL:     u,
+		Sha256:  hex.EncodeToString(h.Sum(nil)),
+		Size:    size,
+		Created: time.Now().UTC(),
+	}
+	p := hc.blobPath(entry.Sha256, u)
+	if err = os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return "", err
+	}
+	if err = os.Rename(tmp.Name(), p); err != nil {
+		return "", err
+	}
+	if err = hc.storeEntry(&entry); err != nil {
+		return "", err
+	}
+	return p, nil
+}
+
+func (hc *HeadersCache) storeEntry(entry *HeadersCacheEntry) error {
+	urlsDir := filepath.Join(hc.dir, headersCacheURLsDir)
+	if err := os.MkdirAll(urlsDir, 0o755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(entry, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(urlsDir, "entry-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filepath.Join(urlsDir, urlKey(entry.URL)))
+}
@@ -0,0 +1,95 @@
+// SPDX-License-Identifier: Apache-2.0
+/*
+Copyright (C) 2023 The Diginfra Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driverbuilder
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/diginfra/driverkit/pkg/driverbuilder/builder"
+	"gotest.tools/assert"
+)
+
+func newHeadersTestServer(t *testing.T, downloads *int) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasSuffix(r.URL.Path, ".deb") {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		*downloads++
+		_, _ = w.Write([]byte("headers of " + r.URL.Path))
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestHeadersCacheFetch(t *testing.T) {
+	downloads := 0
+	srv := newHeadersTestServer(t, &downloads)
+	hc := NewHeadersCache(t.TempDir())
+	u := srv.URL + "/pool/linux-headers_amd64.deb"
+
+	// First fetch downloads the package, second one is served by the cache
+	for i := 0; i < 2; i++ {
+		p, hit, err := hc.Fetch(context.Background(), u, nil)
+		assert.NilError(t, err)
+		assert.Equal(t, hit, i == 1)
+		assert.Assert(t, strings.HasSuffix(p, "/linux-headers_amd64.deb"))
+		data, err := os.ReadFile(p)
+		assert.NilError(t, err)
+		assert.Equal(t, string(data), "headers of /pool/linux-headers_amd64.deb")
+	}
+	assert.Equal(t, downloads, 1)
+
+	_, _, err := hc.Fetch(context.Background(), srv.URL+"/pool/missing.rpm", nil)
+	assert.ErrorContains(t, err, "404")
+}
+
+func TestHeadersCacheFetchChecksum(t *testing.T) {
+	downloads := 0
+	srv := newHeadersTestServer(t, &downloads)
+	hc := NewHeadersCache(t.TempDir())
+	u := srv.URL + "/pool/linux-headers_amd64.deb"
+	sum := sha256.Sum256([]byte("headers of /pool/linux-headers_amd64.deb"))
+	good, err := builder.NewChecksum("sha256", hex.EncodeToString(sum[:]))
+	assert.NilError(t, err)
+	bad, err := builder.NewChecksum("sha256", strings.Repeat("0", 64))
+	assert.NilError(t, err)
+
+	// Mismatching packages are not stored
+	_, _, err = hc.Fetch(context.Background(), u, bad)
+	assert.ErrorContains(t, err, "checksum mismatch")
+	_, ok := hc.lookup(u, nil)
+	assert.Assert(t, !ok)
+
+	_, hit, err := hc.Fetch(context.Background(), u, good)
+	assert.NilError(t, err)
+	assert.Assert(t, !hit)
+	_, hit, err = hc.Fetch(context.Background(), u, good)
+	assert.NilError(t, err)
+	assert.Assert(t, hit)
+	assert.Equal(t, downloads, 2)
+
+	// A cached package not matching the expected checksum is downloaded again
+	_, _, err = hc.Fetch(context.Background(), u, bad)
+	assert.ErrorContains(t, err, "checksum mismatch")
+	assert.Equal(t, downloads, 3)
+}
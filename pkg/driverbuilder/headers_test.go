@@ -0,0 +1,122 @@
+// SPDX-License-Identifier: Apache-2.0
+/*
+Copyright (C) 2023 The Diginfra Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driverbuilder
+
+import (
+	"archive/tar"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/diginfra/driverkit/pkg/driverbuilder/builder"
+	"gotest.tools/assert"
+)
+
+func TestLocalizeHeadersCache(t *testing.T) {
+	downloads := 0
+	srv := newHeadersTestServer(t, &downloads)
+	b := newCacheTestBuild(t.TempDir(), "7.0.0+driver")
+	v, err := builder.Factory(b.TargetType)
+	assert.NilError(t, err)
+	kr, err := b.KernelReleaseFromBuildConfig()
+	assert.NilError(t, err)
+	urls := []string{srv.URL + "/a/headers.deb", srv.URL + "/b/headers.deb"}
+
+	// Disabled cache leaves the script untouched
+	script, headers, err := localizeHeaders(context.Background(), b, v, kr, urls, "script", builderHeadersDir, NewBuildResult("test", b))
+	assert.NilError(t, err)
+	assert.Equal(t, script, "script")
+	assert.Equal(t, len(headers), 0)
+
+	b.HeadersCacheDir = t.TempDir()
+	res := NewBuildResult("test", b)
+	script, headers, err = localizeHeaders(context.Background(), b, v, kr, urls, "script", builderHeadersDir, res)
+	assert.NilError(t, err)
+	assert.Equal(t, downloads, 2)
+	assert.Equal(t, len(headers), 2)
+	assert.Equal(t, res.Stages[len(res.Stages)-1].Name, StageHeaders)
+	// Packages sharing the same file name do not clash within the builder
+	assert.Assert(t, strings.Contains(script, "file://"+builderHeadersDir+"/0-headers.deb"))
+	assert.Assert(t, strings.Contains(script, "file://"+builderHeadersDir+"/1-headers.deb"))
+	assert.Assert(t, !strings.Contains(script, srv.URL))
+
+	tr := tar.NewReader(headersTarReader(headers, builderHeadersDir))
+	for _, header := range headers {
+		hdr, err := tr.Next()
+		assert.NilError(t, err)
+		assert.Equal(t, hdr.Name, builderHeadersDir+"/"+header.Name)
+		data, err := io.ReadAll(tr)
+		assert.NilError(t, err)
+		assert.Assert(t, strings.HasPrefix(string(data), "headers of "))
+	}
+	_, err = tr.Next()
+	assert.Equal(t, err, io.EOF)
+
+	// Without a builder directory, the packages are extracted from the host cache
+	script, _, err = localizeHeaders(context.Background(), b, v, kr, urls, "script", "", res)
+	assert.NilError(t, err)
+	assert.Equal(t, downloads, 2)
+	assert.Assert(t, strings.Contains(script, "file://"+headers[0].Path))
+}
+
+func TestLocalizeHeadersLocalPackages(t *testing.T) {
+	downloads := 0
+	srv := newHeadersTestServer(t, &downloads)
+	b := newCacheTestBuild(t.TempDir(), "7.0.0+driver")
+	v, err := builder.Factory(b.TargetType)
+	assert.NilError(t, err)
+	kr, err := b.KernelReleaseFromBuildConfig()
+	assert.NilError(t, err)
+	local := filepath.Join(t.TempDir(), "linux-headers_amd64.deb")
+	assert.NilError(t, os.WriteFile(local, []byte("local headers"), 0o600))
+
+	// Local packages are shipped even without the headers cache, remote ones are left untouched
+	urls := []string{"file://" + local, srv.URL + "/remote/headers.deb"}
+	script, headers, err := localizeHeaders(context.Background(), b, v, kr, urls, "script", builderHeadersDir, NewBuildResult("test", b))
+	assert.NilError(t, err)
+	assert.Equal(t, downloads, 0)
+	assert.Equal(t, len(headers), 1)
+	assert.Equal(t, headers[0].Path, local)
+	assert.Assert(t, strings.Contains(script, "file://"+builderHeadersDir+"/0-linux-headers_amd64.deb"))
+	assert.Assert(t, strings.Contains(script, srv.URL+"/remote/headers.deb"))
+}
+
+func TestLocalizeHeadersChecksum(t *testing.T) {
+	b := newCacheTestBuild(t.TempDir(), "7.0.0+driver")
+	v, err := builder.Factory(b.TargetType)
+	assert.NilError(t, err)
+	kr, err := b.KernelReleaseFromBuildConfig()
+	assert.NilError(t, err)
+	local := filepath.Join(t.TempDir(), "linux-headers_amd64.deb")
+	assert.NilError(t, os.WriteFile(local, []byte("local headers"), 0o600))
+	sum := sha256.Sum256([]byte("local headers"))
+	digest := hex.EncodeToString(sum[:])
+
+	// The checksum is verified on the host, then kept for the builder
+	urls := []string{local + "#sha256=" + digest}
+	script, headers, err := localizeHeaders(context.Background(), b, v, kr, urls, "script", builderHeadersDir, NewBuildResult("test", b))
+	assert.NilError(t, err)
+	assert.Equal(t, len(headers), 1)
+	assert.Assert(t, strings.Contains(script, digest+"  "+builderHeadersDir+"/0-linux-headers_amd64.deb"))
+
+	urls = []string{local + "#sha256=" + strings.Repeat("0", 64)}
+	_, _, err = localizeHeaders(context.Background(), b, v, kr, urls, "script", builderHeadersDir, NewBuildResult("test", b))
+	assert.ErrorContains(t, err, "checksum mismatch")
+}
@@ -21,20 +21,22 @@ import (
 	"errors"
 	"fmt"
 	"github.com/diginfra/diginfractl/pkg/output"
+	"io"
 	"k8s.io/cli-runtime/pkg/genericiooptions"
+	"net/url"
 	"os"
 	"time"
 
-	"github.com/diginfra/driverkit/pkg/signals"
-
 	"github.com/diginfra/driverkit/pkg/driverbuilder/builder"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/httpstream"
 	"k8s.io/apimachinery/pkg/util/uuid"
 	"k8s.io/cli-runtime/pkg/genericclioptions"
 	v1 "k8s.io/client-go/kubernetes/typed/core/v1"
 	restclient "k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/remotecommand"
 	"k8s.io/kubectl/pkg/cmd/exec"
 	"k8s.io/utils/pointer"
 )
@@ -81,60 +83,137 @@ func (bp *KubernetesBuildProcessor) String() string {
 	return KubernetesBuildProcessorName
 }
 
-func (bp *KubernetesBuildProcessor) Start(b *builder.Build) error {
+// kubernetesBuild contains the resources needed to run a build into the cluster.
+type kubernetesBuild struct {
+	uid       string
+	config    builder.Config
+	meta      metav1.ObjectMeta
+	configMap *corev1.ConfigMap
+	podSpec   corev1.PodSpec
+	// headers are the local kernel headers to upload into the pod once running.
+	headers []localHeader
+}
+
+func (bp *KubernetesBuildProcessor) Start(ctx context.Context, b *builder.Build) (*BuildResult, error) {
 	bp.Printer = b.Printer
+	res := NewBuildResult(bp.String(), b)
+	return res.Finish(b, bp.run(ctx, b, res))
+}
+
+func (bp *KubernetesBuildProcessor) run(ctx context.Context, b *builder.Build, res *BuildResult) error {
+	kb, err := bp.prepareBuild(ctx, b, res, true, func(c builder.Config, script string) string {
+		if c.ModuleFilePath != "" {
+			script = fmt.Sprintf("%s\n%s", "touch "+moduleLockFile, script)
+			script = fmt.Sprintf("%s\n%s", script, "rm "+moduleLockFile)
+		}
+		if c.ProbeFilePath != "" {
+			script = fmt.Sprintf("%s\n%s", "touch "+probeLockFile, script)
+			script = fmt.Sprintf("%s\n%s", script, "rm "+probeLockFile)
+		}
+
+		// Append a script to the entrypoint to wait
+		// for the module to be ready before exiting PID 1
+		return fmt.Sprintf("%s\n%s", script, waitForLockScript)
+	})
+	if err != nil {
+		return err
+	}
 
 	deadline := int64(bp.timeout)
+	kb.podSpec.ActiveDeadlineSeconds = pointer.Int64Ptr(deadline)
+	kb.podSpec.RestartPolicy = corev1.RestartPolicyNever
+
+	pod := &corev1.Pod{
+		ObjectMeta: kb.meta,
+		Spec:       kb.podSpec,
+	}
+
+	bp.Logger.Debug("starting pod",
+		bp.Logger.Args("name", pod.Name, "spec", pod.Spec.String()))
+
+	podClient := bp.coreV1Client.Pods(bp.namespace)
+	configClient := bp.coreV1Client.ConfigMaps(bp.namespace)
+
+	_, err = configClient.Create(ctx, kb.configMap, metav1.CreateOptions{})
+	if err != nil {
+		return err
+	}
+	// Resources must be deleted also when the build is canceled
+	cleanupCtx := context.WithoutCancel(ctx)
+	defer configClient.Delete(cleanupCtx, kb.configMap.Name, metav1.DeleteOptions{})
+	_, err = podClient.Create(ctx, pod, metav1.CreateOptions{})
+	if err != nil {
+		return err
+	}
+	defer podClient.Delete(cleanupCtx, pod.Name, metav1.DeleteOptions{})
+	// Drivers are downloaded while the pod is running, the build stage includes the copy
+	defer res.StageDone(StageBuild, time.Now())
+	return bp.copyModuleAndProbeFromPodWithUID(ctx, kb.config, b, bp.namespace, kb.uid, kb.headers)
+}
+
+// prepareBuild generates the build scripts and the spec of the pod running them, filling the result;
+// shipHeaders tells whether the local kernel headers can be uploaded into the pod,
+// wrapScript allows to customize the script run by the builder container.
+func (bp *KubernetesBuildProcessor) prepareBuild(ctx context.Context, b *builder.Build, result *BuildResult, shipHeaders bool, wrapScript func(c builder.Config, script string) string) (*kubernetesBuild, error) {
+	defer result.StageDone(StagePrepare, time.Now())
 	namespace := bp.namespace
 	uid := uuid.NewUUID()
 	name := fmt.Sprintf("driverkit-%s", string(uid))
 
-	podClient := bp.coreV1Client.Pods(namespace)
-	configClient := bp.coreV1Client.ConfigMaps(namespace)
-
-	kr := b.KernelReleaseFromBuildConfig()
+	kr, err := b.KernelReleaseFromBuildConfig()
+	if err != nil {
+		return nil, err
+	}
 
 	// create a builder based on the chosen build type
 	v, err := builder.Factory(b.TargetType)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	c := b.ToConfig()
 
 	libsDownloadScript, err := builder.LibsDownloadScript(c)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	kernelDownloadScript, err := builder.KernelDownloadScript(v, c.KernelUrls, kr, bp.Printer)
+	kernelDownloadScript, kernelURLs, err := builder.KernelDownloadScript(builder.WithMirrors(ctx, c.Mirrors), v, c.KernelUrls, kr, bp.Printer)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	result.KernelHeadersURLs = kernelURLs
+	result.LibsDownloadURL = c.LibsDownloadURL()
+	var headers []localHeader
+	if shipHeaders {
+		kernelDownloadScript, headers, err = localizeHeaders(ctx, b, v, kr, kernelURLs, kernelDownloadScript, builderHeadersDir, result)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		for _, u := range kernelURLs {
+			if _, local := builder.LocalPath(u); local {
+				return nil, fmt.Errorf("local kernel headers %s cannot be shipped to the builder", u)
+			}
+		}
 	}
 
 	// generate the build script from the builder
-	res, err := builder.Script(v, c, kr)
+	res, err := builder.Script(ctx, v, c, kr)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	// We run a script that downloads libs,
 	// then downloads and extracts kernelURLs exporting KERNELDIR env variable,
 	// then finally runs the build script.
-	res = fmt.Sprintf("%s\n%s\n%s", libsDownloadScript, kernelDownloadScript, res)
-
-	if c.ModuleFilePath != "" {
-		res = fmt.Sprintf("%s\n%s", "touch "+moduleLockFile, res)
-		res = fmt.Sprintf("%s\n%s", res, "rm "+moduleLockFile)
+	// The local kernel headers are uploaded once the pod is running: wait for them before extracting them.
+	if len(headers) > 0 {
+		res = fmt.Sprintf("%s\n%s\n%s\n%s", libsDownloadScript, waitForHeadersScript, kernelDownloadScript, res)
+	} else {
+		res = fmt.Sprintf("%s\n%s\n%s", libsDownloadScript, kernelDownloadScript, res)
 	}
-	if c.ProbeFilePath != "" {
-		res = fmt.Sprintf("%s\n%s", "touch "+probeLockFile, res)
-		res = fmt.Sprintf("%s\n%s", res, "rm "+probeLockFile)
-	}
-
-	// Append a script to the entrypoint to wait
-	// for the module to be ready before exiting PID 1
-	res = fmt.Sprintf("%s\n%s", res, waitForLockScript)
+	res = wrapScript(c, res)
 
 	buildCmd := []string{
 		"/bin/bash",
@@ -152,7 +231,7 @@ func (bp *KubernetesBuildProcessor) Start(b *builder.Build) error {
 
 	configDecoded, err := base64.StdEncoding.DecodeString(b.KernelConfigData)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	cm := &corev1.ConfigMap{
@@ -182,7 +261,12 @@ func (bp *KubernetesBuildProcessor) Start(b *builder.Build) error {
 		)
 	}
 
-	builderImage := b.GetBuilderImage()
+	builderImage, err := b.GetBuilderImage()
+	if err != nil {
+		return nil, err
+	}
+	result.BuilderImage = builderImage
+	result.GCCVersion = b.GCCVersion
 
 	secuContext := corev1.PodSecurityContext{
 		RunAsUser: &bp.runAsUser,
@@ -193,49 +277,44 @@ func (bp *KubernetesBuildProcessor) Start(b *builder.Build) error {
 		imagePullSecrets = append(imagePullSecrets, corev1.LocalObjectReference{Name: bp.imagePullSecret})
 	}
 
-	pod := &corev1.Pod{
-		ObjectMeta: commonMeta,
-		Spec: corev1.PodSpec{
-			ActiveDeadlineSeconds: pointer.Int64Ptr(deadline),
-			RestartPolicy:         corev1.RestartPolicyNever,
-			SecurityContext:       &secuContext,
-			ImagePullSecrets:      imagePullSecrets,
-			NodeSelector:          map[string]string{corev1.LabelArchStable: kr.Architecture.String()},
-			Containers: []corev1.Container{
-				{
-					Name:            name,
-					Image:           builderImage,
-					Command:         buildCmd,
-					Env:             envs,
-					ImagePullPolicy: corev1.PullIfNotPresent,
-
-					Resources: corev1.ResourceRequirements{
-						Requests: corev1.ResourceList{
-							corev1.ResourceCPU:    resource.MustParse("1000m"),
-							corev1.ResourceMemory: resource.MustParse("2000Mi"),
-						},
-						Limits: corev1.ResourceList{
-							corev1.ResourceCPU:    resource.MustParse("4"),
-							corev1.ResourceMemory: resource.MustParse("4G"),
-						},
+	podSpec := corev1.PodSpec{
+		SecurityContext:  &secuContext,
+		ImagePullSecrets: imagePullSecrets,
+		NodeSelector:     map[string]string{corev1.LabelArchStable: kr.Architecture.String()},
+		Containers: []corev1.Container{
+			{
+				Name:            name,
+				Image:           builderImage,
+				Command:         buildCmd,
+				Env:             envs,
+				ImagePullPolicy: corev1.PullIfNotPresent,
+
+				Resources: corev1.ResourceRequirements{
+					Requests: corev1.ResourceList{
+						corev1.ResourceCPU:    resource.MustParse("1000m"),
+						corev1.ResourceMemory: resource.MustParse("2000Mi"),
 					},
-					VolumeMounts: []corev1.VolumeMount{
-						{
-							Name:      "driverkit",
-							MountPath: "/driverkit",
-							ReadOnly:  true,
-						},
+					Limits: corev1.ResourceList{
+						corev1.ResourceCPU:    resource.MustParse("4"),
+						corev1.ResourceMemory: resource.MustParse("4G"),
+					},
+				},
+				VolumeMounts: []corev1.VolumeMount{
+					{
+						Name:      "driverkit",
+						MountPath: "/driverkit",
+						ReadOnly:  true,
 					},
 				},
 			},
-			Volumes: []corev1.Volume{
-				{
-					Name: "driverkit",
-					VolumeSource: corev1.VolumeSource{
-						ConfigMap: &corev1.ConfigMapVolumeSource{
-							LocalObjectReference: corev1.LocalObjectReference{
-								Name: cm.Name,
-							},
+		},
+		Volumes: []corev1.Volume{
+			{
+				Name: "driverkit",
+				VolumeSource: corev1.VolumeSource{
+					ConfigMap: &corev1.ConfigMapVolumeSource{
+						LocalObjectReference: corev1.LocalObjectReference{
+							Name: cm.Name,
 						},
 					},
 				},
@@ -243,25 +322,30 @@ func (bp *KubernetesBuildProcessor) Start(b *builder.Build) error {
 		},
 	}
 
-	bp.Logger.Debug("starting pod",
-		bp.Logger.Args("name", pod.Name, "spec", pod.Spec.String()))
-
-	ctx := context.Background()
-	ctx = signals.WithStandardSignals(ctx)
-	_, err = configClient.Create(ctx, cm, metav1.CreateOptions{})
-	if err != nil {
-		return err
-	}
-	defer configClient.Delete(ctx, cm.Name, metav1.DeleteOptions{})
-	_, err = podClient.Create(ctx, pod, metav1.CreateOptions{})
-	if err != nil {
-		return err
+	if len(headers) > 0 {
+		podSpec.Containers[0].VolumeMounts = append(podSpec.Containers[0].VolumeMounts, corev1.VolumeMount{
+			Name:      "headers",
+			MountPath: builderHeadersDir,
+		})
+		podSpec.Volumes = append(podSpec.Volumes, corev1.Volume{
+			Name: "headers",
+			VolumeSource: corev1.VolumeSource{
+				EmptyDir: &corev1.EmptyDirVolumeSource{},
+			},
+		})
 	}
-	defer podClient.Delete(ctx, pod.Name, metav1.DeleteOptions{})
-	return bp.copyModuleAndProbeFromPodWithUID(ctx, c, b, namespace, string(uid))
+
+	return &kubernetesBuild{
+		uid:       string(uid),
+		config:    c,
+		meta:      commonMeta,
+		configMap: cm,
+		podSpec:   podSpec,
+		headers:   headers,
+	}, nil
 }
 
-func (bp *KubernetesBuildProcessor) copyModuleAndProbeFromPodWithUID(ctx context.Context, c builder.Config, build *builder.Build, namespace string, diginfraBuilderUID string) error {
+func (bp *KubernetesBuildProcessor) copyModuleAndProbeFromPodWithUID(ctx context.Context, c builder.Config, build *builder.Build, namespace string, diginfraBuilderUID string, headers []localHeader) error {
 	namespacedClient := bp.coreV1Client.Pods(namespace)
 	watch, err := namespacedClient.Watch(ctx, metav1.ListOptions{
 		LabelSelector: fmt.Sprintf("%s=%s", diginfraBuilderUIDLabel, diginfraBuilderUID),
@@ -269,16 +353,15 @@ func (bp *KubernetesBuildProcessor) copyModuleAndProbeFromPodWithUID(ctx context
 	if err != nil {
 		return err
 	}
-	// Give it ten minutes to complete, if it doesn't give an error
-	// TODO(fntlnz): maybe pass this from the outside?
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer watch.Stop()
+	// The pod is bounded by the build timeout, leave a minute to copy the drivers
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(bp.timeout)*time.Second+time.Minute)
 	defer cancel()
 	for {
 		select {
 		case <-ctx.Done():
-			return errors.New("module copy from pod interrupted before the copy was complete")
-		default:
-			event := <-watch.ResultChan()
+			return fmt.Errorf("module copy from pod interrupted before the copy was complete: %w", ctx.Err())
+		case event := <-watch.ResultChan():
 			p, ok := event.Object.(*corev1.Pod)
 			if !ok {
 				bp.Logger.Error("unexpected type when watching pods")
@@ -288,23 +371,31 @@ func (bp *KubernetesBuildProcessor) copyModuleAndProbeFromPodWithUID(ctx context
 				continue
 			}
 			if p.Status.Phase == corev1.PodRunning {
+				if len(headers) > 0 {
+					bp.Logger.Info("uploading local kernel headers to pod",
+						bp.Logger.Args(diginfraBuilderUIDLabel, diginfraBuilderUID))
+					err = uploadHeadersToPod(ctx, bp.coreV1Client, bp.clientConfig, p, headers)
+					if err != nil {
+						return err
+					}
+				}
 				bp.Logger.Info("start downloading module and probe from pod",
 					bp.Logger.Args(diginfraBuilderUIDLabel, diginfraBuilderUID))
 				if c.ModuleFilePath != "" {
-					err = copySingleFileFromPod(c.ModuleFilePath, bp.coreV1Client, bp.clientConfig, p.Namespace, p.Name, c.ToDriverFullPath(), moduleLockFile)
+					err = copySingleFileFromPod(ctx, c.ModuleFilePath, bp.coreV1Client, bp.clientConfig, p.Namespace, p.Name, c.ToDriverFullPath(), moduleLockFile)
 					if err != nil {
 						return err
 					}
 					bp.Logger.Info("Kernel Module extraction successful")
 				}
 				if c.ProbeFilePath != "" {
-					err = copySingleFileFromPod(c.ProbeFilePath, bp.coreV1Client, bp.clientConfig, p.Namespace, p.Name, c.ToProbeFullPath(), probeLockFile)
+					err = copySingleFileFromPod(ctx, c.ProbeFilePath, bp.coreV1Client, bp.clientConfig, p.Namespace, p.Name, c.ToProbeFullPath(), probeLockFile)
 					if err != nil {
 						return err
 					}
 					bp.Logger.Info("Probe Module extraction successful")
 				}
-				err = unlockPod(bp.coreV1Client, bp.clientConfig, p)
+				err = unlockPod(ctx, bp.coreV1Client, bp.clientConfig, p)
 				if err != nil {
 					return err
 				}
@@ -316,7 +407,38 @@ func (bp *KubernetesBuildProcessor) copyModuleAndProbeFromPodWithUID(ctx context
 	}
 }
 
-func unlockPod(podClient v1.PodsGetter, clientConfig *restclient.Config, pod *corev1.Pod) error {
+// uploadHeadersToPod extracts the local kernel headers into the headers volume of the pod,
+// then marks them as ready for the build script.
+func uploadHeadersToPod(ctx context.Context, podClient v1.PodsGetter, clientConfig *restclient.Config, pod *corev1.Pod, headers []localHeader) error {
+	in := headersTarReader(headers, builderHeadersDir)
+	defer in.Close()
+	options := &exec.ExecOptions{
+		PodClient: podClient,
+		Config:    clientConfig,
+		StreamOptions: exec.StreamOptions{
+			IOStreams: genericiooptions.IOStreams{
+				In:     in,
+				Out:    bytes.NewBuffer([]byte{}),
+				ErrOut: bytes.NewBuffer([]byte{}),
+			},
+			Stdin:     true,
+			Namespace: pod.Namespace,
+			PodName:   pod.Name,
+		},
+		Command: []string{
+			"/bin/bash",
+			"-c",
+			fmt.Sprintf("tar -xf - -C / && touch %s", headersReadyFile),
+		},
+		Executor: &contextRemoteExecutor{ctx: ctx},
+	}
+	if err := options.Validate(); err != nil {
+		return err
+	}
+	return options.Run()
+}
+
+func unlockPod(ctx context.Context, podClient v1.PodsGetter, clientConfig *restclient.Config, pod *corev1.Pod) error {
 	options := &exec.ExecOptions{
 		PodClient: podClient,
 		Config:    clientConfig,
@@ -333,7 +455,7 @@ func unlockPod(podClient v1.PodsGetter, clientConfig *restclient.Config, pod *co
 			"/bin/bash",
 			"/driverkit/unlock.sh",
 		},
-		Executor: &exec.DefaultRemoteExecutor{},
+		Executor: &contextRemoteExecutor{ctx: ctx},
 	}
 	if err := options.Validate(); err != nil {
 		return err
@@ -345,7 +467,7 @@ func unlockPod(podClient v1.PodsGetter, clientConfig *restclient.Config, pod *co
 	return nil
 }
 
-func copySingleFileFromPod(dstFile string, podClient v1.PodsGetter, clientConfig *restclient.Config, namespace string, podName string, fileNameToCopy string, lockFilename string) error {
+func copySingleFileFromPod(ctx context.Context, dstFile string, podClient v1.PodsGetter, clientConfig *restclient.Config, namespace string, podName string, fileNameToCopy string, lockFilename string) error {
 	if len(namespace) == 0 {
 		return errors.New("need a namespace to copy from pod")
 	}
@@ -379,7 +501,7 @@ func copySingleFileFromPod(dstFile string, podClient v1.PodsGetter, clientConfig
 			fileNameToCopy,
 			lockFilename,
 		},
-		Executor: &exec.DefaultRemoteExecutor{},
+		Executor: &contextRemoteExecutor{ctx: ctx},
 	}
 	if err := options.Validate(); err != nil {
 		return err
@@ -390,3 +512,31 @@ func copySingleFileFromPod(dstFile string, podClient v1.PodsGetter, clientConfig
 
 	return nil
 }
+
+// contextRemoteExecutor streams the exec sessions like the kubectl default executor,
+// stopping them once ctx is done.
+type contextRemoteExecutor struct {
+	ctx context.Context
+}
+
+func (e *contextRemoteExecutor) Execute(u *url.URL, config *restclient.Config, stdin io.Reader, stdout, stderr io.Writer, tty bool, terminalSizeQueue remotecommand.TerminalSizeQueue) error {
+	spdyExecutor, err := remotecommand.NewSPDYExecutor(config, "POST", u)
+	if err != nil {
+		return err
+	}
+	websocketExecutor, err := remotecommand.NewWebSocketExecutor(config, "GET", u.String())
+	if err != nil {
+		return err
+	}
+	executor, err := remotecommand.NewFallbackExecutor(websocketExecutor, spdyExecutor, httpstream.IsUpgradeFailure)
+	if err != nil {
+		return err
+	}
+	return executor.StreamWithContext(e.ctx, remotecommand.StreamOptions{
+		Stdin:             stdin,
+		Stdout:            stdout,
+		Stderr:            stderr,
+		Tty:               tty,
+		TerminalSizeQueue: terminalSizeQueue,
+	})
+}
@@ -0,0 +1,368 @@
+// SPDX-License-Identifier: Apache-2.0
+/*
+Copyright (C) 2023 The Diginfra Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driverbuilder
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"time"
+
+	"github.com/diginfra/driverkit/pkg/driverbuilder/builder"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/cli-runtime/pkg/genericiooptions"
+	batchv1client "k8s.io/client-go/kubernetes/typed/batch/v1"
+	v1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	restclient "k8s.io/client-go/rest"
+	"k8s.io/kubectl/pkg/cmd/exec"
+	"k8s.io/utils/ptr"
+)
+
+const (
+	// jobArtifactsDir is the directory, shared by the job pod containers, where the built drivers are stored.
+	jobArtifactsDir = "/driverkit-artifacts"
+	// jobDoneFile is created by driverkit once the drivers are downloaded, letting the job complete.
+	jobDoneFile = jobArtifactsDir + "/.done"
+	// jobArtifactsContainer is the name of the container serving the drivers built by the init container.
+	jobArtifactsContainer = "artifacts"
+)
+
+var waitForDoneScript = fmt.Sprintf(`
+while [ ! -f %s ]; do
+  sleep 1
+done
+`, jobDoneFile)
+
+// KubernetesJobBuildProcessor runs the build as a batch/v1 Job:
+// the build runs in an init container, so that the Job controller retries it upon failures and evictions,
+// while the main container serves the built drivers until driverkit downloads them.
+type KubernetesJobBuildProcessor struct {
+	*KubernetesBuildProcessor
+	batchV1Client           batchv1client.BatchV1Interface
+	backoffLimit            int32
+	ttlSecondsAfterFinished int32
+}
+
+// NewKubernetesJobBuildProcessor constructs a KubernetesJobBuildProcessor.
+func NewKubernetesJobBuildProcessor(corev1Client v1.CoreV1Interface,
+	batchV1Client batchv1client.BatchV1Interface,
+	clientConfig *restclient.Config,
+	runAsUser int64,
+	namespace string,
+	imagePullSecret string,
+	timeout int,
+	proxy string,
+	backoffLimit int32,
+	ttlSecondsAfterFinished int32,
+) *KubernetesJobBuildProcessor {
+	return &KubernetesJobBuildProcessor{
+		KubernetesBuildProcessor: NewKubernetesBuildProcessor(corev1Client,
+			clientConfig,
+			runAsUser,
+			namespace,
+			imagePullSecret,
+			timeout,
+			proxy),
+		batchV1Client:           batchV1Client,
+		backoffLimit:            backoffLimit,
+		ttlSecondsAfterFinished: ttlSecondsAfterFinished,
+	}
+}
+
+func (bp *KubernetesJobBuildProcessor) Start(ctx context.Context, b *builder.Build) (*BuildResult, error) {
+	bp.Printer = b.Printer
+	res := NewBuildResult(bp.String(), b)
+	return res.Finish(b, bp.run(ctx, b, res))
+}
+
+func (bp *KubernetesJobBuildProcessor) run(ctx context.Context, b *builder.Build, res *BuildResult) error {
+	if b.HeadersCacheDir != "" {
+		return errors.New("the headers cache is not supported by the kubernetes-job build processor")
+	}
+	kb, err := bp.prepareBuild(ctx, b, res, false, func(c builder.Config, script string) string {
+		// Store the drivers into the volume shared with the artifacts container
+		script = fmt.Sprintf("%s\nmkdir -p %s", script, jobArtifactsDir)
+		if c.ModuleFilePath != "" {
+			script = fmt.Sprintf("%s\ncp %s %s", script, c.ToDriverFullPath(), path.Join(jobArtifactsDir, path.Base(c.ToDriverFullPath())))
+		}
+		if c.ProbeFilePath != "" {
+			script = fmt.Sprintf("%s\ncp %s %s", script, c.ToProbeFullPath(), path.Join(jobArtifactsDir, path.Base(c.ToProbeFullPath())))
+		}
+		return script
+	})
+	if err != nil {
+		return err
+	}
+
+	// The builder container becomes an init container,
+	// the artifacts container only starts once the build succeeded.
+	artifactsMount := corev1.VolumeMount{
+		Name:      "artifacts",
+		MountPath: jobArtifactsDir,
+	}
+	buildContainer := kb.podSpec.Containers[0]
+	buildContainer.VolumeMounts = append(buildContainer.VolumeMounts, artifactsMount)
+	kb.podSpec.InitContainers = []corev1.Container{buildContainer}
+	kb.podSpec.Containers = []corev1.Container{
+		{
+			Name:            jobArtifactsContainer,
+			Image:           buildContainer.Image,
+			Command:         []string{"/bin/bash", "-c", waitForDoneScript},
+			ImagePullPolicy: corev1.PullIfNotPresent,
+			Resources: corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{
+					corev1.ResourceCPU:    resource.MustParse("10m"),
+					corev1.ResourceMemory: resource.MustParse("16Mi"),
+				},
+			},
+			VolumeMounts: []corev1.VolumeMount{artifactsMount},
+		},
+	}
+	kb.podSpec.Volumes = append(kb.podSpec.Volumes, corev1.Volume{
+		Name: "artifacts",
+		VolumeSource: corev1.VolumeSource{
+			EmptyDir: &corev1.EmptyDirVolumeSource{},
+		},
+	})
+	// Required by the pod failure policy
+	kb.podSpec.RestartPolicy = corev1.RestartPolicyNever
+
+	job := &batchv1.Job{
+		ObjectMeta: kb.meta,
+		Spec: batchv1.JobSpec{
+			ActiveDeadlineSeconds:   ptr.To(int64(bp.timeout)),
+			BackoffLimit:            ptr.To(bp.backoffLimit),
+			TTLSecondsAfterFinished: ptr.To(bp.ttlSecondsAfterFinished),
+			// Pods disrupted by evictions or preemptions do not count towards the backoff limit
+			PodFailurePolicy: &batchv1.PodFailurePolicy{
+				Rules: []batchv1.PodFailurePolicyRule{
+					{
+						Action: batchv1.PodFailurePolicyActionIgnore,
+						OnPodConditions: []batchv1.PodFailurePolicyOnPodConditionsPattern{
+							{
+								Type:   corev1.DisruptionTarget,
+								Status: corev1.ConditionTrue,
+							},
+						},
+					},
+				},
+			},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: kb.meta.Labels,
+				},
+				Spec: kb.podSpec,
+			},
+		},
+	}
+
+	bp.Logger.Debug("starting job",
+		bp.Logger.Args("name", job.Name, "spec", job.Spec.String()))
+
+	jobClient := bp.batchV1Client.Jobs(bp.namespace)
+	job, err = jobClient.Create(ctx, job, metav1.CreateOptions{})
+	if err != nil {
+		return err
+	}
+
+	// Pods wait for the config map to be created;
+	// owned by the job, it gets garbage collected together with it.
+	kb.configMap.OwnerReferences = []metav1.OwnerReference{
+		*metav1.NewControllerRef(job, batchv1.SchemeGroupVersion.WithKind("Job")),
+	}
+	if _, err = bp.coreV1Client.ConfigMaps(bp.namespace).Create(ctx, kb.configMap, metav1.CreateOptions{}); err != nil {
+		bp.deleteJob(ctx, job.Name)
+		return err
+	}
+
+	// Drivers are downloaded while the job is running, the build stage includes the copy
+	defer res.StageDone(StageBuild, time.Now())
+	// Successful jobs are cleaned up by the TTL controller
+	if err = bp.waitJobAndCopyDrivers(ctx, kb.config, job.Name, kb.uid); err != nil {
+		bp.deleteJob(ctx, job.Name)
+		return err
+	}
+	return nil
+}
+
+// deleteJob deletes the job, also when ctx has been canceled.
+func (bp *KubernetesJobBuildProcessor) deleteJob(ctx context.Context, name string) {
+	propagation := metav1.DeletePropagationBackground
+	if err := bp.batchV1Client.Jobs(bp.namespace).Delete(context.WithoutCancel(ctx), name, metav1.DeleteOptions{
+		PropagationPolicy: &propagation,
+	}); err != nil {
+		bp.Logger.Warn("error deleting job",
+			bp.Logger.Args("name", name, "err", err.Error()))
+	}
+}
+
+// waitJobAndCopyDrivers downloads the drivers from the first pod whose build succeeded,
+// then waits for the job to complete.
+func (bp *KubernetesJobBuildProcessor) waitJobAndCopyDrivers(ctx context.Context, c builder.Config, name, diginfraBuilderUID string) error {
+	// Give the job some more time than its deadline to report its status
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(bp.timeout)*time.Second+time.Minute)
+	defer cancel()
+
+	jobWatch, err := bp.batchV1Client.Jobs(bp.namespace).Watch(ctx, metav1.ListOptions{
+		FieldSelector: fields.OneTermEqualSelector("metadata.name", name).String(),
+	})
+	if err != nil {
+		return err
+	}
+	defer jobWatch.Stop()
+	podWatch, err := bp.coreV1Client.Pods(bp.namespace).Watch(ctx, metav1.ListOptions{
+		LabelSelector: fmt.Sprintf("%s=%s", diginfraBuilderUIDLabel, diginfraBuilderUID),
+	})
+	if err != nil {
+		return err
+	}
+	defer podWatch.Stop()
+
+	copied := false
+	failedPods := make(map[string]bool)
+	for {
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("job %s did not complete in time: %w", name, ctx.Err())
+		case event, ok := <-podWatch.ResultChan():
+			if !ok {
+				return errors.New("pods watch closed before the job completed")
+			}
+			p, ok := event.Object.(*corev1.Pod)
+			if !ok {
+				continue
+			}
+			switch {
+			case p.Status.Phase == corev1.PodFailed && !failedPods[p.Name]:
+				failedPods[p.Name] = true
+				bp.Logger.Warn("build pod failed, the job may retry it",
+					bp.Logger.Args("pod", p.Name, "reason", podFailureReason(p)))
+			case p.Status.Phase == corev1.PodRunning && !copied:
+				// The artifacts container runs only after the build init container succeeded
+				bp.Logger.Info("start downloading module and probe from pod",
+					bp.Logger.Args(diginfraBuilderUIDLabel, diginfraBuilderUID, "pod", p.Name))
+				if err = bp.copyDrivers(ctx, c, p); err != nil {
+					return err
+				}
+				copied = true
+				bp.Logger.Info("completed downloading from pod",
+					bp.Logger.Args(diginfraBuilderUIDLabel, diginfraBuilderUID, "pod", p.Name))
+			}
+		case event, ok := <-jobWatch.ResultChan():
+			if !ok {
+				return errors.New("job watch closed before the job completed")
+			}
+			job, ok := event.Object.(*batchv1.Job)
+			if !ok {
+				continue
+			}
+			for _, cond := range job.Status.Conditions {
+				if cond.Status != corev1.ConditionTrue {
+					continue
+				}
+				switch cond.Type {
+				case batchv1.JobFailed:
+					return fmt.Errorf("job %s failed: %s: %s", name, cond.Reason, cond.Message)
+				case batchv1.JobComplete:
+					if !copied {
+						return fmt.Errorf("job %s completed without the drivers being downloaded", name)
+					}
+					bp.Logger.Debug("job completed", bp.Logger.Args("name", name))
+					return nil
+				}
+			}
+		}
+	}
+}
+
+func (bp *KubernetesJobBuildProcessor) copyDrivers(ctx context.Context, c builder.Config, pod *corev1.Pod) error {
+	if c.ModuleFilePath != "" {
+		if err := copyFileFromContainer(ctx, bp.coreV1Client, bp.clientConfig, pod, jobArtifactsContainer,
+			path.Join(jobArtifactsDir, path.Base(c.ToDriverFullPath())), c.ModuleFilePath); err != nil {
+			return err
+		}
+		bp.Logger.Info("kernel module available", bp.Logger.Args("path", c.ModuleFilePath))
+	}
+	if c.ProbeFilePath != "" {
+		if err := copyFileFromContainer(ctx, bp.coreV1Client, bp.clientConfig, pod, jobArtifactsContainer,
+			path.Join(jobArtifactsDir, path.Base(c.ToProbeFullPath())), c.ProbeFilePath); err != nil {
+			return err
+		}
+		bp.Logger.Info("eBPF probe available", bp.Logger.Args("path", c.ProbeFilePath))
+	}
+	// Let the artifacts container exit, completing the job
+	return execInContainer(ctx, bp.coreV1Client, bp.clientConfig, pod, jobArtifactsContainer, io.Discard,
+		"touch", jobDoneFile)
+}
+
+// podFailureReason returns the most meaningful reason of a pod failure.
+func podFailureReason(pod *corev1.Pod) string {
+	if pod.Status.Reason != "" {
+		return fmt.Sprintf("%s: %s", pod.Status.Reason, pod.Status.Message)
+	}
+	for _, cs := range append(pod.Status.InitContainerStatuses, pod.Status.ContainerStatuses...) {
+		if t := cs.State.Terminated; t != nil && t.ExitCode != 0 {
+			return fmt.Sprintf("container %s terminated with exit code %d: %s", cs.Name, t.ExitCode, t.Reason)
+		}
+	}
+	return string(pod.Status.Phase)
+}
+
+func copyFileFromContainer(ctx context.Context, podClient v1.PodsGetter, clientConfig *restclient.Config, pod *corev1.Pod, container, from, to string) error {
+	out, err := os.Create(to)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	return execInContainer(ctx, podClient, clientConfig, pod, container, out, "cat", from)
+}
+
+func execInContainer(ctx context.Context, podClient v1.PodsGetter, clientConfig *restclient.Config, pod *corev1.Pod, container string, out io.Writer, command ...string) error {
+	var errOut bytes.Buffer
+	options := &exec.ExecOptions{
+		PodClient: podClient,
+		Config:    clientConfig,
+		StreamOptions: exec.StreamOptions{
+			IOStreams: genericiooptions.IOStreams{
+				Out:    out,
+				ErrOut: &errOut,
+			},
+			Stdin:         false,
+			Namespace:     pod.Namespace,
+			PodName:       pod.Name,
+			ContainerName: container,
+		},
+		Command:  command,
+		Executor: &contextRemoteExecutor{ctx: ctx},
+	}
+	if err := options.Validate(); err != nil {
+		return err
+	}
+	if err := options.Run(); err != nil {
+		if errOut.Len() > 0 {
+			return fmt.Errorf("%w: %s", err, bytes.TrimSpace(errOut.Bytes()))
+		}
+		return err
+	}
+	return nil
+}
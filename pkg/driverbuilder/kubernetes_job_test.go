@@ -0,0 +1,94 @@
+// SPDX-License-Identifier: Apache-2.0
+/*
+Copyright (C) 2023 The Diginfra Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driverbuilder
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/diginfra/diginfractl/pkg/output"
+	"github.com/diginfra/driverkit/pkg/driverbuilder/builder"
+	"github.com/pterm/pterm"
+	"gotest.tools/assert"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestKubernetesJobFailure(t *testing.T) {
+	job := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{Name: "driverkit-test", Namespace: "default"},
+	}
+	kc := fake.NewSimpleClientset(job)
+	// Events are pushed through fake watches, once they are started
+	jobWatch := watch.NewFake()
+	podWatch := watch.NewFake()
+	kc.PrependWatchReactor("jobs", k8stesting.DefaultWatchReactor(jobWatch, nil))
+	kc.PrependWatchReactor("pods", k8stesting.DefaultWatchReactor(podWatch, nil))
+	bp := NewKubernetesJobBuildProcessor(kc.CoreV1(), kc.BatchV1(), nil, 0, "default", "", 60, "", 3, 300)
+	bp.Printer = output.NewPrinter(pterm.LogLevelInfo, pterm.LogFormatterColorful, io.Discard)
+
+	errCh := make(chan error)
+	go func() {
+		errCh <- bp.waitJobAndCopyDrivers(context.Background(), builder.Config{}, job.Name, "test")
+	}()
+
+	evicted := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "driverkit-test-1", Namespace: "default"},
+		Status: corev1.PodStatus{
+			Phase:   corev1.PodFailed,
+			Reason:  "Evicted",
+			Message: "The node was low on resource: memory.",
+		},
+	}
+	assert.Equal(t, podFailureReason(evicted), "Evicted: The node was low on resource: memory.")
+	// Sending to a fake watch blocks until the event is received
+	podWatch.Add(evicted)
+
+	job.Status.Conditions = []batchv1.JobCondition{
+		{
+			Type:    batchv1.JobFailed,
+			Status:  corev1.ConditionTrue,
+			Reason:  "BackoffLimitExceeded",
+			Message: "Job has reached the specified backoff limit",
+		},
+	}
+	jobWatch.Modify(job)
+
+	select {
+	case err := <-errCh:
+		assert.Error(t, err, "job driverkit-test failed: BackoffLimitExceeded: Job has reached the specified backoff limit")
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for the job failure")
+	}
+}
+
+func TestKubernetesJobHeadersCache(t *testing.T) {
+	kc := fake.NewSimpleClientset()
+	bp := NewKubernetesJobBuildProcessor(kc.CoreV1(), kc.BatchV1(), nil, 0, "default", "", 60, "", 3, 300)
+	bp.Printer = output.NewPrinter(pterm.LogLevelInfo, pterm.LogFormatterColorful, io.Discard)
+
+	b := &builder.Build{HeadersCacheDir: t.TempDir()}
+	err := bp.run(context.Background(), b, NewBuildResult(bp.String(), b))
+	assert.Error(t, err, "the headers cache is not supported by the kubernetes-job build processor")
+	jobs, err := kc.BatchV1().Jobs("default").List(context.Background(), metav1.ListOptions{})
+	assert.NilError(t, err)
+	assert.Equal(t, len(jobs.Items), 0)
+}
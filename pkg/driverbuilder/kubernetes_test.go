@@ -0,0 +1,43 @@
+// SPDX-License-Identifier: Apache-2.0
+/*
+Copyright (C) 2023 The Diginfra Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driverbuilder
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/diginfra/diginfractl/pkg/output"
+	"github.com/diginfra/driverkit/pkg/driverbuilder/builder"
+	"github.com/pterm/pterm"
+	"gotest.tools/assert"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestKubernetesCopyCanceled(t *testing.T) {
+	kc := fake.NewSimpleClientset()
+	// The pod never shows up, only the context can end the copy
+	kc.PrependWatchReactor("pods", k8stesting.DefaultWatchReactor(watch.NewFake(), nil))
+	bp := NewKubernetesBuildProcessor(kc.CoreV1(), nil, 0, "default", "", 60, "")
+	bp.Printer = output.NewPrinter(pterm.LogLevelInfo, pterm.LogFormatterColorful, io.Discard)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := bp.copyModuleAndProbeFromPodWithUID(ctx, builder.Config{}, &builder.Build{}, "default", "test", nil)
+	assert.Assert(t, errors.Is(err, context.Canceled), "unexpected error: %v", err)
+}
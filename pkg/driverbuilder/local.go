@@ -14,6 +14,7 @@ import (
 	"os/exec"
 	"os/user"
 	"path/filepath"
+	"strings"
 	"time"
 )
 
@@ -55,8 +56,14 @@ func (lbp *LocalBuildProcessor) String() string {
 	return LocalBuildProcessorName
 }
 
-func (lbp *LocalBuildProcessor) Start(b *builder.Build) error {
+func (lbp *LocalBuildProcessor) Start(ctx context.Context, b *builder.Build) (*BuildResult, error) {
 	lbp.Printer = b.Printer
+	res := NewBuildResult(lbp.String(), b)
+	return res.Finish(b, lbp.run(ctx, b, res))
+}
+
+func (lbp *LocalBuildProcessor) run(ctx context.Context, b *builder.Build, res *BuildResult) error {
+	stageStart := time.Now()
 	if lbp.useDKMS {
 		currentUser, err := user.Current()
 		if err != nil {
@@ -68,7 +75,10 @@ func (lbp *LocalBuildProcessor) Start(b *builder.Build) error {
 	}
 
 	// We don't want to download headers
-	kr := b.KernelReleaseFromBuildConfig()
+	kr, err := b.KernelReleaseFromBuildConfig()
+	if err != nil {
+		return err
+	}
 
 	if lbp.downloadHeaders {
 		// Download headers for current distro
@@ -78,12 +88,17 @@ func (lbp *LocalBuildProcessor) Start(b *builder.Build) error {
 		// Go on skipping automatic kernel headers download.
 		if err == nil {
 			lbp.Logger.Info("Trying automatic kernel headers download.")
-			kernelDownloadScript, err := builder.KernelDownloadScript(realBuilder, nil, kr, lbp.Printer)
+			kernelDownloadScript, kernelURLs, err := builder.KernelDownloadScript(builder.WithMirrors(ctx, b.Mirrors), realBuilder, nil, kr, lbp.Printer)
+			res.KernelHeadersURLs = kernelURLs
+			if err == nil {
+				// Local and cached headers are extracted straight from the host
+				kernelDownloadScript, _, err = localizeHeaders(ctx, b, realBuilder, kr, kernelURLs, kernelDownloadScript, "", res)
+			}
 			// Patch kernel download script to echo KERNELDIR.
 			// We need to capture KERNELDIR to later pass it as env variable to the build.
 			kernelDownloadScript += "\necho $KERNELDIR"
 			if err == nil {
-				out, err := exec.Command("bash", "-c", kernelDownloadScript).Output()
+				out, err := exec.CommandContext(ctx, "bash", "-c", kernelDownloadScript).Output()
 				if err == nil {
 					// Scan all stdout line by line and
 					// store last line as KERNELDIR path.
@@ -159,20 +174,27 @@ func (lbp *LocalBuildProcessor) Start(b *builder.Build) error {
 
 	if len(lbp.srcDir) == 0 {
 		lbp.Logger.Info("Downloading driver sources")
+		res.LibsDownloadURL = c.LibsDownloadURL()
 		// Download src!
 		libsDownloadScript, err := builder.LibsDownloadScript(c)
 		if err != nil {
 			return err
 		}
-		_, err = exec.Command("/bin/bash", "-c", libsDownloadScript).CombinedOutput()
+		_, err = exec.CommandContext(ctx, "/bin/bash", "-c", libsDownloadScript).CombinedOutput()
 		if err != nil {
 			return err
 		}
 	}
 
+	res.StageDone(StagePrepare, stageStart)
+	stageStart = time.Now()
+	defer res.StageDone(StageBuild, stageStart)
+
 	for _, gcc := range gccs {
 		vv.GccPath = gcc
 		if c.ModuleFilePath != "" {
+			res.GCCPath = gcc
+			res.GCCVersion = localGCCVersion(ctx, gcc)
 			lbp.Logger.Info("Trying to dkms install module.", lbp.Logger.Args("gcc", gcc))
 		}
 		if c.ProbeFilePath != "" {
@@ -180,13 +202,13 @@ func (lbp *LocalBuildProcessor) Start(b *builder.Build) error {
 		}
 
 		// Generate the build script from the builder
-		driverkitScript, err := builder.Script(v, c, kr)
+		driverkitScript, err := builder.Script(ctx, v, c, kr)
 		if err != nil {
 			return err
 		}
-		ctx, cancelFunc := context.WithTimeout(context.Background(), time.Duration(lbp.timeout)*time.Second)
+		buildCtx, cancelFunc := context.WithTimeout(ctx, time.Duration(lbp.timeout)*time.Second)
 		defer cancelFunc()
-		cmd := exec.CommandContext(ctx, "/bin/bash", "-c", driverkitScript)
+		cmd := exec.CommandContext(buildCtx, "/bin/bash", "-c", driverkitScript)
 		cmd.Env = os.Environ()
 		// Append requested env variables to the command env
 		for key, val := range lbp.envMap {
@@ -249,6 +271,16 @@ func (lbp *LocalBuildProcessor) Start(b *builder.Build) error {
 	return nil
 }
 
+// localGCCVersion returns the version of the given gcc, e.g. 12.2.0 for /usr/bin/gcc-12, or an empty string if unknown.
+func localGCCVersion(ctx context.Context, gcc string) string {
+	// gcc older than 7 does not support -dumpfullversion, and falls back to -dumpversion
+	out, err := exec.CommandContext(ctx, gcc, "-dumpfullversion", "-dumpversion").Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
+
 func copyDataToLocalPath(src, dest string) error {
 	in, err := os.Open(filepath.Clean(src))
 	if err != nil {
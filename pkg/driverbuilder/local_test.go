@@ -0,0 +1,31 @@
+// SPDX-License-Identifier: Apache-2.0
+/*
+Copyright (C) 2023 The Diginfra Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driverbuilder
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"gotest.tools/assert"
+)
+
+func TestLocalGCCVersion(t *testing.T) {
+	gcc := filepath.Join(t.TempDir(), "gcc-12")
+	assert.NilError(t, os.WriteFile(gcc, []byte("#!/bin/sh\n[ \"$1\" = -dumpfullversion ] && echo 12.2.0\n"), 0o755))
+	assert.Equal(t, localGCCVersion(context.Background(), gcc), "12.2.0")
+	assert.Equal(t, localGCCVersion(context.Background(), filepath.Join(t.TempDir(), "missing")), "")
+}
@@ -14,7 +14,11 @@ limitations under the License.
 
 package driverbuilder
 
-import "github.com/diginfra/driverkit/pkg/driverbuilder/builder"
+import (
+	"context"
+
+	"github.com/diginfra/driverkit/pkg/driverbuilder/builder"
+)
 
 type NopBuildProcessor struct {
 }
@@ -27,6 +31,6 @@ func (bp *NopBuildProcessor) String() string {
 	return "no-op"
 }
 
-func (bp *NopBuildProcessor) Start(b *builder.Build) error {
-	return nil
+func (bp *NopBuildProcessor) Start(_ context.Context, b *builder.Build) (*BuildResult, error) {
+	return NewBuildResult(bp.String(), b).Finish(b, nil)
 }
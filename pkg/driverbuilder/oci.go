@@ -0,0 +1,168 @@
+// SPDX-License-Identifier: Apache-2.0
+/*
+Copyright (C) 2023 The Diginfra Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driverbuilder
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/diginfra/driverkit/pkg/driverbuilder/builder"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"oras.land/oras-go/v2"
+	"oras.land/oras-go/v2/content"
+	"oras.land/oras-go/v2/registry/remote"
+)
+
+// Media types of the pushed drivers.
+const (
+	OCIArtifactType    = "application/vnd.diginfra.driverkit.drivers.v1"
+	OCIModuleMediaType = "application/vnd.diginfra.driverkit.module.v1"
+	OCIProbeMediaType  = "application/vnd.diginfra.driverkit.probe.v1"
+)
+
+// Annotations of the pushed drivers manifest.
+const (
+	OCIAnnotationTarget        = "io.diginfra.driverkit.target"
+	OCIAnnotationKernelRelease = "io.diginfra.driverkit.kernelrelease"
+	OCIAnnotationKernelVersion = "io.diginfra.driverkit.kernelversion"
+	OCIAnnotationArchitecture  = "io.diginfra.driverkit.architecture"
+	OCIAnnotationDriverVersion = "io.diginfra.driverkit.driverversion"
+	OCIAnnotationGCCVersion    = "io.diginfra.driverkit.gccversion"
+)
+
+// StagePush is the stage pushing the drivers to the OCI registry.
+const StagePush = "push"
+
+const maxOCITagLength = 128
+
+var invalidOCITagChars = regexp.MustCompile(`[^a-zA-Z0-9_.-]`)
+
+// OCIPushBuildProcessor wraps a BuildProcessor, pushing the built drivers
+// to an OCI repository as a single artifact once the build succeeds.
+type OCIPushBuildProcessor struct {
+	BuildProcessor
+	repo string
+}
+
+// NewOCIPushBuildProcessor wraps the given BuildProcessor, pushing the built drivers to repo.
+func NewOCIPushBuildProcessor(processor BuildProcessor, repo string) *OCIPushBuildProcessor {
+	return &OCIPushBuildProcessor{
+		BuildProcessor: processor,
+		repo:           repo,
+	}
+}
+
+func (op *OCIPushBuildProcessor) Start(ctx context.Context, b *builder.Build) (*BuildResult, error) {
+	res, err := op.BuildProcessor.Start(ctx, b)
+	if err != nil || len(res.Artifacts) == 0 {
+		return res, err
+	}
+
+	stageStart := time.Now()
+	repo, err := remote.NewRepository(op.repo)
+	if err != nil {
+		return res.Finish(b, err)
+	}
+	repo.PlainHTTP = b.RegistryPlainHTTP
+	repo.Client = b.ClientForRegistry(repo.Reference.Registry)
+	if res.GCCVersion == "" {
+		// Not all the processors report the gcc version they picked
+		res.GCCVersion = b.GCCVersion
+	}
+
+	tag := OCITag(res)
+	b.Logger.Info("pushing drivers", b.Logger.Args("repo", op.repo, "tag", tag))
+	if err = pushDrivers(ctx, repo, tag, res); err != nil {
+		return res.Finish(b, fmt.Errorf("error pushing drivers to %s: %w", op.repo, err))
+	}
+	res.OCIReference = fmt.Sprintf("%s:%s", op.repo, tag)
+	res.StageDone(StagePush, stageStart)
+	b.Logger.Info("drivers pushed", b.Logger.Args("ref", res.OCIReference))
+	return res, nil
+}
+
+// OCITag returns the tag of the pushed drivers of the given build,
+// that is "<driverversion>_<architecture>_<target>_<kernelrelease>_<kernelversion>_gcc<gccversion>"
+// with the characters not allowed in tags replaced by underscores.
+// The gcc version is part of the tag, so that builds with different gcc versions do not overwrite each other.
+func OCITag(res *BuildResult) string {
+	parts := []string{res.DriverVersion, res.Architecture, res.Target, res.KernelRelease, res.KernelVersion}
+	if res.GCCVersion != "" {
+		parts = append(parts, "gcc"+res.GCCVersion)
+	}
+	tag := strings.Join(parts, "_")
+	tag = invalidOCITagChars.ReplaceAllString(tag, "_")
+	if len(tag) > maxOCITagLength {
+		// Keep the tag unique while truncating it
+		sum := sha256.Sum256([]byte(tag))
+		suffix := "-" + hex.EncodeToString(sum[:])[:16]
+		tag = tag[:maxOCITagLength-len(suffix)] + suffix
+	}
+	return tag
+}
+
+// pushDrivers pushes the artifacts of the given result to target,
+// tagging the manifest with the given tag.
+func pushDrivers(ctx context.Context, target oras.Target, tag string, res *BuildResult) error {
+	var layers []ocispec.Descriptor
+	for _, artifact := range res.Artifacts {
+		mediaType := OCIModuleMediaType
+		if artifact.Kind == ArtifactKindProbe {
+			mediaType = OCIProbeMediaType
+		}
+		data, err := os.ReadFile(filepath.Clean(artifact.Path))
+		if err != nil {
+			return err
+		}
+		desc := content.NewDescriptorFromBytes(mediaType, data)
+		desc.Annotations = map[string]string{
+			ocispec.AnnotationTitle: filepath.Base(artifact.Path),
+		}
+		exists, err := target.Exists(ctx, desc)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			if err = target.Push(ctx, desc, bytes.NewReader(data)); err != nil {
+				return err
+			}
+		}
+		layers = append(layers, desc)
+	}
+
+	manifest, err := oras.PackManifest(ctx, target, oras.PackManifestVersion1_1, OCIArtifactType, oras.PackManifestOptions{
+		Layers: layers,
+		ManifestAnnotations: map[string]string{
+			OCIAnnotationTarget:        res.Target,
+			OCIAnnotationKernelRelease: res.KernelRelease,
+			OCIAnnotationKernelVersion: res.KernelVersion,
+			OCIAnnotationArchitecture:  res.Architecture,
+			OCIAnnotationDriverVersion: res.DriverVersion,
+			OCIAnnotationGCCVersion:    res.GCCVersion,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	return target.Tag(ctx, manifest, tag)
+}
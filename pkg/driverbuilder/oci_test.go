@@ -0,0 +1,94 @@
+// SPDX-License-Identifier: Apache-2.0
+/*
+Copyright (C) 2023 The Diginfra Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driverbuilder
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"gotest.tools/assert"
+	"oras.land/oras-go/v2/content"
+	"oras.land/oras-go/v2/content/memory"
+)
+
+func TestOCITag(t *testing.T) {
+	res := &BuildResult{
+		Target:        "ubuntu-generic",
+		KernelRelease: "5.15.0-1-generic",
+		KernelVersion: "1",
+		Architecture:  "amd64",
+		DriverVersion: "7.0.0+driver",
+	}
+	assert.Equal(t, "7.0.0_driver_amd64_ubuntu-generic_5.15.0-1-generic_1", OCITag(res))
+	res.GCCVersion = "9.0.0"
+	assert.Equal(t, "7.0.0_driver_amd64_ubuntu-generic_5.15.0-1-generic_1_gcc9.0.0", OCITag(res))
+
+	res.KernelRelease = strings.Repeat("a", 200)
+	tag := OCITag(res)
+	assert.Equal(t, maxOCITagLength, len(tag))
+	res.KernelRelease = strings.Repeat("a", 199) + "b"
+	assert.Assert(t, tag != OCITag(res))
+}
+
+func TestPushDrivers(t *testing.T) {
+	dir := t.TempDir()
+	modulePath := filepath.Join(dir, "diginfra.ko")
+	probePath := filepath.Join(dir, "diginfra.o")
+	assert.NilError(t, os.WriteFile(modulePath, []byte("module"), 0o644))
+	assert.NilError(t, os.WriteFile(probePath, []byte("probe"), 0o644))
+	res := &BuildResult{
+		Target:        "ubuntu-generic",
+		KernelRelease: "5.15.0-1-generic",
+		KernelVersion: "1",
+		Architecture:  "amd64",
+		DriverVersion: "7.0.0+driver",
+		GCCVersion:    "9.0.0",
+		Artifacts: []BuildArtifact{
+			{Kind: ArtifactKindModule, Path: modulePath},
+			{Kind: ArtifactKindProbe, Path: probePath},
+		},
+	}
+
+	ctx := context.Background()
+	store := memory.New()
+	tag := OCITag(res)
+	assert.NilError(t, pushDrivers(ctx, store, tag, res))
+	// Pushing again the same drivers is fine
+	assert.NilError(t, pushDrivers(ctx, store, tag, res))
+
+	desc, err := store.Resolve(ctx, tag)
+	assert.NilError(t, err)
+	data, err := content.FetchAll(ctx, store, desc)
+	assert.NilError(t, err)
+	var manifest ocispec.Manifest
+	assert.NilError(t, json.Unmarshal(data, &manifest))
+
+	assert.Equal(t, OCIArtifactType, manifest.ArtifactType)
+	assert.Equal(t, "5.15.0-1-generic", manifest.Annotations[OCIAnnotationKernelRelease])
+	assert.Equal(t, "9.0.0", manifest.Annotations[OCIAnnotationGCCVersion])
+	assert.Equal(t, 2, len(manifest.Layers))
+	assert.Equal(t, OCIModuleMediaType, manifest.Layers[0].MediaType)
+	assert.Equal(t, "diginfra.ko", manifest.Layers[0].Annotations[ocispec.AnnotationTitle])
+	assert.Equal(t, OCIProbeMediaType, manifest.Layers[1].MediaType)
+
+	module, err := content.FetchAll(ctx, store, manifest.Layers[0])
+	assert.NilError(t, err)
+	assert.Equal(t, "module", string(module))
+}
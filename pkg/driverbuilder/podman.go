@@ -0,0 +1,591 @@
+// SPDX-License-Identifier: Apache-2.0
+/*
+Copyright (C) 2023 The Diginfra Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driverbuilder
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"time"
+
+	"github.com/diginfra/diginfractl/pkg/output"
+	"github.com/diginfra/driverkit/pkg/driverbuilder/builder"
+	"github.com/diginfra/driverkit/pkg/kernelrelease"
+	"github.com/docker/docker/pkg/archive"
+	"k8s.io/apimachinery/pkg/util/uuid"
+)
+
+// PodmanBuildProcessorName is a constant containing the podman name.
+const PodmanBuildProcessorName = "podman"
+
+// podmanAPIVersion is the libpod REST API version used; supported since podman 4.
+const podmanAPIVersion = "v4.0.0"
+
+// podmanExecInspectRetries is the number of times an exec session still running
+// after the end of its output stream is inspected again.
+const podmanExecInspectRetries = 20
+
+type PodmanBuildProcessor struct {
+	clean   bool
+	timeout int
+	proxy   string
+	*output.Printer
+}
+
+// NewPodmanBuildProcessor ...
+func NewPodmanBuildProcessor(timeout int, proxy string) *PodmanBuildProcessor {
+	return &PodmanBuildProcessor{
+		timeout: timeout,
+		proxy:   proxy,
+	}
+}
+
+func (bp *PodmanBuildProcessor) String() string {
+	return PodmanBuildProcessorName
+}
+
+func (bp *PodmanBuildProcessor) checkArchUseQemu(ctx context.Context, b *builder.Build, cli *podmanClient) error {
+	if b.Architecture == runtime.GOARCH {
+		// Nothing to do
+		return nil
+	}
+
+	if runtime.GOARCH != kernelrelease.ArchitectureAmd64 {
+		// See https://github.com/multiarch/qemu-user-static#supported-host-architectures
+		return fmt.Errorf("%w: qemu-user-static image is only available for x86_64 hosts, cannot build for %s on %s",
+			kernelrelease.ErrUnsupportedArch, b.Architecture, runtime.GOARCH)
+	}
+
+	bp.Logger.Debug("using qemu for cross build")
+	if err := bp.ensureImage(ctx, cli, "docker.io/multiarch/qemu-user-static", ""); err != nil {
+		return err
+	}
+
+	id, err := cli.containerCreate(ctx, podmanContainerSpec{
+		Image:      "docker.io/multiarch/qemu-user-static",
+		Command:    []string{"--reset", "-p", "yes"},
+		Remove:     true,
+		Privileged: true,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create qemu container: %w", err)
+	}
+	if err = cli.containerStart(ctx, id); err != nil {
+		return err
+	}
+	return cli.containerWait(ctx, id)
+}
+
+// ensureImage pulls the image unless it is already available for the given architecture.
+func (bp *PodmanBuildProcessor) ensureImage(ctx context.Context, cli *podmanClient, image, arch string) error {
+	inspect, err := cli.imageInspect(ctx, image)
+	if err == nil && (arch == "" || inspect.Architecture == arch) {
+		return nil
+	}
+	if err != nil && !isPodmanNotFound(err) {
+		return err
+	}
+	bp.Logger.Debug("pulling builder image",
+		bp.Logger.Args("image", image, "arch", arch))
+	return cli.imagePull(ctx, image, arch)
+}
+
+// Start the podman processor
+func (bp *PodmanBuildProcessor) Start(ctx context.Context, b *builder.Build) (*BuildResult, error) {
+	bp.Printer = b.Printer
+	res := NewBuildResult(bp.String(), b)
+	return res.Finish(b, bp.run(ctx, b, res))
+}
+
+func (bp *PodmanBuildProcessor) run(ctx context.Context, b *builder.Build, res *BuildResult) error {
+	stageStart := time.Now()
+
+	socket, err := podmanSocketPath()
+	if err != nil {
+		return err
+	}
+	cli := newPodmanClient(socket)
+
+	kr, err := b.KernelReleaseFromBuildConfig()
+	if err != nil {
+		return err
+	}
+
+	// create a builder based on the choosen build type
+	v, err := builder.Factory(b.TargetType)
+	if err != nil {
+		return err
+	}
+	c := b.ToConfig()
+
+	libsDownloadScript, err := builder.LibsDownloadScript(c)
+	if err != nil {
+		return err
+	}
+
+	kernelDownloadScript, kernelURLs, err := builder.KernelDownloadScript(builder.WithMirrors(ctx, c.Mirrors), v, c.KernelUrls, kr, b.Printer)
+	if err != nil {
+		return err
+	}
+	res.KernelHeadersURLs = kernelURLs
+	res.LibsDownloadURL = c.LibsDownloadURL()
+	kernelDownloadScript, headers, err := localizeHeaders(ctx, b, v, kr, kernelURLs, kernelDownloadScript, builderHeadersDir, res)
+	if err != nil {
+		return err
+	}
+
+	// Generate the build script from the builder
+	driverkitScript, err := builder.Script(ctx, v, c, kr)
+	if err != nil {
+		return err
+	}
+
+	configDecoded, err := base64.StdEncoding.DecodeString(b.KernelConfigData)
+	if err != nil {
+		return err
+	}
+
+	builderImage, err := b.GetBuilderImage()
+	if err != nil {
+		return err
+	}
+	res.BuilderImage = builderImage
+	res.GCCVersion = b.GCCVersion
+	res.StageDone(StagePrepare, stageStart)
+
+	// Stops the cleanup goroutine once the build is over
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	stageStart = time.Now()
+
+	bp.Logger.Debug("using podman socket", bp.Logger.Args("path", socket))
+	if err = bp.checkArchUseQemu(ctx, b, cli); err != nil {
+		return err
+	}
+
+	if err = bp.ensureImage(ctx, cli, builderImage, b.Architecture); err != nil {
+		return err
+	}
+
+	res.StageDone(StagePull, stageStart)
+
+	bp.Logger.Debug("starting container", bp.Logger.Args("image", builderImage))
+	stageStart = time.Now()
+
+	spec := podmanContainerSpec{
+		Name:    fmt.Sprintf("driverkit-%s", string(uuid.NewUUID())),
+		Image:   builderImage,
+		Command: []string{"/bin/sleep", strconv.Itoa(bp.timeout)},
+		Remove:  true,
+	}
+	// check for any overridden builder image network modes by the builder
+	if vv, ok := v.(builder.BuilderImageNetworkMode); ok {
+		spec.Netns = &podmanNamespace{NSMode: vv.BuilderImageNetMode()}
+	}
+
+	id, err := cli.containerCreate(ctx, spec)
+	if err != nil {
+		return err
+	}
+
+	defer bp.cleanup(cli, id)
+	go func() {
+		<-ctx.Done()
+		bp.cleanup(cli, id)
+	}()
+
+	if err = cli.containerStart(ctx, id); err != nil {
+		return err
+	}
+
+	// Same steps of the docker processor
+	runCmd :=
+		`
+#!/bin/bash
+
+chmod +x /driverkit/download-libs.sh
+chmod +x /driverkit/download-headers.sh
+chmod +x /driverkit/driverkit.sh
+
+/driverkit/download-libs.sh
+. /driverkit/download-headers.sh
+/driverkit/driverkit.sh
+`
+
+	files := []dockerCopyFile{
+		{"/driverkit/download-libs.sh", libsDownloadScript},
+		{"/driverkit/download-headers.sh", kernelDownloadScript},
+		{"/driverkit/driverkit.sh", driverkitScript},
+		{"/driverkit/cmd.sh", runCmd},
+		{"/driverkit/kernel.config", string(configDecoded)},
+	}
+
+	var buf bytes.Buffer
+	if err = tarWriterFiles(&buf, files); err != nil {
+		return err
+	}
+	// Copy the needed files to the container
+	if err = cli.copyToContainer(ctx, id, "/", &buf); err != nil {
+		return err
+	}
+	// Copy the local kernel headers to the container
+	if len(headers) > 0 {
+		if err = cli.copyToContainer(ctx, id, "/", headersTarReader(headers, builderHeadersDir)); err != nil {
+			return err
+		}
+	}
+
+	// Construct environment variable array of string
+	var envs []string
+	// Add http_proxy and https_proxy environment variable
+	if bp.proxy != "" {
+		envs = append(envs,
+			fmt.Sprintf("http_proxy=%s", bp.proxy),
+			fmt.Sprintf("https_proxy=%s", bp.proxy),
+		)
+	}
+
+	execID, err := cli.execCreate(ctx, id, []string{"/bin/bash", "-l", "/driverkit/cmd.sh"}, envs)
+	if err != nil {
+		return err
+	}
+
+	execRes, err := cli.execStart(ctx, execID)
+	if err != nil {
+		return err
+	}
+	// Without a tty, the exec output is always multiplexed, whatever the content type announced by podman
+	multiplexedForwardLogs(bp.Logger, execRes.Body)
+	_ = execRes.Body.Close()
+	exitCode, err := cli.execInspect(ctx, execID)
+	if err != nil {
+		return err
+	}
+	if exitCode != 0 {
+		return fmt.Errorf("build script exited with code %d", exitCode)
+	}
+	res.StageDone(StageBuild, stageStart)
+
+	stageStart = time.Now()
+	defer res.StageDone(StageCopy, stageStart)
+
+	if len(b.ModuleFilePath) > 0 {
+		if err = cli.copyFromContainer(ctx, id, c.ToDriverFullPath(), b.ModuleFilePath); err != nil {
+			return err
+		}
+		bp.Logger.Info("kernel module available", bp.Logger.Args("path", b.ModuleFilePath))
+	}
+
+	if len(b.ProbeFilePath) > 0 {
+		if err = cli.copyFromContainer(ctx, id, c.ToProbeFullPath(), b.ProbeFilePath); err != nil {
+			return err
+		}
+		bp.Logger.Info("eBPF probe available", bp.Logger.Args("path", b.ProbeFilePath))
+	}
+
+	return nil
+}
+
+func (bp *PodmanBuildProcessor) cleanup(cli *podmanClient, ID string) {
+	if !bp.clean {
+		bp.clean = true
+		bp.Logger.Debug("context canceled")
+		// The build context may be already canceled
+		if err := cli.containerStop(context.Background(), ID, 1); err != nil && !isPodmanNotFound(err) {
+			bp.Logger.Error("error stopping container",
+				bp.Logger.Args("err", err.Error()))
+		}
+	}
+}
+
+// podmanSocketPath returns the path of the podman API socket:
+// the one set through CONTAINER_HOST, if any, otherwise the rootless or rootful default one.
+func podmanSocketPath() (string, error) {
+	if host := os.Getenv("CONTAINER_HOST"); host != "" {
+		u, err := url.Parse(host)
+		if err != nil {
+			return "", err
+		}
+		if u.Scheme != "unix" {
+			return "", fmt.Errorf("unsupported CONTAINER_HOST scheme %q: only unix sockets are supported", u.Scheme)
+		}
+		return u.Path, nil
+	}
+	if uid := os.Geteuid(); uid != 0 {
+		if runtimeDir := os.Getenv("XDG_RUNTIME_DIR"); runtimeDir != "" {
+			return filepath.Join(runtimeDir, "podman", "podman.sock"), nil
+		}
+		return fmt.Sprintf("/run/user/%d/podman/podman.sock", uid), nil
+	}
+	return "/run/podman/podman.sock", nil
+}
+
+// podmanClient is a minimal client of the libpod REST API.
+type podmanClient struct {
+	httpClient *http.Client
+	baseURL    string
+}
+
+// podmanError is the error returned by the libpod REST API.
+type podmanError struct {
+	StatusCode int    `json:"response"`
+	Message    string `json:"message"`
+}
+
+func (e *podmanError) Error() string {
+	return fmt.Sprintf("podman API error (%d): %s", e.StatusCode, e.Message)
+}
+
+func isPodmanNotFound(err error) bool {
+	var perr *podmanError
+	return errors.As(err, &perr) && perr.StatusCode == http.StatusNotFound
+}
+
+type podmanNamespace struct {
+	NSMode string `json:"nsmode"`
+}
+
+// podmanContainerSpec is the subset of the libpod SpecGenerator used by driverkit.
+type podmanContainerSpec struct {
+	Name       string           `json:"name,omitempty"`
+	Image      string           `json:"image"`
+	Command    []string         `json:"command,omitempty"`
+	Remove     bool             `json:"remove,omitempty"`
+	Privileged bool             `json:"privileged,omitempty"`
+	Netns      *podmanNamespace `json:"netns,omitempty"`
+}
+
+func newPodmanClient(socket string) *podmanClient {
+	return &podmanClient{
+		httpClient: &http.Client{
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					var d net.Dialer
+					return d.DialContext(ctx, "unix", socket)
+				},
+			},
+		},
+		// Host is ignored when dialing the unix socket
+		baseURL: "http://podman/" + podmanAPIVersion + "/libpod",
+	}
+}
+
+// do sends the request, returning a *podmanError for unsuccessful status codes.
+// The caller must close the body of the returned response.
+func (pc *podmanClient) do(ctx context.Context, method, path string, query url.Values, body io.Reader, contentType string) (*http.Response, error) {
+	u := pc.baseURL + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, body)
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	res, err := pc.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode >= http.StatusBadRequest {
+		defer res.Body.Close()
+		perr := &podmanError{}
+		data, _ := io.ReadAll(res.Body)
+		if json.Unmarshal(data, perr) != nil || perr.Message == "" {
+			perr.Message = string(bytes.TrimSpace(data))
+		}
+		perr.StatusCode = res.StatusCode
+		return nil, perr
+	}
+	return res, nil
+}
+
+// doJSON sends the request with a json body, decoding the json response into out, if not nil.
+func (pc *podmanClient) doJSON(ctx context.Context, method, path string, query url.Values, in, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+	res, err := pc.do(ctx, method, path, query, body, "application/json")
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if out != nil {
+		return json.NewDecoder(res.Body).Decode(out)
+	}
+	_, err = io.Copy(io.Discard, res.Body)
+	return err
+}
+
+type podmanImageInspect struct {
+	Architecture string `json:"Architecture"`
+}
+
+func (pc *podmanClient) imageInspect(ctx context.Context, image string) (*podmanImageInspect, error) {
+	var inspect podmanImageInspect
+	if err := pc.doJSON(ctx, http.MethodGet, "/images/"+url.PathEscape(image)+"/json", nil, nil, &inspect); err != nil {
+		return nil, err
+	}
+	return &inspect, nil
+}
+
+func (pc *podmanClient) imagePull(ctx context.Context, image, arch string) error {
+	query := url.Values{"reference": {image}}
+	if arch != "" {
+		query.Set("arch", arch)
+	}
+	res, err := pc.do(ctx, http.MethodPost, "/images/pull", query, nil, "")
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	// The pull report is a stream of json objects; errors are reported inside it.
+	dec := json.NewDecoder(res.Body)
+	for {
+		var report struct {
+			Error string `json:"error"`
+		}
+		if err = dec.Decode(&report); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if report.Error != "" {
+			return fmt.Errorf("failed to pull image %s: %s", image, report.Error)
+		}
+	}
+}
+
+func (pc *podmanClient) containerCreate(ctx context.Context, spec podmanContainerSpec) (string, error) {
+	var res struct {
+		ID string `json:"Id"`
+	}
+	if err := pc.doJSON(ctx, http.MethodPost, "/containers/create", nil, spec, &res); err != nil {
+		return "", err
+	}
+	return res.ID, nil
+}
+
+func (pc *podmanClient) containerStart(ctx context.Context, id string) error {
+	return pc.doJSON(ctx, http.MethodPost, "/containers/"+id+"/start", nil, nil, nil)
+}
+
+func (pc *podmanClient) containerWait(ctx context.Context, id string) error {
+	return pc.doJSON(ctx, http.MethodPost, "/containers/"+id+"/wait", url.Values{"condition": {"stopped"}}, nil, nil)
+}
+
+func (pc *podmanClient) containerStop(ctx context.Context, id string, timeout int) error {
+	return pc.doJSON(ctx, http.MethodPost, "/containers/"+id+"/stop", url.Values{"timeout": {strconv.Itoa(timeout)}}, nil, nil)
+}
+
+func (pc *podmanClient) copyToContainer(ctx context.Context, id, path string, tarball io.Reader) error {
+	res, err := pc.do(ctx, http.MethodPut, "/containers/"+id+"/archive", url.Values{"path": {path}}, tarball, "application/x-tar")
+	if err != nil {
+		return err
+	}
+	return res.Body.Close()
+}
+
+func (pc *podmanClient) copyFromContainer(ctx context.Context, id, from, to string) error {
+	res, err := pc.do(ctx, http.MethodGet, "/containers/"+id+"/archive", url.Values{"path": {from}}, nil, "")
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	srcInfo := archive.CopyInfo{
+		Path:   from,
+		Exists: true,
+	}
+	return archive.CopyTo(res.Body, srcInfo, to)
+}
+
+func (pc *podmanClient) execCreate(ctx context.Context, id string, cmd, env []string) (string, error) {
+	req := struct {
+		AttachStdout bool     `json:"AttachStdout"`
+		AttachStderr bool     `json:"AttachStderr"`
+		Cmd          []string `json:"Cmd"`
+		Env          []string `json:"Env,omitempty"`
+	}{
+		AttachStdout: true,
+		AttachStderr: true,
+		Cmd:          cmd,
+		Env:          env,
+	}
+	var res struct {
+		ID string `json:"Id"`
+	}
+	if err := pc.doJSON(ctx, http.MethodPost, "/containers/"+id+"/exec", nil, req, &res); err != nil {
+		return "", err
+	}
+	return res.ID, nil
+}
+
+// execStart starts the exec session and returns the response streaming its output,
+// that is closed by podman when the command ends.
+func (pc *podmanClient) execStart(ctx context.Context, id string) (*http.Response, error) {
+	data, err := json.Marshal(struct {
+		Detach bool `json:"Detach"`
+		Tty    bool `json:"Tty"`
+	}{})
+	if err != nil {
+		return nil, err
+	}
+	return pc.do(ctx, http.MethodPost, "/exec/"+id+"/start", nil, bytes.NewReader(data), "application/json")
+}
+
+// execInspect returns the exit code of the given exec session,
+// waiting for podman to record it after the output stream ends.
+func (pc *podmanClient) execInspect(ctx context.Context, id string) (int, error) {
+	for i := 0; ; i++ {
+		var res struct {
+			Running  bool `json:"Running"`
+			ExitCode int  `json:"ExitCode"`
+		}
+		if err := pc.doJSON(ctx, http.MethodGet, "/exec/"+id+"/json", nil, nil, &res); err != nil {
+			return 0, err
+		}
+		if !res.Running {
+			return res.ExitCode, nil
+		}
+		if i == podmanExecInspectRetries {
+			return 0, fmt.Errorf("exec session %s still running", id)
+		}
+		select {
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+}
@@ -0,0 +1,79 @@
+// SPDX-License-Identifier: Apache-2.0
+/*
+Copyright (C) 2023 The Diginfra Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driverbuilder
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"path/filepath"
+	"testing"
+
+	"gotest.tools/assert"
+)
+
+func TestPodmanSocketPath(t *testing.T) {
+	t.Setenv("CONTAINER_HOST", "unix:///run/user/1000/podman/podman.sock")
+	socket, err := podmanSocketPath()
+	assert.NilError(t, err)
+	assert.Equal(t, socket, "/run/user/1000/podman/podman.sock")
+
+	t.Setenv("CONTAINER_HOST", "ssh://core@localhost:22/run/podman/podman.sock")
+	_, err = podmanSocketPath()
+	assert.ErrorContains(t, err, "only unix sockets are supported")
+}
+
+func TestPodmanClient(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "podman.sock")
+	l, err := net.Listen("unix", socket)
+	assert.NilError(t, err)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/"+podmanAPIVersion+"/libpod/images/foo/json", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte(`{"cause":"failed to find image foo","message":"failed to find image foo: image not known","response":404}`))
+	})
+	mux.HandleFunc("/"+podmanAPIVersion+"/libpod/images/pull", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, r.URL.Query().Get("reference"), "foo")
+		assert.Equal(t, r.URL.Query().Get("arch"), "arm64")
+		_, _ = w.Write([]byte(`{"stream":"Trying to pull foo...\n"}` + "\n" + `{"error":"manifest unknown"}`))
+	})
+	inspects := 0
+	mux.HandleFunc("/"+podmanAPIVersion+"/libpod/exec/bar/json", func(w http.ResponseWriter, r *http.Request) {
+		// The exit code is recorded shortly after the end of the output stream
+		inspects++
+		if inspects == 1 {
+			_, _ = w.Write([]byte(`{"Running":true,"ExitCode":0}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"Running":false,"ExitCode":2}`))
+	})
+	srv := &http.Server{Handler: mux}
+	go func() { _ = srv.Serve(l) }()
+	defer srv.Close()
+
+	cli := newPodmanClient(socket)
+	_, err = cli.imageInspect(context.Background(), "foo")
+	assert.Assert(t, isPodmanNotFound(err))
+	assert.ErrorContains(t, err, "image not known")
+
+	err = cli.imagePull(context.Background(), "foo", "arm64")
+	assert.ErrorContains(t, err, "manifest unknown")
+
+	exitCode, err := cli.execInspect(context.Background(), "bar")
+	assert.NilError(t, err)
+	assert.Equal(t, exitCode, 2)
+	assert.Equal(t, inspects, 2)
+}
@@ -0,0 +1,181 @@
+// SPDX-License-Identifier: Apache-2.0
+/*
+Copyright (C) 2023 The Diginfra Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driverbuilder
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/diginfra/driverkit/pkg/driverbuilder/builder"
+)
+
+// BuildStatus is the final status of a build.
+type BuildStatus string
+
+const (
+	BuildStatusSuccess BuildStatus = "success"
+	BuildStatusFailure BuildStatus = "failure"
+)
+
+// Artifact kinds.
+const (
+	ArtifactKindModule = "module"
+	ArtifactKindProbe  = "probe"
+)
+
+// Build stages recorded by the processors.
+const (
+	StagePrepare = "prepare"
+	StageHeaders = "headers"
+	StagePull    = "pull"
+	StageBuild   = "build"
+	StageCopy    = "copy"
+)
+
+// Duration is a time.Duration marshalled to JSON as seconds.
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).Seconds())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var secs float64
+	if err := json.Unmarshal(data, &secs); err != nil {
+		return err
+	}
+	*d = Duration(secs * float64(time.Second))
+	return nil
+}
+
+// BuildArtifact is a driver produced by a build.
+type BuildArtifact struct {
+	Kind   string `json:"kind"`
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// BuildStage is a timed step of a build.
+type BuildStage struct {
+	Name     string   `json:"name"`
+	Duration Duration `json:"durationSeconds"`
+}
+
+// BuildResult describes what a build did and produced.
+type BuildResult struct {
+	Processor     string `json:"processor"`
+	Target        string `json:"target"`
+	KernelRelease string `json:"kernelRelease"`
+	KernelVersion string `json:"kernelVersion"`
+	Architecture  string `json:"architecture"`
+	DriverVersion string `json:"driverVersion"`
+	BuilderImage  string `json:"builderImage,omitempty"`
+	GCCVersion    string `json:"gccVersion,omitempty"`
+	// GCCPath is the gcc the local processor built the kernel module with.
+	GCCPath           string          `json:"gccPath,omitempty"`
+	KernelHeadersURLs []string        `json:"kernelHeadersURLs,omitempty"`
+	LibsDownloadURL   string          `json:"libsDownloadURL,omitempty"`
+	Cached            bool            `json:"cached,omitempty"`
+	OCIReference      string          `json:"ociReference,omitempty"`
+	Artifacts         []BuildArtifact `json:"artifacts"`
+	Stages            []BuildStage    `json:"stages"`
+	Duration          Duration        `json:"durationSeconds"`
+	Status            BuildStatus     `json:"status"`
+	Error             string          `json:"error,omitempty"`
+
+	start      time.Time
+	modulePath string
+	probePath  string
+}
+
+// NewBuildResult returns an empty result for the given build,
+// whose duration is measured starting from now.
+func NewBuildResult(processor string, b *builder.Build) *BuildResult {
+	return &BuildResult{
+		Processor:     processor,
+		Target:        b.TargetType.String(),
+		KernelRelease: b.KernelRelease,
+		KernelVersion: b.KernelVersion,
+		Architecture:  b.Architecture,
+		DriverVersion: b.DriverVersion,
+		Artifacts:     []BuildArtifact{},
+		Stages:        []BuildStage{},
+		start:         time.Now(),
+		// Processors may reset the paths of the drivers while building them
+		modulePath: b.ModuleFilePath,
+		probePath:  b.ProbeFilePath,
+	}
+}
+
+// StageDone records a stage started at the given time and ended now.
+func (r *BuildResult) StageDone(name string, start time.Time) {
+	r.Stages = append(r.Stages, BuildStage{
+		Name:     name,
+		Duration: Duration(time.Since(start)),
+	})
+}
+
+// Finish sets the final status of the result given the build error, if any,
+// and collects the built artifacts. It returns the result and the given error.
+func (r *BuildResult) Finish(b *builder.Build, err error) (*BuildResult, error) {
+	r.Duration = Duration(time.Since(r.start))
+	if err != nil {
+		r.Status = BuildStatusFailure
+		r.Error = err.Error()
+		return r, err
+	}
+	r.Status = BuildStatusSuccess
+	for _, a := range []struct{ kind, path string }{
+		{ArtifactKindModule, r.modulePath},
+		{ArtifactKindProbe, r.probePath},
+	} {
+		if len(a.path) == 0 {
+			continue
+		}
+		artifact, aErr := newBuildArtifact(a.kind, a.path)
+		if aErr != nil {
+			b.Logger.Warn("error inspecting artifact",
+				b.Logger.Args("path", a.path, "err", aErr.Error()))
+			continue
+		}
+		r.Artifacts = append(r.Artifacts, *artifact)
+	}
+	return r, nil
+}
+
+func newBuildArtifact(kind, path string) (*BuildArtifact, error) {
+	f, err := os.Open(filepath.Clean(path))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	size, err := io.Copy(h, f)
+	if err != nil {
+		return nil, err
+	}
+	return &BuildArtifact{
+		Kind:   kind,
+		Path:   path,
+		Size:   size,
+		SHA256: hex.EncodeToString(h.Sum(nil)),
+	}, nil
+}
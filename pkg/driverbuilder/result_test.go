@@ -0,0 +1,61 @@
+// SPDX-License-Identifier: Apache-2.0
+/*
+Copyright (C) 2023 The Diginfra Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driverbuilder
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gotest.tools/assert"
+)
+
+func TestBuildResult(t *testing.T) {
+	outDir := t.TempDir()
+	b := newCacheTestBuild(outDir, "7.0.0+driver")
+	assert.NilError(t, os.WriteFile(b.ModuleFilePath, []byte("module"), 0o644))
+
+	res := NewBuildResult("counting", b)
+	// Processors may reset the paths while building
+	b.ModuleFilePath = ""
+	res.StageDone(StageBuild, time.Now().Add(-2*time.Second))
+	res, err := res.Finish(b, nil)
+	assert.NilError(t, err)
+	assert.Equal(t, res.Status, BuildStatusSuccess)
+	assert.DeepEqual(t, res.Artifacts, []BuildArtifact{{
+		Kind: ArtifactKindModule,
+		Path: filepath.Join(outDir, "diginfra.ko"),
+		Size: 6,
+		// sha256 of "module"
+		SHA256: "120970d812836f19888625587a4606a5ad23cef31c8684e601771552548fc6b9",
+	}})
+
+	data, err := json.Marshal(res)
+	assert.NilError(t, err)
+	var decoded BuildResult
+	assert.NilError(t, json.Unmarshal(data, &decoded))
+	assert.Equal(t, decoded.Stages[0].Name, StageBuild)
+	assert.Assert(t, time.Duration(decoded.Stages[0].Duration) >= 2*time.Second)
+
+	failErr := errors.New("build failed")
+	res, err = NewBuildResult("counting", newCacheTestBuild(outDir, "7.0.0+driver")).Finish(b, failErr)
+	assert.Equal(t, err, failErr)
+	assert.Equal(t, res.Status, BuildStatusFailure)
+	assert.Equal(t, res.Error, failErr.Error())
+	assert.Equal(t, len(res.Artifacts), 0)
+}
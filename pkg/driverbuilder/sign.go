@@ -0,0 +1,101 @@
+// SPDX-License-Identifier: Apache-2.0
+/*
+Copyright (C) 2023 The Diginfra Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driverbuilder
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/diginfra/driverkit/pkg/driverbuilder/builder"
+	"github.com/diginfra/driverkit/pkg/modsign"
+)
+
+// StageSign is the stage signing the kernel module.
+const StageSign = "sign"
+
+// SigningBuildProcessor wraps a BuildProcessor, signing the built kernel module
+// with the given key and certificate once the build succeeds.
+// Modules are signed on the host, so that any processor is supported.
+type SigningBuildProcessor struct {
+	BuildProcessor
+	keyPath  string
+	certPath string
+	hash     string
+}
+
+// NewSigningBuildProcessor wraps the given BuildProcessor, signing the built module
+// with the PEM private key at keyPath and the certificate at certPath, using the named hash algorithm.
+func NewSigningBuildProcessor(processor BuildProcessor, keyPath, certPath, hash string) *SigningBuildProcessor {
+	return &SigningBuildProcessor{
+		BuildProcessor: processor,
+		keyPath:        keyPath,
+		certPath:       certPath,
+		hash:           hash,
+	}
+}
+
+func (sp *SigningBuildProcessor) Start(ctx context.Context, b *builder.Build) (*BuildResult, error) {
+	res, err := sp.BuildProcessor.Start(ctx, b)
+	if err != nil {
+		return res, err
+	}
+	for i, artifact := range res.Artifacts {
+		if artifact.Kind != ArtifactKindModule {
+			continue
+		}
+		stageStart := time.Now()
+		if err = sp.sign(artifact.Path); err != nil {
+			return res.Finish(b, fmt.Errorf("error signing kernel module: %w", err))
+		}
+		// The signature changes the module size and digest
+		signed, aErr := newBuildArtifact(artifact.Kind, artifact.Path)
+		if aErr != nil {
+			return res.Finish(b, aErr)
+		}
+		res.Artifacts[i] = *signed
+		res.StageDone(StageSign, stageStart)
+		b.Logger.Info("kernel module signed", b.Logger.Args("path", artifact.Path, "hash", sp.hash))
+	}
+	return res, nil
+}
+
+// sign signs the module at path in place, checking that the appended signature is valid.
+func (sp *SigningBuildProcessor) sign(path string) error {
+	key, cert, err := modsign.LoadKeyPair(sp.keyPath, sp.certPath)
+	if err != nil {
+		return err
+	}
+	path = filepath.Clean(path)
+	module, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	signed, err := modsign.Sign(module, key, cert, sp.hash)
+	if err != nil {
+		return err
+	}
+	// Also catches keys not matching the certificate
+	if err = modsign.Verify(signed, cert); err != nil {
+		return err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, signed, info.Mode().Perm())
+}
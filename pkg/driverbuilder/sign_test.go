@@ -0,0 +1,68 @@
+// SPDX-License-Identifier: Apache-2.0
+/*
+Copyright (C) 2023 The Diginfra Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driverbuilder
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/diginfra/driverkit/pkg/modsign"
+	"gotest.tools/assert"
+)
+
+func TestSigningBuildProcessor(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NilError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "driverkit test"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	certDER, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	assert.NilError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	assert.NilError(t, err)
+
+	keyDir := t.TempDir()
+	keyPath := filepath.Join(keyDir, "key.pem")
+	certPath := filepath.Join(keyDir, "cert.der")
+	assert.NilError(t, os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600))
+	assert.NilError(t, os.WriteFile(certPath, certDER, 0o600))
+
+	outDir := t.TempDir()
+	sp := NewSigningBuildProcessor(&countingBuildProcessor{}, keyPath, certPath, "sha512")
+	res, err := sp.Start(context.Background(), newCacheTestBuild(outDir, "7.0.0+driver"))
+	assert.NilError(t, err)
+
+	module, err := os.ReadFile(filepath.Join(outDir, "diginfra.ko"))
+	assert.NilError(t, err)
+	cert, err := x509.ParseCertificate(certDER)
+	assert.NilError(t, err)
+	assert.NilError(t, modsign.Verify(module, cert))
+	assert.Equal(t, len(res.Artifacts), 1)
+	assert.Equal(t, res.Artifacts[0].Size, int64(len(module)))
+	assert.Equal(t, res.Stages[len(res.Stages)-1].Name, StageSign)
+}
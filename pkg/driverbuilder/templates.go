@@ -46,3 +46,17 @@ while true; do
 done
 cat "$1"
 `
+
+const headersReadyFile = builderHeadersDir + "/.ready"
+
+// waitForHeadersScript waits for the local kernel headers to be uploaded into the pod
+var waitForHeadersScript = `
+while true; do
+  if [ ! -f ` + headersReadyFile + ` ]; then
+    echo "Kernel headers not uploaded yet - waiting for 5 seconds"
+    sleep 5
+    continue
+  fi
+  break
+done
+`
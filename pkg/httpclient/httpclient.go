@@ -0,0 +1,295 @@
+// SPDX-License-Identifier: Apache-2.0
+/*
+Copyright (C) 2023 The Diginfra Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package httpclient provides the HTTP client shared by the kernel headers resolvers and downloads.
+package httpclient
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/diginfra/driverkit/pkg/version"
+	"golang.org/x/net/http/httpproxy"
+)
+
+const (
+	// DefaultTimeout is the default timeout to connect, to receive the response headers and to receive each part of the body.
+	DefaultTimeout = 30 * time.Second
+	// DefaultRetries is the default number of retries of the requests failing with a transient error.
+	DefaultRetries = 3
+	// DefaultConcurrency is the default number of concurrent probing requests.
+	DefaultConcurrency = 8
+
+	maxBackoff = 10 * time.Second
+)
+
+// Options configures a Client.
+type Options struct {
+	// ProxyURL is the proxy used for every request, but the ones towards the hosts listed in NO_PROXY.
+	// When empty, the proxy is read from the HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables.
+	ProxyURL string
+	// Timeout bounds the time to connect, to receive the response headers, and to receive data while reading the body:
+	// a download stalling for longer is aborted, however long the download is.
+	Timeout time.Duration
+	// Retries is the number of retries of the requests failing with a network error, a 5xx or a 429 status code.
+	Retries int
+	// Concurrency bounds the number of concurrent requests issued by Probe.
+	Concurrency int
+	// Backoff is the delay before the first retry, doubled at each retry.
+	Backoff time.Duration
+}
+
+// Client is an HTTP client with timeouts, retries with backoff and proxy support.
+type Client struct {
+	client      *http.Client
+	timeout     time.Duration
+	userAgent   string
+	retries     int
+	concurrency int
+	backoff     time.Duration
+}
+
+// New returns a Client configured with the given options, defaulting the unset ones.
+func New(opts Options) (*Client, error) {
+	if opts.Timeout <= 0 {
+		opts.Timeout = DefaultTimeout
+	}
+	if opts.Retries < 0 {
+		opts.Retries = 0
+	}
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = DefaultConcurrency
+	}
+	if opts.Backoff <= 0 {
+		opts.Backoff = 500 * time.Millisecond
+	}
+
+	proxyConfig := httpproxy.FromEnvironment()
+	if opts.ProxyURL != "" {
+		if _, err := url.Parse(opts.ProxyURL); err != nil {
+			return nil, fmt.Errorf("invalid proxy url: %w", err)
+		}
+		proxyConfig.HTTPProxy = opts.ProxyURL
+		proxyConfig.HTTPSProxy = opts.ProxyURL
+	}
+	proxyFunc := proxyConfig.ProxyFunc()
+
+	dialer := &net.Dialer{
+		Timeout:   opts.Timeout,
+		KeepAlive: 30 * time.Second,
+	}
+	transport := &http.Transport{
+		Proxy: func(req *http.Request) (*url.URL, error) {
+			return proxyFunc(req.URL)
+		},
+		DialContext:           dialer.DialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConnsPerHost:   opts.Concurrency,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   opts.Timeout,
+		ResponseHeaderTimeout: opts.Timeout,
+		ExpectContinueTimeout: time.Second,
+	}
+	return &Client{
+		client:      &http.Client{Transport: transport},
+		timeout:     opts.Timeout,
+		userAgent:   UserAgent(),
+		retries:     opts.Retries,
+		concurrency: opts.Concurrency,
+		backoff:     opts.Backoff,
+	}, nil
+}
+
+// UserAgent returns the User-Agent header sent by the Client.
+func UserAgent() string {
+	v := version.GitTag()
+	if v == "" {
+		v = "dev"
+	}
+	return "driverkit/" + v
+}
+
+var (
+	defaultMu     sync.RWMutex
+	defaultClient *Client
+)
+
+func init() {
+	defaultClient, _ = New(Options{Retries: DefaultRetries})
+}
+
+// Default returns the Client shared by driverkit.
+func Default() *Client {
+	defaultMu.RLock()
+	defer defaultMu.RUnlock()
+	return defaultClient
+}
+
+// SetDefault replaces the Client shared by driverkit.
+func SetDefault(c *Client) {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+	defaultClient = c
+}
+
+// Do sends the given request, retrying it with exponential backoff
+// upon network errors and 5xx or 429 status codes.
+// Requests with a body are retried only if their body can be rewound.
+// Reading the response body fails once no data is received for the client timeout.
+func (c *Client) Do(req *http.Request) (*http.Response, error) {
+	// Canceled when the body stalls, or once it is closed
+	ctx, cancel := context.WithCancel(req.Context())
+	req = req.WithContext(ctx)
+	req.Header.Set("User-Agent", c.userAgent)
+	backoff := c.backoff
+	for attempt := 0; ; attempt++ {
+		res, err := c.client.Do(req)
+		if attempt >= c.retries || !retryable(res, err) || req.Context().Err() != nil || !rewindBody(req) {
+			if err != nil {
+				cancel()
+				return res, err
+			}
+			res.Body = &idleTimeoutBody{ReadCloser: res.Body, timeout: c.timeout, cancel: cancel}
+			return res, nil
+		}
+		delay := backoff
+		if res != nil {
+			if retryAfter := retryAfterDelay(res); retryAfter > 0 {
+				delay = retryAfter
+			}
+			res.Body.Close()
+		}
+		if delay > maxBackoff {
+			delay = maxBackoff
+		}
+		select {
+		case <-req.Context().Done():
+			cancel()
+			return nil, req.Context().Err()
+		case <-time.After(delay):
+		}
+		backoff *= 2
+	}
+}
+
+// rewindBody resets the body of the given request before retrying it,
+// returning false if it cannot be rewound.
+func rewindBody(req *http.Request) bool {
+	if req.Body == nil {
+		return true
+	}
+	if req.GetBody == nil {
+		return false
+	}
+	body, err := req.GetBody()
+	if err != nil {
+		return false
+	}
+	req.Body = body
+	return true
+}
+
+// idleTimeoutBody is a response body whose reads fail when no data is received for the given timeout,
+// canceling the request.
+type idleTimeoutBody struct {
+	io.ReadCloser
+	timeout time.Duration
+	cancel  context.CancelFunc
+}
+
+func (b *idleTimeoutBody) Read(p []byte) (int, error) {
+	timer := time.AfterFunc(b.timeout, b.cancel)
+	n, err := b.ReadCloser.Read(p)
+	if !timer.Stop() && err != nil {
+		err = fmt.Errorf("no data received for %s: %w", b.timeout, err)
+	}
+	return n, err
+}
+
+func (b *idleTimeoutBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+func retryable(res *http.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+	return res.StatusCode == http.StatusTooManyRequests || res.StatusCode >= http.StatusInternalServerError
+}
+
+// retryAfterDelay returns the delay requested by the Retry-After header, if any, in seconds.
+func retryAfterDelay(res *http.Response) time.Duration {
+	seconds, err := strconv.Atoi(res.Header.Get("Retry-After"))
+	if err != nil || seconds < 0 {
+		return 0
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// Get issues a GET request to the given url.
+func (c *Client) Get(ctx context.Context, u string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	return c.Do(req)
+}
+
+// Head issues a HEAD request to the given url.
+func (c *Client) Head(ctx context.Context, u string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := c.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	res.Body.Close()
+	return res, nil
+}
+
+// Probe calls fn for each of the given urls, concurrently, running at most the configured concurrency at once.
+// It returns once every call returned; no more calls are issued once ctx is done.
+func (c *Client) Probe(ctx context.Context, urls []string, fn func(i int, u string)) error {
+	sem := make(chan struct{}, c.concurrency)
+	var wg sync.WaitGroup
+	for i, u := range urls {
+		select {
+		case <-ctx.Done():
+			wg.Wait()
+			return ctx.Err()
+		case sem <- struct{}{}:
+		}
+		wg.Add(1)
+		go func(i int, u string) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			fn(i, u)
+		}(i, u)
+	}
+	wg.Wait()
+	return ctx.Err()
+}
@@ -0,0 +1,153 @@
+// SPDX-License-Identifier: Apache-2.0
+/*
+Copyright (C) 2023 The Diginfra Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package httpclient
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"gotest.tools/assert"
+)
+
+func TestClientRetries(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, r.Header.Get("User-Agent"), UserAgent())
+		switch r.URL.Path {
+		case "/flaky":
+			// Fails twice, then succeeds
+			if atomic.AddInt32(&calls, 1) <= 2 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+			}
+		case "/throttled":
+			w.WriteHeader(http.StatusTooManyRequests)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	c, err := New(Options{Retries: 2, Backoff: time.Millisecond})
+	assert.NilError(t, err)
+	res, err := c.Get(context.Background(), srv.URL+"/flaky")
+	assert.NilError(t, err)
+	res.Body.Close()
+	assert.Equal(t, res.StatusCode, http.StatusOK)
+	assert.Equal(t, atomic.LoadInt32(&calls), int32(3))
+
+	res, err = c.Head(context.Background(), srv.URL+"/throttled")
+	assert.NilError(t, err)
+	assert.Equal(t, res.StatusCode, http.StatusTooManyRequests)
+
+	// Client errors are not retried
+	res, err = c.Head(context.Background(), srv.URL+"/missing")
+	assert.NilError(t, err)
+	assert.Equal(t, res.StatusCode, http.StatusNotFound)
+}
+
+func TestClientTimeout(t *testing.T) {
+	done := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-done
+	}))
+	defer srv.Close()
+	defer close(done)
+
+	c, err := New(Options{Timeout: 50 * time.Millisecond})
+	assert.NilError(t, err)
+	_, err = c.Head(context.Background(), srv.URL)
+	assert.ErrorContains(t, err, "timeout")
+}
+
+func TestClientBodyTimeout(t *testing.T) {
+	done := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "1024")
+		_, _ = w.Write([]byte("partial"))
+		w.(http.Flusher).Flush()
+		<-done
+	}))
+	defer srv.Close()
+	defer close(done)
+
+	c, err := New(Options{Timeout: 50 * time.Millisecond})
+	assert.NilError(t, err)
+	res, err := c.Get(context.Background(), srv.URL)
+	assert.NilError(t, err)
+	defer res.Body.Close()
+	data, err := io.ReadAll(res.Body)
+	assert.ErrorContains(t, err, "no data received for 50ms")
+	assert.Equal(t, string(data), "partial")
+}
+
+func TestClientProxy(t *testing.T) {
+	t.Setenv("NO_PROXY", "internal.example.com")
+	c, err := New(Options{ProxyURL: "http://proxy.example.com:3128"})
+	assert.NilError(t, err)
+	proxy := c.client.Transport.(*http.Transport).Proxy
+
+	req, err := http.NewRequest(http.MethodGet, "https://mirrors.edge.kernel.org/ubuntu", nil)
+	assert.NilError(t, err)
+	u, err := proxy(req)
+	assert.NilError(t, err)
+	assert.Equal(t, u.String(), "http://proxy.example.com:3128")
+
+	req, err = http.NewRequest(http.MethodGet, "https://internal.example.com/ubuntu", nil)
+	assert.NilError(t, err)
+	u, err = proxy(req)
+	assert.NilError(t, err)
+	assert.Assert(t, u == nil)
+}
+
+func TestClientProbe(t *testing.T) {
+	c, err := New(Options{Concurrency: 2})
+	assert.NilError(t, err)
+
+	var (
+		mu          sync.Mutex
+		running     int
+		maxRunning  int
+		probedCount int
+	)
+	urls := []string{"a", "b", "c", "d", "e"}
+	err = c.Probe(context.Background(), urls, func(i int, u string) {
+		mu.Lock()
+		running++
+		probedCount++
+		if running > maxRunning {
+			maxRunning = running
+		}
+		mu.Unlock()
+		time.Sleep(10 * time.Millisecond)
+		mu.Lock()
+		running--
+		mu.Unlock()
+	})
+	assert.NilError(t, err)
+	assert.Equal(t, probedCount, len(urls))
+	assert.Assert(t, maxRunning <= 2)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = c.Probe(ctx, urls, func(i int, u string) {})
+	assert.Assert(t, errors.Is(err, context.Canceled))
+}
@@ -15,8 +15,8 @@ limitations under the License.
 package kernelrelease
 
 import (
+	"errors"
 	"fmt"
-	"log"
 	"regexp"
 	"strconv"
 	"strings"
@@ -28,6 +28,13 @@ var (
 	kernelVersionPattern = regexp.MustCompile(`(?P<fullversion>^(?P<version>0|[1-9]\d*)\.(?P<patchlevel>0|[1-9]\d*)[.+]?(?P<sublevel>0|[1-9]\d*)?)(?P<fullextraversion>[-.+](?P<extraversion>\d+|\d*[a-zA-Z-][0-9a-zA-Z-]*)([\.+~](\d+|\d*[a-zA-Z-][0-9a-zA-Z-_]*))*)?(\+[0-9a-zA-Z-]+(\.[0-9a-zA-Z-]+)*)?$`)
 )
 
+var (
+	// ErrInvalidKernelRelease is returned when a kernel release cannot be parsed.
+	ErrInvalidKernelRelease = errors.New("invalid kernel release")
+	// ErrUnsupportedArch is returned when an architecture is not supported.
+	ErrUnsupportedArch = errors.New("unsupported architecture")
+)
+
 const (
 	ArchitectureAmd64 = "amd64"
 	ArchitectureArm64 = "arm64"
@@ -80,11 +87,18 @@ func (aa Architectures) Strings() []string {
 
 type Architecture string
 
-func (a Architecture) ToNonDeb() string {
-	if val, ok := SupportedArchs[a]; ok {
-		return val
+// Validate returns ErrUnsupportedArch if the architecture is not one of SupportedArchs.
+func (a Architecture) Validate() error {
+	if _, ok := SupportedArchs[a]; !ok {
+		return fmt.Errorf("%w: %s", ErrUnsupportedArch, a.String())
 	}
-	panic(fmt.Errorf("missing non-deb name for arch: %s", a.String()))
+	return nil
+}
+
+// ToNonDeb returns the non-deb name of the architecture,
+// or an empty string if it is not supported; see Validate.
+func (a Architecture) ToNonDeb() string {
+	return SupportedArchs[a]
 }
 
 func (a Architecture) String() string {
@@ -105,10 +119,21 @@ type KernelRelease struct {
 	KernelVersion    string
 }
 
-// FromString extracts a KernelRelease object from string.
+// FromString extracts a KernelRelease object from string,
+// leaving empty the parts that cannot be parsed; use Parse to detect invalid kernel releases.
 func FromString(kernelVersionStr string) KernelRelease {
+	kv, _ := Parse(kernelVersionStr)
+	return kv
+}
+
+// Parse extracts a KernelRelease object from string,
+// returning ErrInvalidKernelRelease if it is not a valid kernel release.
+func Parse(kernelVersionStr string) (KernelRelease, error) {
 	kv := KernelRelease{}
 	match := kernelVersionPattern.FindStringSubmatch(kernelVersionStr)
+	if match == nil {
+		return kv, fmt.Errorf("%w: %q", ErrInvalidKernelRelease, kernelVersionStr)
+	}
 	for i, name := range kernelVersionPattern.SubexpNames() {
 		if i > 0 && i <= len(match) {
 			var err error
@@ -132,11 +157,11 @@ func FromString(kernelVersionStr string) KernelRelease {
 			}
 
 			if err != nil {
-				log.Fatal(err)
+				return kv, fmt.Errorf("%w: %q: %v", ErrInvalidKernelRelease, kernelVersionStr, err)
 			}
 		}
 	}
-	return kv
+	return kv, nil
 }
 
 func (k *KernelRelease) SupportsModule() bool {
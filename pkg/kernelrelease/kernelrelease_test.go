@@ -15,6 +15,7 @@ limitations under the License.
 package kernelrelease
 
 import (
+	"errors"
 	"testing"
 
 	"github.com/blang/semver"
@@ -257,6 +258,19 @@ func TestFromString(t *testing.T) {
 	}
 }
 
+func TestParseInvalid(t *testing.T) {
+	for _, s := range []string{"", "foo", "-generic"} {
+		_, err := Parse(s)
+		assert.Assert(t, errors.Is(err, ErrInvalidKernelRelease), "kernel release %q", s)
+	}
+}
+
+func TestArchitectureValidate(t *testing.T) {
+	assert.NilError(t, Architecture(ArchitectureAmd64).Validate())
+	assert.NilError(t, Architecture(ArchitectureArm64).Validate())
+	assert.Assert(t, errors.Is(Architecture("ppc64le").Validate(), ErrUnsupportedArch))
+}
+
 func TestSupportsModule(t *testing.T) {
 	unsupported := []KernelRelease{
 		{
@@ -15,6 +15,8 @@ limitations under the License.
 package factory
 
 import (
+	"errors"
+
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/meta"
 	"k8s.io/apimachinery/pkg/runtime/schema"
@@ -64,16 +66,19 @@ type factoryImpl struct {
 	clientGetter genericclioptions.RESTClientGetter
 }
 
-func NewFactory(clientGetter genericclioptions.RESTClientGetter) Factory {
+// ErrNilClientGetter is returned when creating a Factory without a client getter.
+var ErrNilClientGetter = errors.New("attempt to instantiate client_access_factory with nil clientGetter")
+
+func NewFactory(clientGetter genericclioptions.RESTClientGetter) (Factory, error) {
 	if clientGetter == nil {
-		panic("attempt to instantiate client_access_factory with nil clientGetter")
+		return nil, ErrNilClientGetter
 	}
 
 	f := &factoryImpl{
 		clientGetter: clientGetter,
 	}
 
-	return f
+	return f, nil
 }
 
 func (f *factoryImpl) ToRESTConfig() (*restclient.Config, error) {
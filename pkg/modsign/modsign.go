@@ -0,0 +1,345 @@
+// SPDX-License-Identifier: Apache-2.0
+/*
+Copyright (C) 2023 The Diginfra Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package modsign signs kernel modules the same way the kernel scripts/sign-file does:
+// a detached PKCS#7 signature without signed attributes and certificates,
+// appended to the module together with the module signature trailer.
+package modsign
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	// Register the supported hash algorithms
+	_ "crypto/sha1"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+)
+
+// MagicString is the marker ending signed kernel modules.
+const MagicString = "~Module signature appended~\n"
+
+// pkeyIDPKCS7 is the module signature id type of PKCS#7 signatures.
+const pkeyIDPKCS7 = 2
+
+// moduleSignatureLen is the size of the kernel struct module_signature.
+const moduleSignatureLen = 12
+
+var (
+	// ErrNotSigned is returned when verifying a module without signature.
+	ErrNotSigned = errors.New("module is not signed")
+	// ErrAlreadySigned is returned when signing a module that is already signed.
+	ErrAlreadySigned = errors.New("module is already signed")
+	// ErrInvalidSignature is returned when the module signature does not match the certificate.
+	ErrInvalidSignature = errors.New("invalid module signature")
+)
+
+var (
+	oidData          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidSignedData    = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+	oidRSAEncryption = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 1}
+)
+
+type hashAlgorithm struct {
+	hash     crypto.Hash
+	oid      asn1.ObjectIdentifier
+	ecdsaOID asn1.ObjectIdentifier
+}
+
+// hashAlgorithms are the hash algorithms supported by the kernel for module signatures.
+var hashAlgorithms = map[string]hashAlgorithm{
+	"sha1": {
+		hash:     crypto.SHA1,
+		oid:      asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26},
+		ecdsaOID: asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 1},
+	},
+	"sha224": {
+		hash:     crypto.SHA224,
+		oid:      asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 4},
+		ecdsaOID: asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 1},
+	},
+	"sha256": {
+		hash:     crypto.SHA256,
+		oid:      asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1},
+		ecdsaOID: asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2},
+	},
+	"sha384": {
+		hash:     crypto.SHA384,
+		oid:      asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 2},
+		ecdsaOID: asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 3},
+	},
+	"sha512": {
+		hash:     crypto.SHA512,
+		oid:      asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 3},
+		ecdsaOID: asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 4},
+	},
+}
+
+// HashAlgorithms returns the names of the supported hash algorithms.
+func HashAlgorithms() []string {
+	res := make([]string, 0, len(hashAlgorithms))
+	for name := range hashAlgorithms {
+		res = append(res, name)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// PKCS#7 structures, see RFC 2315.
+type contentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"explicit,optional,tag:0"`
+}
+
+type signedData struct {
+	Version          int
+	DigestAlgorithms []pkix.AlgorithmIdentifier `asn1:"set"`
+	ContentInfo      contentInfo
+	Certificates     asn1.RawValue `asn1:"optional,tag:0"`
+	SignerInfos      []signerInfo  `asn1:"set"`
+}
+
+type issuerAndSerialNumber struct {
+	Issuer       asn1.RawValue
+	SerialNumber *big.Int
+}
+
+type signerInfo struct {
+	Version                   int
+	IssuerAndSerialNumber     issuerAndSerialNumber
+	DigestAlgorithm           pkix.AlgorithmIdentifier
+	DigestEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedDigest           []byte
+}
+
+// IsSigned returns whether the module ends with a signature.
+func IsSigned(module []byte) bool {
+	return bytes.HasSuffix(module, []byte(MagicString))
+}
+
+// Sign returns the module with a PKCS#7 signature appended,
+// made with the given key and certificate using the named hash algorithm.
+func Sign(module []byte, key crypto.Signer, cert *x509.Certificate, hashName string) ([]byte, error) {
+	if IsSigned(module) {
+		return nil, ErrAlreadySigned
+	}
+	algo, ok := hashAlgorithms[hashName]
+	if !ok {
+		return nil, fmt.Errorf("unsupported hash algorithm %q, one of [%s]", hashName, strings.Join(HashAlgorithms(), ","))
+	}
+
+	h := algo.hash.New()
+	h.Write(module)
+	digest := h.Sum(nil)
+
+	var encryptionAlgo pkix.AlgorithmIdentifier
+	switch key.Public().(type) {
+	case *rsa.PublicKey:
+		encryptionAlgo = pkix.AlgorithmIdentifier{Algorithm: oidRSAEncryption, Parameters: asn1.NullRawValue}
+	case *ecdsa.PublicKey:
+		encryptionAlgo = pkix.AlgorithmIdentifier{Algorithm: algo.ecdsaOID}
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", key.Public())
+	}
+	sig, err := key.Sign(rand.Reader, digest, algo.hash)
+	if err != nil {
+		return nil, err
+	}
+
+	digestAlgo := pkix.AlgorithmIdentifier{Algorithm: algo.oid}
+	sd, err := asn1.Marshal(signedData{
+		Version:          1,
+		DigestAlgorithms: []pkix.AlgorithmIdentifier{digestAlgo},
+		ContentInfo:      contentInfo{ContentType: oidData},
+		SignerInfos: []signerInfo{
+			{
+				Version: 1,
+				IssuerAndSerialNumber: issuerAndSerialNumber{
+					Issuer:       asn1.RawValue{FullBytes: cert.RawIssuer},
+					SerialNumber: cert.SerialNumber,
+				},
+				DigestAlgorithm:           digestAlgo,
+				DigestEncryptionAlgorithm: encryptionAlgo,
+				EncryptedDigest:           sig,
+			},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	pkcs7, err := asn1.Marshal(contentInfo{
+		ContentType: oidSignedData,
+		Content:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: sd},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// struct module_signature: only id_type and sig_len are set for PKCS#7 signatures
+	trailer := make([]byte, moduleSignatureLen)
+	trailer[2] = pkeyIDPKCS7
+	binary.BigEndian.PutUint32(trailer[8:], uint32(len(pkcs7)))
+
+	res := make([]byte, 0, len(module)+len(pkcs7)+len(trailer)+len(MagicString))
+	res = append(res, module...)
+	res = append(res, pkcs7...)
+	res = append(res, trailer...)
+	return append(res, MagicString...), nil
+}
+
+// Verify checks that the signature appended to the module was made with the key of the given certificate.
+func Verify(module []byte, cert *x509.Certificate) error {
+	if !IsSigned(module) {
+		return ErrNotSigned
+	}
+	end := len(module) - len(MagicString)
+	if end < moduleSignatureLen {
+		return fmt.Errorf("%w: truncated signature", ErrInvalidSignature)
+	}
+	trailer := module[end-moduleSignatureLen : end]
+	if trailer[2] != pkeyIDPKCS7 {
+		return fmt.Errorf("%w: unsupported signature type %d", ErrInvalidSignature, trailer[2])
+	}
+	sigLen := int(binary.BigEndian.Uint32(trailer[8:]))
+	end -= moduleSignatureLen
+	if sigLen > end {
+		return fmt.Errorf("%w: truncated signature", ErrInvalidSignature)
+	}
+	content, pkcs7 := module[:end-sigLen], module[end-sigLen:end]
+
+	var ci contentInfo
+	if _, err := asn1.Unmarshal(pkcs7, &ci); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidSignature, err)
+	}
+	if !ci.ContentType.Equal(oidSignedData) {
+		return fmt.Errorf("%w: not a PKCS#7 signed data", ErrInvalidSignature)
+	}
+	var sd signedData
+	if _, err := asn1.Unmarshal(ci.Content.Bytes, &sd); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidSignature, err)
+	}
+	if len(sd.SignerInfos) != 1 {
+		return fmt.Errorf("%w: expected one signer, got %d", ErrInvalidSignature, len(sd.SignerInfos))
+	}
+	si := sd.SignerInfos[0]
+	if !bytes.Equal(si.IssuerAndSerialNumber.Issuer.FullBytes, cert.RawIssuer) ||
+		si.IssuerAndSerialNumber.SerialNumber.Cmp(cert.SerialNumber) != 0 {
+		return fmt.Errorf("%w: signed by a different certificate", ErrInvalidSignature)
+	}
+
+	var algo *hashAlgorithm
+	for _, a := range hashAlgorithms {
+		if a.oid.Equal(si.DigestAlgorithm.Algorithm) {
+			algo = &a
+			break
+		}
+	}
+	if algo == nil {
+		return fmt.Errorf("%w: unsupported digest algorithm %s", ErrInvalidSignature, si.DigestAlgorithm.Algorithm)
+	}
+	h := algo.hash.New()
+	h.Write(content)
+	digest := h.Sum(nil)
+
+	switch pub := cert.PublicKey.(type) {
+	case *rsa.PublicKey:
+		if err := rsa.VerifyPKCS1v15(pub, algo.hash, digest, si.EncryptedDigest); err != nil {
+			return fmt.Errorf("%w: %w", ErrInvalidSignature, err)
+		}
+	case *ecdsa.PublicKey:
+		if !ecdsa.VerifyASN1(pub, digest, si.EncryptedDigest) {
+			return ErrInvalidSignature
+		}
+	default:
+		return fmt.Errorf("unsupported key type %T", cert.PublicKey)
+	}
+	return nil
+}
+
+// LoadKeyPair reads the private key and the certificate used to sign modules.
+// The key is PEM encoded, the certificate either PEM or DER encoded; they can be stored in the same file,
+// like the kernel signing_key.pem.
+func LoadKeyPair(keyPath, certPath string) (crypto.Signer, *x509.Certificate, error) {
+	keyData, err := os.ReadFile(filepath.Clean(keyPath))
+	if err != nil {
+		return nil, nil, err
+	}
+	key, err := parsePrivateKey(keyData)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error parsing signing key %s: %w", keyPath, err)
+	}
+
+	certData, err := os.ReadFile(filepath.Clean(certPath))
+	if err != nil {
+		return nil, nil, err
+	}
+	cert, err := parseCertificate(certData)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error parsing signing certificate %s: %w", certPath, err)
+	}
+	return key, cert, nil
+}
+
+func parsePrivateKey(data []byte) (crypto.Signer, error) {
+	for block, rest := pem.Decode(data); block != nil; block, rest = pem.Decode(rest) {
+		var (
+			key interface{}
+			err error
+		)
+		switch block.Type {
+		case "PRIVATE KEY":
+			key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+		case "RSA PRIVATE KEY":
+			key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+		case "EC PRIVATE KEY":
+			key, err = x509.ParseECPrivateKey(block.Bytes)
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		signer, ok := key.(crypto.Signer)
+		if !ok {
+			return nil, fmt.Errorf("unsupported key type %T", key)
+		}
+		return signer, nil
+	}
+	return nil, errors.New("no PEM private key found")
+}
+
+func parseCertificate(data []byte) (*x509.Certificate, error) {
+	for block, rest := pem.Decode(data); block != nil; block, rest = pem.Decode(rest) {
+		if block.Type == "CERTIFICATE" {
+			return x509.ParseCertificate(block.Bytes)
+		}
+	}
+	// Not PEM encoded, like the kernel signing_key.x509
+	return x509.ParseCertificate(data)
+}
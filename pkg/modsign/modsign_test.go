@@ -0,0 +1,113 @@
+// SPDX-License-Identifier: Apache-2.0
+/*
+Copyright (C) 2023 The Diginfra Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package modsign
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gotest.tools/assert"
+)
+
+func newKeyPair(t *testing.T, key crypto.Signer, serial int64) *x509.Certificate {
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "driverkit test"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	assert.NilError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	assert.NilError(t, err)
+	return cert
+}
+
+func TestSignVerify(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NilError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NilError(t, err)
+	module := []byte("\x7fELF fake kernel module")
+
+	for name, key := range map[string]crypto.Signer{"rsa": rsaKey, "ecdsa": ecKey} {
+		for _, hash := range HashAlgorithms() {
+			t.Run(name+"/"+hash, func(t *testing.T) {
+				cert := newKeyPair(t, key, 1)
+				signed, err := Sign(module, key, cert, hash)
+				assert.NilError(t, err)
+				assert.Assert(t, IsSigned(signed))
+				assert.NilError(t, Verify(signed, cert))
+
+				// Tampered module
+				tampered := append([]byte{}, signed...)
+				tampered[0] ^= 0xff
+				assert.Assert(t, errors.Is(Verify(tampered, cert), ErrInvalidSignature))
+
+				// Different certificate
+				otherCert := newKeyPair(t, key, 2)
+				assert.Assert(t, errors.Is(Verify(signed, otherCert), ErrInvalidSignature))
+
+				_, err = Sign(signed, key, cert, hash)
+				assert.Assert(t, errors.Is(err, ErrAlreadySigned))
+			})
+		}
+	}
+
+	cert := newKeyPair(t, rsaKey, 1)
+	assert.Assert(t, errors.Is(Verify(module, cert), ErrNotSigned))
+	_, err = Sign(module, rsaKey, cert, "md5")
+	assert.ErrorContains(t, err, "unsupported hash algorithm")
+}
+
+func TestLoadKeyPair(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NilError(t, err)
+	cert := newKeyPair(t, key, 1)
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	assert.NilError(t, err)
+
+	// Key and certificate in the same file, like the kernel signing_key.pem
+	dir := t.TempDir()
+	pemPath := filepath.Join(dir, "signing_key.pem")
+	data := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})
+	data = append(data, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})...)
+	assert.NilError(t, os.WriteFile(pemPath, data, 0o600))
+	// DER certificate, like the kernel signing_key.x509
+	derPath := filepath.Join(dir, "signing_key.x509")
+	assert.NilError(t, os.WriteFile(derPath, cert.Raw, 0o600))
+
+	for _, certPath := range []string{pemPath, derPath} {
+		loadedKey, loadedCert, err := LoadKeyPair(pemPath, certPath)
+		assert.NilError(t, err)
+		assert.Assert(t, key.Equal(loadedKey))
+		assert.Assert(t, cert.Equal(loadedCert))
+	}
+
+	_, _, err = LoadKeyPair(derPath, pemPath)
+	assert.ErrorContains(t, err, "no PEM private key found")
+}